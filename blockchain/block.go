@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
+	"io"
+
+	"github.com/singurty/goldchain/wire"
 )
 
 type Block struct {
@@ -20,10 +23,8 @@ type Block struct {
 
 type Transaction struct {
 	Version int
-	Flag [2]uint8
 	Inputs []*TxIn
 	Outputs []*TxOut
-	Witnesses [][]byte
 	LockTime int
 }
 
@@ -32,6 +33,7 @@ type TxIn struct {
 	PrevTxIndex int
 	Script []byte
 	Sequence [4]byte
+	Witness [][]byte // BIP144 gives every input its own stack
 }
 
 type TxOut struct {
@@ -53,3 +55,140 @@ func (b *Block) GetHash() [32]byte {
 	double := sha256.Sum256(single[:])
 	return double
 }
+
+// BlockFromHeader builds a header-only block out of a wire header
+func BlockFromHeader(header *wire.BlockHeader) *Block {
+	return &Block{
+		Version: int(header.Version),
+		PrevHash: header.PrevBlock,
+		MerkleRoot: header.MerkleRoot,
+		Time: int(header.Timestamp),
+		Bits: int(header.Bits),
+		Nonce: int(header.Nonce),
+	}
+}
+
+func BlockFromWire(msg *wire.BlockMsg) *Block {
+	block := BlockFromHeader(&msg.Header)
+	block.Transactions = make([]*Transaction, 0, len(msg.Transactions))
+	for _, tx := range msg.Transactions {
+		block.Transactions = append(block.Transactions, TransactionFromWire(tx))
+	}
+	return block
+}
+
+func TransactionFromWire(msg *wire.TxMsg) *Transaction {
+	transaction := &Transaction{
+		Version: int(msg.Version),
+		LockTime: int(msg.LockTime),
+	}
+	for _, in := range msg.TxIn {
+		txIn := &TxIn{
+			PrevTxHash: in.PreviousOutPoint.Hash,
+			PrevTxIndex: int(in.PreviousOutPoint.Index),
+			Script: in.SignatureScript,
+		}
+		binary.LittleEndian.PutUint32(txIn.Sequence[:], in.Sequence)
+		txIn.Witness = in.Witness
+		transaction.Inputs = append(transaction.Inputs, txIn)
+	}
+	for _, out := range msg.TxOut {
+		transaction.Outputs = append(transaction.Outputs, &TxOut{Value: int(out.Value), Script: out.PkScript})
+	}
+	return transaction
+}
+
+// ToWire converts the block back to what goes over the network
+func (b *Block) ToWire() *wire.BlockMsg {
+	msg := &wire.BlockMsg{
+		Header: wire.BlockHeader{
+			Version: int32(b.Version),
+			PrevBlock: b.PrevHash,
+			MerkleRoot: b.MerkleRoot,
+			Timestamp: uint32(b.Time),
+			Bits: uint32(b.Bits),
+			Nonce: uint32(b.Nonce),
+		},
+	}
+	for _, tx := range b.Transactions {
+		msg.Transactions = append(msg.Transactions, tx.ToWire())
+	}
+	return msg
+}
+
+func (tx *Transaction) ToWire() *wire.TxMsg {
+	msg := &wire.TxMsg{
+		Version: int32(tx.Version),
+		LockTime: uint32(tx.LockTime),
+	}
+	for _, in := range tx.Inputs {
+		msg.TxIn = append(msg.TxIn, &wire.TxIn{
+			PreviousOutPoint: wire.OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)},
+			SignatureScript: in.Script,
+			Witness: in.Witness,
+			Sequence: binary.LittleEndian.Uint32(in.Sequence[:]),
+		})
+	}
+	for _, out := range tx.Outputs {
+		msg.TxOut = append(msg.TxOut, &wire.TxOut{Value: int64(out.Value), PkScript: out.Script})
+	}
+	return msg
+}
+
+func (tx *Transaction) HasWitness() bool {
+	for _, in := range tx.Inputs {
+		if len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Serialize writes the transaction with its witness data if it has any
+func (tx *Transaction) Serialize(w io.Writer) error {
+	return tx.ToWire().Encode(w)
+}
+
+// SerializeNoWitness writes the pre-segwit serialization
+func (tx *Transaction) SerializeNoWitness(w io.Writer) error {
+	return tx.ToWire().EncodeNoWitness(w)
+}
+
+// TxID is the hash of the transaction without witness data
+func (tx *Transaction) TxID() [32]byte {
+	var buf bytes.Buffer
+	tx.SerializeNoWitness(&buf)
+	return doubleSha256(buf.Bytes())
+}
+
+// WTxID also commits to the witness, it is the same as TxID for
+// transactions without one
+func (tx *Transaction) WTxID() [32]byte {
+	var buf bytes.Buffer
+	tx.Serialize(&buf)
+	return doubleSha256(buf.Bytes())
+}
+
+// IsCoinbase tells whether tx creates coins out of nothing, it spends just
+// the null outpoint
+func (tx *Transaction) IsCoinbase() bool {
+	if len(tx.Inputs) != 1 {
+		return false
+	}
+	in := tx.Inputs[0]
+	return in.PrevTxHash == [32]byte{} && uint32(in.PrevTxIndex) == 0xffffffff
+}
+
+// Weight is BIP141's measure of size, witness bytes count once and the
+// rest four times
+func (tx *Transaction) Weight() int {
+	var base, total bytes.Buffer
+	tx.SerializeNoWitness(&base)
+	tx.Serialize(&total)
+	return base.Len()*(WitnessScaleFactor-1) + total.Len()
+}
+
+func doubleSha256(b []byte) [32]byte {
+	single := sha256.Sum256(b)
+	return sha256.Sum256(single[:])
+}
@@ -0,0 +1,256 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/singurty/goldchain/chainparams"
+	"github.com/singurty/goldchain/wire"
+)
+
+// blocks 0 to 2 are straight from mainnet. segwit.hex chains onto block 2
+// at the segwit activation height with a coinbase witness commitment, the
+// transaction from block 170 and the native and P2SH wrapped P2WPKH spends
+// from BIP143. it is mined to regtest difficulty, so it is not a real mainnet
+// block, and its hashes were worked out outside of this code.
+var blockTests = []struct {
+	file   string
+	height int
+	hash   string
+	merkle string
+	txids  []string
+	wtxids []string
+}{
+	{
+		file:   "block-0.hex",
+		height: 0,
+		hash:   "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f",
+		merkle: "4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b",
+		txids:  []string{"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"},
+		wtxids: []string{"4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b"},
+	},
+	{
+		file:   "block-1.hex",
+		height: 1,
+		hash:   "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048",
+		merkle: "0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098",
+		txids:  []string{"0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"},
+		wtxids: []string{"0e3e2357e806b6cdb1f70b54c3a3a17b6714ee1f0e68bebb44a74b1efd512098"},
+	},
+	{
+		file:   "block-2.hex",
+		height: 2,
+		hash:   "000000006a625f06636b8bb6ac7b960a8d03705d1ace08b1a19da3fdcc99ddbd",
+		merkle: "9b0fc92260312ce44e74ef369f5c66bbb85848f2eddd5a7a1cde251e54ccfdd5",
+		txids:  []string{"9b0fc92260312ce44e74ef369f5c66bbb85848f2eddd5a7a1cde251e54ccfdd5"},
+		wtxids: []string{"9b0fc92260312ce44e74ef369f5c66bbb85848f2eddd5a7a1cde251e54ccfdd5"},
+	},
+	{
+		file:   "segwit.hex",
+		height: 481824,
+		hash:   "233c8d62e9a1311638a4e3ebbf621a187354673b69e3d1b993d97f5cd90b25a2",
+		merkle: "bb761fa44c06948f69ec00498c621e8cf8a4de181f75e65328de24e61e0dd893",
+		txids: []string{
+			"a3e097ee099ce86e23381da6baff7dd5bddf93399fa191f128a0f22d53571428",
+			"f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16",
+			"e8151a2af31c368a35053ddd4bdb285a8595c769a3ad83e0fa02314a602d4609",
+			"ef48d9d0f595052e0f8cdcf825f7a5e50b6a388a81f206f3f4846e5ecd7a0c23",
+		},
+		wtxids: []string{
+			"e5036a2b24af4768043c6c2680ea9c4dde525420b073101953454b445b48dc85",
+			"f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16",
+			"c36c38370907df2324d9ce9d149d191192f338b37665a82e78e76a12c909b762",
+			"680f483b2bf6c5dcbf111e69e885ba248a41a5e92070cfb0afec3cfc49a9fabb",
+		},
+	},
+}
+
+func mustHash(t *testing.T, s string) [32]byte {
+	t.Helper()
+	hash, err := chainparams.DecodeHash(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+func readBlock(t *testing.T, file string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("%v: %v", file, err)
+	}
+	return raw
+}
+
+func TestBlocks(t *testing.T) {
+	params = &chainparams.MainNetParams
+	defer func() { params = nil }()
+
+	for _, test := range blockTests {
+		t.Run(test.file, func(t *testing.T) {
+			raw := readBlock(t, test.file)
+			msg := &wire.BlockMsg{}
+			if err := msg.Decode(bytes.NewReader(raw)); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+
+			// both the wire message and our own block have to give back
+			// exactly the bytes they came from
+			var buf bytes.Buffer
+			if err := msg.Encode(&buf); err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), raw) {
+				t.Errorf("wire round trip changed the block")
+			}
+			block := BlockFromWire(msg)
+			buf.Reset()
+			if err := block.ToWire().Encode(&buf); err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			if !bytes.Equal(buf.Bytes(), raw) {
+				t.Errorf("block round trip changed the block")
+			}
+
+			hash := mustHash(t, test.hash)
+			if got := msg.Header.BlockHash(); got != hash {
+				t.Errorf("header hash %x, want %x", got, hash)
+			}
+			if got := block.GetHash(); got != hash {
+				t.Errorf("block hash %x, want %x", got, hash)
+			}
+
+			if len(block.Transactions) != len(test.txids) {
+				t.Fatalf("%v transactions, want %v", len(block.Transactions), len(test.txids))
+			}
+			txids := make([][32]byte, 0, len(block.Transactions))
+			for i, tx := range block.Transactions {
+				txid, wtxid := mustHash(t, test.txids[i]), mustHash(t, test.wtxids[i])
+				if got := tx.TxID(); got != txid {
+					t.Errorf("tx %v txid %x, want %x", i, got, txid)
+				}
+				if got := tx.WTxID(); got != wtxid {
+					t.Errorf("tx %v wtxid %x, want %x", i, got, wtxid)
+				}
+				txids = append(txids, tx.TxID())
+			}
+
+			merkle := mustHash(t, test.merkle)
+			if root, mutated := calcMerkleRoot(txids); root != merkle || mutated {
+				t.Errorf("merkle root %x (mutated %v), want %x", root, mutated, merkle)
+			}
+			if err := checkMerkleRoot(block); err != nil {
+				t.Errorf("checkMerkleRoot: %v", err)
+			}
+			if err := checkWitnessCommitment(block, test.height); err != nil {
+				t.Errorf("checkWitnessCommitment: %v", err)
+			}
+		})
+	}
+}
+
+// a witness commitment that doesn't match, or witness data without one,
+// has to be caught
+func TestBadWitnessCommitment(t *testing.T) {
+	params = &chainparams.MainNetParams
+	defer func() { params = nil }()
+
+	decode := func() *Block {
+		msg := &wire.BlockMsg{}
+		if err := msg.Decode(bytes.NewReader(readBlock(t, "segwit.hex"))); err != nil {
+			t.Fatal(err)
+		}
+		return BlockFromWire(msg)
+	}
+
+	block := decode()
+	block.Transactions[2].Inputs[1].Witness[0][10] ^= 1
+	err := checkWitnessCommitment(block, 481824)
+	if ruleErr, ok := err.(RuleError); !ok || ruleErr.ErrorCode != ErrWitnessCommitmentMismatch {
+		t.Errorf("tampered witness: got %v, want ErrWitnessCommitmentMismatch", err)
+	}
+	// txids don't cover the witness so the merkle root still holds
+	if err := checkMerkleRoot(block); err != nil {
+		t.Errorf("checkMerkleRoot: %v", err)
+	}
+
+	block = decode()
+	coinbase := block.Transactions[0]
+	coinbase.Outputs = coinbase.Outputs[:len(coinbase.Outputs)-1]
+	err = checkWitnessCommitment(block, 481824)
+	if ruleErr, ok := err.(RuleError); !ok || ruleErr.ErrorCode != ErrUnexpectedWitness {
+		t.Errorf("missing commitment: got %v, want ErrUnexpectedWitness", err)
+	}
+	// before activation no block can have witness data, commitment or not
+	for _, block := range []*Block{block, decode()} {
+		err = checkWitnessCommitment(block, 481823)
+		if ruleErr, ok := err.(RuleError); !ok || ruleErr.ErrorCode != ErrUnexpectedWitness {
+			t.Errorf("before segwit: got %v, want ErrUnexpectedWitness", err)
+		}
+	}
+}
+
+// the first mainnet block segwit was enforced on
+const segwitActivationHash = "0000000000000000001c8018d9cb3b742ef25114f27563e3fc4a1902167f9893"
+
+// TestMainnetSegwitBlock checks a real segwit block against the hash it has
+// on mainnet. The header commits to the txids and the coinbase to the
+// wtxids, so a block that hashes right and passes both checks was decoded
+// and hashed right all the way down.
+func TestMainnetSegwitBlock(t *testing.T) {
+	file := filepath.Join("testdata", "block-481824.hex")
+	if _, err := os.Stat(file); err != nil {
+		t.Skipf("%v is missing, it is the output of bitcoin-cli getblock %v 0", file, segwitActivationHash)
+	}
+	params = &chainparams.MainNetParams
+	defer func() { params = nil }()
+
+	raw := readBlock(t, "block-481824.hex")
+	msg := &wire.BlockMsg{}
+	if err := msg.Decode(bytes.NewReader(raw)); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := BlockFromWire(msg).ToWire().Encode(&buf); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), raw) {
+		t.Errorf("round trip changed the block")
+	}
+	block := BlockFromWire(msg)
+	if hash := mustHash(t, segwitActivationHash); block.GetHash() != hash {
+		t.Fatalf("hash %x, want %x", block.GetHash(), hash)
+	}
+	if err := checkMerkleRoot(block); err != nil {
+		t.Errorf("checkMerkleRoot: %v", err)
+	}
+	if _, ok := witnessCommitment(block.Transactions[0]); !ok {
+		t.Errorf("no witness commitment")
+	}
+	if err := checkWitnessCommitment(block, 481824); err != nil {
+		t.Errorf("checkWitnessCommitment: %v", err)
+	}
+	witnesses := 0
+	for _, tx := range block.Transactions {
+		if tx.HasWitness() {
+			witnesses++
+			if tx.TxID() == tx.WTxID() {
+				t.Errorf("transaction %x has witness data but the same wtxid", tx.TxID())
+			}
+		} else if tx.TxID() != tx.WTxID() {
+			t.Errorf("transaction %x has no witness data but a different wtxid", tx.TxID())
+		}
+	}
+	if witnesses == 0 {
+		t.Errorf("none of the %v transactions have witness data", len(block.Transactions))
+	}
+}
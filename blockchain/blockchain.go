@@ -9,25 +9,53 @@ import (
 	"fmt"
 	"os"
 	"math/big"
+	"sync"
 
 //	"github.com/btcsuite/btcd/txscript"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/singurty/goldchain/chainparams"
 //	"github.com/davecgh/go-spew/spew"
 )
 
 var db *sql.DB
-var LastBlock *Block
-var FirstHeader *Block
+var lastBlock *Block
 var rootPath string // where the blockchain sould be stored
+var params *chainparams.Params
 
-var OrphanBlocks = make([]*Block, 0)
+var orphanBlocks = make([]*Block, 0)
 
-func Start() {
+// chainLock guards the block index, the main chain, the orphans and the
+// utxo set. Every exported function that touches them takes it, the
+// unexported ones expect it held.
+var chainLock sync.RWMutex
+
+// LastBlock is the header at the tip of the main chain
+func LastBlock() *Block {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	return lastBlock
+}
+
+// OrphanBlocks returns the blocks we hold whose parent we don't know yet
+func OrphanBlocks() []*Block {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	orphans := make([]*Block, len(orphanBlocks))
+	copy(orphans, orphanBlocks)
+	return orphans
+}
+
+func Start(chainParams *chainparams.Params) {
+	params = chainParams
 	home, err := os.UserHomeDir()
 	if err != nil {
 		panic(err)
 	}
 	rootPath = home + "/.goldchain/"
+	// mainnet lives in the root so existing databases keep working
+	if params.Name != chainparams.MainNetParams.Name {
+		rootPath += params.Name + "/"
+	}
 	// create root path and transactions directory if does not exists
 	err = os.MkdirAll(rootPath, 0775)
 	if err != nil {
@@ -46,29 +74,45 @@ func Start() {
 		panic(err)
 	}
 	db.SetMaxOpenConns(1)
-	// create blockchain table if does not exist
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS blockchain (height INTEGER PRIMARY KEY, version INTEGER, hash TEXT, prev_hash TEXT, merkle_root TEXT, time INTEGER, bits INTEGER, nonce INTEGER, tx INTEGER)")
+	err = createSchema()
 	if err != nil {
-		fmt.Println(err)
+		panic(err)
+	}
+	err = loadBlockIndex()
+	if err != nil {
+		panic(err)
+	}
+	err = migrateBlockData()
+	if err != nil {
+		panic(err)
+	}
+	err = loadUtxoTip()
+	if err != nil {
+		panic(err)
 	}
 	err = refreshLastBlock()
 	if err != nil {
 		bootstrapBlockChain()
 	}
-	refreshFirstHeader()
+	// catch up on blocks connected but not flushed before we last stopped
+	err = updateUtxoSet()
+	if err != nil {
+		panic(err)
+	}
+	// nothing is listening yet
+	takeEvents()
 }
 
-func refreshFirstHeader() {
-	// get the first header-only block from the chain
-	firstHeaderRow := db.QueryRow("SELECT * FROM blockchain WHERE tx = 1 ORDER BY height LIMIT 1")
-	FirstHeader, _ = getBlockFromRow(firstHeaderRow)
+// DataDir is where the chain's files are kept, with a trailing slash
+func DataDir() string {
+	return rootPath
 }
 
 func refreshLastBlock() error {
 	// get the block with biggest height
-	lastBlockRow := db.QueryRow("SELECT * FROM blockchain ORDER BY height DESC LIMIT 1;")
+	lastBlockRow := db.QueryRow("SELECT " + blockColumns + " FROM main_chain ORDER BY height DESC LIMIT 1;")
 	var err error
-	LastBlock, err = getBlockFromRow(lastBlockRow)
+	lastBlock, err = getBlockFromRow(lastBlockRow)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return err
@@ -81,96 +125,161 @@ func refreshLastBlock() error {
 
 func bootstrapBlockChain() {
 	fmt.Println("bootstrapping blockchain...")
-	merkleRoot, err := hex.DecodeString("3BA3EDFD7A7B12B27AC72C3E67768F617FC81BC3888A51323A9FB8AA4B1E5E4A")
-	if err != nil {
-		panic(err)
-	}
-	scriptSig, err := hex.DecodeString("04FFFF001D0104455468652054696D65732030332F4A616E2F32303039204368616E63656C6C6F72206F6E206272696E6B206F66207365636F6E64206261696C6F757420666F722062616E6B73")
-	if err != nil {
-		panic(err)
-	}
-	script, err := hex.DecodeString("4104678AFDB0FE5548271967F1A67130B7105CD6A828E03909A67962E0EA1F61DEB649F6BC3F4CEF38C4F35504E51EC112DE5C384DF7BA0B8D578A4C702B6BF11D5FAC")
+	err := NewBlock(BlockFromWire(params.GenesisBlock))
 	if err != nil {
 		panic(err)
 	}
-	prevTxHash, err := hex.DecodeString("0000000000000000000000000000000000000000000000000000000000000000FFFFFFFF")
-	if err != nil {
-		panic(err)
-	}
-	genesis := &Block{
-		Version: 1,
-		Time: 1231006505,
-		Bits: 0x1d00ffff,
-		Nonce: 2083236893,
-	}
-	copy(genesis.MerkleRoot[:], merkleRoot)
-	genesis.Transactions = make([]*Transaction, 0)
-	genesis.Transactions = append(genesis.Transactions, &Transaction{LockTime: 0})
-	genesis.Transactions[0].Inputs = make([]*TxIn, 0)
-	genesis.Transactions[0].Inputs = append(genesis.Transactions[0].Inputs, &TxIn{})
-	copy(genesis.Transactions[0].Inputs[0].PrevTxHash[:], prevTxHash)
-	genesis.Transactions[0].Inputs[0].Script = scriptSig
-	genesis.Transactions[0].Outputs = make([]*TxOut, 0)
-	genesis.Transactions[0].Outputs = append(genesis.Transactions[0].Outputs, &TxOut{Value: 5000000000})
-	genesis.Transactions[0].Outputs[0].Script = script
-	NewBlock(genesis)
 }
 
-func NewBlock(block *Block) {
+// how many blocks without a known parent we hold on to
+const maxOrphanBlocks = 100
+
+// NewBlock adds a header or a full block to the chain. Blocks that break
+// consensus rules return a RuleError naming the one at fault. If the
+// block leaves some branch with more work than the main chain we
+// reorganize to it.
+func NewBlock(block *Block) error {
+	chainLock.Lock()
+	err := newBlock(block)
+	var ruleErr RuleError
+	if errors.As(err, &ruleErr) && ruleErr.Hash == [32]byte{} {
+		ruleErr.Hash = block.Hash
+		err = ruleErr
+	}
+	events := takeEvents()
+	chainLock.Unlock()
+	fireEvents(events)
+	return err
+}
+
+func newBlock(block *Block) error {
 	if block.Hash == [32]byte{} {
 		block.Hash = block.GetHash()
 	}
-	existing, err := getBlockFromHash(block.Hash)
 	// this block already exists
-	if err == nil {
+	if node, ok := index[block.Hash]; ok {
+		// storing its data again would make it look valid
+		if node.status == statusInvalid {
+			return ruleError(ErrKnownInvalid, fmt.Sprintf("block %x is already known to be invalid", block.Hash))
+		}
 		// header exists, add transactions
-		if existing.Transactions == nil && block.Transactions != nil {
-			newTransactions(block)
+		if !node.hasData() && block.Transactions != nil {
+			return storeBlockData(node, block)
 		}
-		return
-	}
-	// check if PoW is valid
-	if !verifyPoW(block) {
-		fmt.Println("got invalid PoW")
-		return
-	}
-	// this is not genesis
-	if LastBlock != nil {
-		if !bytes.Equal(LastBlock.Hash[:], block.PrevHash[:]) {
-			// is this block already an orphan
-			for _, orphan := range OrphanBlocks {
-				if bytes.Equal(block.Hash[:], orphan.Hash[:]) {
-					return
-				}
-			}
-			fmt.Println("found an orphan")
-			OrphanBlocks = append(OrphanBlocks, block)
-			return
+		return nil
+	}
+	err := checkProofOfWork(block)
+	if err != nil {
+		return err
+	}
+	var parent *blockNode
+	if len(index) == 0 {
+		if block.Hash != params.GenesisHash {
+			return ruleError(ErrBadGenesis, fmt.Sprintf("block %x is not the genesis block", block.Hash))
+		}
+	} else {
+		var ok bool
+		parent, ok = index[block.PrevHash]
+		if !ok {
+			addOrphan(block)
+			return nil
+		}
+		if parent.status == statusInvalid {
+			return ruleError(ErrInvalidAncestor, fmt.Sprintf("block %x builds on invalid block %x", block.Hash, parent.hash))
+		}
+		err = checkBlockHeaderContext(block, parent)
+		if err != nil {
+			return err
 		}
-		block.Height = LastBlock.Height + 1
 	}
-	statement := "INSERT INTO blockchain (height, version, hash, prev_hash, merkle_root, time, bits, nonce, tx) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)"
-	hashHex := hex.EncodeToString(block.Hash[:])
-	prevHashHex := hex.EncodeToString(block.PrevHash[:])
-	merkleRootHex := hex.EncodeToString(block.MerkleRoot[:])
-	var tx int
+	node := newBlockNode(block, parent)
+	block.Height = node.height
 	if block.Transactions != nil {
-		tx = 1
+		err = checkBlockData(block, node.height)
+		if err != nil {
+			return err
+		}
+		err = newTransactions(block)
+		if err != nil {
+			return err
+		}
+		node.status = statusDataStored
 	}
-	_, err = db.Exec(statement, block.Height, block.Version, hashHex, prevHashHex, merkleRootHex, block.Time, block.Bits, block.Nonce, tx)
+	err = insertBlock(node)
 	if err != nil {
-		panic(err)
+		return err
 	}
-	// if this is only a header
-	if block.Transactions == nil {
-		refreshLastBlock()
-		refreshFirstHeader()
-		return
+	addToIndex(node)
+	tip := bestTip()
+	if tip == nil || node.workSum.Cmp(tip.workSum) > 0 {
+		err = setBestChain(node)
+		if err != nil {
+			return err
+		}
+		err = updateUtxoSet()
+		if err != nil {
+			return err
+		}
 	}
-	newTransactions(block)
-	refreshLastBlock()
-	refreshFirstHeader()
 	processOrphans()
+	return nil
+}
+
+// checkBlockData makes sure a body belongs to its header. A body that
+// fails these could have been mangled by whoever sent it, so it doesn't
+// make the header invalid.
+func checkBlockData(block *Block, height int) error {
+	err := checkMerkleRoot(block)
+	if err != nil {
+		return err
+	}
+	return checkWitnessCommitment(block, height)
+}
+
+func storeBlockData(node *blockNode, block *Block) error {
+	err := checkBlockData(block, node.height)
+	if err != nil {
+		return err
+	}
+	err = newTransactions(block)
+	if err != nil {
+		return err
+	}
+	err = setBlockStatus(node, statusDataStored)
+	if err != nil {
+		return err
+	}
+	if inBestChain(node) {
+		return updateUtxoSet()
+	}
+	return nil
+}
+
+// setBestChain makes newTip the tip of the main chain, rolling back the
+// rows of blocks that are no longer on it and writing the new branch.
+func setBestChain(newTip *blockNode) error {
+	fork := newTip.parent
+	oldTip := bestTip()
+	if oldTip != nil {
+		fork = findFork(oldTip, newTip)
+		if fork != oldTip {
+			fmt.Printf("reorganizing from %x (height %v) to %x (height %v), fork at height %v\n", oldTip.hash, oldTip.height, newTip.hash, newTip.height, fork.height)
+		}
+	}
+	attach := make([]*blockNode, 0)
+	for node := newTip; node != fork; node = node.parent {
+		attach = append([]*blockNode{node}, attach...)
+	}
+	err := updateActiveChain(fork, attach)
+	if err != nil {
+		return err
+	}
+	if fork != nil {
+		bestChain = bestChain[:fork.height+1]
+	}
+	bestChain = append(bestChain, attach...)
+	refreshLastBlock()
+	return nil
 }
 
 func newTransactions(block *Block) error {
@@ -179,52 +288,79 @@ func newTransactions(block *Block) error {
 	if err != nil {
 		return err
 	}
-	encode := gob.NewEncoder(txFile)
-	encode.Encode(block.Transactions)
-	return nil
+	err = gob.NewEncoder(txFile).Encode(block.Transactions)
+	if err != nil {
+		txFile.Close()
+		return err
+	}
+	return txFile.Close()
+}
+
+func addOrphan(block *Block) {
+	// is this block already an orphan
+	for _, orphan := range orphanBlocks {
+		if bytes.Equal(block.Hash[:], orphan.Hash[:]) {
+			return
+		}
+	}
+	fmt.Println("found an orphan")
+	if len(orphanBlocks) >= maxOrphanBlocks {
+		orphanBlocks = orphanBlocks[1:]
+	}
+	orphanBlocks = append(orphanBlocks, block)
 }
 
 func processOrphans() {
-	for i, block := range OrphanBlocks {
-		if bytes.Equal(LastBlock.Hash[:], block.PrevHash[:]) {
-			fmt.Println("found a parent")
-			NewBlock(block)
-			OrphanBlocks = append(OrphanBlocks[:i], OrphanBlocks[i+1:]...)
+	for i := 0; i < len(orphanBlocks); i++ {
+		block := orphanBlocks[i]
+		if _, ok := index[block.PrevHash]; !ok {
+			continue
 		}
+		fmt.Println("found a parent")
+		orphanBlocks = append(orphanBlocks[:i], orphanBlocks[i+1:]...)
+		err := newBlock(block)
+		if err != nil {
+			fmt.Println("orphan rejected:", err)
+		}
+		// newBlock may have taken more orphans, start over
+		i = -1
 	}
 }
 
 func getBlockFromHash(hash [32]byte) (*Block, error) {
 	hashHex := hex.EncodeToString(hash[:])
-	statement := "SELECT * FROM blockchain WHERE hash = $1"
+	statement := "SELECT " + blockColumns + " FROM blocks WHERE hash = $1"
 	return getBlockFromRow(db.QueryRow(statement, hashHex))
 }
 
 func getBlockFromHeight(height int) (*Block, error) {
-	statement := "SELECT * FROM blockchain WHERE height = $1"
+	statement := "SELECT " + blockColumns + " FROM main_chain WHERE height = $1"
 	return getBlockFromRow(db.QueryRow(statement, height))
 }
 
+// GetNBlockHashesAfter returns up to n main chain hashes following start
 func GetNBlockHashesAfter(start [32]byte, n int) ([][32]byte, error) {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	return nBlockHashesAfter(start, n)
+}
+
+func nBlockHashesAfter(start [32]byte, n int) ([][32]byte, error) {
 	blocks := make([][32]byte, 0)
-	startBlock, err := getBlockFromHash(start)
-	if err != nil {
-		return nil, err
+	startNode, ok := index[start]
+	if !ok || !inBestChain(startNode) {
+		return nil, errors.New("block not in main chain")
 	}
-	startHeight := startBlock.Height + 1
-	stopHeight := startHeight + n
-	for i := startHeight; i < stopHeight; i++ {
-		block, err := getBlockFromHeight(i)
-		if err != nil {
-			break
-		}
-		blocks = append(blocks, block.Hash)
+	for i := startNode.height + 1; i < len(bestChain) && len(blocks) < n; i++ {
+		blocks = append(blocks, bestChain[i].hash)
 	}
 	return blocks, nil
 }
 
 func GetBlockAfter(hash [32]byte) (*Block, error) {
-	afterHash, err := GetNBlockHashesAfter(hash, 1)
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	afterHash, err := nBlockHashesAfter(hash, 1)
 	if err != nil {
 		return nil, err
 	}
@@ -245,26 +381,18 @@ func getBlockFromRow(row *sql.Row) (*Block, error) {
 	var merkleRootHex string
 	var height int
 	var version int
-	var tx int
-	err := row.Scan(&height, &version, &hashHex, &prevHashHex, &merkleRootHex, &block.Time, &block.Bits, &block.Nonce, &tx)
+	var status blockStatus
+	err := row.Scan(&hashHex, &prevHashHex, &height, &version, &merkleRootHex, &block.Time, &block.Bits, &block.Nonce, &status)
 	if err != nil {
 		return nil, err
 	}
 	block.Height = height
 	block.Version = version
-	hash, err := hex.DecodeString(hashHex)
-	prevHash, err := hex.DecodeString(prevHashHex)
-	if err != nil {
-		return nil, err
-	}
-	merkleRoot, err := hex.DecodeString(merkleRootHex)
+	err = decodeHashes(block, hashHex, prevHashHex, merkleRootHex)
 	if err != nil {
 		return nil, err
 	}
-	copy(block.Hash[:], hash)
-	copy(block.PrevHash[:], prevHash)
-	copy(block.MerkleRoot[:], merkleRoot)
-	if tx == 1 {
+	if status == statusDataStored || status == statusValid {
 		txFile, err := os.ReadFile(rootPath + "transactions/" + hashHex)
 		if err != nil {
 			return nil, err
@@ -278,13 +406,23 @@ func getBlockFromRow(row *sql.Row) (*Block, error) {
 	return block, nil
 }
 
-func verifyPoW(block *Block) bool {
-	target := compactToBig(uint32(block.Bits))
-	hashNum := hashToBig(block.Hash)
-	if hashNum.Cmp(target) > 0 {
-		return false
+func decodeHashes(block *Block, hashHex, prevHashHex, merkleRootHex string) error {
+	hash, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return err
+	}
+	prevHash, err := hex.DecodeString(prevHashHex)
+	if err != nil {
+		return err
 	}
-	return true
+	merkleRoot, err := hex.DecodeString(merkleRootHex)
+	if err != nil {
+		return err
+	}
+	copy(block.Hash[:], hash)
+	copy(block.PrevHash[:], prevHash)
+	copy(block.MerkleRoot[:], merkleRoot)
+	return nil
 }
 
 // CompactToBig converts a compact representation of a whole number N to an
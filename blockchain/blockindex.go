@@ -0,0 +1,270 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+)
+
+// blockNode is the in memory view of a header we know about, whether or
+// not it is on the main chain.
+type blockNode struct {
+	parent     *blockNode
+	hash       [32]byte
+	height     int
+	version    int
+	merkleRoot [32]byte
+	time       int
+	bits       int
+	nonce      int
+	// total work of the chain up to and including this block
+	workSum *big.Int
+	status  blockStatus
+}
+
+type blockStatus int
+
+const (
+	statusHeaderOnly blockStatus = iota
+	// transactions are stored on disk
+	statusDataStored
+	// transactions stored and the block passed full validation
+	statusValid
+	// the block or one of its ancestors broke a consensus rule
+	statusInvalid
+)
+
+func (n *blockNode) hasData() bool {
+	return n.status == statusDataStored || n.status == statusValid
+}
+
+// every header we know about
+var index = make(map[[32]byte]*blockNode)
+
+// nodes nothing builds on yet, one per known branch
+var tips = make(map[[32]byte]*blockNode)
+
+// the most-work chain, bestChain[h] is the block at height h
+var bestChain []*blockNode
+
+func newBlockNode(block *Block, parent *blockNode) *blockNode {
+	node := &blockNode{
+		parent:     parent,
+		hash:       block.Hash,
+		version:    block.Version,
+		merkleRoot: block.MerkleRoot,
+		time:       block.Time,
+		bits:       block.Bits,
+		nonce:      block.Nonce,
+		workSum:    calcWork(uint32(block.Bits)),
+	}
+	if parent != nil {
+		node.height = parent.height + 1
+		node.workSum.Add(node.workSum, parent.workSum)
+	}
+	return node
+}
+
+func addToIndex(node *blockNode) {
+	index[node.hash] = node
+	if node.parent != nil {
+		delete(tips, node.parent.hash)
+	}
+	tips[node.hash] = node
+}
+
+// header returns the node as a header-only block
+func (n *blockNode) header() *Block {
+	block := &Block{
+		Height:     n.height,
+		Version:    n.version,
+		Hash:       n.hash,
+		MerkleRoot: n.merkleRoot,
+		Time:       n.time,
+		Bits:       n.bits,
+		Nonce:      n.nonce,
+	}
+	if n.parent != nil {
+		block.PrevHash = n.parent.hash
+	}
+	return block
+}
+
+// ancestor returns the block at height on n's branch
+func (n *blockNode) ancestor(height int) *blockNode {
+	if height < 0 || height > n.height {
+		return nil
+	}
+	node := n
+	for node != nil && node.height != height {
+		// the main chain can be indexed directly
+		if inBestChain(node) {
+			return bestChain[height]
+		}
+		node = node.parent
+	}
+	return node
+}
+
+func inBestChain(node *blockNode) bool {
+	return node.height < len(bestChain) && bestChain[node.height] == node
+}
+
+func bestTip() *blockNode {
+	if len(bestChain) == 0 {
+		return nil
+	}
+	return bestChain[len(bestChain)-1]
+}
+
+// findFork returns the last block a and b have in common
+func findFork(a, b *blockNode) *blockNode {
+	if a.height > b.height {
+		a = a.ancestor(b.height)
+	} else if b.height > a.height {
+		b = b.ancestor(a.height)
+	}
+	for a != b {
+		a = a.parent
+		b = b.parent
+	}
+	return a
+}
+
+// calcWork is the expected number of hashes to find a block with the
+// given bits, 2^256 / (target+1)
+func calcWork(bits uint32) *big.Int {
+	target := compactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(target, bigOne)
+	return new(big.Int).Div(oneLsh256, denominator)
+}
+
+var bigOne = big.NewInt(1)
+
+var oneLsh256 = new(big.Int).Lsh(bigOne, 256)
+
+// BlockLocator lists hashes from the block from back to the genesis
+// block, the first 10 one by one and then twice as far apart each time, so
+// a peer can find where its chain forks from ours. An unknown from starts
+// at the tip of the main chain.
+func BlockLocator(from [32]byte) [][32]byte {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	node, ok := index[from]
+	if !ok {
+		node = bestTip()
+	}
+	locator := make([][32]byte, 0, 32)
+	step := 1
+	for node != nil {
+		locator = append(locator, node.hash)
+		if node.height == 0 {
+			break
+		}
+		if len(locator) >= 10 {
+			step *= 2
+		}
+		height := node.height - step
+		if height < 0 {
+			height = 0
+		}
+		node = node.ancestor(height)
+	}
+	return locator
+}
+
+// BlockHeight returns the height of the block hash if we have its header
+func BlockHeight(hash [32]byte) (int, bool) {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	node, ok := index[hash]
+	if !ok {
+		return 0, false
+	}
+	return node.height, true
+}
+
+// BestHeight is the height of the last block connected to the UTXO set
+func BestHeight() int {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	if utxoTip == nil {
+		return 0
+	}
+	return utxoTip.height
+}
+
+// HaveBlock tells whether we have the header of hash, valid or not
+func HaveBlock(hash [32]byte) bool {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	_, ok := index[hash]
+	return ok
+}
+
+// MissingBlocks returns the headers of main chain blocks we have no data
+// for, looking at most window blocks past the last connected one so
+// downloads can't run too far ahead of validation.
+func MissingBlocks(window int) []*Block {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	missing := make([]*Block, 0)
+	if utxoTip == nil {
+		return missing
+	}
+	end := utxoTip.height + window
+	if end > len(bestChain)-1 {
+		end = len(bestChain) - 1
+	}
+	for height := utxoTip.height + 1; height <= end; height++ {
+		node := bestChain[height]
+		if !node.hasData() {
+			missing = append(missing, node.header())
+		}
+	}
+	return missing
+}
+
+// LocateBlocks returns up to max main chain headers following the first
+// locator hash that is on our main chain, or following the genesis block
+// if none are, ending early after stop. An empty locator asks for stop alone.
+func LocateBlocks(locator [][32]byte, stop [32]byte, max int) []*Block {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	blocks := make([]*Block, 0)
+	if len(locator) == 0 {
+		if node, ok := index[stop]; ok {
+			blocks = append(blocks, node.header())
+		}
+		return blocks
+	}
+	start := 1
+	for _, hash := range locator {
+		if node, ok := index[hash]; ok && inBestChain(node) {
+			start = node.height + 1
+			break
+		}
+	}
+	for height := start; height < len(bestChain) && len(blocks) < max; height++ {
+		node := bestChain[height]
+		blocks = append(blocks, node.header())
+		if node.hash == stop {
+			break
+		}
+	}
+	return blocks
+}
+
+// FetchBlock loads the block hash with its transactions, failing if we
+// only have its header
+func FetchBlock(hash [32]byte) (*Block, error) {
+	chainLock.RLock()
+	defer chainLock.RUnlock()
+	node, ok := index[hash]
+	if !ok || !node.hasData() {
+		return nil, errors.New("block data not found")
+	}
+	return getBlockFromHash(hash)
+}
@@ -0,0 +1,131 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/singurty/goldchain/chainparams"
+	"github.com/singurty/goldchain/script"
+)
+
+// startTestChain starts a regtest chain with only the genesis block in a
+// temporary directory. Start expects a fresh process so whatever an
+// earlier test left in memory is dropped first.
+func startTestChain(t *testing.T) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	index = make(map[[32]byte]*blockNode)
+	tips = make(map[[32]byte]*blockNode)
+	bestChain = nil
+	orphanBlocks = make([]*Block, 0)
+	utxos = &utxoCache{entries: make(map[OutPoint]*cacheEntry)}
+	utxoTip = nil
+	lastBlock = nil
+	pendingEvents = nil
+	Start(&chainparams.RegTestParams)
+	t.Cleanup(func() {
+		if err := Stop(); err != nil {
+			t.Error(err)
+		}
+		params = nil
+	})
+}
+
+// coinbaseTx pays value at height to OP_TRUE
+func coinbaseTx(height int, value int64) *Transaction {
+	return &Transaction{
+		Version: 1,
+		Inputs: []*TxIn{{
+			PrevTxIndex: math.MaxUint32,
+			// coinbase scripts need two bytes at least
+			Script:   append(script.NumberScript(int64(height)), 0x00, 0x00),
+			Sequence: [4]byte{0xff, 0xff, 0xff, 0xff},
+		}},
+		Outputs: []*TxOut{{Value: int(value), Script: []byte{script.OP_TRUE}}},
+	}
+}
+
+// mineBlock solves a block on parent with a coinbase paying coinbaseValue
+// followed by txs
+func mineBlock(parent *Block, height int, coinbaseValue int64, txs ...*Transaction) *Block {
+	block := &Block{
+		Version:      4,
+		PrevHash:     parent.Hash,
+		Time:         parent.Time + 1,
+		Bits:         int(chainparams.RegTestParams.PowLimitBits),
+		Transactions: append([]*Transaction{coinbaseTx(height, coinbaseValue)}, txs...),
+	}
+	txids := make([][32]byte, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		txids = append(txids, tx.TxID())
+	}
+	block.MerkleRoot, _ = calcMerkleRoot(txids)
+	target := compactToBig(uint32(block.Bits))
+	for {
+		block.Hash = block.GetHash()
+		if hashToBig(block.Hash).Cmp(target) <= 0 {
+			return block
+		}
+		block.Nonce++
+	}
+}
+
+// extendChain mines n blocks paying the full subsidy on top of the tip
+func extendChain(t *testing.T, n int) []*Block {
+	t.Helper()
+	blocks := make([]*Block, 0, n)
+	for i := 0; i < n; i++ {
+		tip := LastBlock()
+		height := BestHeight() + 1
+		block := mineBlock(tip, height, CalcBlockSubsidy(height))
+		if err := NewBlock(block); err != nil {
+			t.Fatalf("block %v: %v", height, err)
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func requireRuleError(t *testing.T, err error, code ErrorCode) {
+	t.Helper()
+	var ruleErr RuleError
+	if !errors.As(err, &ruleErr) || ruleErr.ErrorCode != code {
+		t.Fatalf("got %v, want %v", err, code)
+	}
+}
+
+// a block that failed to connect stays invalid when it is sent again and
+// whoever sends it is told so
+func TestKnownInvalidBlock(t *testing.T) {
+	startTestChain(t)
+	blocks := extendChain(t, 1)
+
+	bad := mineBlock(blocks[0], 2, CalcBlockSubsidy(2)+1)
+	err := NewBlock(bad)
+	requireRuleError(t, err, ErrBadCoinbaseValue)
+	if err.(RuleError).Hash != bad.Hash {
+		t.Errorf("error names %x, want %x", err.(RuleError).Hash, bad.Hash)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = NewBlock(mineBlock(blocks[0], 2, CalcBlockSubsidy(2)+1))
+		requireRuleError(t, err, ErrKnownInvalid)
+		// the header alone too
+		err = NewBlock(BlockFromHeader(&bad.ToWire().Header))
+		requireRuleError(t, err, ErrKnownInvalid)
+	}
+	if status := index[bad.Hash].status; status != statusInvalid {
+		t.Errorf("status %v, want invalid", status)
+	}
+	if height := BestHeight(); height != 1 {
+		t.Errorf("best height %v, want 1", height)
+	}
+	if LastBlock().Hash != blocks[0].Hash {
+		t.Errorf("tip %x, want %x", LastBlock().Hash, blocks[0].Hash)
+	}
+
+	// children of it are turned away as well
+	err = NewBlock(mineBlock(bad, 3, CalcBlockSubsidy(3)))
+	requireRuleError(t, err, ErrInvalidAncestor)
+}
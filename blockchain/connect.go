@@ -0,0 +1,333 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// utxoView collects the changes a block makes to the utxo set so nothing
+// reaches the cache unless the whole block connects. A nil entry is spent.
+type utxoView struct {
+	entries map[OutPoint]*UtxoEntry
+}
+
+func (v *utxoView) fetch(op OutPoint) (*UtxoEntry, error) {
+	if entry, ok := v.entries[op]; ok {
+		return entry, nil
+	}
+	return utxos.fetch(op)
+}
+
+func (v *utxoView) commit() {
+	for op, entry := range v.entries {
+		if entry == nil {
+			utxos.spend(op)
+		} else {
+			utxos.add(op, entry)
+		}
+	}
+}
+
+// checkConnectBlock runs every consensus check on block as the child of
+// utxoTip and returns the utxo changes connecting it makes along with what
+// it spends, in spending order.
+func checkConnectBlock(node *blockNode, block *Block) (*utxoView, []spentOutput, error) {
+	err := checkBlockSanity(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = checkBlockContext(node, block)
+	if err != nil {
+		return nil, nil, err
+	}
+	// before BIP34 a coinbase could repeat an earlier one and overwrite
+	// outputs that weren't spent yet
+	checkOverwrite := node.height < params.BIP34Height
+	for _, hash := range params.BIP30Exceptions {
+		if node.hash == hash {
+			checkOverwrite = false
+		}
+	}
+	flags := scriptFlags(node)
+	checkScripts := !assumedValid(node)
+	view := &utxoView{entries: make(map[OutPoint]*UtxoEntry)}
+	spent := make([]spentOutput, 0)
+	var fees int64
+	sigOpCost := 0
+	// scripts are the expensive part so they run last, all at once
+	jobs := make([]scriptJob, 0)
+	for i, tx := range block.Transactions {
+		txid := tx.TxID()
+		if checkOverwrite {
+			for j := range tx.Outputs {
+				entry, err := view.fetch(OutPoint{Hash: txid, Index: uint32(j)})
+				if err != nil {
+					return nil, nil, err
+				}
+				if entry != nil {
+					return nil, nil, ruleError(ErrOverwriteTx, fmt.Sprintf("transaction %x overwrites unspent outputs", txid))
+				}
+			}
+		}
+		var prevOuts []*UtxoEntry
+		// the coinbase has nothing to spend
+		if i > 0 {
+			prevOuts = make([]*UtxoEntry, len(tx.Inputs))
+			for k, in := range tx.Inputs {
+				op := OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+				entry, err := view.fetch(op)
+				if err != nil {
+					return nil, nil, err
+				}
+				if entry == nil {
+					return nil, nil, ruleError(ErrMissingTxOut, fmt.Sprintf("transaction %x spends missing or spent output %x:%v", txid, op.Hash, op.Index))
+				}
+				prevOuts[k] = entry
+				spent = append(spent, spentOutput{OutPoint: op, Entry: *entry})
+				view.entries[op] = nil
+			}
+			fee, err := checkTxInputs(tx, prevOuts, node.height)
+			if err != nil {
+				return nil, nil, err
+			}
+			fees += fee
+			if fees > MaxMoney {
+				return nil, nil, ruleError(ErrBadTxOutValue, fmt.Sprintf("block fees are more than %v", int64(MaxMoney)))
+			}
+			err = checkSequenceLocks(tx, prevOuts, node)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		sigOpCost += txSigOpCost(tx, prevOuts, flags)
+		if sigOpCost > MaxBlockSigOpsCost {
+			return nil, nil, ruleError(ErrTooManySigOps, fmt.Sprintf("block sigop cost is more than the max of %v", MaxBlockSigOpsCost))
+		}
+		if i > 0 && checkScripts {
+			jobs = append(jobs, scriptJobs(tx, prevOuts)...)
+		}
+		for j, out := range tx.Outputs {
+			if isUnspendable(out.Script) {
+				continue
+			}
+			view.entries[OutPoint{Hash: txid, Index: uint32(j)}] = &UtxoEntry{
+				Value:    int64(out.Value),
+				Script:   out.Script,
+				Height:   node.height,
+				Coinbase: i == 0,
+			}
+		}
+	}
+	var coinbaseValue int64
+	for _, out := range block.Transactions[0].Outputs {
+		coinbaseValue += int64(out.Value)
+	}
+	maxValue := CalcBlockSubsidy(node.height) + fees
+	if coinbaseValue > maxValue {
+		return nil, nil, ruleError(ErrBadCoinbaseValue, fmt.Sprintf("coinbase pays %v, more than the subsidy and fees of %v", coinbaseValue, maxValue))
+	}
+	err = verifyScripts(jobs, flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	return view, spent, nil
+}
+
+// connectBlock spends the inputs of block and adds its outputs to the utxo
+// set. It has to be the block right after utxoTip.
+func connectBlock(node *blockNode, block *Block) error {
+	view, spent, err := checkConnectBlock(node, block)
+	if err != nil {
+		return err
+	}
+	// undo data has to be there before anything it reverses is flushed
+	err = storeUndo(node, spent)
+	if err != nil {
+		return err
+	}
+	view.commit()
+	utxoTip = node
+	pendingEvents = append(pendingEvents, chainEvent{block: block, connected: true})
+	if node.status != statusValid {
+		err = setBlockStatus(node, statusValid)
+		if err != nil {
+			return err
+		}
+	}
+	if len(utxos.entries) > maxUtxoCacheEntries {
+		return utxos.flush()
+	}
+	return nil
+}
+
+// ValidateBlock checks block against every consensus rule as the next
+// block of the chain without adding it. Proof of work isn't checked so
+// blocks can be tested before they are mined. Rules the block breaks come
+// back as a RuleError.
+func ValidateBlock(block *Block) error {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	if block.Hash == [32]byte{} {
+		block.Hash = block.GetHash()
+	}
+	if utxoTip == nil || block.PrevHash != utxoTip.hash {
+		return fmt.Errorf("block %x does not build on the tip of the utxo set", block.Hash)
+	}
+	err := checkBlockHeaderContext(block, utxoTip)
+	if err != nil {
+		return err
+	}
+	node := newBlockNode(block, utxoTip)
+	err = checkBlockData(block, node.height)
+	if err != nil {
+		return err
+	}
+	_, _, err = checkConnectBlock(node, block)
+	return err
+}
+
+// disconnectBlock takes utxoTip's outputs back out of the utxo set and
+// restores what it spent from its undo data.
+func disconnectBlock(node *blockNode) error {
+	block, err := getBlockFromHash(node.hash)
+	if err != nil {
+		return err
+	}
+	spent, err := fetchUndo(node)
+	if err != nil {
+		return err
+	}
+	// undo data is in spending order so walk everything backwards
+	pos := len(spent)
+	for i := len(block.Transactions) - 1; i >= 0; i-- {
+		tx := block.Transactions[i]
+		txid := tx.TxID()
+		for j, out := range tx.Outputs {
+			if isUnspendable(out.Script) {
+				continue
+			}
+			utxos.spend(OutPoint{Hash: txid, Index: uint32(j)})
+		}
+		if i == 0 {
+			continue
+		}
+		for k := len(tx.Inputs) - 1; k >= 0; k-- {
+			pos--
+			if pos < 0 {
+				return fmt.Errorf("undo data of block %x is missing spent outputs", node.hash)
+			}
+			entry := spent[pos].Entry
+			utxos.add(spent[pos].OutPoint, &entry)
+		}
+	}
+	if pos != 0 {
+		return fmt.Errorf("undo data of block %x has %v extra spent outputs", node.hash, pos)
+	}
+	utxoTip = node.parent
+	pendingEvents = append(pendingEvents, chainEvent{block: block})
+	return nil
+}
+
+// updateUtxoSet brings the utxo set to the main chain, disconnecting blocks
+// the last reorg left behind and connecting every block after utxoTip that
+// we have data for. Blocks that fail to connect are marked invalid and the
+// chain goes back to the best branch still valid.
+func updateUtxoSet() error {
+	if len(bestChain) == 0 {
+		return nil
+	}
+	if utxoTip == nil {
+		// the genesis coinbase can't be spent so there is nothing to add
+		utxoTip = bestChain[0]
+	}
+	for !inBestChain(utxoTip) {
+		err := disconnectBlock(utxoTip)
+		if err != nil {
+			return err
+		}
+	}
+	for utxoTip.height+1 < len(bestChain) {
+		node := bestChain[utxoTip.height+1]
+		if !node.hasData() {
+			break
+		}
+		block, err := getBlockFromHash(node.hash)
+		if err != nil {
+			return err
+		}
+		err = connectBlock(node, block)
+		var ruleErr RuleError
+		if errors.As(err, &ruleErr) {
+			fmt.Printf("block %x at height %v is invalid: %v\n", node.hash, node.height, err)
+			invalidErr := invalidateBlock(node)
+			if invalidErr != nil {
+				return invalidErr
+			}
+			invalidErr = setBestChain(bestValidTip())
+			if invalidErr != nil {
+				return invalidErr
+			}
+			// the chain we went back to may need blocks disconnected
+			invalidErr = updateUtxoSet()
+			if invalidErr != nil {
+				return invalidErr
+			}
+			ruleErr.Hash = node.hash
+			return ruleErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// invalidateBlock marks node and everything building on it invalid
+func invalidateBlock(node *blockNode) error {
+	err := setBlockStatus(node, statusInvalid)
+	if err != nil {
+		return err
+	}
+	for _, n := range index {
+		if n.height > node.height && n.status != statusInvalid && n.ancestor(node.height) == node {
+			err = setBlockStatus(n, statusInvalid)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bestValidTip returns the block with the most work that isn't invalid,
+// either a tip or the last valid block of a branch that went bad.
+func bestValidTip() *blockNode {
+	var best *blockNode
+	consider := func(node *blockNode) {
+		if best == nil || node.workSum.Cmp(best.workSum) > 0 {
+			best = node
+		}
+	}
+	for _, tip := range tips {
+		if tip.status != statusInvalid {
+			consider(tip)
+		}
+	}
+	for _, node := range index {
+		if node.status == statusInvalid && node.parent != nil && node.parent.status != statusInvalid {
+			consider(node.parent)
+		}
+	}
+	return best
+}
+
+// Stop writes out the utxo cache and closes the database
+func Stop() error {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	err := utxos.flush()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
@@ -0,0 +1,269 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+
+	"github.com/singurty/goldchain/script"
+)
+
+// spendTx spends OP_TRUE outputs into new OP_TRUE outputs of values
+func spendTx(ins []OutPoint, values ...int64) *Transaction {
+	tx := &Transaction{Version: 2}
+	for _, in := range ins {
+		tx.Inputs = append(tx.Inputs, &TxIn{PrevTxHash: in.Hash, PrevTxIndex: int(in.Index), Sequence: [4]byte{0xff, 0xff, 0xff, 0xff}})
+	}
+	for _, value := range values {
+		tx.Outputs = append(tx.Outputs, &TxOut{Value: int(value), Script: []byte{script.OP_TRUE}})
+	}
+	return tx
+}
+
+func coinbaseOut(block *Block) OutPoint {
+	return OutPoint{Hash: block.Transactions[0].TxID(), Index: 0}
+}
+
+// utxoSet writes out the cache and reads back the whole utxo set
+func utxoSet(t *testing.T) map[OutPoint]UtxoEntry {
+	t.Helper()
+	if err := utxos.flush(); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := db.Query("SELECT txid, vout, value, script, height, coinbase FROM utxos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	set := make(map[OutPoint]UtxoEntry)
+	for rows.Next() {
+		var txid string
+		var op OutPoint
+		var entry UtxoEntry
+		var coinbase int
+		if err := rows.Scan(&txid, &op.Index, &entry.Value, &entry.Script, &entry.Height, &coinbase); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := hex.Decode(op.Hash[:], []byte(txid)); err != nil {
+			t.Fatal(err)
+		}
+		entry.Coinbase = coinbase == 1
+		set[op] = entry
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return set
+}
+
+// diffUtxos reports what want has that got doesn't and the other way round
+func diffUtxos(t *testing.T, got, want map[OutPoint]UtxoEntry) {
+	t.Helper()
+	for op, entry := range want {
+		if have, ok := got[op]; !ok || !reflect.DeepEqual(have, entry) {
+			t.Errorf("%x:%v is %+v, want %+v", op.Hash, op.Index, got[op], entry)
+		}
+	}
+	for op, entry := range got {
+		if _, ok := want[op]; !ok {
+			t.Errorf("%x:%v is %+v, want it spent", op.Hash, op.Index, entry)
+		}
+	}
+}
+
+// a block spending a coinbase and an output made earlier in the block
+// changes the utxo set by exactly that, and disconnecting it puts every
+// output back the way it was from its undo data
+func TestConnectDisconnect(t *testing.T) {
+	startTestChain(t)
+	blocks := extendChain(t, 101)
+	before := utxoSet(t)
+	if len(before) != 101 {
+		t.Fatalf("%v outputs after 101 blocks, want 101", len(before))
+	}
+
+	subsidy := CalcBlockSubsidy(102)
+	funding := coinbaseOut(blocks[0])
+	a := spendTx([]OutPoint{funding}, 1e9, subsidy-1e9-1000)
+	b := spendTx([]OutPoint{{Hash: a.TxID(), Index: 0}}, 1e9-2000)
+	// unspendable outputs never go in the set
+	b.Outputs = append(b.Outputs, &TxOut{Value: 0, Script: []byte{opReturn, 0x01, 0x02}})
+	block := mineBlock(blocks[100], 102, subsidy+3000, a, b)
+	if err := NewBlock(block); err != nil {
+		t.Fatal(err)
+	}
+	after := utxoSet(t)
+
+	want := make(map[OutPoint]UtxoEntry)
+	for op, entry := range before {
+		want[op] = entry
+	}
+	delete(want, funding)
+	want[coinbaseOut(block)] = UtxoEntry{Value: subsidy + 3000, Script: []byte{script.OP_TRUE}, Height: 102, Coinbase: true}
+	want[OutPoint{Hash: a.TxID(), Index: 1}] = UtxoEntry{Value: subsidy - 1e9 - 1000, Script: []byte{script.OP_TRUE}, Height: 102}
+	want[OutPoint{Hash: b.TxID(), Index: 0}] = UtxoEntry{Value: 1e9 - 2000, Script: []byte{script.OP_TRUE}, Height: 102}
+	diffUtxos(t, after, want)
+
+	node := index[block.Hash]
+	spent, err := fetchUndo(node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSpent := []spentOutput{
+		{OutPoint: funding, Entry: before[funding]},
+		{OutPoint: OutPoint{Hash: a.TxID(), Index: 0}, Entry: UtxoEntry{Value: 1e9, Script: []byte{script.OP_TRUE}, Height: 102}},
+	}
+	if !reflect.DeepEqual(spent, wantSpent) {
+		t.Errorf("undo data %+v, want %+v", spent, wantSpent)
+	}
+
+	chainLock.Lock()
+	err = disconnectBlock(node)
+	chainLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if utxoTip != node.parent {
+		t.Errorf("utxo tip at height %v, want 101", utxoTip.height)
+	}
+	diffUtxos(t, utxoSet(t), before)
+
+	// and back again
+	chainLock.Lock()
+	err = connectBlock(node, block)
+	pendingEvents = nil
+	chainLock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffUtxos(t, utxoSet(t), after)
+
+	// a longer branch from block 101 takes the block back out by itself
+	fork1 := mineBlock(blocks[100], 102, subsidy-1)
+	fork2 := mineBlock(fork1, 103, CalcBlockSubsidy(103))
+	for _, fork := range []*Block{fork1, fork2} {
+		if err := NewBlock(fork); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if LastBlock().Hash != fork2.Hash {
+		t.Fatalf("tip %x, want the fork", LastBlock().Hash)
+	}
+	before[coinbaseOut(fork1)] = UtxoEntry{Value: subsidy - 1, Script: []byte{script.OP_TRUE}, Height: 102, Coinbase: true}
+	before[coinbaseOut(fork2)] = UtxoEntry{Value: CalcBlockSubsidy(103), Script: []byte{script.OP_TRUE}, Height: 103, Coinbase: true}
+	diffUtxos(t, utxoSet(t), before)
+}
+
+func TestCoinbaseValue(t *testing.T) {
+	startTestChain(t)
+	halving := []struct {
+		height  int
+		subsidy int64
+	}{
+		{0, 50e8},
+		{149, 50e8},
+		{150, 25e8},
+		{299, 25e8},
+		{300, 12.5e8},
+		{150 * 33, 0},
+		{150 * 64, 0},
+	}
+	for _, test := range halving {
+		if got := CalcBlockSubsidy(test.height); got != test.subsidy {
+			t.Errorf("subsidy at %v is %v, want %v", test.height, got, test.subsidy)
+		}
+	}
+
+	blocks := extendChain(t, 101)
+	subsidy := CalcBlockSubsidy(102)
+	tx := spendTx([]OutPoint{coinbaseOut(blocks[0])}, CalcBlockSubsidy(1)-5000)
+	requireRuleError(t, ValidateBlock(mineBlock(blocks[100], 102, subsidy+5001, tx)), ErrBadCoinbaseValue)
+	// BIP34 wants the height first in the coinbase
+	requireRuleError(t, ValidateBlock(mineBlock(blocks[100], 103, subsidy+5000, tx)), ErrBadCoinbaseHeight)
+	// less than allowed is fine, the rest is gone for good
+	if err := ValidateBlock(mineBlock(blocks[100], 102, subsidy, tx)); err != nil {
+		t.Error(err)
+	}
+	if err := NewBlock(mineBlock(blocks[100], 102, subsidy+5000, tx)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// redeemScript counts 3960 sigops without running any, each bare
+// OP_CHECKMULTISIG counts as 20 and they sit in a branch that isn't taken
+var redeemScript = append(append([]byte{script.OP_0, script.OP_IF}, bytes.Repeat([]byte{script.OP_CHECKMULTISIG}, 198)...), script.OP_ENDIF, script.OP_TRUE)
+
+// hash160 of redeemScript, worked out separately
+const redeemScriptHash = "4b2a6b9c81334abd3b532470f652f32fbd1401d6"
+
+// P2SH sigops only show up once the outputs being spent are known, so
+// they are counted while connecting
+func TestSigOpCost(t *testing.T) {
+	startTestChain(t)
+	blocks := extendChain(t, 101)
+	hash, _ := hex.DecodeString(redeemScriptHash)
+	p2sh := append(append([]byte{script.OP_HASH160, 20}, hash...), script.OP_EQUAL)
+	funding := spendTx([]OutPoint{coinbaseOut(blocks[0])}, 1e8, 1e8, 1e8, 1e8, 1e8, 1e8)
+	for _, out := range funding.Outputs {
+		out.Script = p2sh
+	}
+	tip := mineBlock(blocks[100], 102, CalcBlockSubsidy(102), funding)
+	if err := NewBlock(tip); err != nil {
+		t.Fatal(err)
+	}
+
+	spend := func(n int) *Transaction {
+		var ins []OutPoint
+		for i := 0; i < n; i++ {
+			ins = append(ins, OutPoint{Hash: funding.TxID(), Index: uint32(i)})
+		}
+		tx := spendTx(ins, int64(n)*1e8-1000)
+		for _, in := range tx.Inputs {
+			in.Script = append([]byte{script.OP_PUSHDATA1, byte(len(redeemScript))}, redeemScript...)
+		}
+		return tx
+	}
+	// 6*3960*4 is over 80000
+	requireRuleError(t, ValidateBlock(mineBlock(tip, 103, CalcBlockSubsidy(103), spend(6))), ErrTooManySigOps)
+	// 5*3960*4 isn't
+	if err := NewBlock(mineBlock(tip, 103, CalcBlockSubsidy(103), spend(5))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// before BIP34 a coinbase could repeat an earlier one, which is only
+// allowed once the earlier one is spent, or in the two blocks BIP30
+// lets off
+func TestBIP30(t *testing.T) {
+	startTestChain(t)
+	noBIP34 := *params
+	noBIP34.BIP34Height = 1 << 30
+	params = &noBIP34
+	blocks := extendChain(t, 101)
+
+	// the coinbase of block 1 again
+	dup := mineBlock(blocks[100], 1, CalcBlockSubsidy(1))
+	dup.Time = blocks[100].Time + 1
+	requireRuleError(t, ValidateBlock(dup), ErrOverwriteTx)
+	noBIP34.BIP30Exceptions = [][32]byte{dup.Hash}
+	if err := NewBlock(dup); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := FetchUtxo(coinbaseOut(dup))
+	if err != nil || entry == nil || entry.Height != 102 {
+		t.Fatalf("overwritten output is %+v, %v", entry, err)
+	}
+
+	// block 2's coinbase is spent so repeating it is fine
+	spend := mineBlock(dup, 103, CalcBlockSubsidy(103)+1000, spendTx([]OutPoint{coinbaseOut(blocks[1])}, CalcBlockSubsidy(2)-1000))
+	if err := NewBlock(spend); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBlock(mineBlock(spend, 2, CalcBlockSubsidy(2))); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := FetchUtxo(coinbaseOut(blocks[1])); entry == nil || entry.Height != 104 {
+		t.Errorf("repeated coinbase output is %+v, want it at height 104", entry)
+	}
+}
@@ -0,0 +1,250 @@
+package blockchain
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+)
+
+// blocks holds every header we know about whether or not it is on the main
+// chain, active_chain maps the heights of the main chain to hashes and
+// main_chain puts the two together. utxos is the set of unspent outputs as
+// of the block in state's utxo_tip and undo keeps what each connected block
+// spent so it can be disconnected.
+var schema = []string{
+	"CREATE TABLE IF NOT EXISTS blocks (hash TEXT PRIMARY KEY, prev_hash TEXT, height INTEGER, version INTEGER, merkle_root TEXT, time INTEGER, bits INTEGER, nonce INTEGER, chainwork TEXT, status INTEGER)",
+	"CREATE INDEX IF NOT EXISTS blocks_prev_hash ON blocks (prev_hash)",
+	"CREATE INDEX IF NOT EXISTS blocks_height ON blocks (height)",
+	"CREATE TABLE IF NOT EXISTS active_chain (height INTEGER PRIMARY KEY, hash TEXT NOT NULL)",
+	"CREATE VIEW IF NOT EXISTS main_chain AS SELECT blocks.* FROM active_chain JOIN blocks ON blocks.hash = active_chain.hash",
+	"CREATE TABLE IF NOT EXISTS utxos (txid TEXT, vout INTEGER, value INTEGER, script BLOB, height INTEGER, coinbase INTEGER, PRIMARY KEY (txid, vout))",
+	"CREATE TABLE IF NOT EXISTS undo (hash TEXT PRIMARY KEY, data BLOB)",
+	"CREATE TABLE IF NOT EXISTS state (key TEXT PRIMARY KEY, value TEXT)",
+}
+
+// bumped whenever stored block data changes format, kept in user_version
+const dbVersion = 1
+
+const blockColumns = "hash, prev_hash, height, version, merkle_root, time, bits, nonce, status"
+
+func createSchema() error {
+	for _, statement := range schema {
+		_, err := db.Exec(statement)
+		if err != nil {
+			return err
+		}
+	}
+	return migrateHeightKeyedTable()
+}
+
+// migrateHeightKeyedTable moves databases from when blocks were stored in
+// one table keyed by height over to the new schema.
+func migrateHeightKeyedTable() error {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'blockchain'").Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("migrating blockchain table...")
+	// databases from before chainwork was tracked
+	_, err = db.Exec("ALTER TABLE blockchain ADD COLUMN chainwork TEXT")
+	if err != nil && !strings.Contains(err.Error(), "duplicate column") {
+		return err
+	}
+	dbTx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	statements := []string{
+		fmt.Sprintf("INSERT OR IGNORE INTO blocks (hash, prev_hash, height, version, merkle_root, time, bits, nonce, chainwork, status) SELECT hash, prev_hash, height, version, merkle_root, time, bits, nonce, chainwork, CASE tx WHEN 1 THEN %d ELSE %d END FROM blockchain", statusDataStored, statusHeaderOnly),
+		"INSERT OR REPLACE INTO active_chain (height, hash) SELECT height, hash FROM blockchain",
+		"DROP TABLE blockchain",
+	}
+	for _, statement := range statements {
+		_, err = dbTx.Exec(statement)
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+	return dbTx.Commit()
+}
+
+// loadBlockIndex rebuilds the in memory index and main chain from the
+// database.
+func loadBlockIndex() error {
+	rows, err := db.Query("SELECT " + blockColumns + ", chainwork FROM blocks ORDER BY height")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	missingWork := make([]*blockNode, 0)
+	for rows.Next() {
+		block := &Block{}
+		var hashHex, prevHashHex, merkleRootHex string
+		var status blockStatus
+		var chainwork sql.NullString
+		err = rows.Scan(&hashHex, &prevHashHex, &block.Height, &block.Version, &merkleRootHex, &block.Time, &block.Bits, &block.Nonce, &status, &chainwork)
+		if err != nil {
+			return err
+		}
+		err = decodeHashes(block, hashHex, prevHashHex, merkleRootHex)
+		if err != nil {
+			return err
+		}
+		// parents always come first since they are lower
+		parent, ok := index[block.PrevHash]
+		if !ok && len(index) > 0 {
+			return fmt.Errorf("block %x at height %v has no parent", block.Hash, block.Height)
+		}
+		node := newBlockNode(block, parent)
+		node.status = status
+		if !chainwork.Valid {
+			missingWork = append(missingWork, node)
+		}
+		addToIndex(node)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	err = loadActiveChain()
+	if err != nil {
+		return err
+	}
+	if len(missingWork) == 0 {
+		return nil
+	}
+	fmt.Printf("computing chainwork for %v blocks...\n", len(missingWork))
+	dbTx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, node := range missingWork {
+		_, err = dbTx.Exec("UPDATE blocks SET chainwork = $1 WHERE hash = $2", workHex(node.workSum), hex.EncodeToString(node.hash[:]))
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+	return dbTx.Commit()
+}
+
+func loadActiveChain() error {
+	rows, err := db.Query("SELECT height, hash FROM active_chain ORDER BY height")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var height int
+		var hashHex string
+		err = rows.Scan(&height, &hashHex)
+		if err != nil {
+			return err
+		}
+		var hash [32]byte
+		_, err = hex.Decode(hash[:], []byte(hashHex))
+		if err != nil {
+			return err
+		}
+		node, ok := index[hash]
+		if !ok || node.height != height || height != len(bestChain) {
+			return fmt.Errorf("active chain is broken at height %v", height)
+		}
+		bestChain = append(bestChain, node)
+	}
+	return rows.Err()
+}
+
+func workHex(work *big.Int) string {
+	return fmt.Sprintf("%064x", work)
+}
+
+func insertBlock(node *blockNode) error {
+	statement := "INSERT INTO blocks (hash, prev_hash, height, version, merkle_root, time, bits, nonce, chainwork, status) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)"
+	block := node.header()
+	hashHex := hex.EncodeToString(block.Hash[:])
+	prevHashHex := hex.EncodeToString(block.PrevHash[:])
+	merkleRootHex := hex.EncodeToString(block.MerkleRoot[:])
+	_, err := db.Exec(statement, hashHex, prevHashHex, block.Height, block.Version, merkleRootHex, block.Time, block.Bits, block.Nonce, workHex(node.workSum), node.status)
+	return err
+}
+
+func setBlockStatus(node *blockNode, status blockStatus) error {
+	_, err := db.Exec("UPDATE blocks SET status = $1 WHERE hash = $2", status, hex.EncodeToString(node.hash[:]))
+	if err != nil {
+		return err
+	}
+	node.status = status
+	return nil
+}
+
+// updateActiveChain drops the main chain above fork and puts attach on top
+func updateActiveChain(fork *blockNode, attach []*blockNode) error {
+	dbTx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if fork != nil {
+		_, err = dbTx.Exec("DELETE FROM active_chain WHERE height > $1", fork.height)
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+	for _, node := range attach {
+		_, err = dbTx.Exec("INSERT INTO active_chain (height, hash) VALUES ($1, $2)", node.height, hex.EncodeToString(node.hash[:]))
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+	return dbTx.Commit()
+}
+
+// migrateBlockData drops stored block data from before dbVersion 1. Older
+// versions kept witnesses in one flat list per transaction, which can't be
+// put back on their inputs, and before that the parser misread segwit
+// transactions altogether. Whatever doesn't match its header again is
+// downloaded again.
+func migrateBlockData() error {
+	var version int
+	err := db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	if version >= dbVersion {
+		return nil
+	}
+	dropped := 0
+	for _, node := range index {
+		if !node.hasData() {
+			continue
+		}
+		block, err := getBlockFromHash(node.hash)
+		if err == nil {
+			err = checkBlockData(block, node.height)
+		}
+		if err == nil {
+			continue
+		}
+		err = setBlockStatus(node, statusHeaderOnly)
+		if err != nil {
+			return err
+		}
+		os.Remove(rootPath + "transactions/" + hex.EncodeToString(node.hash[:]))
+		dropped++
+	}
+	if dropped > 0 {
+		fmt.Printf("dropped stored data of %v blocks, they will be downloaded again\n", dropped)
+	}
+	_, err = db.Exec(fmt.Sprintf("PRAGMA user_version = %d", dbVersion))
+	return err
+}
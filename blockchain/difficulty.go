@@ -0,0 +1,93 @@
+package blockchain
+
+import (
+	"math/big"
+)
+
+// bigToCompact is the reverse of compactToBig, see it for the format.
+func bigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+	// Since the base for the exponent is 256, the exponent can be treated
+	// as the number of bytes.  So, shift the number right or left
+	// accordingly.  This is equivalent to:
+	// mantissa = mantissa / 256^(exponent-3)
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+
+	// When the mantissa already has the sign bit set, the number is too
+	// large to fit into the available 23-bits, so divide the number by 256
+	// and increment the exponent accordingly.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// calcNextRequiredDifficulty returns the bits a block with the given time
+// building on parent must have.
+func calcNextRequiredDifficulty(parent *blockNode, newBlockTime int) uint32 {
+	interval := params.BlocksPerRetarget()
+	// not a retarget block
+	if (parent.height+1)%interval != 0 {
+		if params.ReduceMinDifficulty {
+			// nothing found for a while, allow a min difficulty block
+			allowMinTime := parent.time + int(params.MinDiffReductionTime.Seconds())
+			if newBlockTime > allowMinTime {
+				return params.PowLimitBits
+			}
+			// otherwise use the difficulty of the last block that wasn't
+			// one of those
+			return findPrevTestNetDifficulty(parent)
+		}
+		return uint32(parent.bits)
+	}
+	if params.NoRetargeting {
+		return uint32(parent.bits)
+	}
+	first := parent.ancestor(parent.height - (interval - 1))
+	targetTimespan := int64(params.TargetTimespan.Seconds())
+	minTimespan := targetTimespan / params.RetargetAdjustmentFactor
+	maxTimespan := targetTimespan * params.RetargetAdjustmentFactor
+	actualTimespan := int64(parent.time - first.time)
+	if actualTimespan < minTimespan {
+		actualTimespan = minTimespan
+	} else if actualTimespan > maxTimespan {
+		actualTimespan = maxTimespan
+	}
+	// BIP94 starts from the first block of the period so the min
+	// difficulty blocks at the end of a testnet period don't stick
+	oldTarget := compactToBig(uint32(parent.bits))
+	if params.EnforceBIP94 {
+		oldTarget = compactToBig(uint32(first.bits))
+	}
+	newTarget := new(big.Int).Mul(oldTarget, big.NewInt(actualTimespan))
+	newTarget.Div(newTarget, big.NewInt(targetTimespan))
+	if newTarget.Cmp(params.PowLimit) > 0 {
+		newTarget.Set(params.PowLimit)
+	}
+	return bigToCompact(newTarget)
+}
+
+func findPrevTestNetDifficulty(start *blockNode) uint32 {
+	interval := params.BlocksPerRetarget()
+	node := start
+	for node.parent != nil && node.height%interval != 0 && uint32(node.bits) == params.PowLimitBits {
+		node = node.parent
+	}
+	return uint32(node.bits)
+}
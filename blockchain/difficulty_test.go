@@ -0,0 +1,187 @@
+package blockchain
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/singurty/goldchain/chainparams"
+)
+
+func useParams(t *testing.T, p *chainparams.Params) {
+	old := params
+	params = p
+	t.Cleanup(func() { params = old })
+}
+
+// testNodes links n headers ending at height last, the first at firstTime
+// and every one after it a block interval later, all with bits
+func testNodes(last, n int, firstTime int, bits uint32) *blockNode {
+	var node *blockNode
+	for height := last - n + 1; height <= last; height++ {
+		node = &blockNode{parent: node, height: height, time: firstTime + (height-last+n-1)*600, bits: int(bits)}
+	}
+	return node
+}
+
+// a retarget period starting at firstTime whose last block is at height
+// last and time lastTime
+func retargetPeriod(last int, firstTime, lastTime int, bits uint32) *blockNode {
+	node := testNodes(last, params.BlocksPerRetarget(), firstTime, bits)
+	node.time = lastTime
+	return node
+}
+
+// the same cases as bitcoind's pow_tests
+func TestRetarget(t *testing.T) {
+	useParams(t, &chainparams.MainNetParams)
+	tests := []struct {
+		name      string
+		firstTime int
+		height    int
+		lastTime  int
+		bits      uint32
+		want      uint32
+	}{
+		{"block 32256", 1261130161, 32255, 1262152739, 0x1d00ffff, 0x1d00d86a},
+		{"capped at the pow limit", 1231006505, 2015, 1233061996, 0x1d00ffff, 0x1d00ffff},
+		{"at most 4x harder", 1279008237, 68543, 1279297671, 0x1c05a3f4, 0x1c0168fd},
+		{"at most 4x easier", 1263163443, 46367, 1269211443, 0x1c387f6f, 0x1d00e1fd},
+	}
+	for _, test := range tests {
+		parent := retargetPeriod(test.height, test.firstTime, test.lastTime, test.bits)
+		if got := calcNextRequiredDifficulty(parent, test.lastTime+600); got != test.want {
+			t.Errorf("%v: got %#x, want %#x", test.name, got, test.want)
+		}
+	}
+
+	// anywhere else the bits stay the same however long it took
+	parent := testNodes(32254, 10, 1262152739, 0x1c05a3f4)
+	if got := calcNextRequiredDifficulty(parent, parent.time+100000); got != 0x1c05a3f4 {
+		t.Errorf("block 32255 got %#x, want the parent's", got)
+	}
+}
+
+func TestNoRetargeting(t *testing.T) {
+	useParams(t, &chainparams.RegTestParams)
+	parent := retargetPeriod(2015, 1296688602, 1296688602+1000000, 0x207fffff)
+	if got := calcNextRequiredDifficulty(parent, parent.time+1); got != 0x207fffff {
+		t.Errorf("got %#x, want the parent's bits", got)
+	}
+}
+
+// testnet lets a block 20 minutes after its parent use the minimum
+// difficulty, and the blocks after it go back to the last real difficulty
+func TestMinDifficulty(t *testing.T) {
+	useParams(t, &chainparams.TestNet3Params)
+	const real = 0x1c05a3f4
+	parent := testNodes(4100, 10, 1600000000, real)
+	if got := calcNextRequiredDifficulty(parent, parent.time+20*60); got != real {
+		t.Errorf("after exactly 20 minutes got %#x, want %#x", got, real)
+	}
+	if got := calcNextRequiredDifficulty(parent, parent.time+20*60+1); got != params.PowLimitBits {
+		t.Errorf("after 20 minutes got %#x, want the pow limit", got)
+	}
+
+	// a run of min difficulty blocks is skipped to find the real one
+	for i := 0; i < 3; i++ {
+		parent = &blockNode{parent: parent, height: parent.height + 1, time: parent.time + 1201, bits: int(params.PowLimitBits)}
+	}
+	if got := calcNextRequiredDifficulty(parent, parent.time+60); got != real {
+		t.Errorf("after min difficulty blocks got %#x, want %#x", got, real)
+	}
+
+	// but not past the start of the period
+	before := &blockNode{height: 4031, time: 1599999400, bits: real}
+	start := &blockNode{parent: before, height: 4032, time: 1600000000, bits: int(params.PowLimitBits)}
+	parent = &blockNode{parent: start, height: 4033, time: 1600000600, bits: int(params.PowLimitBits)}
+	if got := calcNextRequiredDifficulty(parent, parent.time+60); got != params.PowLimitBits {
+		t.Errorf("got %#x, want the first block of the period's bits", got)
+	}
+}
+
+// a period ending in a min difficulty block retargets from its first block
+// under BIP94, and before BIP94 from its last
+func TestBIP94(t *testing.T) {
+	const real = 0x1c05a3f4
+	for _, p := range []*chainparams.Params{&chainparams.TestNet3Params, &chainparams.TestNet4Params} {
+		useParams(t, p)
+		target := int(params.TargetTimespan.Seconds())
+		parent := retargetPeriod(4031, 1600000000, 1600000000+target, real)
+		parent.bits = int(params.PowLimitBits)
+		want := params.PowLimitBits
+		if params.EnforceBIP94 {
+			want = real
+		}
+		if got := calcNextRequiredDifficulty(parent, parent.time+600); got != want {
+			t.Errorf("%v: got %#x, want %#x", params.Name, got, want)
+		}
+	}
+}
+
+func TestPastMedianTime(t *testing.T) {
+	var node *blockNode
+	// the median of an even count is the later one, and past 11 blocks the
+	// oldest drop out
+	times := []int{10, 3, 7, 1, 9, 2, 8, 4, 6, 5, 11, 100, 200}
+	want := []int{10, 10, 7, 7, 7, 7, 7, 7, 6, 6, 6, 6, 7}
+	for i, time := range times {
+		node = &blockNode{parent: node, height: i, time: time}
+		if got := pastMedianTime(node); got != want[i] {
+			t.Errorf("height %v: got %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	tests := []struct {
+		compact uint32
+		n       string
+		// what the number goes back to, zero if the same
+		back uint32
+	}{
+		{0x00000000, "0", 0},
+		{0x00123456, "0", 0x00000000},
+		{0x01003456, "0", 0x00000000},
+		{0x02000056, "0", 0x00000000},
+		{0x03000000, "0", 0x00000000},
+		{0x04000000, "0", 0x00000000},
+		{0x00923456, "0", 0x00000000},
+		{0x01803456, "0", 0x00000000},
+		{0x02800056, "0", 0x00000000},
+		{0x03800000, "0", 0x00000000},
+		{0x04800000, "0", 0x00000000},
+		{0x01123456, "12", 0x01120000},
+		{0x02123456, "1234", 0x02123400},
+		{0x03123456, "123456", 0},
+		{0x04123456, "12345600", 0},
+		{0x04923456, "-12345600", 0},
+		{0x05009234, "92340000", 0},
+		{0x20123456, "1234560000000000000000000000000000000000000000000000000000000000", 0},
+		{0x01fedcba, "-7e", 0x01fe0000},
+		{0x1d00ffff, "ffff0000000000000000000000000000000000000000000000000000", 0},
+		{0x207fffff, "7fffff0000000000000000000000000000000000000000000000000000000000", 0},
+	}
+	for _, test := range tests {
+		want, _ := new(big.Int).SetString(test.n, 16)
+		n := compactToBig(test.compact)
+		if n.Cmp(want) != 0 {
+			t.Errorf("%#08x: got %x, want %v", test.compact, n, test.n)
+		}
+		back := test.back
+		if back == 0 && test.n != "0" {
+			back = test.compact
+		}
+		if got := bigToCompact(n); got != back {
+			t.Errorf("%v: got %#08x, want %#08x", test.n, got, back)
+		}
+	}
+
+	// a mantissa with its top bit set moves up a byte
+	n, _ := new(big.Int).SetString("80", 16)
+	if got := bigToCompact(n); got != 0x02008000 {
+		t.Errorf("0x80: got %#08x, want 0x02008000", got)
+	}
+	if got := bigToCompact(chainparams.MainNetParams.PowLimit); got != 0x1d00ffff {
+		t.Errorf("mainnet pow limit: got %#08x, want 0x1d00ffff", got)
+	}
+}
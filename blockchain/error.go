@@ -0,0 +1,175 @@
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+// ErrorCode says which consensus rule a block broke.
+type ErrorCode int
+
+const (
+	// bits doesn't match what the retarget rules require
+	ErrUnexpectedDifficulty ErrorCode = iota
+	// bits decodes to a target above the chain's proof of work limit
+	ErrPowLimit
+	// the hash is above the target
+	ErrHighHash
+	// timestamp not after the median of the previous 11 blocks
+	ErrTimeTooOld
+	// timestamp more than two hours ahead of us
+	ErrTimeTooNew
+	// first block of a period too far before the last of the previous (BIP94)
+	ErrTimewarpAttack
+	// a genesis block that isn't this chain's
+	ErrBadGenesis
+	// builds on a block we know is invalid
+	ErrInvalidAncestor
+	// a block we already found invalid, sent again
+	ErrKnownInvalid
+	// a block body without even a coinbase
+	ErrNoTransactions
+	// the transactions don't hash to the header's merkle root
+	ErrBadMerkleRoot
+	// the transaction list was padded with duplicates (CVE-2012-2459)
+	ErrDuplicateTx
+	// witness data in a block without a witness commitment
+	ErrUnexpectedWitness
+	// the coinbase witness isn't a single 32 byte reserved value
+	ErrBadWitnessNonceSize
+	// the witness commitment doesn't match the wtxids
+	ErrWitnessCommitmentMismatch
+	// spends an output that doesn't exist or is already spent
+	ErrMissingTxOut
+	// a version older than the soft forks active at its height require
+	ErrBlockVersionTooOld
+	// heavier than MaxBlockWeight
+	ErrBlockWeightTooHigh
+	// the first transaction isn't a coinbase
+	ErrFirstTxNotCoinbase
+	// a coinbase anywhere but first
+	ErrMultipleCoinbases
+	// coinbase scriptSig shorter than 2 or longer than 100 bytes
+	ErrBadCoinbaseScriptLen
+	// the coinbase doesn't start with the block height (BIP34)
+	ErrBadCoinbaseHeight
+	// the coinbase pays more than the subsidy and fees
+	ErrBadCoinbaseValue
+	// a transaction without inputs or outputs
+	ErrNoTxInputs
+	ErrNoTxOutputs
+	// an output or sum of outputs that is negative or above MaxMoney
+	ErrBadTxOutValue
+	// the same output spent twice by one transaction
+	ErrDuplicateTxInputs
+	// a transaction other than the coinbase spends the null outpoint
+	ErrBadTxInput
+	// a lock time that hasn't passed yet
+	ErrUnfinalizedTx
+	// a relative lock time that hasn't passed yet (BIP68)
+	ErrSequenceLock
+	// a transaction whose outputs are already unspent (BIP30)
+	ErrOverwriteTx
+	// spends a coinbase less than CoinbaseMaturity blocks old
+	ErrImmatureSpend
+	// outputs worth more than the inputs
+	ErrSpendTooHigh
+	// more than MaxBlockSigOpsCost
+	ErrTooManySigOps
+	// an input script fails
+	ErrScriptValidation
+	// a block at a checkpoint height that isn't the checkpoint
+	ErrBadCheckpoint
+	// a fork from below the last checkpoint
+	ErrForkTooOld
+	// a coinbase outside of a block
+	ErrLooseCoinbase
+	// an input script that passes consensus but fails the extra flags a
+	// loose transaction was checked under
+	ErrScriptPolicy
+)
+
+var errorCodeStrings = map[ErrorCode]string{
+	ErrUnexpectedDifficulty:      "ErrUnexpectedDifficulty",
+	ErrPowLimit:                  "ErrPowLimit",
+	ErrHighHash:                  "ErrHighHash",
+	ErrTimeTooOld:                "ErrTimeTooOld",
+	ErrTimeTooNew:                "ErrTimeTooNew",
+	ErrTimewarpAttack:            "ErrTimewarpAttack",
+	ErrBadGenesis:                "ErrBadGenesis",
+	ErrInvalidAncestor:           "ErrInvalidAncestor",
+	ErrKnownInvalid:              "ErrKnownInvalid",
+	ErrNoTransactions:            "ErrNoTransactions",
+	ErrBadMerkleRoot:             "ErrBadMerkleRoot",
+	ErrDuplicateTx:               "ErrDuplicateTx",
+	ErrUnexpectedWitness:         "ErrUnexpectedWitness",
+	ErrBadWitnessNonceSize:       "ErrBadWitnessNonceSize",
+	ErrWitnessCommitmentMismatch: "ErrWitnessCommitmentMismatch",
+	ErrMissingTxOut:              "ErrMissingTxOut",
+	ErrBlockVersionTooOld:        "ErrBlockVersionTooOld",
+	ErrBlockWeightTooHigh:        "ErrBlockWeightTooHigh",
+	ErrFirstTxNotCoinbase:        "ErrFirstTxNotCoinbase",
+	ErrMultipleCoinbases:         "ErrMultipleCoinbases",
+	ErrBadCoinbaseScriptLen:      "ErrBadCoinbaseScriptLen",
+	ErrBadCoinbaseHeight:         "ErrBadCoinbaseHeight",
+	ErrBadCoinbaseValue:          "ErrBadCoinbaseValue",
+	ErrNoTxInputs:                "ErrNoTxInputs",
+	ErrNoTxOutputs:               "ErrNoTxOutputs",
+	ErrBadTxOutValue:             "ErrBadTxOutValue",
+	ErrDuplicateTxInputs:         "ErrDuplicateTxInputs",
+	ErrBadTxInput:                "ErrBadTxInput",
+	ErrUnfinalizedTx:             "ErrUnfinalizedTx",
+	ErrSequenceLock:              "ErrSequenceLock",
+	ErrOverwriteTx:               "ErrOverwriteTx",
+	ErrImmatureSpend:             "ErrImmatureSpend",
+	ErrSpendTooHigh:              "ErrSpendTooHigh",
+	ErrTooManySigOps:             "ErrTooManySigOps",
+	ErrScriptValidation:          "ErrScriptValidation",
+	ErrBadCheckpoint:             "ErrBadCheckpoint",
+	ErrForkTooOld:                "ErrForkTooOld",
+	ErrLooseCoinbase:             "ErrLooseCoinbase",
+	ErrScriptPolicy:              "ErrScriptPolicy",
+}
+
+func (e ErrorCode) String() string {
+	if s, ok := errorCodeStrings[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// RuleError is returned for blocks that break consensus rules, as opposed
+// to database or other internal failures.
+type RuleError struct {
+	ErrorCode   ErrorCode
+	Description string
+	// the block that broke the rule. Adding a block can connect others
+	// that came before it so it isn't always the one being added.
+	Hash [32]byte
+}
+
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+func ruleError(c ErrorCode, desc string) RuleError {
+	return RuleError{ErrorCode: c, Description: desc}
+}
+
+// RejectCode is the reject message code peers are told the block failed
+// with.
+func (e RuleError) RejectCode() uint8 {
+	switch e.ErrorCode {
+	case ErrBlockVersionTooOld:
+		return wire.RejectObsolete
+	case ErrBadCheckpoint, ErrForkTooOld:
+		return wire.RejectCheckpoint
+	case ErrOverwriteTx:
+		return wire.RejectDuplicate
+	case ErrScriptPolicy:
+		return wire.RejectNonstandard
+	default:
+		return wire.RejectInvalid
+	}
+}
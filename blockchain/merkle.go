@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// witness commitments are an OP_RETURN output starting with these bytes
+var witnessCommitmentHeader = []byte{0x6a, 0x24, 0xaa, 0x21, 0xa9, 0xed}
+
+const witnessCommitmentSize = 38
+
+// calcMerkleRoot returns the merkle root of hashes. mutated is set when two
+// identical hashes are paired up, since duplicating the last transactions
+// of a block gives the same root (CVE-2012-2459).
+func calcMerkleRoot(hashes [][32]byte) (root [32]byte, mutated bool) {
+	if len(hashes) == 0 {
+		return [32]byte{}, false
+	}
+	level := make([][32]byte, len(hashes))
+	copy(level, hashes)
+	for len(level) > 1 {
+		for i := 0; i+1 < len(level); i += 2 {
+			if level[i] == level[i+1] {
+				mutated = true
+			}
+		}
+		// odd levels pair the last hash with itself
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([][32]byte, len(level)/2)
+		var pair [64]byte
+		for i := range next {
+			copy(pair[:32], level[2*i][:])
+			copy(pair[32:], level[2*i+1][:])
+			next[i] = doubleSha256(pair[:])
+		}
+		level = next
+	}
+	return level[0], mutated
+}
+
+// checkMerkleRoot makes sure the transactions are the ones the header
+// commits to.
+func checkMerkleRoot(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block does not contain any transactions")
+	}
+	txids := make([][32]byte, 0, len(block.Transactions))
+	for _, tx := range block.Transactions {
+		txids = append(txids, tx.TxID())
+	}
+	root, mutated := calcMerkleRoot(txids)
+	if root != block.MerkleRoot {
+		return ruleError(ErrBadMerkleRoot, fmt.Sprintf("block merkle root is invalid - block header indicates %x, but calculated value is %x", block.MerkleRoot, root))
+	}
+	if mutated {
+		return ruleError(ErrDuplicateTx, "block contains duplicate transactions")
+	}
+	return nil
+}
+
+// witnessCommitment returns the last commitment output of the coinbase
+func witnessCommitment(coinbase *Transaction) ([]byte, bool) {
+	for i := len(coinbase.Outputs) - 1; i >= 0; i-- {
+		script := coinbase.Outputs[i].Script
+		if len(script) >= witnessCommitmentSize && bytes.HasPrefix(script, witnessCommitmentHeader) {
+			return script[len(witnessCommitmentHeader):witnessCommitmentSize], true
+		}
+	}
+	return nil, false
+}
+
+// checkWitnessCommitment validates the BIP141 commitment to the wtxids of
+// the block. Blocks without one, and every block before segwit, can't have
+// witness data at all.
+func checkWitnessCommitment(block *Block, height int) error {
+	if len(block.Transactions) == 0 {
+		return nil
+	}
+	coinbase := block.Transactions[0]
+	commitment, ok := witnessCommitment(coinbase)
+	// before segwit a commitment is just another output
+	if !ok || height < params.SegwitHeight {
+		for _, tx := range block.Transactions {
+			if tx.HasWitness() {
+				return ruleError(ErrUnexpectedWitness, fmt.Sprintf("transaction %x has witness data but the block doesn't commit to any", tx.TxID()))
+			}
+		}
+		return nil
+	}
+	// the coinbase witness is the reserved value the commitment is salted with
+	if len(coinbase.Inputs) != 1 || len(coinbase.Inputs[0].Witness) != 1 || len(coinbase.Inputs[0].Witness[0]) != 32 {
+		return ruleError(ErrBadWitnessNonceSize, "coinbase witness reserved value must be a single 32 byte item")
+	}
+	wtxids := make([][32]byte, 0, len(block.Transactions))
+	// the coinbase can't commit to itself
+	wtxids = append(wtxids, [32]byte{})
+	for _, tx := range block.Transactions[1:] {
+		wtxids = append(wtxids, tx.WTxID())
+	}
+	witnessRoot, _ := calcMerkleRoot(wtxids)
+	expected := doubleSha256(append(witnessRoot[:], coinbase.Inputs[0].Witness[0]...))
+	if !bytes.Equal(commitment, expected[:]) {
+		return ruleError(ErrWitnessCommitmentMismatch, fmt.Sprintf("witness commitment does not match: computed %x, coinbase includes %x", expected, commitment))
+	}
+	return nil
+}
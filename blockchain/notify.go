@@ -0,0 +1,73 @@
+package blockchain
+
+import "sync"
+
+// chainEvent is a block joining or leaving the utxo set. Events are queued
+// while chainLock is held and only handed to the callbacks once it is
+// released so they can call back into the chain.
+type chainEvent struct {
+	block     *Block
+	connected bool
+}
+
+// queued by connectBlock and disconnectBlock, guarded by chainLock
+var pendingEvents []chainEvent
+
+var callbacks struct {
+	sync.Mutex
+	onConnected    []func(*Block)
+	onDisconnected []func([]*Block)
+}
+
+// OnBlockConnected registers f to be called with every block whose
+// transactions are added to the utxo set, in chain order
+func OnBlockConnected(f func(*Block)) {
+	callbacks.Lock()
+	defer callbacks.Unlock()
+	callbacks.onConnected = append(callbacks.onConnected, f)
+}
+
+// OnBlocksDisconnected registers f to be called with the blocks a reorg
+// takes back out of the utxo set. They come all at once and oldest first
+// so their transactions can be put back in the order they were mined.
+func OnBlocksDisconnected(f func([]*Block)) {
+	callbacks.Lock()
+	defer callbacks.Unlock()
+	callbacks.onDisconnected = append(callbacks.onDisconnected, f)
+}
+
+// takeEvents empties the queue, chainLock has to be held
+func takeEvents() []chainEvent {
+	events := pendingEvents
+	pendingEvents = nil
+	return events
+}
+
+// fireEvents runs the callbacks for events, chainLock must not be held
+func fireEvents(events []chainEvent) {
+	if len(events) == 0 {
+		return
+	}
+	callbacks.Lock()
+	onConnected := callbacks.onConnected
+	onDisconnected := callbacks.onDisconnected
+	callbacks.Unlock()
+	for i := 0; i < len(events); {
+		if events[i].connected {
+			for _, f := range onConnected {
+				f(events[i].block)
+			}
+			i++
+			continue
+		}
+		// disconnects are queued tip first, a run of them goes out
+		// together the other way around
+		var blocks []*Block
+		for ; i < len(events) && !events[i].connected; i++ {
+			blocks = append([]*Block{events[i].block}, blocks...)
+		}
+		for _, f := range onDisconnected {
+			f(blocks)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/singurty/goldchain/script"
+)
+
+// how often script verification throughput is reported
+const scriptStatsInterval = 10 * time.Second
+
+// how many goroutines check scripts, set with SetScriptWorkers
+var scriptWorkers = runtime.NumCPU()
+
+// scriptJob is one input whose script needs checking
+type scriptJob struct {
+	tx       *Transaction
+	index    int
+	prevOuts []*UtxoEntry
+	hashes   *TxSigHashes
+}
+
+func (j *scriptJob) verify(flags script.Flags) error {
+	in := j.tx.Inputs[j.index]
+	checker := NewTxSigChecker(j.tx, j.index, j.prevOuts, j.hashes)
+	err := script.VerifyScript(in.Script, j.prevOuts[j.index].Script, in.Witness, flags, checker)
+	if err != nil {
+		return ruleError(ErrScriptValidation, fmt.Sprintf("input %v of transaction %x fails its script: %v", j.index, j.tx.TxID(), err))
+	}
+	return nil
+}
+
+// scriptStats adds up verification work between reports
+var scriptStats struct {
+	inputs  int
+	elapsed time.Duration
+	since   time.Time
+}
+
+// SetScriptWorkers sets how many inputs are checked at once. Zero uses
+// every CPU and a negative n leaves that many CPUs free.
+func SetScriptWorkers(n int) {
+	if n <= 0 {
+		n += runtime.NumCPU()
+	}
+	if n < 1 {
+		n = 1
+	}
+	scriptWorkers = n
+}
+
+// scriptJobs returns a job for every input of tx
+func scriptJobs(tx *Transaction, prevOuts []*UtxoEntry) []scriptJob {
+	hashes := NewTxSigHashes(tx, prevOuts)
+	jobs := make([]scriptJob, len(tx.Inputs))
+	for i := range tx.Inputs {
+		jobs[i] = scriptJob{tx: tx, index: i, prevOuts: prevOuts, hashes: hashes}
+	}
+	return jobs
+}
+
+// verifyScripts checks the jobs across scriptWorkers goroutines. Once one
+// fails the rest are dropped and that failure is returned.
+func verifyScripts(jobs []scriptJob, flags script.Flags) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+	start := time.Now()
+	defer func() {
+		recordScriptStats(len(jobs), time.Since(start))
+	}()
+	workers := scriptWorkers
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	if workers == 1 {
+		for i := range jobs {
+			err := jobs[i].verify(flags)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var next int64 = -1
+	var failed int32
+	var firstErr error
+	var once sync.Once
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(jobs) {
+					return
+				}
+				err := jobs[i].verify(flags)
+				if err != nil {
+					once.Do(func() {
+						firstErr = err
+						atomic.StoreInt32(&failed, 1)
+					})
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func recordScriptStats(inputs int, elapsed time.Duration) {
+	if scriptStats.since.IsZero() {
+		scriptStats.since = time.Now()
+	}
+	scriptStats.inputs += inputs
+	scriptStats.elapsed += elapsed
+	if time.Since(scriptStats.since) < scriptStatsInterval {
+		return
+	}
+	rate := float64(scriptStats.inputs) / scriptStats.elapsed.Seconds()
+	fmt.Printf("verified %v input scripts in %v, %.0f inputs/s with %v workers\n", scriptStats.inputs, scriptStats.elapsed.Round(time.Millisecond), rate, scriptWorkers)
+	scriptStats.inputs = 0
+	scriptStats.elapsed = 0
+	scriptStats.since = time.Now()
+}
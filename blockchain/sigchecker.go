@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"encoding/binary"
+
+	"github.com/singurty/goldchain/script"
+	"github.com/singurty/goldchain/secp256k1"
+)
+
+const (
+	// lock times below this are heights, above it timestamps
+	lockTimeThreshold = 500000000
+	// BIP68 sequence fields
+	sequenceLockTimeDisableFlag = 1 << 31
+	sequenceLockTimeTypeFlag    = 1 << 22
+	sequenceLockTimeMask        = 0x0000ffff
+)
+
+// TxSigChecker checks signatures and lock times for one input of a
+// transaction.
+type TxSigChecker struct {
+	tx       *Transaction
+	index    int
+	prevOuts []*UtxoEntry
+	hashes   *TxSigHashes
+}
+
+// NewTxSigChecker returns a checker for input index of tx, prevOuts are the
+// outputs every input spends and hashes comes from NewTxSigHashes.
+func NewTxSigChecker(tx *Transaction, index int, prevOuts []*UtxoEntry, hashes *TxSigHashes) *TxSigChecker {
+	return &TxSigChecker{tx: tx, index: index, prevOuts: prevOuts, hashes: hashes}
+}
+
+func (c *TxSigChecker) CheckECDSASignature(sig, pubKey, scriptCode []byte, sigVersion script.SigVersion) bool {
+	if len(sig) == 0 {
+		return false
+	}
+	key, err := secp256k1.ParsePubKey(pubKey)
+	if err != nil {
+		return false
+	}
+	hashType := uint32(sig[len(sig)-1])
+	parsed, ok := secp256k1.ParseDERSignature(sig[:len(sig)-1])
+	if !ok {
+		return false
+	}
+	var hash [32]byte
+	if sigVersion == script.SigVersionWitnessV0 {
+		hash = witnessV0SigHash(c.tx, c.index, scriptCode, c.prevOuts[c.index].Value, hashType, c.hashes)
+	} else {
+		hash = legacySigHash(c.tx, c.index, scriptCode, hashType)
+	}
+	return secp256k1.VerifyECDSA(key, hash[:], parsed)
+}
+
+func (c *TxSigChecker) CheckSchnorrSignature(sig, pubKey []byte, sigVersion script.SigVersion, data *script.TaprootData) bool {
+	if len(sig) != 64 && len(sig) != 65 {
+		return false
+	}
+	hashType := byte(script.SigHashDefault)
+	if len(sig) == 65 {
+		// an explicit SIGHASH_DEFAULT would make the same signature valid in two sizes
+		hashType = sig[64]
+		if hashType == script.SigHashDefault {
+			return false
+		}
+		sig = sig[:64]
+	}
+	hash, ok := taprootSigHash(c.tx, c.index, c.prevOuts, hashType, sigVersion, data, c.hashes)
+	if !ok {
+		return false
+	}
+	return secp256k1.VerifySchnorr(pubKey, hash[:], sig)
+}
+
+// CheckLockTime is BIP65, the transaction has to be locked to at least
+// lockTime of the same kind
+func (c *TxSigChecker) CheckLockTime(lockTime int64) bool {
+	txLockTime := int64(uint32(c.tx.LockTime))
+	if (txLockTime < lockTimeThreshold) != (lockTime < lockTimeThreshold) {
+		return false
+	}
+	if lockTime > txLockTime {
+		return false
+	}
+	// a final input ignores the lock time
+	return c.sequence() != 0xffffffff
+}
+
+// CheckSequence is BIP112, the input has to be relatively locked to at
+// least sequence of the same kind
+func (c *TxSigChecker) CheckSequence(sequence int64) bool {
+	txSequence := int64(c.sequence())
+	if uint32(c.tx.Version) < 2 {
+		return false
+	}
+	if txSequence&sequenceLockTimeDisableFlag != 0 {
+		return false
+	}
+	mask := int64(sequenceLockTimeTypeFlag | sequenceLockTimeMask)
+	txSequence &= mask
+	sequence &= mask
+	if (txSequence < sequenceLockTimeTypeFlag) != (sequence < sequenceLockTimeTypeFlag) {
+		return false
+	}
+	return sequence <= txSequence
+}
+
+func (c *TxSigChecker) CheckTapTweak(outputKey, internalKey []byte, merkleRoot [32]byte, parity bool) bool {
+	tweak := script.TaggedHash("TapTweak", internalKey, merkleRoot[:])
+	return secp256k1.CheckTweakAdd(outputKey, internalKey, tweak, parity)
+}
+
+func (c *TxSigChecker) sequence() uint32 {
+	return binary.LittleEndian.Uint32(c.tx.Inputs[c.index].Sequence[:])
+}
@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/singurty/goldchain/script"
+	"github.com/singurty/goldchain/wire"
+)
+
+// legacy signature hashes of SIGHASH_SINGLE without a matching output sign
+// this instead of failing, a bug every node has to keep
+var sigHashOne = [32]byte{1}
+
+// TxSigHashes holds the parts of signature hashes every input of a
+// transaction shares so they are only computed once.
+type TxSigHashes struct {
+	// single sha256 as taproot uses them, BIP143 hashes them once more
+	prevouts  [32]byte
+	sequences [32]byte
+	outputs   [32]byte
+	// taproot only, they need every spent output
+	amounts       [32]byte
+	scriptPubKeys [32]byte
+	hasPrevOuts   bool
+}
+
+// NewTxSigHashes prepares the shared hashes of tx, prevOuts are the outputs
+// its inputs spend in order.
+func NewTxSigHashes(tx *Transaction, prevOuts []*UtxoEntry) *TxSigHashes {
+	hashes := &TxSigHashes{}
+	var prevouts, sequences, outputs bytes.Buffer
+	for _, in := range tx.Inputs {
+		prevouts.Write(in.PrevTxHash[:])
+		binary.Write(&prevouts, binary.LittleEndian, uint32(in.PrevTxIndex))
+		sequences.Write(in.Sequence[:])
+	}
+	for _, out := range tx.Outputs {
+		writeTxOut(&outputs, out)
+	}
+	hashes.prevouts = sha256.Sum256(prevouts.Bytes())
+	hashes.sequences = sha256.Sum256(sequences.Bytes())
+	hashes.outputs = sha256.Sum256(outputs.Bytes())
+	if len(prevOuts) != len(tx.Inputs) {
+		return hashes
+	}
+	var amounts, scriptPubKeys bytes.Buffer
+	for _, prevOut := range prevOuts {
+		if prevOut == nil {
+			return hashes
+		}
+		binary.Write(&amounts, binary.LittleEndian, prevOut.Value)
+		wire.WriteVarBytes(&scriptPubKeys, prevOut.Script)
+	}
+	hashes.amounts = sha256.Sum256(amounts.Bytes())
+	hashes.scriptPubKeys = sha256.Sum256(scriptPubKeys.Bytes())
+	hashes.hasPrevOuts = true
+	return hashes
+}
+
+func writeTxOut(w *bytes.Buffer, out *TxOut) {
+	binary.Write(w, binary.LittleEndian, int64(out.Value))
+	wire.WriteVarBytes(w, out.Script)
+}
+
+// legacySigHash is the original signature hash, scriptCode goes in place
+// of the signed input's scriptSig.
+func legacySigHash(tx *Transaction, index int, scriptCode []byte, hashType uint32) [32]byte {
+	if index >= len(tx.Inputs) {
+		return sigHashOne
+	}
+	if hashType&0x1f == script.SigHashSingle && index >= len(tx.Outputs) {
+		return sigHashOne
+	}
+	msg := tx.ToWire()
+	scriptCode = script.RemoveCodeSeparators(scriptCode)
+	for i, in := range msg.TxIn {
+		in.Witness = nil
+		if i == index {
+			in.SignatureScript = scriptCode
+			continue
+		}
+		in.SignatureScript = nil
+		// the other inputs may change their sequence if the outputs aren't all signed
+		if hashType&0x1f == script.SigHashNone || hashType&0x1f == script.SigHashSingle {
+			in.Sequence = 0
+		}
+	}
+	switch hashType & 0x1f {
+	case script.SigHashNone:
+		msg.TxOut = nil
+	case script.SigHashSingle:
+		msg.TxOut = msg.TxOut[:index+1]
+		for i := 0; i < index; i++ {
+			msg.TxOut[i] = &wire.TxOut{Value: -1}
+		}
+	}
+	if hashType&script.SigHashAnyOneCanPay != 0 {
+		msg.TxIn = msg.TxIn[index : index+1]
+	}
+	var buf bytes.Buffer
+	msg.EncodeNoWitness(&buf)
+	binary.Write(&buf, binary.LittleEndian, hashType)
+	return doubleSha256(buf.Bytes())
+}
+
+// witnessV0SigHash is the BIP143 signature hash, which also signs the
+// amount being spent.
+func witnessV0SigHash(tx *Transaction, index int, scriptCode []byte, amount int64, hashType uint32, hashes *TxSigHashes) [32]byte {
+	var zero, hashPrevouts, hashSequence, hashOutputs [32]byte
+	anyoneCanPay := hashType&script.SigHashAnyOneCanPay != 0
+	baseType := hashType & 0x1f
+	if !anyoneCanPay {
+		hashPrevouts = sha256.Sum256(hashes.prevouts[:])
+	}
+	if !anyoneCanPay && baseType != script.SigHashSingle && baseType != script.SigHashNone {
+		hashSequence = sha256.Sum256(hashes.sequences[:])
+	}
+	if baseType != script.SigHashSingle && baseType != script.SigHashNone {
+		hashOutputs = sha256.Sum256(hashes.outputs[:])
+	} else if baseType == script.SigHashSingle && index < len(tx.Outputs) {
+		var out bytes.Buffer
+		writeTxOut(&out, tx.Outputs[index])
+		hashOutputs = doubleSha256(out.Bytes())
+	} else {
+		hashOutputs = zero
+	}
+	in := tx.Inputs[index]
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, int32(tx.Version))
+	buf.Write(hashPrevouts[:])
+	buf.Write(hashSequence[:])
+	buf.Write(in.PrevTxHash[:])
+	binary.Write(&buf, binary.LittleEndian, uint32(in.PrevTxIndex))
+	wire.WriteVarBytes(&buf, scriptCode)
+	binary.Write(&buf, binary.LittleEndian, amount)
+	buf.Write(in.Sequence[:])
+	buf.Write(hashOutputs[:])
+	binary.Write(&buf, binary.LittleEndian, uint32(tx.LockTime))
+	binary.Write(&buf, binary.LittleEndian, hashType)
+	return doubleSha256(buf.Bytes())
+}
+
+// taprootSigHash is the BIP341 signature hash, ok is false for hash types
+// that aren't defined or SIGHASH_SINGLE without a matching output.
+func taprootSigHash(tx *Transaction, index int, prevOuts []*UtxoEntry, hashType byte, sigVersion script.SigVersion, data *script.TaprootData, hashes *TxSigHashes) ([32]byte, bool) {
+	switch hashType {
+	case script.SigHashDefault, script.SigHashAll, script.SigHashNone, script.SigHashSingle,
+		script.SigHashAll | script.SigHashAnyOneCanPay,
+		script.SigHashNone | script.SigHashAnyOneCanPay,
+		script.SigHashSingle | script.SigHashAnyOneCanPay:
+	default:
+		return [32]byte{}, false
+	}
+	if !hashes.hasPrevOuts {
+		return [32]byte{}, false
+	}
+	outputType := hashType & 0x03
+	if hashType == script.SigHashDefault {
+		outputType = script.SigHashAll
+	}
+	anyoneCanPay := hashType&script.SigHashAnyOneCanPay != 0
+	var buf bytes.Buffer
+	// epoch
+	buf.WriteByte(0)
+	buf.WriteByte(hashType)
+	binary.Write(&buf, binary.LittleEndian, int32(tx.Version))
+	binary.Write(&buf, binary.LittleEndian, uint32(tx.LockTime))
+	if !anyoneCanPay {
+		buf.Write(hashes.prevouts[:])
+		buf.Write(hashes.amounts[:])
+		buf.Write(hashes.scriptPubKeys[:])
+		buf.Write(hashes.sequences[:])
+	}
+	if outputType != script.SigHashNone && outputType != script.SigHashSingle {
+		buf.Write(hashes.outputs[:])
+	}
+	var spendType byte
+	if sigVersion == script.SigVersionTapscript {
+		spendType = 2
+	}
+	if data.Annex != nil {
+		spendType |= 1
+	}
+	buf.WriteByte(spendType)
+	if anyoneCanPay {
+		in := tx.Inputs[index]
+		buf.Write(in.PrevTxHash[:])
+		binary.Write(&buf, binary.LittleEndian, uint32(in.PrevTxIndex))
+		binary.Write(&buf, binary.LittleEndian, prevOuts[index].Value)
+		wire.WriteVarBytes(&buf, prevOuts[index].Script)
+		buf.Write(in.Sequence[:])
+	} else {
+		binary.Write(&buf, binary.LittleEndian, uint32(index))
+	}
+	if data.Annex != nil {
+		var annex bytes.Buffer
+		wire.WriteVarBytes(&annex, data.Annex)
+		annexHash := sha256.Sum256(annex.Bytes())
+		buf.Write(annexHash[:])
+	}
+	if outputType == script.SigHashSingle {
+		if index >= len(tx.Outputs) {
+			return [32]byte{}, false
+		}
+		var out bytes.Buffer
+		writeTxOut(&out, tx.Outputs[index])
+		outHash := sha256.Sum256(out.Bytes())
+		buf.Write(outHash[:])
+	}
+	if sigVersion == script.SigVersionTapscript {
+		buf.Write(data.TapLeafHash[:])
+		// key version
+		buf.WriteByte(0)
+		binary.Write(&buf, binary.LittleEndian, data.CodeSepPos)
+	}
+	return script.TaggedHash("TapSighash", buf.Bytes()), true
+}
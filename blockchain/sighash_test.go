@@ -0,0 +1,204 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/singurty/goldchain/script"
+	"github.com/singurty/goldchain/wire"
+)
+
+func decodeTx(t *testing.T, s string) *Transaction {
+	t.Helper()
+	raw, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := &wire.TxMsg{}
+	if err := msg.Decode(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+	return TransactionFromWire(msg)
+}
+
+func mustDecodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// the examples in BIP143, sighashes as they are hashed, not reversed
+func TestWitnessV0SigHash(t *testing.T) {
+	// the 6 of 6 P2SH-P2WSH example signs with every hash type
+	multisigTx := "010000000136641869ca081e70f394c6948e8af409e18b619df2ed74aa106c1ca29787b96e0100000000ffffffff0200e9a435000000001976a914389ffce9cd9ae88dcc0631e88a821ffdbe9bfe2688acc0832f05000000001976a9147480a33f950689af511e6e84c138dbbd3c3ee41588ac00000000"
+	witnessScript := "56210307b8ae49ac90a048e9b53357a2354b3334e9c8bee813ecb98e99a7e07e8c3ba32103b28f0c28bfab54554ae8c658ac5c3e0ce6e79ad336331f78c428dd43eea8449b21034b8113d703413d57761b8b9781957b8c0ac1dfe69f492580ca4195f50376ba4a21033400f6afecb833092a9a21cfdf1ed1376e58c5d1f47de74683123987e967a8f42103a6d48b1131e94ba04d9737d61acdaa1322008af9602b3b14862c07a1789aac162102d8b661b0b3302ee2f162b09e07a55ad5dfbe673a9f01d9f0c19617681024306b56ae"
+	tests := []struct {
+		name       string
+		tx         string
+		index      int
+		scriptCode string
+		amount     int64
+		hashType   uint32
+		want       string
+	}{
+		{
+			name:       "native P2WPKH",
+			tx:         "0100000002fff7f7881a8099afa6940d42d1e7f6362bec38171ea3edf433541db4e4ad969f0000000000eeffffffef51e1b804cc89d182d279655c3aa89e815b1b309fe287d9b2b55d57b90ec68a0100000000ffffffff02202cb206000000001976a9148280b37df378db99f66f85c95a783a76ac7a6d5988ac9093510d000000001976a9143bde42dbee7e4dbe6a21b2d50ce2f0167faa815988ac11000000",
+			index:      1,
+			scriptCode: "76a9141d0f172a0ecb48aee1be1f2687d2963ae33f71a188ac",
+			amount:     600000000,
+			hashType:   script.SigHashAll,
+			want:       "c37af31116d1b27caf68aae9e3ac82f1477929014d5b917657d0eb49478cb670",
+		},
+		{
+			name:       "P2SH-P2WPKH",
+			tx:         "0100000001db6b1b20aa0fd7b23880be2ecbd4a98130974cf4748fb66092ac4d3ceb1a54770100000000feffffff02b8b4eb0b000000001976a914a457b684d7f0d539a46a45bbc043f35b59d0d96388ac0008af2f000000001976a914fd270b1ee6abcaea97fea7ad0402e8bd8ad6d77c88ac92040000",
+			index:      0,
+			scriptCode: "76a91479091972186c449eb1ded22b78e40d009bdf008988ac",
+			amount:     1000000000,
+			hashType:   script.SigHashAll,
+			want:       "64f3b0f4dd2bb3aa1ce8566d220cc74dda9df97d8490cc81d89d735c92e59fb6",
+		},
+		{"P2SH-P2WSH 6 of 6", multisigTx, 0, witnessScript, 987654321, script.SigHashAll, "185c0be5263dce5b4bb50a047973c1b6272bfbd0103a89444597dc40b248ee7c"},
+		{"P2SH-P2WSH 6 of 6", multisigTx, 0, witnessScript, 987654321, script.SigHashNone, "e9733bc60ea13c95c6527066bb975a2ff29a925e80aa14c213f686cbae5d2f36"},
+		{"P2SH-P2WSH 6 of 6", multisigTx, 0, witnessScript, 987654321, script.SigHashSingle, "1e1f1c303dc025bd664acb72e583e933fae4cff9148bf78c157d1e8f78530aea"},
+		{"P2SH-P2WSH 6 of 6", multisigTx, 0, witnessScript, 987654321, script.SigHashAll | script.SigHashAnyOneCanPay, "2a67f03e63a6a422125878b40b82da593be8d4efaafe88ee528af6e5a9955c6e"},
+		{"P2SH-P2WSH 6 of 6", multisigTx, 0, witnessScript, 987654321, script.SigHashNone | script.SigHashAnyOneCanPay, "781ba15f3779d5542ce8ecb5c18716733a5ee42a6f51488ec96154934e2c890a"},
+		{"P2SH-P2WSH 6 of 6", multisigTx, 0, witnessScript, 987654321, script.SigHashSingle | script.SigHashAnyOneCanPay, "511e8e52ed574121fc1b654970395502128263f62662e076dc6baf05c2e6a99b"},
+	}
+	for _, test := range tests {
+		tx := decodeTx(t, test.tx)
+		hashes := NewTxSigHashes(tx, nil)
+		got := witnessV0SigHash(tx, test.index, mustDecodeHex(t, test.scriptCode), test.amount, test.hashType, hashes)
+		if hex.EncodeToString(got[:]) != test.want {
+			t.Errorf("%v hash type %#x: got %x, want %v", test.name, test.hashType, got, test.want)
+		}
+	}
+}
+
+// sigHashTx has three inputs and two outputs so SIGHASH_SINGLE has an input
+// without an output. sigHashPrevOuts are what its inputs spend. The hashes
+// of it below come from a separate implementation of BIP341 and the
+// original algorithm, not from this code.
+const sigHashTx = "0200000003fd0183a807af08fe5dab52bca263d2fc087eef7c4d896f11a83889e0273a169a0300000000ffffffff885340f8f87a789dfe9c85d603fb5538351955822847098d72f0b865d3aed6020000000000feffffffbb6d6c886898122652dfc703cfe6126389cea9602724f6f5b20db9b953064aef07000000000500000002a0860100000000001600144291881b3ca0c7396fa02d4fa364b49568dee8ca00f90295000000002251200be707061e82913ed222214843da0a2cf4f2bb5092554cac0fa2c2839347950920a10700"
+
+func sigHashPrevOuts(t *testing.T) []*UtxoEntry {
+	return []*UtxoEntry{
+		{Value: 150000, Script: mustDecodeHex(t, "51208aff4e4562537de42662251490477486a66a1bccc2cd2d2c235bec0efcea97d3")},
+		{Value: 2000000000, Script: mustDecodeHex(t, "00147f206c9a9ac15f225168e3e143bf1b130e8b6546")},
+		{Value: 700000000, Script: mustDecodeHex(t, "76a914f57b656766d96077a708b8e3026aa580a875b55788ac")},
+	}
+}
+
+func TestLegacySigHash(t *testing.T) {
+	tx := decodeTx(t, sigHashTx)
+	// code separators are taken out, the one that's pushed stays
+	scriptCode := mustDecodeHex(t, "ab4c01ab76a914f57b656766d96077a708b8e3026aa580a875b55788ac")
+	tests := []struct {
+		index    int
+		hashType uint32
+		want     string
+	}{
+		{0, 0x01, "35a99e1ce79626037fd2b765a1775601b68dd505c7e9fcaf45f3673e54803be2"},
+		{0, 0x02, "a1bd88576706e3bd870d8502e6357adbfae11a1245c4697451df2305781da581"},
+		{0, 0x03, "36e1728cf9e349ce52dad1bf71858be98b92b0666132f25c8513ecc421df2bb5"},
+		{0, 0x81, "0f344fb14f7f131bdd776b225d021e3a4a152c0192fd3d07068fa781a593a043"},
+		{0, 0x82, "7d0b3a42ab9f6f4f8309f277d206cc90d368791f080b4a437dc824dbe08a693e"},
+		{0, 0x83, "9148124ee7e7aa7d8275c3d069c89ff7fa076be252c182b42aa7f197d7e6fc30"},
+		{1, 0x01, "e2173bb8b220c139453c53f5fb02463213965e7c2e4b88039c80db2307b3ded8"},
+		{1, 0x02, "74356b82888df2de384e7f138d29d8b409a8755be7667adc80292b4dcb9e4a6f"},
+		{1, 0x03, "e3ab04f78c0a7a28d384f2b2cf43fd6f46f8be7335af3e7ab856a5d44eb5702f"},
+		{1, 0x81, "a0c856d09377e63d7e6879ef760626fdaf796a533d9b59fd69f519b6c1b9f0d4"},
+		{1, 0x82, "f486c0782af960b9a235925b2bded021a8bbdbada01fa7338ab6ebd0dac24d24"},
+		{1, 0x83, "8ec4113cad7b22c068221f0ca1f4fdec27f526b7eec2a2f1ba73a92f1fea8c6a"},
+		{2, 0x01, "4eba69619e7f759a3d02f7a422a759051d8a0e4405ebb0f9ec394ec37a3c51f7"},
+		{2, 0x02, "6d2deb691ccaa956c86a20634ab23de956adbecb909884da71ba99d2162f7669"},
+		{2, 0x81, "40c17480d6fd9c60dcfcd092dd2770e6edeea2bd13604e598aac57cadd3e3659"},
+		{2, 0x82, "1b8821e73c4771fe1265b3744ee4fad8ba1c13fcb1c9e139f8f70a469681f659"},
+	}
+	for _, test := range tests {
+		got := legacySigHash(tx, test.index, scriptCode, test.hashType)
+		if hex.EncodeToString(got[:]) != test.want {
+			t.Errorf("input %v hash type %#x: got %x, want %v", test.index, test.hashType, got, test.want)
+		}
+	}
+
+	// SIGHASH_SINGLE without an output signs 1
+	for _, hashType := range []uint32{0x03, 0x83} {
+		if got := legacySigHash(tx, 2, scriptCode, hashType); got != sigHashOne {
+			t.Errorf("hash type %#x without an output: got %x", hashType, got)
+		}
+	}
+	if got := legacySigHash(tx, 3, scriptCode, script.SigHashAll); got != sigHashOne {
+		t.Errorf("input out of range: got %x", got)
+	}
+}
+
+func TestTaprootSigHash(t *testing.T) {
+	tx := decodeTx(t, sigHashTx)
+	prevOuts := sigHashPrevOuts(t)
+	hashes := NewTxSigHashes(tx, prevOuts)
+	annex := mustDecodeHex(t, "50deadbeef")
+	// the leaf is just OP_TRUE
+	var leafHash [32]byte
+	copy(leafHash[:], mustDecodeHex(t, "a85b2107f791b26a84e7586c28cec7cb61202ed3d01944d832500f363782d675"))
+	tests := []struct {
+		index      int
+		hashType   byte
+		sigVersion script.SigVersion
+		data       script.TaprootData
+		// empty when there's no signature hash
+		want string
+	}{
+		{0, 0x00, script.SigVersionTaproot, script.TaprootData{}, "d6910e7615110e58a0a3c2f89fa0d6978dea51e5bfb6baaf809c1bbd16b27979"},
+		{0, 0x01, script.SigVersionTaproot, script.TaprootData{}, "54f0eb83a0545e65c6299eaeb717d0bc46eef33c14561f0661c53eea82b27bc8"},
+		{0, 0x02, script.SigVersionTaproot, script.TaprootData{}, "39b4aec73f197d76a000d738b065093793b81e94c32269c3c4fa5aad06ced7ab"},
+		{0, 0x03, script.SigVersionTaproot, script.TaprootData{}, "c2644db1cdc4e4fe628fedec166232e2afa68921e076410cbb1d91cbec4a298a"},
+		{0, 0x81, script.SigVersionTaproot, script.TaprootData{}, "3ba03b1981ae2a388b6c2101db077a4f31adbf7a64e4fd9d30db0c0f8c48216f"},
+		{0, 0x82, script.SigVersionTaproot, script.TaprootData{}, "5f2af22e20db87b7d8c2d1c06c7a44bcf2e7f85df37f8aa1103889ba518de2bf"},
+		{0, 0x83, script.SigVersionTaproot, script.TaprootData{}, "224f6d6d2451d8c399f7e515815bafed9c2c64ad6c4252cd99f8cb4d4ff3567f"},
+		{0, 0x04, script.SigVersionTaproot, script.TaprootData{}, ""},
+		{0, 0x80, script.SigVersionTaproot, script.TaprootData{}, ""},
+		{0, 0x84, script.SigVersionTaproot, script.TaprootData{}, ""},
+		{2, 0x00, script.SigVersionTaproot, script.TaprootData{}, "4a3790237a841378f1c4a5eb389b46672a5d541ce6e77363dd828318e4a6b34c"},
+		{2, 0x01, script.SigVersionTaproot, script.TaprootData{}, "37c01ab4504df7b272cd43df22ca658b7ca0688a42915436e0e26a3cf4350c22"},
+		{2, 0x02, script.SigVersionTaproot, script.TaprootData{}, "10f13e60d676c2770ab5ba5351306b26780dfff8ab71033672abc0bd43232b55"},
+		{2, 0x81, script.SigVersionTaproot, script.TaprootData{}, "b5e42b6b9429e5c230a09508aa4f40ef9ef815a35cba0a211b023ab970281527"},
+		{2, 0x82, script.SigVersionTaproot, script.TaprootData{}, "935fd5946dff58bfd54fc8a1039f56ba13e443d2928f4e91c289fc2aedfa0bb3"},
+		// SIGHASH_SINGLE without an output fails instead of signing 1
+		{2, 0x03, script.SigVersionTaproot, script.TaprootData{}, ""},
+		{2, 0x83, script.SigVersionTaproot, script.TaprootData{}, ""},
+		{0, 0x00, script.SigVersionTaproot, script.TaprootData{Annex: annex}, "4567e7651d0c63a42da703c495f1af9af9ece63b683939860020965c524782a4"},
+		{0, 0x81, script.SigVersionTaproot, script.TaprootData{Annex: annex}, "ff0dad440381cf8e805541e1a3af02e5460884cc9447351625e4b0f081859228"},
+		{2, 0x00, script.SigVersionTaproot, script.TaprootData{Annex: annex}, "1390bd45b5e24f0a12e29cad0da72c46dd2ae7a97625409b4e1503b54c0d13ed"},
+		{2, 0x81, script.SigVersionTaproot, script.TaprootData{Annex: annex}, "2585eed2a1651e513977ddea4b264d933a551aed2994be62f38940e85dfdd48e"},
+		{0, 0x00, script.SigVersionTapscript, script.TaprootData{Annex: annex, TapLeafHash: leafHash, CodeSepPos: 3}, "d2575210fd62de566881e1575d3d0588008e6c851cbf97fd67f0ba4dbc85408d"},
+		{0, 0x81, script.SigVersionTapscript, script.TaprootData{Annex: annex, TapLeafHash: leafHash, CodeSepPos: 3}, "92c291a6f48aef675b4c29d2b00fe3adcd46fed65c3a143401bb7fce281739e6"},
+		{2, 0x00, script.SigVersionTapscript, script.TaprootData{Annex: annex, TapLeafHash: leafHash, CodeSepPos: 3}, "db84c6a5fc30cac75cf34402da1350d3d79acbf7fba3ad4e696cdf82d2eaed3a"},
+		{2, 0x81, script.SigVersionTapscript, script.TaprootData{Annex: annex, TapLeafHash: leafHash, CodeSepPos: 3}, "39af09659ebf2f53153321846d4be39c113275d9ab9a56e22e97da27c4766847"},
+	}
+	for _, test := range tests {
+		data := test.data
+		got, ok := taprootSigHash(tx, test.index, prevOuts, test.hashType, test.sigVersion, &data, hashes)
+		if test.want == "" {
+			if ok {
+				t.Errorf("input %v hash type %#x: got %x, want none", test.index, test.hashType, got)
+			}
+			continue
+		}
+		if !ok || hex.EncodeToString(got[:]) != test.want {
+			t.Errorf("input %v hash type %#x annex %v leaf %x: got %x %v, want %v",
+				test.index, test.hashType, data.Annex != nil, data.TapLeafHash, got, ok, test.want)
+		}
+	}
+
+	// taproot needs every spent output
+	partial := NewTxSigHashes(tx, prevOuts[:2])
+	if _, ok := taprootSigHash(tx, 0, prevOuts, 0x00, script.SigVersionTaproot, &script.TaprootData{}, partial); ok {
+		t.Error("signature hash without all the spent outputs")
+	}
+}
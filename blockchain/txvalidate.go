@@ -0,0 +1,314 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/singurty/goldchain/script"
+)
+
+const (
+	// coinbase outputs can't be spent for this many blocks
+	CoinbaseMaturity = 100
+	MaxBlockWeight   = 4000000
+	// legacy sigops cost 4, witness ones 1
+	MaxBlockSigOpsCost = 80000
+	WitnessScaleFactor = 4
+	// 21 million coins in satoshis
+	MaxMoney = 21000000 * 100000000
+	// the subsidy before any halving
+	baseSubsidy = 50 * 100000000
+	// time based BIP68 locks count in units of 512 seconds
+	sequenceLockTimeGranularity = 9
+)
+
+// CalcBlockSubsidy is what the coinbase at height may create on top of
+// the fees it collects.
+func CalcBlockSubsidy(height int) int64 {
+	halvings := height / params.SubsidyHalvingInterval
+	// shifting by 64 or more is undefined in C++ so Bitcoin stops here
+	if halvings >= 64 {
+		return 0
+	}
+	return baseSubsidy >> uint(halvings)
+}
+
+// checkTransactionSanity checks the rules a transaction has to follow on
+// its own, without looking at what it spends.
+func checkTransactionSanity(tx *Transaction) error {
+	txid := tx.TxID()
+	if len(tx.Inputs) == 0 {
+		return ruleError(ErrNoTxInputs, fmt.Sprintf("transaction %x has no inputs", txid))
+	}
+	if len(tx.Outputs) == 0 {
+		return ruleError(ErrNoTxOutputs, fmt.Sprintf("transaction %x has no outputs", txid))
+	}
+	var total int64
+	for _, out := range tx.Outputs {
+		value := int64(out.Value)
+		if value < 0 || value > MaxMoney {
+			return ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x has an output value of %v", txid, value))
+		}
+		total += value
+		if total > MaxMoney {
+			return ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x has outputs worth more than %v", txid, int64(MaxMoney)))
+		}
+	}
+	seen := make(map[OutPoint]bool, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		op := OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+		if seen[op] {
+			return ruleError(ErrDuplicateTxInputs, fmt.Sprintf("transaction %x spends %x:%v more than once", txid, op.Hash, op.Index))
+		}
+		seen[op] = true
+	}
+	if tx.IsCoinbase() {
+		size := len(tx.Inputs[0].Script)
+		if size < 2 || size > 100 {
+			return ruleError(ErrBadCoinbaseScriptLen, fmt.Sprintf("coinbase script is %v bytes, it must be 2 to 100", size))
+		}
+		return nil
+	}
+	for _, in := range tx.Inputs {
+		if in.PrevTxHash == [32]byte{} && uint32(in.PrevTxIndex) == 0xffffffff {
+			return ruleError(ErrBadTxInput, fmt.Sprintf("transaction %x spends the null outpoint", txid))
+		}
+	}
+	return nil
+}
+
+// isFinalTx tells whether tx's lock time has passed for a block at height
+// whose lock times are measured against blockTime
+func isFinalTx(tx *Transaction, height int, blockTime int64) bool {
+	lockTime := int64(uint32(tx.LockTime))
+	if lockTime == 0 {
+		return true
+	}
+	limit := blockTime
+	if lockTime < lockTimeThreshold {
+		limit = int64(height)
+	}
+	if lockTime < limit {
+		return true
+	}
+	// the lock time only counts if some input asks for it
+	for _, in := range tx.Inputs {
+		if binary.LittleEndian.Uint32(in.Sequence[:]) != 0xffffffff {
+			return false
+		}
+	}
+	return true
+}
+
+// checkTxInputs checks tx against the outputs it spends in a block at
+// height and returns the fee it pays.
+func checkTxInputs(tx *Transaction, prevOuts []*UtxoEntry, height int) (int64, error) {
+	txid := tx.TxID()
+	var in int64
+	for i, prevOut := range prevOuts {
+		if prevOut.Coinbase && height-prevOut.Height < CoinbaseMaturity {
+			return 0, ruleError(ErrImmatureSpend, fmt.Sprintf("transaction %x spends coinbase %x from height %v at height %v", txid, tx.Inputs[i].PrevTxHash, prevOut.Height, height))
+		}
+		if prevOut.Value < 0 || prevOut.Value > MaxMoney {
+			return 0, ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x spends an output worth %v", txid, prevOut.Value))
+		}
+		in += prevOut.Value
+		if in > MaxMoney {
+			return 0, ruleError(ErrBadTxOutValue, fmt.Sprintf("transaction %x spends more than %v", txid, int64(MaxMoney)))
+		}
+	}
+	var out int64
+	for _, txOut := range tx.Outputs {
+		out += int64(txOut.Value)
+	}
+	if in < out {
+		return 0, ruleError(ErrSpendTooHigh, fmt.Sprintf("transaction %x spends %v but its outputs are worth %v", txid, in, out))
+	}
+	return in - out, nil
+}
+
+// checkSequenceLocks enforces the BIP68 relative lock times of tx in the
+// block of node.
+func checkSequenceLocks(tx *Transaction, prevOuts []*UtxoEntry, node *blockNode) error {
+	if node.height < params.CSVHeight || uint32(tx.Version) < 2 {
+		return nil
+	}
+	// the last height and time at which tx is still locked
+	minHeight := -1
+	minTime := -1
+	for i, in := range tx.Inputs {
+		sequence := binary.LittleEndian.Uint32(in.Sequence[:])
+		if sequence&sequenceLockTimeDisableFlag != 0 {
+			continue
+		}
+		coinHeight := prevOuts[i].Height
+		lock := int(sequence & sequenceLockTimeMask)
+		if sequence&sequenceLockTimeTypeFlag != 0 {
+			// time locks start at the median time of the block before the coin
+			ancestorHeight := coinHeight - 1
+			if ancestorHeight < 0 {
+				ancestorHeight = 0
+			}
+			coinTime := pastMedianTime(node.ancestor(ancestorHeight))
+			if t := coinTime + lock<<sequenceLockTimeGranularity - 1; t > minTime {
+				minTime = t
+			}
+		} else if h := coinHeight + lock - 1; h > minHeight {
+			minHeight = h
+		}
+	}
+	if minHeight >= node.height || minTime >= pastMedianTime(node.parent) {
+		return ruleError(ErrSequenceLock, fmt.Sprintf("transaction %x is locked until after height %v or time %v", tx.TxID(), minHeight, minTime))
+	}
+	return nil
+}
+
+// legacySigOpCount counts sigops the way blocks were limited before
+// segwit, without looking at what the inputs spend.
+func legacySigOpCount(tx *Transaction) int {
+	count := 0
+	for _, in := range tx.Inputs {
+		count += script.SigOpCount(in.Script, false)
+	}
+	for _, out := range tx.Outputs {
+		count += script.SigOpCount(out.Script, false)
+	}
+	return count
+}
+
+// txSigOpCost is the cost tx adds towards MaxBlockSigOpsCost, prevOuts is
+// nil for the coinbase.
+func txSigOpCost(tx *Transaction, prevOuts []*UtxoEntry, flags script.Flags) int {
+	cost := legacySigOpCount(tx) * WitnessScaleFactor
+	if prevOuts == nil {
+		return cost
+	}
+	for i, in := range tx.Inputs {
+		prevScript := prevOuts[i].Script
+		if flags&script.VerifyP2SH != 0 && script.IsPayToScriptHash(prevScript) {
+			cost += script.P2SHSigOpCount(in.Script, prevScript) * WitnessScaleFactor
+		}
+		cost += script.WitnessSigOpCount(in.Script, prevScript, in.Witness, flags)
+	}
+	return cost
+}
+
+// scriptFlags picks the soft forks scripts in node's block are checked
+// under
+func scriptFlags(node *blockNode) script.Flags {
+	flags := script.VerifyP2SH
+	for _, hash := range params.BIP16Exceptions {
+		if node.hash == hash {
+			flags = 0
+		}
+	}
+	if node.height >= params.BIP66Height {
+		flags |= script.VerifyDERSig
+	}
+	if node.height >= params.BIP65Height {
+		flags |= script.VerifyCheckLockTimeVerify
+	}
+	if node.height >= params.CSVHeight {
+		flags |= script.VerifyCheckSequenceVerify
+	}
+	if node.height >= params.SegwitHeight {
+		flags |= script.VerifyWitness | script.VerifyNullDummy
+	}
+	if node.height >= params.TaprootHeight {
+		flags |= script.VerifyTaproot
+	}
+	return flags
+}
+
+// TxInfo is what CheckTransaction works out about a transaction on the way
+type TxInfo struct {
+	Fee       int64
+	SigOpCost int
+	// the outputs the inputs spend, in input order
+	PrevOuts []*UtxoEntry
+	// the height of the block it was checked as part of
+	Height int
+}
+
+// CheckTransaction checks a loose transaction against every consensus rule
+// as if it were in the block after the tip of the utxo set. Outputs are
+// looked up in pending before the utxo set, that is how a transaction can
+// spend ones that aren't confirmed yet, and those count as created in that
+// next block. An input found in neither fails with ErrMissingTxOut. Scripts
+// are also run under extraFlags, an input that only fails those comes back
+// as ErrScriptPolicy.
+func CheckTransaction(tx *Transaction, pending func(OutPoint) *UtxoEntry, extraFlags script.Flags) (*TxInfo, error) {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	if utxoTip == nil {
+		return nil, fmt.Errorf("no blocks are connected yet")
+	}
+	err := checkTransactionSanity(tx)
+	if err != nil {
+		return nil, err
+	}
+	txid := tx.TxID()
+	if tx.IsCoinbase() {
+		return nil, ruleError(ErrLooseCoinbase, fmt.Sprintf("transaction %x is a coinbase", txid))
+	}
+	node := newBlockNode(&Block{PrevHash: utxoTip.hash}, utxoTip)
+	// BIP113 is always applied to loose transactions whether or not it is
+	// active yet
+	if !isFinalTx(tx, node.height, int64(pastMedianTime(utxoTip))) {
+		return nil, ruleError(ErrUnfinalizedTx, fmt.Sprintf("transaction %x has lock time %v which hasn't passed at height %v", txid, uint32(tx.LockTime), node.height))
+	}
+	for i := range tx.Outputs {
+		entry, err := utxos.fetch(OutPoint{Hash: txid, Index: uint32(i)})
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			return nil, ruleError(ErrOverwriteTx, fmt.Sprintf("transaction %x is already confirmed", txid))
+		}
+	}
+	prevOuts := make([]*UtxoEntry, len(tx.Inputs))
+	for i, in := range tx.Inputs {
+		op := OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+		if pending != nil {
+			if entry := pending(op); entry != nil {
+				unconfirmed := *entry
+				unconfirmed.Height = node.height
+				prevOuts[i] = &unconfirmed
+				continue
+			}
+		}
+		entry, err := utxos.fetch(op)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			return nil, ruleError(ErrMissingTxOut, fmt.Sprintf("transaction %x spends missing or spent output %x:%v", txid, op.Hash, op.Index))
+		}
+		prevOuts[i] = entry
+	}
+	fee, err := checkTxInputs(tx, prevOuts, node.height)
+	if err != nil {
+		return nil, err
+	}
+	err = checkSequenceLocks(tx, prevOuts, node)
+	if err != nil {
+		return nil, err
+	}
+	flags := scriptFlags(node)
+	sigOpCost := txSigOpCost(tx, prevOuts, flags)
+	if sigOpCost > MaxBlockSigOpsCost {
+		return nil, ruleError(ErrTooManySigOps, fmt.Sprintf("transaction %x has a sigop cost of %v, more than the max of %v", txid, sigOpCost, MaxBlockSigOpsCost))
+	}
+	jobs := scriptJobs(tx, prevOuts)
+	err = verifyScripts(jobs, flags|extraFlags)
+	if err != nil && extraFlags != 0 {
+		// tell a peer breaking consensus apart from one with other policy
+		if verifyScripts(jobs, flags) == nil {
+			return nil, ruleError(ErrScriptPolicy, err.Error())
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &TxInfo{Fee: fee, SigOpCost: sigOpCost, PrevOuts: prevOuts, Height: node.height}, nil
+}
@@ -0,0 +1,196 @@
+package blockchain
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+)
+
+// how many entries the utxo cache holds before it is written out
+const maxUtxoCacheEntries = 500000
+
+// scripts longer than this can never be spent
+const maxScriptSize = 10000
+
+const opReturn = 0x6a
+
+type OutPoint struct {
+	Hash  [32]byte
+	Index uint32
+}
+
+type UtxoEntry struct {
+	Value    int64
+	Script   []byte
+	Height   int
+	Coinbase bool
+}
+
+// spentOutput is what undo data remembers about an output a block spent
+type spentOutput struct {
+	OutPoint OutPoint
+	Entry    UtxoEntry
+}
+
+type cacheEntry struct {
+	entry *UtxoEntry // nil once spent
+	// differs from the database
+	dirty bool
+	// not in the database at all, so spending it just drops it
+	fresh bool
+}
+
+// utxoCache sits in front of the utxos table, changes are only written
+// when it is flushed.
+type utxoCache struct {
+	entries map[OutPoint]*cacheEntry
+}
+
+var utxos = &utxoCache{entries: make(map[OutPoint]*cacheEntry)}
+
+// the last block whose transactions are in the utxo set
+var utxoTip *blockNode
+
+func (c *utxoCache) fetch(op OutPoint) (*UtxoEntry, error) {
+	if cached, ok := c.entries[op]; ok {
+		return cached.entry, nil
+	}
+	entry := &UtxoEntry{}
+	var coinbase int
+	err := db.QueryRow("SELECT value, script, height, coinbase FROM utxos WHERE txid = $1 AND vout = $2", hex.EncodeToString(op.Hash[:]), op.Index).Scan(&entry.Value, &entry.Script, &entry.Height, &coinbase)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry.Coinbase = coinbase == 1
+	c.entries[op] = &cacheEntry{entry: entry}
+	return entry, nil
+}
+
+func (c *utxoCache) add(op OutPoint, entry *UtxoEntry) {
+	cached, ok := c.entries[op]
+	if ok {
+		cached.entry = entry
+		cached.dirty = true
+		return
+	}
+	c.entries[op] = &cacheEntry{entry: entry, dirty: true, fresh: true}
+}
+
+func (c *utxoCache) spend(op OutPoint) {
+	cached, ok := c.entries[op]
+	if ok && cached.fresh {
+		delete(c.entries, op)
+		return
+	}
+	c.entries[op] = &cacheEntry{dirty: true}
+}
+
+// flush writes every change to the database along with the block the set
+// now reflects, so a crash never leaves the two out of step.
+func (c *utxoCache) flush() error {
+	dbTx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for op, cached := range c.entries {
+		if !cached.dirty {
+			continue
+		}
+		txid := hex.EncodeToString(op.Hash[:])
+		if cached.entry == nil {
+			_, err = dbTx.Exec("DELETE FROM utxos WHERE txid = $1 AND vout = $2", txid, op.Index)
+		} else {
+			var coinbase int
+			if cached.entry.Coinbase {
+				coinbase = 1
+			}
+			_, err = dbTx.Exec("INSERT OR REPLACE INTO utxos (txid, vout, value, script, height, coinbase) VALUES ($1, $2, $3, $4, $5, $6)", txid, op.Index, cached.entry.Value, cached.entry.Script, cached.entry.Height, coinbase)
+		}
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+	if utxoTip != nil {
+		_, err = dbTx.Exec("INSERT OR REPLACE INTO state (key, value) VALUES ('utxo_tip', $1)", hex.EncodeToString(utxoTip.hash[:]))
+		if err != nil {
+			dbTx.Rollback()
+			return err
+		}
+	}
+	err = dbTx.Commit()
+	if err != nil {
+		return err
+	}
+	c.entries = make(map[OutPoint]*cacheEntry)
+	return nil
+}
+
+// FetchUtxo returns the unspent output at op, or nil if it is spent or
+// never existed.
+func FetchUtxo(op OutPoint) (*UtxoEntry, error) {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	return utxos.fetch(op)
+}
+
+func IsUnspent(op OutPoint) bool {
+	chainLock.Lock()
+	defer chainLock.Unlock()
+	entry, err := utxos.fetch(op)
+	return err == nil && entry != nil
+}
+
+// isUnspendable tells whether an output can be left out of the utxo set
+func isUnspendable(script []byte) bool {
+	return (len(script) > 0 && script[0] == opReturn) || len(script) > maxScriptSize
+}
+
+func loadUtxoTip() error {
+	var hashHex string
+	err := db.QueryRow("SELECT value FROM state WHERE key = 'utxo_tip'").Scan(&hashHex)
+	// nothing connected yet
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var hash [32]byte
+	_, err = hex.Decode(hash[:], []byte(hashHex))
+	if err != nil {
+		return err
+	}
+	node, ok := index[hash]
+	if !ok {
+		return fmt.Errorf("utxo set is at unknown block %v", hashHex)
+	}
+	utxoTip = node
+	return nil
+}
+
+func storeUndo(node *blockNode, spent []spentOutput) error {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(spent)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO undo (hash, data) VALUES ($1, $2)", hex.EncodeToString(node.hash[:]), buf.Bytes())
+	return err
+}
+
+func fetchUndo(node *blockNode) ([]spentOutput, error) {
+	var data []byte
+	err := db.QueryRow("SELECT data FROM undo WHERE hash = $1", hex.EncodeToString(node.hash[:])).Scan(&data)
+	if err != nil {
+		return nil, err
+	}
+	spent := make([]spentOutput, 0)
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&spent)
+	return spent, err
+}
@@ -0,0 +1,190 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/singurty/goldchain/script"
+)
+
+// how far ahead of our clock a block's timestamp may be
+const maxTimeOffset = 2 * time.Hour
+
+// how many blocks the median time past is taken over
+const medianTimeBlocks = 11
+
+// BIP94 allows the first block of a period to go back at most this far
+const maxTimewarp = 600
+
+// checkProofOfWork makes sure the target is sane and the hash is below it.
+func checkProofOfWork(block *Block) error {
+	target := compactToBig(uint32(block.Bits))
+	if target.Sign() <= 0 {
+		return ruleError(ErrPowLimit, fmt.Sprintf("block target difficulty of %064x is too low", target))
+	}
+	if target.Cmp(params.PowLimit) > 0 {
+		return ruleError(ErrPowLimit, fmt.Sprintf("block target difficulty of %064x is higher than max of %064x", target, params.PowLimit))
+	}
+	if hashToBig(block.Hash).Cmp(target) > 0 {
+		return ruleError(ErrHighHash, fmt.Sprintf("block hash of %064x is higher than expected max of %064x", hashToBig(block.Hash), target))
+	}
+	return nil
+}
+
+// checkBlockHeaderContext checks the rules that depend on where in the
+// chain the block goes.
+func checkBlockHeaderContext(block *Block, parent *blockNode) error {
+	height := parent.height + 1
+	err := checkCheckpoints(block.Hash, height)
+	if err != nil {
+		return err
+	}
+	// each of these soft forks bumped the version blocks signal with
+	if (block.Version < 2 && height >= params.BIP34Height) ||
+		(block.Version < 3 && height >= params.BIP66Height) ||
+		(block.Version < 4 && height >= params.BIP65Height) {
+		return ruleError(ErrBlockVersionTooOld, fmt.Sprintf("block version %v is obsolete at height %v", block.Version, height))
+	}
+	requiredBits := calcNextRequiredDifficulty(parent, block.Time)
+	if uint32(block.Bits) != requiredBits {
+		return ruleError(ErrUnexpectedDifficulty, fmt.Sprintf("block difficulty of %08x is not the expected value of %08x", block.Bits, requiredBits))
+	}
+	medianTime := pastMedianTime(parent)
+	if block.Time <= medianTime {
+		return ruleError(ErrTimeTooOld, fmt.Sprintf("block timestamp of %v is not after expected %v", block.Time, medianTime))
+	}
+	maxTime := time.Now().Add(maxTimeOffset).Unix()
+	if int64(block.Time) > maxTime {
+		return ruleError(ErrTimeTooNew, fmt.Sprintf("block timestamp of %v is too far in the future", block.Time))
+	}
+	if params.EnforceBIP94 && (parent.height+1)%params.BlocksPerRetarget() == 0 {
+		if block.Time < parent.time-maxTimewarp {
+			return ruleError(ErrTimewarpAttack, fmt.Sprintf("block timestamp of %v is too far before the previous block's %v", block.Time, parent.time))
+		}
+	}
+	return nil
+}
+
+// checkCheckpoints makes sure a block at height matches the checkpoint
+// there, if any, and doesn't fork the chain below the last checkpoint we
+// have.
+func checkCheckpoints(hash [32]byte, height int) error {
+	for _, checkpoint := range params.Checkpoints {
+		if checkpoint.Height == height && checkpoint.Hash != hash {
+			return ruleError(ErrBadCheckpoint, fmt.Sprintf("block %x at height %v does not match checkpoint %x", hash, height, checkpoint.Hash))
+		}
+	}
+	last := lastCheckpoint()
+	if last != nil && height < last.height {
+		return ruleError(ErrForkTooOld, fmt.Sprintf("block %x at height %v forks the chain before the checkpoint at height %v", hash, height, last.height))
+	}
+	return nil
+}
+
+// lastCheckpoint is the highest checkpoint in the block index
+func lastCheckpoint() *blockNode {
+	for i := len(params.Checkpoints) - 1; i >= 0; i-- {
+		if node, ok := index[params.Checkpoints[i].Hash]; ok {
+			return node
+		}
+	}
+	return nil
+}
+
+// assumedValid tells whether node is the assumevalid block or one of its
+// ancestors on the main chain, whose scripts we don't check
+func assumedValid(node *blockNode) bool {
+	assumeValid, ok := index[params.AssumeValid]
+	if !ok || !inBestChain(assumeValid) {
+		return false
+	}
+	return assumeValid.ancestor(node.height) == node
+}
+
+// pastMedianTime is the median timestamp of node and the 10 before it
+func pastMedianTime(node *blockNode) int {
+	timestamps := make([]int, 0, medianTimeBlocks)
+	for i := 0; i < medianTimeBlocks && node != nil; i++ {
+		timestamps = append(timestamps, node.time)
+		node = node.parent
+	}
+	sort.Ints(timestamps)
+	return timestamps[len(timestamps)/2]
+}
+
+// checkBlockSanity checks the rules a block body has to follow no matter
+// where it goes in the chain.
+func checkBlockSanity(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, fmt.Sprintf("block %x has no transactions", block.Hash))
+	}
+	weight := blockWeight(block)
+	if weight > MaxBlockWeight {
+		return ruleError(ErrBlockWeightTooHigh, fmt.Sprintf("block weight of %v is more than the max of %v", weight, MaxBlockWeight))
+	}
+	if !block.Transactions[0].IsCoinbase() {
+		return ruleError(ErrFirstTxNotCoinbase, "first transaction in block is not a coinbase")
+	}
+	sigOps := 0
+	for i, tx := range block.Transactions {
+		if i > 0 && tx.IsCoinbase() {
+			return ruleError(ErrMultipleCoinbases, fmt.Sprintf("transaction %v of the block is a second coinbase", i))
+		}
+		err := checkTransactionSanity(tx)
+		if err != nil {
+			return err
+		}
+		sigOps += legacySigOpCount(tx)
+	}
+	if sigOps*WitnessScaleFactor > MaxBlockSigOpsCost {
+		return ruleError(ErrTooManySigOps, fmt.Sprintf("block has %v legacy signature operations, more than the max of %v", sigOps, MaxBlockSigOpsCost/WitnessScaleFactor))
+	}
+	return nil
+}
+
+// checkBlockContext checks the rules for the body of a block that depend
+// on its height and the blocks before it.
+func checkBlockContext(node *blockNode, block *Block) error {
+	// BIP113 measures lock times against the median time instead of the
+	// timestamp miners pick
+	lockTimeCutoff := int64(block.Time)
+	if node.height >= params.CSVHeight && node.parent != nil {
+		lockTimeCutoff = int64(pastMedianTime(node.parent))
+	}
+	for _, tx := range block.Transactions {
+		if !isFinalTx(tx, node.height, lockTimeCutoff) {
+			return ruleError(ErrUnfinalizedTx, fmt.Sprintf("transaction %x has lock time %v which hasn't passed at height %v", tx.TxID(), uint32(tx.LockTime), node.height))
+		}
+	}
+	if node.height >= params.BIP34Height {
+		expected := script.NumberScript(int64(node.height))
+		if !bytes.HasPrefix(block.Transactions[0].Inputs[0].Script, expected) {
+			return ruleError(ErrBadCoinbaseHeight, fmt.Sprintf("coinbase script %x does not start with height %v", block.Transactions[0].Inputs[0].Script, node.height))
+		}
+	}
+	return nil
+}
+
+// blockWeight adds the header and transaction count to the weight of the
+// transactions, they don't have witness data so they count four times
+func blockWeight(block *Block) int {
+	size := 80 + compactSizeLen(len(block.Transactions))
+	weight := size * WitnessScaleFactor
+	for _, tx := range block.Transactions {
+		weight += tx.Weight()
+	}
+	return weight
+}
+
+func compactSizeLen(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	default:
+		return 5
+	}
+}
@@ -0,0 +1,124 @@
+package chainparams
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+// the coinbase shared by the genesis blocks of mainnet, testnet3, signet and regtest
+var satoshiCoinbase = &wire.TxMsg{
+	Version: 1,
+	TxIn: []*wire.TxIn{{
+		PreviousOutPoint: wire.OutPoint{Index: 0xffffffff},
+		SignatureScript:  mustDecodeHex("04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73"),
+		Sequence:         0xffffffff,
+	}},
+	TxOut: []*wire.TxOut{{
+		Value:    5000000000,
+		PkScript: mustDecodeHex("4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac"),
+	}},
+}
+
+var satoshiMerkleRoot = mustDecodeHash("4a5e1e4baab89f3a32518a88c31bc87f618f76673e2cc77ab2127b7afdeda33b")
+
+var mainNetGenesisBlock = &wire.BlockMsg{
+	Header: wire.BlockHeader{
+		Version:    1,
+		MerkleRoot: satoshiMerkleRoot,
+		Timestamp:  1231006505,
+		Bits:       0x1d00ffff,
+		Nonce:      2083236893,
+	},
+	Transactions: []*wire.TxMsg{satoshiCoinbase},
+}
+
+var testNet3GenesisBlock = &wire.BlockMsg{
+	Header: wire.BlockHeader{
+		Version:    1,
+		MerkleRoot: satoshiMerkleRoot,
+		Timestamp:  1296688602,
+		Bits:       0x1d00ffff,
+		Nonce:      414098458,
+	},
+	Transactions: []*wire.TxMsg{satoshiCoinbase},
+}
+
+var testNet4GenesisBlock = &wire.BlockMsg{
+	Header: wire.BlockHeader{
+		Version:    1,
+		MerkleRoot: mustDecodeHash("7aa0a7ae1e223414cb807e40cd57e667b718e42aaf9306db9102fe28912b7b4e"),
+		Timestamp:  1714777860,
+		Bits:       0x1d00ffff,
+		Nonce:      393743547,
+	},
+	Transactions: []*wire.TxMsg{{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: 0xffffffff},
+			// "03/May/2024 000000000000000000001ebd58c244970b3aa9d783bb001011fbe8ea8e98e00e"
+			SignatureScript: mustDecodeHex("04ffff001d01044c4c30332f4d61792f323032342030303030303030303030303030303030303030303165626435386332343439373062336161396437383362623030313031316662653865613865393865303065"),
+			Sequence:        0xffffffff,
+		}},
+		TxOut: []*wire.TxOut{{
+			Value:    5000000000,
+			PkScript: mustDecodeHex("21000000000000000000000000000000000000000000000000000000000000000000ac"),
+		}},
+	}},
+}
+
+var sigNetGenesisBlock = &wire.BlockMsg{
+	Header: wire.BlockHeader{
+		Version:    1,
+		MerkleRoot: satoshiMerkleRoot,
+		Timestamp:  1598918400,
+		Bits:       0x1e0377ae,
+		Nonce:      52613770,
+	},
+	Transactions: []*wire.TxMsg{satoshiCoinbase},
+}
+
+var regTestGenesisBlock = &wire.BlockMsg{
+	Header: wire.BlockHeader{
+		Version:    1,
+		MerkleRoot: satoshiMerkleRoot,
+		Timestamp:  1296688602,
+		Bits:       0x207fffff,
+		Nonce:      2,
+	},
+	Transactions: []*wire.TxMsg{satoshiCoinbase},
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// DecodeHash takes a hash the way block explorers show it, which is byte
+// reversed from how it is stored and sent over the wire.
+func DecodeHash(s string) ([32]byte, error) {
+	var hash [32]byte
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return hash, err
+	}
+	if len(b) != 32 {
+		return hash, fmt.Errorf("hash %q is %v bytes instead of 32", s, len(b))
+	}
+	for i := range b {
+		hash[31-i] = b[i]
+	}
+	return hash, nil
+}
+
+func mustDecodeHash(s string) [32]byte {
+	hash, err := DecodeHash(s)
+	if err != nil {
+		panic("chainparams: " + err.Error())
+	}
+	return hash
+}
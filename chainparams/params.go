@@ -0,0 +1,281 @@
+package chainparams
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+type Checkpoint struct {
+	Height int
+	Hash   [32]byte
+}
+
+// Params is everything that differs between the networks we can run on.
+type Params struct {
+	Name        string
+	Net         uint32 // message magic
+	DefaultPort int
+	DNSSeeds    []string
+
+	GenesisBlock *wire.BlockMsg
+	GenesisHash  [32]byte
+
+	// proof of work
+	PowLimit                 *big.Int
+	PowLimitBits             uint32
+	TargetTimespan           time.Duration
+	TargetTimePerBlock       time.Duration
+	RetargetAdjustmentFactor int64
+	// testnets allow a min difficulty block when none was found for twice
+	// the target spacing
+	ReduceMinDifficulty  bool
+	MinDiffReductionTime time.Duration
+	NoRetargeting        bool
+	// testnet4 retargets from the first block of the period and forbids
+	// the timewarp attack
+	EnforceBIP94 bool
+
+	// heights from which the buried soft forks are enforced
+	BIP34Height   int
+	BIP65Height   int
+	BIP66Height   int
+	CSVHeight     int
+	SegwitHeight  int
+	TaprootHeight int
+
+	// blocks the subsidy halves after
+	SubsidyHalvingInterval int
+
+	// historical blocks that broke a rule before it was enforced and are
+	// accepted by hash, BIP16 ones are checked without P2SH and BIP30 ones
+	// may overwrite unspent outputs of an earlier transaction
+	BIP16Exceptions [][32]byte
+	BIP30Exceptions [][32]byte
+
+	// blocks below a checkpoint can't be replaced by a fork
+	Checkpoints []Checkpoint
+	// scripts of this block and its ancestors are assumed to be valid and
+	// not checked, every other rule still is
+	AssumeValid [32]byte
+}
+
+// BlocksPerRetarget is how many blocks pass between difficulty changes.
+func (p *Params) BlocksPerRetarget() int {
+	return int(p.TargetTimespan / p.TargetTimePerBlock)
+}
+
+var bigOne = big.NewInt(1)
+
+// 2^224 - 1
+var mainPowLimit = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 224), bigOne)
+
+// 2^255 - 1
+var regTestPowLimit = new(big.Int).Sub(new(big.Int).Lsh(bigOne, 255), bigOne)
+
+var sigNetPowLimit, _ = new(big.Int).SetString("00000377ae000000000000000000000000000000000000000000000000000000", 16)
+
+var MainNetParams = Params{
+	Name:        "mainnet",
+	Net:         wire.MainNet,
+	DefaultPort: 8333,
+	DNSSeeds: []string{
+		"seed.bitcoin.sipa.be",
+		"dnsseed.bluematt.me",
+		"dnsseed.bitcoin.dashjr.org",
+		"seed.bitcoinstats.com",
+		"seed.bitcoin.jonasschnelli.ch",
+		"seed.btc.petertodd.org",
+		"seed.bitcoin.sprovoost.nl",
+		"dnsseed.emzy.de",
+		"seed.bitcoin.wiz.biz",
+	},
+
+	GenesisBlock: mainNetGenesisBlock,
+	GenesisHash:  mustDecodeHash("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"),
+
+	PowLimit:                 mainPowLimit,
+	PowLimitBits:             0x1d00ffff,
+	TargetTimespan:           14 * 24 * time.Hour,
+	TargetTimePerBlock:       10 * time.Minute,
+	RetargetAdjustmentFactor: 4,
+
+	BIP34Height:   227931,
+	BIP65Height:   388381,
+	BIP66Height:   363725,
+	CSVHeight:     419328,
+	SegwitHeight:  481824,
+	TaprootHeight: 709632,
+
+	SubsidyHalvingInterval: 210000,
+
+	BIP16Exceptions: [][32]byte{
+		mustDecodeHash("00000000000002dc756eebf4f49723ed8d30cc28a5f108eb94b1ba88ac4f9c22"),
+	},
+	BIP30Exceptions: [][32]byte{
+		mustDecodeHash("00000000000a4d0a398161ffc163c503763b1f4360639393e0e4c8e300e0caec"),
+		mustDecodeHash("00000000000743f190a18c5577a3c2d2a1f610ae9601ac046a38084ccb7cd721"),
+	},
+
+	Checkpoints: []Checkpoint{
+		{11111, mustDecodeHash("0000000069e244f73d78e8fd29ba2fd2ed618bd6fa2ee92559f542fdb26e7c1d")},
+		{33333, mustDecodeHash("000000002dd5588a74784eaa7ab0507a18ad16a236e7b1ce69f00d7ddfb5d0a6")},
+		{74000, mustDecodeHash("0000000000573993a3c9e41ce34471c079dcf5f52a0e824a81e7f953b8661a20")},
+		{105000, mustDecodeHash("00000000000291ce28027faea320c8d2b054b2e0fe44a773f3eefb151d6bdc97")},
+		{134444, mustDecodeHash("00000000000005b12ffd4cd315cd34ffd4a594f430ac814c91184a0d42d2b0fe")},
+		{168000, mustDecodeHash("000000000000099e61ea72015e79632f216fe6cb33d7899acb35b75c8303b763")},
+		{193000, mustDecodeHash("000000000000059f452a5f7340de6682a977387c17010ff6e6c3bd83ca8b1317")},
+		{210000, mustDecodeHash("000000000000048b95347e83192f69cf0366076336c639f9b7228e9ba171342e")},
+		{216116, mustDecodeHash("00000000000001b4f4b433e81ee46494af945cf96014816a4e2370f11b23df4e")},
+		{225430, mustDecodeHash("00000000000001c108384350f74090433e7fcf79a606b8e797f065b130575932")},
+		{250000, mustDecodeHash("000000000000003887df1f29024b06fc2200b55f8af8f35453d7be294df2d214")},
+		{267300, mustDecodeHash("000000000000000a83fbd660e918f218bf37edd92b748ad940483c7c116179ac")},
+		{279000, mustDecodeHash("0000000000000001ae8c72a0b0c301f67e3afca10e819efa9041e458e9bd7e40")},
+		{295000, mustDecodeHash("00000000000000004d9b4ef50f0f9d686fd69db2e03af35a100370c64632a983")},
+	},
+	AssumeValid: mustDecodeHash("00000000000000004d9b4ef50f0f9d686fd69db2e03af35a100370c64632a983"),
+}
+
+var TestNet3Params = Params{
+	Name:        "testnet3",
+	Net:         wire.TestNet3,
+	DefaultPort: 18333,
+	DNSSeeds: []string{
+		"testnet-seed.bitcoin.jonasschnelli.ch",
+		"seed.tbtc.petertodd.org",
+		"seed.testnet.bitcoin.sprovoost.nl",
+		"testnet-seed.bluematt.me",
+	},
+
+	GenesisBlock: testNet3GenesisBlock,
+	GenesisHash:  mustDecodeHash("000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943"),
+
+	PowLimit:                 mainPowLimit,
+	PowLimitBits:             0x1d00ffff,
+	TargetTimespan:           14 * 24 * time.Hour,
+	TargetTimePerBlock:       10 * time.Minute,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     20 * time.Minute,
+
+	BIP34Height:  21111,
+	BIP65Height:  581885,
+	BIP66Height:  330776,
+	CSVHeight:    770112,
+	SegwitHeight: 834624,
+	// taproot was never buried on testnet3 and we don't track version bits
+	// deployments, so it is enforced from genesis
+	TaprootHeight: 0,
+
+	SubsidyHalvingInterval: 210000,
+
+	BIP16Exceptions: [][32]byte{
+		mustDecodeHash("00000000dd30457c001f4095d208cc1296b0eed002427aa599874af7a432b105"),
+	},
+
+	Checkpoints: []Checkpoint{
+		{546, mustDecodeHash("000000002a936ca763904c3c35fce2f3556c559c0214345d31b1bcebf76acb70")},
+	},
+	AssumeValid: mustDecodeHash("000000002a936ca763904c3c35fce2f3556c559c0214345d31b1bcebf76acb70"),
+}
+
+var TestNet4Params = Params{
+	Name:        "testnet4",
+	Net:         wire.TestNet4,
+	DefaultPort: 48333,
+	DNSSeeds: []string{
+		"seed.testnet4.bitcoin.sprovoost.nl",
+		"seed.testnet4.wiz.biz",
+	},
+
+	GenesisBlock: testNet4GenesisBlock,
+	GenesisHash:  mustDecodeHash("00000000da84f2bafbbc53dee25a72ae507ff4914b867c565be350b0da8bf043"),
+
+	PowLimit:                 mainPowLimit,
+	PowLimitBits:             0x1d00ffff,
+	TargetTimespan:           14 * 24 * time.Hour,
+	TargetTimePerBlock:       10 * time.Minute,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     20 * time.Minute,
+	EnforceBIP94:             true,
+
+	BIP34Height:   1,
+	BIP65Height:   1,
+	BIP66Height:   1,
+	CSVHeight:     1,
+	SegwitHeight:  1,
+	TaprootHeight: 1,
+
+	SubsidyHalvingInterval: 210000,
+}
+
+// SigNetParams is the default signet. Block signatures against the signet
+// challenge are not checked.
+var SigNetParams = Params{
+	Name:        "signet",
+	Net:         wire.SigNet,
+	DefaultPort: 38333,
+	DNSSeeds: []string{
+		"seed.signet.bitcoin.sprovoost.nl",
+		"seed.signet.achownodes.xyz",
+	},
+
+	GenesisBlock: sigNetGenesisBlock,
+	GenesisHash:  mustDecodeHash("00000008819873e925422c1ff0f99f7cc9bbb232af63a077a480a3633bee1ef6"),
+
+	PowLimit:                 sigNetPowLimit,
+	PowLimitBits:             0x1e0377ae,
+	TargetTimespan:           14 * 24 * time.Hour,
+	TargetTimePerBlock:       10 * time.Minute,
+	RetargetAdjustmentFactor: 4,
+
+	BIP34Height:   1,
+	BIP65Height:   1,
+	BIP66Height:   1,
+	CSVHeight:     1,
+	SegwitHeight:  1,
+	TaprootHeight: 0,
+
+	SubsidyHalvingInterval: 210000,
+}
+
+var RegTestParams = Params{
+	Name:        "regtest",
+	Net:         wire.RegTest,
+	DefaultPort: 18444,
+
+	GenesisBlock: regTestGenesisBlock,
+	GenesisHash:  mustDecodeHash("0f9188f13cb7b2c71f2a335e3a4fc328bf5beb436012afca590b1a11466e2206"),
+
+	PowLimit:                 regTestPowLimit,
+	PowLimitBits:             0x207fffff,
+	TargetTimespan:           14 * 24 * time.Hour,
+	TargetTimePerBlock:       10 * time.Minute,
+	RetargetAdjustmentFactor: 4,
+	ReduceMinDifficulty:      true,
+	MinDiffReductionTime:     20 * time.Minute,
+	NoRetargeting:            true,
+
+	BIP34Height:   1,
+	BIP65Height:   1,
+	BIP66Height:   1,
+	CSVHeight:     1,
+	SegwitHeight:  0,
+	TaprootHeight: 0,
+
+	SubsidyHalvingInterval: 150,
+}
+
+// ByName returns the parameters for one of mainnet, testnet3, testnet4,
+// signet or regtest.
+func ByName(name string) (*Params, error) {
+	for _, params := range []*Params{&MainNetParams, &TestNet3Params, &TestNet4Params, &SigNetParams, &RegTestParams} {
+		if params.Name == name {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown chain %q", name)
+}
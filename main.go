@@ -1,18 +1,122 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/singurty/goldchain/network"
 	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/chainparams"
+	"github.com/singurty/goldchain/mempool"
 )
 
 func main() {
-	blockchain.Start() // blockchain should be ready before we start the network
-	go network.Start()
+	chain := flag.String("chain", "mainnet", "chain to run on: mainnet, testnet3, testnet4, signet or regtest")
+	addNodes := flag.String("addnode", "", "comma separated host:port list of nodes to connect to")
+	par := flag.Int("par", 0, "script verification threads, 0 for one per CPU, negative to leave that many CPUs free")
+	assumeValid := flag.String("assumevalid", "", "block hash whose ancestors' scripts aren't checked, 0 to check them all (default is the chain's)")
+	bind := flag.String("bind", "", "host or host:port to accept connections on (default every interface on the chain's port)")
+	flag.Parse()
+	params, err := chainparams.ByName(*chain)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	switch *assumeValid {
+	case "":
+	case "0":
+		params.AssumeValid = [32]byte{}
+	default:
+		params.AssumeValid, err = chainparams.DecodeHash(*assumeValid)
+		if err != nil {
+			fmt.Println("bad -assumevalid:", err)
+			os.Exit(1)
+		}
+	}
+	blockchain.SetScriptWorkers(*par)
+	blockchain.Start(params) // blockchain should be ready before we start the network
+	mempool.Start()
+	var nodes []string
+	if *addNodes != "" {
+		nodes = strings.Split(*addNodes, ",")
+	}
+	go network.Start(params, nodes, *bind)
+	go commands()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
 	for {
-		fmt.Printf("total peers: %v\n", len(network.Peers))
-		time.Sleep(5 * time.Second)
+		select {
+		case <-interrupt:
+			fmt.Println("shutting down...")
+			err = network.Stop()
+			if err != nil {
+				fmt.Println(err)
+			}
+			err = blockchain.Stop()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			return
+		case <-time.After(5 * time.Second):
+			fmt.Printf("total peers: %v\n", network.Peers.Count())
+		}
+	}
+}
+
+// commands runs what the operator types on stdin, one command a line:
+//
+//	ban <ip or subnet> [duration]
+//	unban <ip or subnet>
+func commands() {
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		err := runCommand(strings.Fields(scanner.Text()))
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+func runCommand(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "ban":
+		if len(args) != 2 && len(args) != 3 {
+			return fmt.Errorf("usage: ban <ip or subnet> [duration]")
+		}
+		// as long as the bans for misbehaving
+		duration := 24 * time.Hour
+		if len(args) == 3 {
+			var err error
+			duration, err = time.ParseDuration(args[2])
+			if err != nil || duration <= 0 {
+				return fmt.Errorf("bad ban duration %q", args[2])
+			}
+		}
+		err := network.Ban(args[1], duration)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("banned %v for %v\n", args[1], duration)
+	case "unban":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: unban <ip or subnet>")
+		}
+		err := network.Unban(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("unbanned %v\n", args[1])
+	default:
+		return fmt.Errorf("unknown command %q, there is ban and unban", args[0])
 	}
+	return nil
 }
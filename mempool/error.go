@@ -0,0 +1,81 @@
+package mempool
+
+import (
+	"fmt"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+// ErrorCode says why the pool turned a transaction away when it didn't
+// break a consensus rule, those come back as a blockchain.RuleError.
+type ErrorCode int
+
+const (
+	// the transaction is in the pool already
+	ErrAlreadyHave ErrorCode = iota
+	// spends an output a transaction in the pool already spends
+	ErrDoubleSpend
+	// an input is in neither the pool nor the utxo set, its parent may
+	// still turn up
+	ErrMissingInputs
+	// breaks one of the standardness rules
+	ErrNonStandard
+	// an output worth less than it would cost to spend
+	ErrDust
+	// pays less than the relay fee or what the pool currently asks
+	ErrInsufficientFee
+	// would make a chain of unconfirmed transactions too long or too big
+	ErrTooLongChain
+	// the pool is full of transactions that pay better
+	ErrMempoolFull
+)
+
+var errorCodeStrings = map[ErrorCode]string{
+	ErrAlreadyHave:     "ErrAlreadyHave",
+	ErrDoubleSpend:     "ErrDoubleSpend",
+	ErrMissingInputs:   "ErrMissingInputs",
+	ErrNonStandard:     "ErrNonStandard",
+	ErrDust:            "ErrDust",
+	ErrInsufficientFee: "ErrInsufficientFee",
+	ErrTooLongChain:    "ErrTooLongChain",
+	ErrMempoolFull:     "ErrMempoolFull",
+}
+
+func (e ErrorCode) String() string {
+	if s, ok := errorCodeStrings[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// RuleError is returned for transactions that are valid but that our
+// policy keeps out of the pool.
+type RuleError struct {
+	ErrorCode   ErrorCode
+	Description string
+}
+
+func (e RuleError) Error() string {
+	return e.Description
+}
+
+func ruleError(c ErrorCode, desc string) RuleError {
+	return RuleError{ErrorCode: c, Description: desc}
+}
+
+// RejectCode is the reject message code peers are told the transaction
+// failed with.
+func (e RuleError) RejectCode() uint8 {
+	switch e.ErrorCode {
+	case ErrAlreadyHave, ErrDoubleSpend:
+		return wire.RejectDuplicate
+	case ErrDust:
+		return wire.RejectDust
+	case ErrInsufficientFee, ErrMempoolFull:
+		return wire.RejectInsufficientFee
+	case ErrMissingInputs:
+		return wire.RejectInvalid
+	default:
+		return wire.RejectNonstandard
+	}
+}
@@ -0,0 +1,490 @@
+// Package mempool keeps the transactions we heard about that aren't in a
+// block yet. They are checked against the utxo set and our relay policy
+// on the way in, and the pool follows the chain as blocks connect and
+// disconnect.
+package mempool
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/script"
+)
+
+const (
+	// the fee rate in satoshis per kilobyte below which transactions
+	// aren't taken or relayed
+	MinRelayFee = 1000
+	// virtual bytes the pool holds before the transactions paying the
+	// least are evicted
+	maxPoolSize = 300 * 1000 * 1000
+	// a transaction and its unconfirmed ancestors can't be more than this
+	// many or this big, and the same goes for descendants
+	maxAncestors      = 25
+	maxAncestorSize   = 101000
+	maxDescendants    = 25
+	maxDescendantSize = 101000
+	// after an eviction new transactions have to beat what was evicted by
+	// this fee rate
+	incrementalRelayFee = 1000
+	// and that minimum halves this often until it is back to MinRelayFee
+	minFeeHalfLife = 12 * time.Hour
+)
+
+// TxDesc is a transaction in the pool with what we worked out about it on
+// the way in
+type TxDesc struct {
+	Tx    *blockchain.Transaction
+	TxID  [32]byte
+	WTxID [32]byte
+	Fee   int64
+	// virtual size, the weight over four rounded up
+	Size      int
+	SigOpCost int
+	Added     time.Time
+	// the height of the block it was checked as part of
+	Height int
+
+	// transactions in the pool it spends and that spend it, guarded by
+	// the pool's lock
+	parents  map[*TxDesc]bool
+	children map[*TxDesc]bool
+	// the fee and size of the transaction with its descendants, which
+	// are mined and evicted as a package. Kept up to date as the pool
+	// changes so trim doesn't have to work them out.
+	packageFee  int64
+	packageSize int
+	// where it is in the pool's eviction heap
+	heapIndex int
+}
+
+// FeeRate is what the transaction pays in satoshis per kilobyte
+func (d *TxDesc) FeeRate() int64 {
+	return feeRate(d.Fee, d.Size)
+}
+
+func feeRate(fee int64, size int) int64 {
+	if size == 0 {
+		return 0
+	}
+	return fee * 1000 / int64(size)
+}
+
+// txPool indexes the transactions every way they are looked up: by txid,
+// by wtxid and by the outputs they spend.
+type txPool struct {
+	mu    sync.RWMutex
+	txs   map[[32]byte]*TxDesc
+	wtxs  map[[32]byte]*TxDesc
+	spent map[blockchain.OutPoint]*TxDesc
+	// total virtual size
+	size int
+	// the fee rate set by the last eviction and when, see minFee
+	evictedFee int64
+	evictedAt  time.Time
+	// every transaction, the package paying the least per byte first
+	evictable evictHeap
+	// virtual bytes it holds before evicting
+	maxSize int
+	// checks transactions against the utxo set, blockchain.CheckTransaction
+	// outside of tests
+	checkTx func(*blockchain.Transaction, func(blockchain.OutPoint) *blockchain.UtxoEntry, script.Flags) (*blockchain.TxInfo, error)
+}
+
+var pool = &txPool{
+	txs:     make(map[[32]byte]*TxDesc),
+	wtxs:    make(map[[32]byte]*TxDesc),
+	spent:   make(map[blockchain.OutPoint]*TxDesc),
+	maxSize: maxPoolSize,
+	checkTx: blockchain.CheckTransaction,
+}
+
+// Start makes the pool follow the chain, it has to be called after the
+// chain is loaded
+func Start() {
+	blockchain.OnBlockConnected(pool.blockConnected)
+	blockchain.OnBlocksDisconnected(pool.blocksDisconnected)
+}
+
+// ProcessTransaction adds tx to the pool if it is valid and our policy
+// lets it in. Broken consensus rules come back as a blockchain.RuleError
+// and policy as a RuleError.
+func ProcessTransaction(tx *blockchain.Transaction) (*TxDesc, error) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	desc, err := pool.accept(tx, false)
+	if err != nil {
+		return nil, err
+	}
+	pool.trim()
+	if pool.txs[desc.TxID] != desc {
+		return nil, ruleError(ErrMempoolFull, fmt.Sprintf("transaction %x doesn't pay enough to stay in the full pool", desc.TxID))
+	}
+	return desc, nil
+}
+
+// Fetch returns the transaction with txid, nil if it isn't in the pool
+func Fetch(txid [32]byte) *TxDesc {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.txs[txid]
+}
+
+// FetchByWTxID returns the transaction with wtxid, nil if it isn't in the
+// pool
+func FetchByWTxID(wtxid [32]byte) *TxDesc {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.wtxs[wtxid]
+}
+
+// Have tells whether a transaction with hash as its txid or wtxid is in
+// the pool
+func Have(hash [32]byte) bool {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.txs[hash] != nil || pool.wtxs[hash] != nil
+}
+
+// Ancestors is how many transactions in the pool d builds on, they have to
+// be mined or relayed before it
+func Ancestors(d *TxDesc) int {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return len(pool.ancestors(d)) - 1
+}
+
+// Count is how many transactions are in the pool
+func Count() int {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return len(pool.txs)
+}
+
+// Size is the virtual size of every transaction in the pool
+func Size() int {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.size
+}
+
+// MinFee is the fee rate in satoshis per kilobyte a transaction has to
+// pay to get in right now
+func MinFee() int64 {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	return pool.minFee()
+}
+
+// minFee decays the fee rate the last eviction set back towards
+// MinRelayFee
+func (p *txPool) minFee() int64 {
+	if p.evictedFee == 0 {
+		return MinRelayFee
+	}
+	halvings := float64(time.Since(p.evictedAt)) / float64(minFeeHalfLife)
+	fee := int64(float64(p.evictedFee) / math.Pow(2, halvings))
+	if fee < MinRelayFee {
+		return MinRelayFee
+	}
+	return fee
+}
+
+// output is how CheckTransaction sees the outputs of transactions in the
+// pool, p.mu has to be held
+func (p *txPool) output(op blockchain.OutPoint) *blockchain.UtxoEntry {
+	desc, ok := p.txs[op.Hash]
+	if !ok || int(op.Index) >= len(desc.Tx.Outputs) {
+		return nil
+	}
+	out := desc.Tx.Outputs[op.Index]
+	return &blockchain.UtxoEntry{Value: int64(out.Value), Script: out.Script}
+}
+
+// accept checks tx and adds it, p.mu has to be held. Transactions put back
+// after a reorg bypass the fee and chain limits since they were good enough
+// to be mined, the pool is trimmed once they are all back.
+func (p *txPool) accept(tx *blockchain.Transaction, reorg bool) (*TxDesc, error) {
+	txid := tx.TxID()
+	wtxid := tx.WTxID()
+	if p.txs[txid] != nil || p.wtxs[wtxid] != nil {
+		return nil, ruleError(ErrAlreadyHave, fmt.Sprintf("transaction %x is already in the pool", txid))
+	}
+	weight := tx.Weight()
+	err := checkStandard(tx, txid, weight)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range tx.Inputs {
+		op := blockchain.OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+		if spender, ok := p.spent[op]; ok {
+			return nil, ruleError(ErrDoubleSpend, fmt.Sprintf("transaction %x spends %x:%v which %x in the pool already spends", txid, op.Hash, op.Index, spender.TxID))
+		}
+	}
+	info, err := p.checkTx(tx, p.output, policyScriptFlags)
+	if err != nil {
+		var ruleErr blockchain.RuleError
+		if errors.As(err, &ruleErr) && ruleErr.ErrorCode == blockchain.ErrMissingTxOut {
+			return nil, ruleError(ErrMissingInputs, ruleErr.Description)
+		}
+		return nil, err
+	}
+	err = checkInputsStandard(tx, txid, info.PrevOuts)
+	if err != nil {
+		return nil, err
+	}
+	if info.SigOpCost > maxStandardSigOpCost {
+		return nil, ruleError(ErrNonStandard, fmt.Sprintf("transaction %x has a sigop cost of %v, more than %v", txid, info.SigOpCost, maxStandardSigOpCost))
+	}
+	desc := &TxDesc{
+		Tx:        tx,
+		TxID:      txid,
+		WTxID:     wtxid,
+		Fee:       info.Fee,
+		Size:      (weight + blockchain.WitnessScaleFactor - 1) / blockchain.WitnessScaleFactor,
+		SigOpCost: info.SigOpCost,
+		Added:     time.Now(),
+		Height:    info.Height,
+		parents:   make(map[*TxDesc]bool),
+		children:  make(map[*TxDesc]bool),
+	}
+	if !reorg {
+		minFee := p.minFee()
+		if desc.FeeRate() < minFee {
+			return nil, ruleError(ErrInsufficientFee, fmt.Sprintf("transaction %x pays %v satoshis per kilobyte, the pool needs %v", txid, desc.FeeRate(), minFee))
+		}
+		err = p.checkChainLimits(desc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.add(desc)
+	return desc, nil
+}
+
+// checkChainLimits makes sure desc doesn't have too many unconfirmed
+// ancestors and doesn't push any of them over the descendant limits
+func (p *txPool) checkChainLimits(desc *TxDesc) error {
+	ancestors := make(map[*TxDesc]bool)
+	for _, in := range desc.Tx.Inputs {
+		if parent, ok := p.txs[in.PrevTxHash]; ok {
+			p.collect(parent, ancestors, func(d *TxDesc) map[*TxDesc]bool { return d.parents })
+		}
+	}
+	if len(ancestors)+1 > maxAncestors {
+		return ruleError(ErrTooLongChain, fmt.Sprintf("transaction %x has %v unconfirmed ancestors, the limit is %v", desc.TxID, len(ancestors), maxAncestors-1))
+	}
+	size := desc.Size
+	for ancestor := range ancestors {
+		size += ancestor.Size
+	}
+	if size > maxAncestorSize {
+		return ruleError(ErrTooLongChain, fmt.Sprintf("transaction %x and its unconfirmed ancestors are %v virtual bytes, more than %v", desc.TxID, size, maxAncestorSize))
+	}
+	for ancestor := range ancestors {
+		descendants := p.descendants(ancestor)
+		size := desc.Size
+		for d := range descendants {
+			size += d.Size
+		}
+		if len(descendants)+1 > maxDescendants || size > maxDescendantSize {
+			return ruleError(ErrTooLongChain, fmt.Sprintf("transaction %x would give %x more than %v unconfirmed descendants or %v virtual bytes of them", desc.TxID, ancestor.TxID, maxDescendants-1, maxDescendantSize))
+		}
+	}
+	return nil
+}
+
+// collect adds d and everything reachable from it through next to set
+func (p *txPool) collect(d *TxDesc, set map[*TxDesc]bool, next func(*TxDesc) map[*TxDesc]bool) {
+	if set[d] {
+		return
+	}
+	set[d] = true
+	for n := range next(d) {
+		p.collect(n, set, next)
+	}
+}
+
+// descendants is d and every transaction in the pool that builds on it
+func (p *txPool) descendants(d *TxDesc) map[*TxDesc]bool {
+	set := make(map[*TxDesc]bool)
+	p.collect(d, set, func(d *TxDesc) map[*TxDesc]bool { return d.children })
+	return set
+}
+
+func (p *txPool) add(desc *TxDesc) {
+	p.txs[desc.TxID] = desc
+	p.wtxs[desc.WTxID] = desc
+	for _, in := range desc.Tx.Inputs {
+		op := blockchain.OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+		p.spent[op] = desc
+		if parent, ok := p.txs[op.Hash]; ok {
+			parent.children[desc] = true
+			desc.parents[parent] = true
+		}
+	}
+	// a transaction put back after a reorg can have children in the pool
+	// already
+	for i := range desc.Tx.Outputs {
+		if child, ok := p.spent[blockchain.OutPoint{Hash: desc.TxID, Index: uint32(i)}]; ok {
+			desc.children[child] = true
+			child.parents[desc] = true
+		}
+	}
+	p.size += desc.Size
+	desc.packageFee, desc.packageSize = desc.Fee, desc.Size
+	heap.Push(&p.evictable, desc)
+	// its ancestors' packages grow by it
+	for ancestor := range p.ancestors(desc) {
+		p.updatePackage(ancestor)
+	}
+}
+
+// ancestors is d and every transaction in the pool it builds on
+func (p *txPool) ancestors(d *TxDesc) map[*TxDesc]bool {
+	set := make(map[*TxDesc]bool)
+	p.collect(d, set, func(d *TxDesc) map[*TxDesc]bool { return d.parents })
+	return set
+}
+
+// updatePackage works out d's package again and moves it in the eviction
+// heap. The chain limits keep packages small so this is cheap.
+func (p *txPool) updatePackage(d *TxDesc) {
+	d.packageFee, d.packageSize = 0, 0
+	for desc := range p.descendants(d) {
+		d.packageFee += desc.Fee
+		d.packageSize += desc.Size
+	}
+	heap.Fix(&p.evictable, d.heapIndex)
+}
+
+// remove takes just desc out, what spends it stays
+func (p *txPool) remove(desc *TxDesc) {
+	ancestors := p.ancestors(desc)
+	delete(p.txs, desc.TxID)
+	delete(p.wtxs, desc.WTxID)
+	for _, in := range desc.Tx.Inputs {
+		op := blockchain.OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+		if p.spent[op] == desc {
+			delete(p.spent, op)
+		}
+	}
+	for parent := range desc.parents {
+		delete(parent.children, desc)
+	}
+	for child := range desc.children {
+		delete(child.parents, desc)
+	}
+	p.size -= desc.Size
+	heap.Remove(&p.evictable, desc.heapIndex)
+	delete(ancestors, desc)
+	for ancestor := range ancestors {
+		p.updatePackage(ancestor)
+	}
+}
+
+// removeWithDescendants takes desc out along with everything that can't
+// be mined without it
+func (p *txPool) removeWithDescendants(desc *TxDesc) {
+	for d := range p.descendants(desc) {
+		p.remove(d)
+	}
+}
+
+// trim evicts transactions until the pool fits in its max size. What goes
+// is whichever transaction pays the least per byte together with its
+// descendants, since those would be mined as a package, and transactions
+// after it have to pay more than that.
+func (p *txPool) trim() {
+	for p.size > p.maxSize && len(p.evictable) > 0 {
+		worst := p.evictable[0]
+		if fee := feeRate(worst.packageFee, worst.packageSize) + incrementalRelayFee; fee > p.minFee() {
+			p.evictedFee = fee
+			p.evictedAt = time.Now()
+		}
+		p.removeWithDescendants(worst)
+	}
+}
+
+// evictHeap orders transactions by the fee rate of their package
+type evictHeap []*TxDesc
+
+func (h evictHeap) Len() int {
+	return len(h)
+}
+
+func (h evictHeap) Less(i, j int) bool {
+	// the same as comparing fee rates without rounding
+	return h[i].packageFee*int64(h[j].packageSize) < h[j].packageFee*int64(h[i].packageSize)
+}
+
+func (h evictHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *evictHeap) Push(x interface{}) {
+	desc := x.(*TxDesc)
+	desc.heapIndex = len(*h)
+	*h = append(*h, desc)
+}
+
+func (h *evictHeap) Pop() interface{} {
+	old := *h
+	desc := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return desc
+}
+
+// blockConnected drops the transactions block confirmed and those that
+// spend the same outputs as one of them
+func (p *txPool) blockConnected(block *blockchain.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, tx := range block.Transactions {
+		if desc, ok := p.txs[tx.TxID()]; ok {
+			p.remove(desc)
+		}
+		for _, in := range tx.Inputs {
+			op := blockchain.OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+			if spender, ok := p.spent[op]; ok {
+				p.removeWithDescendants(spender)
+			}
+		}
+	}
+}
+
+// blocksDisconnected puts the transactions of the blocks a reorg took out
+// back in the pool, oldest block first so parents go in before what spends
+// them. Those that don't make it, and the coinbases, leave whatever spends
+// them in the pool with nothing to spend so that goes as well, unless the
+// new chain confirmed them too.
+func (p *txPool) blocksDisconnected(blocks []*blockchain.Block) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if !tx.IsCoinbase() {
+				_, err := p.accept(tx, true)
+				var ruleErr blockchain.RuleError
+				if err == nil || errors.As(err, &ruleErr) && ruleErr.ErrorCode == blockchain.ErrOverwriteTx {
+					continue
+				}
+			}
+			txid := tx.TxID()
+			for i := range tx.Outputs {
+				if spender, ok := p.spent[blockchain.OutPoint{Hash: txid, Index: uint32(i)}]; ok {
+					p.removeWithDescendants(spender)
+				}
+			}
+		}
+	}
+	p.trim()
+}
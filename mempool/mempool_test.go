@@ -0,0 +1,529 @@
+package mempool
+
+import (
+	"container/heap"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/script"
+)
+
+// testView stands in for the utxo set blockchain.CheckTransaction checks
+// against. It only works out fees, scripts aren't run.
+type testView struct {
+	utxos map[blockchain.OutPoint]*blockchain.UtxoEntry
+	// transactions the chain has, putting them back fails like it would
+	confirmed map[[32]byte]bool
+	funded    int
+}
+
+func (v *testView) check(tx *blockchain.Transaction, pending func(blockchain.OutPoint) *blockchain.UtxoEntry, flags script.Flags) (*blockchain.TxInfo, error) {
+	txid := tx.TxID()
+	if v.confirmed[txid] {
+		return nil, blockchain.RuleError{ErrorCode: blockchain.ErrOverwriteTx, Description: fmt.Sprintf("transaction %x is already confirmed", txid)}
+	}
+	info := &blockchain.TxInfo{Height: 200}
+	for _, in := range tx.Inputs {
+		op := blockchain.OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+		entry := pending(op)
+		if entry == nil {
+			entry = v.utxos[op]
+		}
+		if entry == nil {
+			return nil, blockchain.RuleError{ErrorCode: blockchain.ErrMissingTxOut, Description: fmt.Sprintf("transaction %x spends missing output %x:%v", txid, op.Hash, op.Index)}
+		}
+		info.PrevOuts = append(info.PrevOuts, entry)
+		info.Fee += entry.Value
+	}
+	for _, out := range tx.Outputs {
+		info.Fee -= int64(out.Value)
+	}
+	return info, nil
+}
+
+// fund makes a confirmed output worth value
+func (v *testView) fund(value int64) blockchain.OutPoint {
+	v.funded++
+	op := blockchain.OutPoint{Hash: sha256.Sum256([]byte(fmt.Sprintf("funding %v", v.funded)))}
+	v.utxos[op] = &blockchain.UtxoEntry{Value: value, Script: testScript, Height: 1}
+	return op
+}
+
+// confirm makes tx's outputs confirmed, as if a block had it
+func (v *testView) confirm(tx *blockchain.Transaction) {
+	txid := tx.TxID()
+	v.confirmed[txid] = true
+	for i, out := range tx.Outputs {
+		v.utxos[blockchain.OutPoint{Hash: txid, Index: uint32(i)}] = &blockchain.UtxoEntry{Value: int64(out.Value), Script: out.Script}
+	}
+}
+
+// unconfirm takes tx out of the chain again, giving back what it spent
+func (v *testView) unconfirm(tx *blockchain.Transaction, spent ...blockchain.OutPoint) {
+	txid := tx.TxID()
+	delete(v.confirmed, txid)
+	for i := range tx.Outputs {
+		delete(v.utxos, blockchain.OutPoint{Hash: txid, Index: uint32(i)})
+	}
+	for _, op := range spent {
+		v.utxos[op] = &blockchain.UtxoEntry{Value: 100000, Script: testScript, Height: 1}
+	}
+}
+
+// P2WPKH, every output and spent output pays to it
+var testScript = append([]byte{script.OP_0, 20}, make([]byte, 20)...)
+
+func newTestPool(maxSize int) (*txPool, *testView) {
+	view := &testView{
+		utxos:     make(map[blockchain.OutPoint]*blockchain.UtxoEntry),
+		confirmed: make(map[[32]byte]bool),
+	}
+	p := &txPool{
+		txs:     make(map[[32]byte]*TxDesc),
+		wtxs:    make(map[[32]byte]*TxDesc),
+		spent:   make(map[blockchain.OutPoint]*TxDesc),
+		maxSize: maxSize,
+		checkTx: view.check,
+	}
+	return p, view
+}
+
+// newTx spends ins to outputs worth values. With one input and one output
+// it is 82 virtual bytes.
+func newTx(ins []blockchain.OutPoint, values ...int) *blockchain.Transaction {
+	tx := &blockchain.Transaction{Version: 2}
+	for _, op := range ins {
+		tx.Inputs = append(tx.Inputs, &blockchain.TxIn{
+			PrevTxHash:  op.Hash,
+			PrevTxIndex: int(op.Index),
+			Sequence:    [4]byte{0xff, 0xff, 0xff, 0xff},
+		})
+	}
+	for _, value := range values {
+		tx.Outputs = append(tx.Outputs, &blockchain.TxOut{Value: value, Script: testScript})
+	}
+	return tx
+}
+
+func outPoint(tx *blockchain.Transaction, index uint32) blockchain.OutPoint {
+	return blockchain.OutPoint{Hash: tx.TxID(), Index: index}
+}
+
+func coinbase(height int) *blockchain.Transaction {
+	return &blockchain.Transaction{
+		Version: 1,
+		Inputs: []*blockchain.TxIn{{
+			PrevTxIndex: math.MaxUint32,
+			Script:      append(script.NumberScript(int64(height)), 0x00, 0x00),
+			Sequence:    [4]byte{0xff, 0xff, 0xff, 0xff},
+		}},
+		Outputs: []*blockchain.TxOut{{Value: 5000000000, Script: testScript}},
+	}
+}
+
+// requireCode fails unless err is a RuleError with code, code -1 for no error
+func requireCode(t *testing.T, err error, code ErrorCode) {
+	t.Helper()
+	if code == -1 {
+		if err != nil {
+			t.Fatalf("got %v, want no error", err)
+		}
+		return
+	}
+	var ruleErr RuleError
+	if !errors.As(err, &ruleErr) || ruleErr.ErrorCode != code {
+		t.Fatalf("got %v, want %v", err, code)
+	}
+}
+
+// checkPool makes sure every index and package in the pool agrees with
+// the transactions in it
+func checkPool(t *testing.T, p *txPool) {
+	t.Helper()
+	size := 0
+	for txid, desc := range p.txs {
+		if desc.TxID != txid || p.wtxs[desc.WTxID] != desc {
+			t.Errorf("%x isn't indexed right", txid)
+		}
+		if p.evictable[desc.heapIndex] != desc {
+			t.Errorf("%x isn't where the heap says", txid)
+		}
+		for _, in := range desc.Tx.Inputs {
+			op := blockchain.OutPoint{Hash: in.PrevTxHash, Index: uint32(in.PrevTxIndex)}
+			if p.spent[op] != desc {
+				t.Errorf("%x:%v isn't spent by %x", op.Hash, op.Index, txid)
+			}
+			parent, inPool := p.txs[op.Hash]
+			if inPool != desc.parents[parent] || inPool && !parent.children[desc] {
+				t.Errorf("%x isn't linked to its parent %x", txid, op.Hash)
+			}
+		}
+		var fee int64
+		var packageSize int
+		for d := range p.descendants(desc) {
+			fee += d.Fee
+			packageSize += d.Size
+		}
+		if desc.packageFee != fee || desc.packageSize != packageSize {
+			t.Errorf("%x has package %v/%v, want %v/%v", txid, desc.packageFee, desc.packageSize, fee, packageSize)
+		}
+		size += desc.Size
+	}
+	if len(p.spent) != countInputs(p) || len(p.wtxs) != len(p.txs) || len(p.evictable) != len(p.txs) {
+		t.Errorf("%v spent outputs, %v wtxids and %v in the heap for %v transactions", len(p.spent), len(p.wtxs), len(p.evictable), len(p.txs))
+	}
+	if size != p.size {
+		t.Errorf("pool size %v, want %v", p.size, size)
+	}
+}
+
+func countInputs(p *txPool) int {
+	n := 0
+	for _, desc := range p.txs {
+		n += len(desc.Tx.Inputs)
+	}
+	return n
+}
+
+func TestAccept(t *testing.T) {
+	p, view := newTestPool(maxPoolSize)
+	funding := view.fund(100000)
+	parent := newTx([]blockchain.OutPoint{funding}, 99000)
+	dust := newTx([]blockchain.OutPoint{view.fund(100000)}, 98000, 100)
+	nonStandard := newTx([]blockchain.OutPoint{view.fund(100000)}, 99000)
+	nonStandard.Version = 3
+
+	tests := []struct {
+		name string
+		tx   *blockchain.Transaction
+		code ErrorCode
+	}{
+		{"spends a confirmed output", parent, -1},
+		{"again", parent, ErrAlreadyHave},
+		{"spends what the pool already spends", newTx([]blockchain.OutPoint{funding}, 98000), ErrDoubleSpend},
+		{"spends an output nobody has", newTx([]blockchain.OutPoint{{Hash: [32]byte{1}}}, 1000), ErrMissingInputs},
+		{"spends an output the parent doesn't have", newTx([]blockchain.OutPoint{outPoint(parent, 1)}, 1000), ErrMissingInputs},
+		// 50 satoshis for 82 bytes
+		{"pays less than the relay fee", newTx([]blockchain.OutPoint{view.fund(100000)}, 99950), ErrInsufficientFee},
+		{"has a dust output", dust, ErrDust},
+		{"has a version we don't know", nonStandard, ErrNonStandard},
+		{"spends its parent in the pool", newTx([]blockchain.OutPoint{outPoint(parent, 0)}, 98000), -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			desc, err := p.accept(test.tx, false)
+			requireCode(t, err, test.code)
+			if test.code == -1 && (desc.Size != 82 || desc.Fee != 1000 || desc.FeeRate() != 12195) {
+				t.Errorf("size %v fee %v rate %v, want 82, 1000 and 12195", desc.Size, desc.Fee, desc.FeeRate())
+			}
+		})
+	}
+	if len(p.txs) != 2 {
+		t.Fatalf("%v transactions in the pool, want 2", len(p.txs))
+	}
+	if child := p.spent[outPoint(parent, 0)]; len(p.ancestors(child)) != 2 || child.packageFee != 1000 {
+		t.Errorf("child has ancestors %v package fee %v", len(p.ancestors(child)), child.packageFee)
+	}
+	if desc := p.txs[parent.TxID()]; desc.packageFee != 2000 || desc.packageSize != 164 {
+		t.Errorf("parent package %v/%v, want 2000/164", desc.packageFee, desc.packageSize)
+	}
+	checkPool(t, p)
+}
+
+func TestChainLimits(t *testing.T) {
+	t.Run("ancestors", func(t *testing.T) {
+		p, view := newTestPool(maxPoolSize)
+		op := view.fund(100000)
+		value := 100000
+		var last *blockchain.Transaction
+		for i := 0; i < maxAncestors; i++ {
+			value -= 1000
+			last = newTx([]blockchain.OutPoint{op}, value)
+			if _, err := p.accept(last, false); err != nil {
+				t.Fatalf("transaction %v: %v", i, err)
+			}
+			op = outPoint(last, 0)
+		}
+		tooLong := newTx([]blockchain.OutPoint{op}, value-1000)
+		_, err := p.accept(tooLong, false)
+		requireCode(t, err, ErrTooLongChain)
+		// after a reorg they are let in regardless
+		_, err = p.accept(tooLong, true)
+		requireCode(t, err, -1)
+		checkPool(t, p)
+	})
+
+	// each parent only has the one descendant so this is just the
+	// ancestor limit
+	t.Run("parents", func(t *testing.T) {
+		p, view := newTestPool(maxPoolSize)
+		var ops []blockchain.OutPoint
+		for i := 0; i < maxAncestors; i++ {
+			parent := newTx([]blockchain.OutPoint{view.fund(100000)}, 99000)
+			if _, err := p.accept(parent, false); err != nil {
+				t.Fatal(err)
+			}
+			ops = append(ops, outPoint(parent, 0))
+		}
+		_, err := p.accept(newTx(ops, 99000*maxAncestors-5000), false)
+		requireCode(t, err, ErrTooLongChain)
+		_, err = p.accept(newTx(ops[1:], 99000*(maxAncestors-1)-5000), false)
+		requireCode(t, err, -1)
+		checkPool(t, p)
+	})
+
+	t.Run("descendants", func(t *testing.T) {
+		p, view := newTestPool(maxPoolSize)
+		values := make([]int, maxDescendants+5)
+		for i := range values {
+			values[i] = 2000
+		}
+		parent := newTx([]blockchain.OutPoint{view.fund(100000)}, values...)
+		if _, err := p.accept(parent, false); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < maxDescendants-1; i++ {
+			if _, err := p.accept(newTx([]blockchain.OutPoint{outPoint(parent, uint32(i))}, 1000), false); err != nil {
+				t.Fatalf("child %v: %v", i, err)
+			}
+		}
+		_, err := p.accept(newTx([]blockchain.OutPoint{outPoint(parent, maxDescendants)}, 1000), false)
+		requireCode(t, err, ErrTooLongChain)
+		// spending confirmed outputs as well doesn't help
+		_, err = p.accept(newTx([]blockchain.OutPoint{view.fund(100000), outPoint(parent, maxDescendants)}, 100000), false)
+		requireCode(t, err, ErrTooLongChain)
+		checkPool(t, p)
+	})
+
+	t.Run("size", func(t *testing.T) {
+		p, view := newTestPool(maxPoolSize)
+		// two transactions of about 59000 virtual bytes each
+		values := make([]int, 1900)
+		for i := range values {
+			values[i] = 1000
+		}
+		values[0] = 2000000
+		parent := newTx([]blockchain.OutPoint{view.fund(4000000)}, values...)
+		desc, err := p.accept(parent, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values[0] = 1000
+		child := newTx([]blockchain.OutPoint{outPoint(parent, 0)}, values...)
+		if desc.Size+desc.Size <= maxAncestorSize || desc.Size > maxAncestorSize {
+			t.Fatalf("transactions of %v virtual bytes don't test the limit", desc.Size)
+		}
+		_, err = p.accept(child, false)
+		requireCode(t, err, ErrTooLongChain)
+		checkPool(t, p)
+	})
+}
+
+func TestEvictHeap(t *testing.T) {
+	var h evictHeap
+	descs := []*TxDesc{
+		{packageFee: 3000, packageSize: 100},
+		{packageFee: 1000, packageSize: 100},
+		{packageFee: 5000, packageSize: 200},
+		// a hair under the first
+		{packageFee: 2999, packageSize: 100},
+		{packageFee: 10, packageSize: 1},
+	}
+	for _, desc := range descs {
+		heap.Push(&h, desc)
+	}
+	// the last one jumps to the front
+	descs[4].packageFee = 1
+	heap.Fix(&h, descs[4].heapIndex)
+
+	want := []*TxDesc{descs[4], descs[1], descs[2], descs[3], descs[0]}
+	for i, w := range want {
+		for j, desc := range h {
+			if desc.heapIndex != j {
+				t.Fatalf("heap index %v at %v", desc.heapIndex, j)
+			}
+		}
+		if got := heap.Pop(&h).(*TxDesc); got != w {
+			t.Errorf("pop %v: got %v/%v, want %v/%v", i, got.packageFee, got.packageSize, w.packageFee, w.packageSize)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	// room for three of the 82 byte transactions
+	p, view := newTestPool(3 * 82)
+	defer func(old *txPool) { pool = old }(pool)
+	pool = p
+
+	// fee rates of 2439, 12195 and 3658, the last with a child paying for it
+	cheap := newTx([]blockchain.OutPoint{view.fund(100000)}, 99800)
+	good := newTx([]blockchain.OutPoint{view.fund(100000)}, 99000)
+	parent := newTx([]blockchain.OutPoint{view.fund(100000)}, 99700)
+	child := newTx([]blockchain.OutPoint{outPoint(parent, 0)}, 94700)
+	for _, tx := range []*blockchain.Transaction{cheap, good, parent} {
+		if _, err := ProcessTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if MinFee() != MinRelayFee {
+		t.Fatalf("min fee %v before anything was evicted", MinFee())
+	}
+
+	// the child makes its parent's package the best one, so the cheap
+	// one goes
+	if _, err := ProcessTransaction(child); err != nil {
+		t.Fatal(err)
+	}
+	if Have(cheap.TxID()) || !Have(parent.TxID()) || !Have(child.TxID()) || Count() != 3 {
+		t.Fatalf("cheap %v parent %v child %v count %v", Have(cheap.TxID()), Have(parent.TxID()), Have(child.TxID()), Count())
+	}
+	checkPool(t, p)
+	// from now on transactions have to beat what was evicted
+	if fee := MinFee(); fee > 2439+incrementalRelayFee || fee < 2439+incrementalRelayFee-10 {
+		t.Errorf("min fee %v after evicting at 2439", fee)
+	}
+	_, err := ProcessTransaction(newTx([]blockchain.OutPoint{view.fund(100000)}, 99750))
+	requireCode(t, err, ErrInsufficientFee)
+
+	// one that pays enough to get in but less than everything else is
+	// evicted right away
+	_, err = ProcessTransaction(newTx([]blockchain.OutPoint{view.fund(100000)}, 99700))
+	requireCode(t, err, ErrMempoolFull)
+	if Count() != 3 {
+		t.Errorf("count %v, want 3", Count())
+	}
+
+	// the good one goes next, then the parent takes its child along even
+	// though the child alone would fit
+	p.maxSize = 82
+	p.trim()
+	if Count() != 0 {
+		t.Errorf("count %v, want 0", Count())
+	}
+	checkPool(t, p)
+}
+
+// a block confirming some of the pool takes them out, along with anything
+// spending what the block spends and their descendants
+func TestBlockConnected(t *testing.T) {
+	p, view := newTestPool(maxPoolSize)
+	conflicted := view.fund(100000)
+	mined := newTx([]blockchain.OutPoint{view.fund(100000)}, 99000)
+	minedChild := newTx([]blockchain.OutPoint{outPoint(mined, 0)}, 98000)
+	loser := newTx([]blockchain.OutPoint{conflicted}, 99000)
+	loserChild := newTx([]blockchain.OutPoint{outPoint(loser, 0)}, 98000)
+	unrelated := newTx([]blockchain.OutPoint{view.fund(100000)}, 99000)
+	for _, tx := range []*blockchain.Transaction{mined, minedChild, loser, loserChild, unrelated} {
+		if _, err := p.accept(tx, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	winner := newTx([]blockchain.OutPoint{conflicted}, 90000)
+	p.blockConnected(&blockchain.Block{Transactions: []*blockchain.Transaction{coinbase(200), mined, winner}})
+
+	for _, test := range []struct {
+		name string
+		tx   *blockchain.Transaction
+		in   bool
+	}{
+		{"mined", mined, false},
+		{"child of mined", minedChild, true},
+		{"conflict", loser, false},
+		{"child of conflict", loserChild, false},
+		{"unrelated", unrelated, true},
+	} {
+		if _, ok := p.txs[test.tx.TxID()]; ok != test.in {
+			t.Errorf("%v: in the pool %v, want %v", test.name, ok, test.in)
+		}
+	}
+	if desc := p.txs[minedChild.TxID()]; len(desc.parents) != 0 {
+		t.Errorf("child of mined still has %v parents", len(desc.parents))
+	}
+	if _, ok := p.spent[conflicted]; ok {
+		t.Error("the conflicted output is still spent in the pool")
+	}
+	checkPool(t, p)
+}
+
+// transactions of disconnected blocks come back oldest first, and what
+// can't come back takes whatever spends it along
+func TestBlocksDisconnected(t *testing.T) {
+	p, view := newTestPool(maxPoolSize)
+	funding := view.fund(100000)
+
+	// the first block has a transaction paying no fee, the second one
+	// spending it, one spending the first coinbase and one the new chain
+	// also has
+	coinbase1, coinbase2 := coinbase(200), coinbase(201)
+	free := newTx([]blockchain.OutPoint{funding}, 100000)
+	spendsFree := newTx([]blockchain.OutPoint{outPoint(free, 0)}, 99000)
+	spendsCoinbase := newTx([]blockchain.OutPoint{outPoint(coinbase1, 0)}, 4999990000)
+	alsoInNewChain := newTx([]blockchain.OutPoint{view.fund(100000)}, 99000)
+	blocks := []*blockchain.Block{
+		{Transactions: []*blockchain.Transaction{coinbase1, free}},
+		{Transactions: []*blockchain.Transaction{coinbase2, spendsFree, spendsCoinbase, alsoInNewChain}},
+	}
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			view.confirm(tx)
+		}
+	}
+
+	// the pool has transactions spending what the blocks made
+	grandchild := newTx([]blockchain.OutPoint{outPoint(spendsFree, 0)}, 98000)
+	spendsCoinbase2 := newTx([]blockchain.OutPoint{outPoint(coinbase2, 0)}, 4999990000)
+	keeps := newTx([]blockchain.OutPoint{outPoint(alsoInNewChain, 0)}, 98000)
+	spendsSpendsCoinbase := newTx([]blockchain.OutPoint{outPoint(spendsCoinbase, 0)}, 4999980000)
+	for _, tx := range []*blockchain.Transaction{grandchild, spendsCoinbase2, keeps, spendsSpendsCoinbase} {
+		if _, err := p.accept(tx, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the reorg takes both blocks out, the new chain has alsoInNewChain
+	for _, block := range blocks {
+		for _, tx := range block.Transactions {
+			if tx != alsoInNewChain {
+				view.unconfirm(tx)
+			}
+		}
+	}
+	view.unconfirm(free, funding)
+	p.blocksDisconnected(blocks)
+
+	for _, test := range []struct {
+		name string
+		tx   *blockchain.Transaction
+		in   bool
+	}{
+		{"paying no fee", free, true},
+		{"spending it", spendsFree, true},
+		{"already in the pool and spending that", grandchild, true},
+		{"spending the first coinbase", spendsCoinbase, false},
+		{"spending what spends the first coinbase", spendsSpendsCoinbase, false},
+		{"spending the second coinbase", spendsCoinbase2, false},
+		{"confirmed again", alsoInNewChain, false},
+		{"spending what was confirmed again", keeps, true},
+	} {
+		if _, ok := p.txs[test.tx.TxID()]; ok != test.in {
+			t.Errorf("%v: in the pool %v, want %v", test.name, ok, test.in)
+		}
+	}
+	if desc := p.txs[free.TxID()]; len(p.descendants(desc)) != 3 || desc.packageFee != 2000 {
+		t.Errorf("free has %v descendants and package fee %v", len(p.descendants(desc)), desc.packageFee)
+	}
+	checkPool(t, p)
+
+	// newest first the child doesn't find its parent
+	p, view = newTestPool(maxPoolSize)
+	view.fund(100000)
+	p.blocksDisconnected([]*blockchain.Block{blocks[1], blocks[0]})
+	if _, ok := p.txs[spendsFree.TxID()]; ok {
+		t.Error("child came back without its parent")
+	}
+}
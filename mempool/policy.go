@@ -0,0 +1,205 @@
+package mempool
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/script"
+)
+
+const (
+	// newer versions don't mean anything yet and may some day
+	maxStandardVersion = 2
+	// a tenth of a block
+	maxStandardTxWeight = 400000
+	// 64 bytes is the size of a merkle tree node, a transaction that small
+	// could be passed off as one (CVE-2017-12842)
+	minStandardTxNonWitnessSize = 65
+	// enough for a 15 of 15 multisig redeem script with its signatures
+	maxStandardScriptSigSize = 1650
+	// bare multisig outputs can have this many keys
+	maxStandardMultiSigKeys = 3
+	// the most an OP_RETURN output may carry, script included
+	maxNullDataSize = 83
+	// a fifth of a block's
+	maxStandardSigOpCost = blockchain.MaxBlockSigOpsCost / 5
+	// sigops in the redeem script of a P2SH input
+	maxP2SHSigOps = 15
+	// outputs worth less than spending them costs at this fee rate in
+	// satoshis per kilobyte are dust
+	dustRelayFee = 3000
+)
+
+// scripts are checked under every soft fork we know of on top of what
+// consensus needs at the next height, along with rules consensus leaves
+// out
+const policyScriptFlags = script.VerifyP2SH | script.VerifyStrictEnc | script.VerifyDERSig |
+	script.VerifyCheckLockTimeVerify | script.VerifyCheckSequenceVerify | script.VerifyNullDummy |
+	script.VerifyWitness | script.VerifyTaproot | script.VerifyLowS
+
+// scriptClass is the kind of output script, anything we don't recognize
+// is nonstandard
+type scriptClass int
+
+const (
+	classNonStandard scriptClass = iota
+	classPubKey
+	classPubKeyHash
+	classScriptHash
+	classMultiSig
+	classWitness
+	classNullData
+)
+
+func classify(pkScript []byte) scriptClass {
+	switch {
+	case isPubKey(pkScript):
+		return classPubKey
+	case isPubKeyHash(pkScript):
+		return classPubKeyHash
+	case script.IsPayToScriptHash(pkScript):
+		return classScriptHash
+	case script.IsWitnessProgram(pkScript):
+		return classWitness
+	case isMultiSig(pkScript):
+		return classMultiSig
+	case isNullData(pkScript):
+		return classNullData
+	}
+	return classNonStandard
+}
+
+// isPubKey matches <33 or 65 byte key> OP_CHECKSIG
+func isPubKey(s []byte) bool {
+	return (len(s) == 35 && s[0] == 33 || len(s) == 67 && s[0] == 65) && s[len(s)-1] == script.OP_CHECKSIG
+}
+
+// isPubKeyHash matches OP_DUP OP_HASH160 <20 bytes> OP_EQUALVERIFY
+// OP_CHECKSIG
+func isPubKeyHash(s []byte) bool {
+	return len(s) == 25 && s[0] == script.OP_DUP && s[1] == script.OP_HASH160 && s[2] == 20 &&
+		s[23] == script.OP_EQUALVERIFY && s[24] == script.OP_CHECKSIG
+}
+
+// isMultiSig matches OP_m <keys> OP_n OP_CHECKMULTISIG with at most
+// maxStandardMultiSigKeys keys
+func isMultiSig(s []byte) bool {
+	if len(s) < 3 || s[len(s)-1] != script.OP_CHECKMULTISIG {
+		return false
+	}
+	m, n := int(s[0])-(script.OP_1-1), int(s[len(s)-2])-(script.OP_1-1)
+	if m < 1 || n < m || n > maxStandardMultiSigKeys {
+		return false
+	}
+	keys := 0
+	for i := 1; i < len(s)-2; keys++ {
+		size := int(s[i])
+		if size != 33 && size != 65 {
+			return false
+		}
+		i += 1 + size
+		if i > len(s)-2 {
+			return false
+		}
+	}
+	return keys == n
+}
+
+// isNullData matches OP_RETURN followed by nothing but pushes
+func isNullData(s []byte) bool {
+	return len(s) > 0 && len(s) <= maxNullDataSize && s[0] == script.OP_RETURN && script.IsPushOnly(s[1:])
+}
+
+// isDust tells whether out is worth less than spending it costs at
+// dustRelayFee, counting the output itself and the input that spends it
+func isDust(out *blockchain.TxOut) bool {
+	if len(out.Script) > 0 && out.Script[0] == script.OP_RETURN {
+		return false
+	}
+	size := 8 + compactSizeLen(len(out.Script)) + len(out.Script)
+	// outpoint, script length, sequence and a typical signature and key,
+	// which are witness data for segwit outputs
+	if script.IsWitnessProgram(out.Script) {
+		size += 32 + 4 + 1 + 107/blockchain.WitnessScaleFactor + 4
+	} else {
+		size += 32 + 4 + 1 + 107 + 4
+	}
+	return int64(out.Value) < int64(size)*dustRelayFee/1000
+}
+
+func compactSizeLen(n int) int {
+	switch {
+	case n < 0xfd:
+		return 1
+	case n <= 0xffff:
+		return 3
+	default:
+		return 5
+	}
+}
+
+// checkStandard applies the relay rules that only need the transaction
+// itself. Transactions that break them are valid, they are just odd enough
+// that we don't help them get mined.
+func checkStandard(tx *blockchain.Transaction, txid [32]byte, weight int) error {
+	if tx.Version < 1 || tx.Version > maxStandardVersion {
+		return ruleError(ErrNonStandard, fmt.Sprintf("transaction %x has version %v", txid, tx.Version))
+	}
+	if weight > maxStandardTxWeight {
+		return ruleError(ErrNonStandard, fmt.Sprintf("transaction %x weighs %v, more than %v", txid, weight, maxStandardTxWeight))
+	}
+	var buf bytes.Buffer
+	tx.SerializeNoWitness(&buf)
+	if buf.Len() < minStandardTxNonWitnessSize {
+		return ruleError(ErrNonStandard, fmt.Sprintf("transaction %x is %v bytes without its witness, less than %v", txid, buf.Len(), minStandardTxNonWitnessSize))
+	}
+	for i, in := range tx.Inputs {
+		if len(in.Script) > maxStandardScriptSigSize {
+			return ruleError(ErrNonStandard, fmt.Sprintf("input %v of transaction %x has a %v byte script, more than %v", i, txid, len(in.Script), maxStandardScriptSigSize))
+		}
+		if !script.IsPushOnly(in.Script) {
+			return ruleError(ErrNonStandard, fmt.Sprintf("input %v of transaction %x has a script that isn't push only", i, txid))
+		}
+	}
+	nullData := 0
+	for i, out := range tx.Outputs {
+		switch classify(out.Script) {
+		case classNonStandard:
+			return ruleError(ErrNonStandard, fmt.Sprintf("output %v of transaction %x has nonstandard script %x", i, txid, out.Script))
+		case classNullData:
+			nullData++
+			continue
+		}
+		if isDust(out) {
+			return ruleError(ErrDust, fmt.Sprintf("output %v of transaction %x is dust at %v satoshis", i, txid, out.Value))
+		}
+	}
+	if nullData > 1 {
+		return ruleError(ErrNonStandard, fmt.Sprintf("transaction %x has %v OP_RETURN outputs", txid, nullData))
+	}
+	return nil
+}
+
+// checkInputsStandard makes sure tx only spends outputs we would have
+// relayed, and that P2SH redeem scripts stay cheap to check
+func checkInputsStandard(tx *blockchain.Transaction, txid [32]byte, prevOuts []*blockchain.UtxoEntry) error {
+	for i, prevOut := range prevOuts {
+		switch classify(prevOut.Script) {
+		case classNonStandard:
+			return ruleError(ErrNonStandard, fmt.Sprintf("input %v of transaction %x spends nonstandard script %x", i, txid, prevOut.Script))
+		case classWitness:
+			// future versions are ours to soft fork
+			version, program, _ := script.ExtractWitnessProgram(prevOut.Script)
+			if version > 1 || version == 1 && len(program) != 32 {
+				return ruleError(ErrNonStandard, fmt.Sprintf("input %v of transaction %x spends witness version %v", i, txid, version))
+			}
+		case classScriptHash:
+			sigOps := script.P2SHSigOpCount(tx.Inputs[i].Script, prevOut.Script)
+			if sigOps > maxP2SHSigOps {
+				return ruleError(ErrNonStandard, fmt.Sprintf("input %v of transaction %x has %v sigops in its redeem script, more than %v", i, txid, sigOps, maxP2SHSigOps))
+			}
+		}
+	}
+	return nil
+}
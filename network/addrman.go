@@ -0,0 +1,444 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+const (
+	newBucketCount   = 1024
+	triedBucketCount = 256
+	bucketSize       = 64
+	// how many tried buckets one address's group can land in, and new
+	// buckets one source group can fill
+	triedBucketsPerGroup     = 8
+	newBucketsPerSourceGroup = 64
+	// addresses not heard of for this long are dropped when space is needed
+	addrHorizon = 30 * 24 * time.Hour
+	// addresses that never worked are given up on after this many tries
+	addrRetries = 3
+	// addresses that used to work are given up on after this many failures
+	// over minFailTime
+	maxAddrFailures = 10
+	minFailTime     = 7 * 24 * time.Hour
+	// how often the address book is written to disk
+	addrSaveInterval = 15 * time.Minute
+)
+
+// knownAddress is an address in the book along with how well it has done
+type knownAddress struct {
+	Addr        *wire.NetAddr
+	Source      net.IP
+	Attempts    int
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Tried       bool
+}
+
+func (ka *knownAddress) key() string {
+	return addrKey(ka.Addr.Address, ka.Addr.Port)
+}
+
+func addrKey(ip net.IP, port uint16) string {
+	return net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+}
+
+// isTerrible tells whether an address isn't worth keeping around
+func (ka *knownAddress) isTerrible(now time.Time) bool {
+	// just tried, give it a chance to answer
+	if now.Sub(ka.LastAttempt) < time.Minute {
+		return false
+	}
+	timestamp := time.Unix(int64(ka.Addr.Timestamp), 0)
+	if timestamp.After(now.Add(10*time.Minute)) || now.Sub(timestamp) > addrHorizon {
+		return true
+	}
+	if ka.LastSuccess.IsZero() && ka.Attempts >= addrRetries {
+		return true
+	}
+	if now.Sub(ka.LastSuccess) > minFailTime && ka.Attempts >= maxAddrFailures {
+		return true
+	}
+	return false
+}
+
+// chance weighs how likely the address is to be picked, recent and
+// repeated failures make it less likely
+func (ka *knownAddress) chance(now time.Time) float64 {
+	c := 1.0
+	if now.Sub(ka.LastAttempt) < 10*time.Minute {
+		c *= 0.01
+	}
+	attempts := ka.Attempts
+	if attempts > 8 {
+		attempts = 8
+	}
+	return c * math.Pow(0.66, float64(attempts))
+}
+
+// addrManager is the address book. New addresses we only heard of and
+// tried ones we connected to live in separate tables split into buckets
+// chosen by a secret key and the network group of the address and of who
+// told us about it, so no single source can fill the book with addresses
+// it controls.
+type addrManager struct {
+	mu    sync.Mutex
+	path  string
+	key   [32]byte
+	index map[string]*knownAddress
+	// bucket keys are the address keys
+	newBuckets   [newBucketCount]map[string]*knownAddress
+	triedBuckets [triedBucketCount]map[string]*knownAddress
+	nNew         int
+	nTried       int
+}
+
+var addrMan *addrManager
+
+func newAddrManager(path string) *addrManager {
+	a := &addrManager{path: path, index: make(map[string]*knownAddress)}
+	for i := range a.newBuckets {
+		a.newBuckets[i] = make(map[string]*knownAddress)
+	}
+	for i := range a.triedBuckets {
+		a.triedBuckets[i] = make(map[string]*knownAddress)
+	}
+	rand.Read(a.key[:])
+	return a
+}
+
+// groupKey is the network group of ip, addresses in one group are likely
+// run by the same operator
+func groupKey(ip net.IP) string {
+	if ip == nil {
+		return "self"
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return "local"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d", ip4[0], ip4[1])
+	}
+	return ip.Mask(net.CIDRMask(32, 128)).String()
+}
+
+func (a *addrManager) hash(data ...[]byte) uint64 {
+	h := sha256.New()
+	h.Write(a.key[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	return binary.LittleEndian.Uint64(h.Sum(nil))
+}
+
+func uint64Bytes(n uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	return b[:]
+}
+
+func (a *addrManager) newBucket(addr net.IP, source net.IP) int {
+	sourceGroup := []byte(groupKey(source))
+	h := a.hash([]byte(groupKey(addr)), sourceGroup) % newBucketsPerSourceGroup
+	return int(a.hash(sourceGroup, uint64Bytes(h)) % newBucketCount)
+}
+
+func (a *addrManager) triedBucket(ka *knownAddress) int {
+	h := a.hash([]byte(ka.key())) % triedBucketsPerGroup
+	return int(a.hash([]byte(groupKey(ka.Addr.Address)), uint64Bytes(h)) % triedBucketCount)
+}
+
+// Add puts addresses source told us about in the new table, or refreshes
+// them if we know them already.
+func (a *addrManager) Add(addrs []*wire.NetAddr, source net.IP) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, addr := range addrs {
+		a.add(addr, source)
+	}
+}
+
+func (a *addrManager) add(addr *wire.NetAddr, source net.IP) {
+	if addr.Port == 0 || addr.Address == nil || addr.Address.IsUnspecified() {
+		return
+	}
+	key := addrKey(addr.Address, addr.Port)
+	if ka, ok := a.index[key]; ok {
+		if addr.Timestamp > ka.Addr.Timestamp {
+			ka.Addr.Timestamp = addr.Timestamp
+		}
+		ka.Addr.Services |= addr.Services
+		return
+	}
+	na := *addr
+	if na.Timestamp == 0 || time.Unix(int64(na.Timestamp), 0).After(time.Now().Add(10*time.Minute)) {
+		na.Timestamp = uint32(time.Now().Unix())
+	}
+	ka := &knownAddress{Addr: &na, Source: source}
+	bucket := a.newBuckets[a.newBucket(na.Address, source)]
+	if len(bucket) >= bucketSize {
+		a.evictNew(bucket)
+	}
+	bucket[key] = ka
+	a.index[key] = ka
+	a.nNew++
+}
+
+// evictNew makes room in a full new bucket, dropping a terrible address
+// if there is one and the oldest otherwise
+func (a *addrManager) evictNew(bucket map[string]*knownAddress) {
+	now := time.Now()
+	var oldest *knownAddress
+	for _, ka := range bucket {
+		if ka.isTerrible(now) {
+			oldest = ka
+			break
+		}
+		if oldest == nil || ka.Addr.Timestamp < oldest.Addr.Timestamp {
+			oldest = ka
+		}
+	}
+	delete(bucket, oldest.key())
+	delete(a.index, oldest.key())
+	a.nNew--
+}
+
+// Attempt records that we are dialing the address
+func (a *addrManager) Attempt(addr *wire.NetAddr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ka, ok := a.index[addrKey(addr.Address, addr.Port)]; ok {
+		ka.Attempts++
+		ka.LastAttempt = time.Now()
+	}
+}
+
+// Good records a completed handshake and moves the address to the tried
+// table, pushing the oldest address of a full tried bucket back to new.
+func (a *addrManager) Good(addr *wire.NetAddr) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := addrKey(addr.Address, addr.Port)
+	ka, ok := a.index[key]
+	if !ok {
+		a.add(addr, nil)
+		ka, ok = a.index[key]
+		if !ok {
+			return
+		}
+	}
+	now := time.Now()
+	ka.Attempts = 0
+	ka.LastSuccess = now
+	ka.LastAttempt = now
+	ka.Addr.Timestamp = uint32(now.Unix())
+	if ka.Tried {
+		return
+	}
+	delete(a.newBuckets[a.newBucket(ka.Addr.Address, ka.Source)], key)
+	a.nNew--
+	bucket := a.triedBuckets[a.triedBucket(ka)]
+	if len(bucket) >= bucketSize {
+		var oldest *knownAddress
+		for _, old := range bucket {
+			if oldest == nil || old.LastSuccess.Before(oldest.LastSuccess) {
+				oldest = old
+			}
+		}
+		delete(bucket, oldest.key())
+		a.nTried--
+		oldest.Tried = false
+		newBucket := a.newBuckets[a.newBucket(oldest.Addr.Address, oldest.Source)]
+		if len(newBucket) >= bucketSize {
+			a.evictNew(newBucket)
+		}
+		newBucket[oldest.key()] = oldest
+		a.nNew++
+	}
+	ka.Tried = true
+	bucket[key] = ka
+	a.nTried++
+}
+
+// Select picks an address to connect to, half the time from each table
+// when both have some, favoring addresses that haven't failed lately.
+// newOnly limits it to addresses we have never connected to. It returns nil
+// when there is nothing to pick.
+func (a *addrManager) Select(newOnly bool) *wire.NetAddr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.nNew+a.nTried == 0 || (newOnly && a.nNew == 0) {
+		return nil
+	}
+	useTried := !newOnly && a.nTried > 0 && (a.nNew == 0 || mathrand.Intn(2) == 0)
+	buckets := a.newBuckets[:]
+	if useTried {
+		buckets = a.triedBuckets[:]
+	}
+	now := time.Now()
+	factor := 1.0
+	for {
+		bucket := buckets[mathrand.Intn(len(buckets))]
+		if len(bucket) == 0 {
+			continue
+		}
+		pick := mathrand.Intn(len(bucket))
+		for _, ka := range bucket {
+			if pick > 0 {
+				pick--
+				continue
+			}
+			if mathrand.Float64() < factor*ka.chance(now) {
+				na := *ka.Addr
+				return &na
+			}
+			break
+		}
+		factor *= 1.2
+	}
+}
+
+// addrSamplePercent of the book goes out in answer to a getaddr, capped at
+// wire.MaxAddrPerMsg
+const addrSamplePercent = 23
+
+// Sample returns a random share of the addresses we know, leaving out ones
+// that look dead, to answer a getaddr
+func (a *addrManager) Sample() []*wire.NetAddr {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	addrs := make([]*wire.NetAddr, 0, len(a.index))
+	for _, ka := range a.index {
+		if ka.isTerrible(now) {
+			continue
+		}
+		na := *ka.Addr
+		addrs = append(addrs, &na)
+	}
+	mathrand.Shuffle(len(addrs), func(i, j int) {
+		addrs[i], addrs[j] = addrs[j], addrs[i]
+	})
+	n := len(a.index) * addrSamplePercent / 100
+	if n > wire.MaxAddrPerMsg {
+		n = wire.MaxAddrPerMsg
+	}
+	if n < len(addrs) {
+		addrs = addrs[:n]
+	}
+	return addrs
+}
+
+// Size is how many addresses the book holds
+func (a *addrManager) Size() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.nNew + a.nTried
+}
+
+// addrFile is what the address book looks like on disk, buckets are
+// worked out again from the key when it is loaded
+type addrFile struct {
+	Key   [32]byte
+	Addrs []*knownAddress
+}
+
+// load reads the address book from disk, a missing file leaves it empty
+func (a *addrManager) load() error {
+	data, err := os.ReadFile(a.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file addrFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return fmt.Errorf("corrupt address book %v: %w", a.path, err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.key = file.Key
+	tried := make([]*knownAddress, 0)
+	for _, ka := range file.Addrs {
+		if ka.Addr == nil {
+			continue
+		}
+		wasTried := ka.Tried
+		ka.Tried = false
+		a.add(ka.Addr, ka.Source)
+		loaded, ok := a.index[ka.key()]
+		if !ok {
+			continue
+		}
+		loaded.Attempts = ka.Attempts
+		loaded.LastAttempt = ka.LastAttempt
+		loaded.LastSuccess = ka.LastSuccess
+		if wasTried {
+			tried = append(tried, loaded)
+		}
+	}
+	for _, ka := range tried {
+		// a full bucket can have evicted it when later addresses went in
+		if a.index[ka.key()] != ka {
+			continue
+		}
+		delete(a.newBuckets[a.newBucket(ka.Addr.Address, ka.Source)], ka.key())
+		a.nNew--
+		bucket := a.triedBuckets[a.triedBucket(ka)]
+		if len(bucket) >= bucketSize {
+			// leave it in new, put it back
+			a.newBuckets[a.newBucket(ka.Addr.Address, ka.Source)][ka.key()] = ka
+			a.nNew++
+			continue
+		}
+		ka.Tried = true
+		bucket[ka.key()] = ka
+		a.nTried++
+	}
+	return nil
+}
+
+// save writes the address book to disk through a temporary file so a crash
+// can't leave half of one
+func (a *addrManager) save() error {
+	a.mu.Lock()
+	file := addrFile{Key: a.key, Addrs: make([]*knownAddress, 0, len(a.index))}
+	for _, ka := range a.index {
+		file.Addrs = append(file.Addrs, ka)
+	}
+	data, err := json.Marshal(file)
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := a.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.path)
+}
+
+// saveLoop writes the address book out every addrSaveInterval
+func (a *addrManager) saveLoop() {
+	for range time.Tick(addrSaveInterval) {
+		err := a.save()
+		if err != nil {
+			fmt.Println("failed to save addresses:", err)
+		}
+	}
+}
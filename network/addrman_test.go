@@ -0,0 +1,242 @@
+package network
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+// checkAddrMan makes sure every address is in the bucket its key and
+// groups say, once, and the counts match
+func checkAddrMan(t *testing.T, a *addrManager) {
+	t.Helper()
+	seen := 0
+	for i, bucket := range a.newBuckets {
+		for key, ka := range bucket {
+			if a.index[key] != ka || ka.Tried || a.newBucket(ka.Addr.Address, ka.Source) != i {
+				t.Errorf("%v is in new bucket %v but shouldn't be", key, i)
+			}
+		}
+		seen += len(bucket)
+	}
+	if seen != a.nNew {
+		t.Errorf("%v addresses in new buckets, nNew is %v", seen, a.nNew)
+	}
+	seen = 0
+	for i, bucket := range a.triedBuckets {
+		for key, ka := range bucket {
+			if a.index[key] != ka || !ka.Tried || a.triedBucket(ka) != i {
+				t.Errorf("%v is in tried bucket %v but shouldn't be", key, i)
+			}
+		}
+		seen += len(bucket)
+	}
+	if seen != a.nTried {
+		t.Errorf("%v addresses in tried buckets, nTried is %v", seen, a.nTried)
+	}
+	if len(a.index) != a.nNew+a.nTried {
+		t.Errorf("%v addresses indexed, %v new and %v tried", len(a.index), a.nNew, a.nTried)
+	}
+}
+
+func testAddr(ip net.IP, port uint16, age time.Duration) *wire.NetAddr {
+	return &wire.NetAddr{Timestamp: uint32(time.Now().Add(-age).Unix()), Services: 1, Address: ip, Port: port}
+}
+
+var testSource = net.ParseIP("8.8.8.8")
+
+func TestAddrBucketPlacement(t *testing.T) {
+	a := newAddrManager("")
+	newBuckets := make(map[int]bool)
+	for i := 0; i < 5000; i++ {
+		ip := net.IPv4(byte(1+i%200), byte(i/200), 1, 1)
+		newBuckets[a.newBucket(ip, testSource)] = true
+		// the port doesn't matter for new buckets
+		if a.newBucket(ip, testSource) != a.newBucket(net.IPv4(ip[12], ip[13], 9, 9), testSource) {
+			t.Fatalf("%v and its /16 got different new buckets", ip)
+		}
+	}
+	if len(newBuckets) > newBucketsPerSourceGroup {
+		t.Errorf("one source filled %v new buckets, the limit is %v", len(newBuckets), newBucketsPerSourceGroup)
+	}
+	if len(newBuckets) < newBucketsPerSourceGroup/2 {
+		t.Errorf("one source only got %v new buckets", len(newBuckets))
+	}
+
+	triedBuckets := make(map[int]bool)
+	for i := 0; i < 2000; i++ {
+		ka := &knownAddress{Addr: testAddr(net.IPv4(50, 60, byte(i/250), byte(i%250)), uint16(8333+i%3), 0)}
+		triedBuckets[a.triedBucket(ka)] = true
+	}
+	if len(triedBuckets) > triedBucketsPerGroup {
+		t.Errorf("one group got %v tried buckets, the limit is %v", len(triedBuckets), triedBucketsPerGroup)
+	}
+
+	// nobody else can work out where an address goes
+	other := newAddrManager("")
+	same := 0
+	for i := 0; i < 100; i++ {
+		ip := net.IPv4(byte(1+i), 2, 3, 4)
+		if a.newBucket(ip, testSource) == other.newBucket(ip, testSource) {
+			same++
+		}
+	}
+	if same > 10 {
+		t.Errorf("%v of 100 addresses got the same bucket under another key", same)
+	}
+}
+
+func TestAddrNewEviction(t *testing.T) {
+	a := newAddrManager("")
+	// one /16 from one source all goes in one bucket
+	var addrs []*wire.NetAddr
+	for i := 0; i < bucketSize; i++ {
+		addrs = append(addrs, testAddr(net.IPv4(20, 1, byte(i), 1), 8333, time.Duration(bucketSize-i)*time.Minute))
+	}
+	a.Add(addrs, testSource)
+	bucket := a.newBuckets[a.newBucket(addrs[0].Address, testSource)]
+	if len(bucket) != bucketSize {
+		t.Fatalf("bucket has %v addresses, want %v", len(bucket), bucketSize)
+	}
+
+	// the oldest goes to make room
+	a.Add([]*wire.NetAddr{testAddr(net.IPv4(20, 1, 200, 1), 8333, 0)}, testSource)
+	if _, ok := a.index[addrKey(addrs[0].Address, 8333)]; ok {
+		t.Error("the oldest address wasn't evicted")
+	}
+	checkAddrMan(t, a)
+
+	// unless one is terrible
+	terrible := a.index[addrKey(addrs[10].Address, 8333)]
+	terrible.Attempts = addrRetries
+	terrible.LastAttempt = time.Now().Add(-time.Hour)
+	a.Add([]*wire.NetAddr{testAddr(net.IPv4(20, 1, 201, 1), 8333, 0)}, testSource)
+	if _, ok := a.index[terrible.key()]; ok {
+		t.Error("the terrible address wasn't evicted")
+	}
+	if _, ok := a.index[addrKey(addrs[1].Address, 8333)]; !ok {
+		t.Error("the oldest address was evicted instead of the terrible one")
+	}
+	if a.Size() != bucketSize {
+		t.Errorf("size %v, want %v", a.Size(), bucketSize)
+	}
+	checkAddrMan(t, a)
+}
+
+func TestAddrTriedEviction(t *testing.T) {
+	a := newAddrManager("")
+	// find enough addresses of one group for a tried bucket and one more
+	var addrs []*wire.NetAddr
+	want := -1
+	for i := 0; len(addrs) <= bucketSize; i++ {
+		addr := testAddr(net.IPv4(30, 2, byte(i/250), byte(i%250)), 8333, 0)
+		bucket := a.triedBucket(&knownAddress{Addr: addr})
+		if want == -1 {
+			want = bucket
+		}
+		if bucket == want {
+			addrs = append(addrs, addr)
+		}
+	}
+	// spread them over new buckets so those don't fill up
+	for i, addr := range addrs {
+		a.Add([]*wire.NetAddr{addr}, net.IPv4(9, byte(i), 1, 1))
+	}
+	for _, addr := range addrs[:bucketSize] {
+		a.Good(addr)
+	}
+	if a.nTried != bucketSize || len(a.triedBuckets[want]) != bucketSize {
+		t.Fatalf("%v tried, want %v in bucket %v", a.nTried, bucketSize, want)
+	}
+	checkAddrMan(t, a)
+
+	// the one that connected longest ago goes back to new
+	oldest := a.index[addrKey(addrs[3].Address, 8333)]
+	oldest.LastSuccess = time.Now().Add(-time.Hour)
+	a.Good(addrs[bucketSize])
+	if oldest.Tried || a.index[oldest.key()] != oldest {
+		t.Errorf("oldest tried %v, indexed %v", oldest.Tried, a.index[oldest.key()] != nil)
+	}
+	if !a.index[addrKey(addrs[bucketSize].Address, 8333)].Tried {
+		t.Error("the new good address isn't tried")
+	}
+	if a.nTried != bucketSize || a.nNew != 1 {
+		t.Errorf("%v tried and %v new, want %v and 1", a.nTried, a.nNew, bucketSize)
+	}
+	checkAddrMan(t, a)
+}
+
+func TestAddrSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	a := newAddrManager(path)
+	for i := 0; i < 300; i++ {
+		a.Add([]*wire.NetAddr{testAddr(net.IPv4(byte(1+i%100), byte(i), 3, 4), 8333, time.Duration(i)*time.Minute)}, net.IPv4(byte(100+i%7), 1, 1, 1))
+	}
+	for i := 0; i < 300; i += 7 {
+		a.Good(testAddr(net.IPv4(byte(1+i%100), byte(i), 3, 4), 8333, 0))
+	}
+	attempted := testAddr(net.IPv4(2, 1, 3, 4), 8333, 0)
+	a.Attempt(attempted)
+	a.Attempt(attempted)
+	checkAddrMan(t, a)
+	if err := a.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newAddrManager(path)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	checkAddrMan(t, loaded)
+	if loaded.key != a.key {
+		t.Error("key wasn't loaded")
+	}
+	if loaded.nNew != a.nNew || loaded.nTried != a.nTried {
+		t.Errorf("loaded %v new and %v tried, saved %v and %v", loaded.nNew, loaded.nTried, a.nNew, a.nTried)
+	}
+	for key, ka := range a.index {
+		got, ok := loaded.index[key]
+		if !ok {
+			t.Errorf("%v wasn't loaded", key)
+			continue
+		}
+		if got.Tried != ka.Tried || got.Attempts != ka.Attempts || !got.LastSuccess.Equal(ka.LastSuccess) ||
+			!got.LastAttempt.Equal(ka.LastAttempt) || got.Addr.Timestamp != ka.Addr.Timestamp || !got.Source.Equal(ka.Source) {
+			t.Errorf("%v loaded as %+v, saved as %+v", key, got, ka)
+		}
+	}
+}
+
+// a tried address a later address pushes out of its full new bucket while
+// loading isn't put in the tried table anyway
+func TestAddrLoadEvictedTried(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.json")
+	now := time.Now()
+	tried := &knownAddress{Addr: testAddr(net.IPv4(40, 1, 0, 1), 8333, time.Hour), Source: testSource, LastSuccess: now, LastAttempt: now, Tried: true}
+	file := addrFile{Addrs: []*knownAddress{tried}}
+	// the same /16 and source so they share its new bucket, all newer
+	for i := 0; i < bucketSize; i++ {
+		file.Addrs = append(file.Addrs, &knownAddress{Addr: testAddr(net.IPv4(40, 1, 1, byte(i)), 8333, 0), Source: testSource})
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAddrManager(path)
+	if err := a.load(); err != nil {
+		t.Fatal(err)
+	}
+	checkAddrMan(t, a)
+	if a.nTried != 0 || a.nNew != bucketSize {
+		t.Errorf("%v tried and %v new, want 0 and %v", a.nTried, a.nNew, bucketSize)
+	}
+}
@@ -0,0 +1,224 @@
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// peers whose misbehavior adds up to this are banned
+	banThreshold = 100
+	// how long automatic bans last
+	defaultBanTime = 24 * time.Hour
+)
+
+// banEntry is a banned subnet, single addresses are /32 or /128
+type banEntry struct {
+	Subnet  string
+	Created time.Time
+	Until   time.Time
+}
+
+// banManager keeps the subnets we refuse to talk to and writes them to
+// disk whenever they change so bans outlive a restart.
+type banManager struct {
+	mu   sync.Mutex
+	path string
+	bans map[string]*banEntry
+}
+
+var banMan *banManager
+
+// bansLoaded is closed once Start has loaded banMan. Ban and Unban are
+// called from outside the network's goroutines and may come first.
+var bansLoaded = make(chan struct{})
+
+var errNotStarted = errors.New("the network isn't started yet")
+
+// loadedBans is banMan, nil until Start has loaded it
+func loadedBans() *banManager {
+	select {
+	case <-bansLoaded:
+		return banMan
+	default:
+		return nil
+	}
+}
+
+func newBanManager(path string) *banManager {
+	return &banManager{path: path, bans: make(map[string]*banEntry)}
+}
+
+// parseSubnet accepts an IP or a CIDR subnet
+func parseSubnet(target string) (*net.IPNet, error) {
+	if strings.Contains(target, "/") {
+		_, subnet, err := net.ParseCIDR(target)
+		return subnet, err
+	}
+	ip := net.ParseIP(target)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP or subnet %q", target)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+func (b *banManager) ban(subnet *net.IPNet, duration time.Duration) error {
+	now := time.Now()
+	b.mu.Lock()
+	b.bans[subnet.String()] = &banEntry{Subnet: subnet.String(), Created: now, Until: now.Add(duration)}
+	b.mu.Unlock()
+	return b.save()
+}
+
+func (b *banManager) unban(subnet *net.IPNet) error {
+	b.mu.Lock()
+	_, ok := b.bans[subnet.String()]
+	delete(b.bans, subnet.String())
+	b.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%v is not banned", subnet)
+	}
+	return b.save()
+}
+
+// isBanned tells whether ip is in a subnet whose ban hasn't run out
+func (b *banManager) isBanned(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for key, entry := range b.bans {
+		if now.After(entry.Until) {
+			delete(b.bans, key)
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(entry.Subnet)
+		if err == nil && subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *banManager) load() error {
+	data, err := os.ReadFile(b.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*banEntry
+	err = json.Unmarshal(data, &entries)
+	if err != nil {
+		return fmt.Errorf("corrupt ban list %v: %w", b.path, err)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for _, entry := range entries {
+		_, subnet, err := net.ParseCIDR(entry.Subnet)
+		if err != nil || now.After(entry.Until) {
+			continue
+		}
+		entry.Subnet = subnet.String()
+		b.bans[entry.Subnet] = entry
+	}
+	return nil
+}
+
+// save writes the ban list through a temporary file like the address book
+func (b *banManager) save() error {
+	b.mu.Lock()
+	entries := make([]*banEntry, 0, len(b.bans))
+	for _, entry := range b.bans {
+		entries = append(entries, entry)
+	}
+	data, err := json.Marshal(entries)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+// Ban refuses connections from target, an IP or CIDR subnet, for duration
+// and drops the peers we have in it
+func Ban(target string, duration time.Duration) error {
+	bans := loadedBans()
+	if bans == nil {
+		return errNotStarted
+	}
+	subnet, err := parseSubnet(target)
+	if err != nil {
+		return err
+	}
+	err = bans.ban(subnet, duration)
+	if err != nil {
+		return err
+	}
+	for _, peer := range Peers.All() {
+		if subnet.Contains(remoteIP(peer)) {
+			peer.disconnect()
+		}
+	}
+	connMgr.mu.Lock()
+	defer connMgr.mu.Unlock()
+	for peer := range connMgr.inbound {
+		if subnet.Contains(remoteIP(peer)) {
+			peer.disconnect()
+		}
+	}
+	return nil
+}
+
+// Unban lifts the ban on target, which has to match how it was banned
+func Unban(target string) error {
+	bans := loadedBans()
+	if bans == nil {
+		return errNotStarted
+	}
+	subnet, err := parseSubnet(target)
+	if err != nil {
+		return err
+	}
+	return bans.unban(subnet)
+}
+
+// misbehaving adds howMuch to the peer's score and bans its address once
+// the score reaches banThreshold. Peers we were told to connect to are
+// only disconnected.
+func (p *Peer) misbehaving(howMuch int, reason string) {
+	score := atomic.AddInt32(&p.misbehavior, int32(howMuch))
+	fmt.Printf("peer %v misbehaving (%v -> %v): %v\n", p.Conn.RemoteAddr(), score-int32(howMuch), score, reason)
+	if score < banThreshold {
+		return
+	}
+	if p.connType != connManual {
+		subnet, err := parseSubnet(remoteIP(p).String())
+		if err == nil {
+			err = banMan.ban(subnet, defaultBanTime)
+		}
+		if err != nil {
+			fmt.Println("failed to ban peer:", err)
+		}
+	}
+	p.disconnect()
+}
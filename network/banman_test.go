@@ -0,0 +1,50 @@
+package network
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Ban and Unban come from the operator, who can be quicker than Start
+func TestBanBeforeStart(t *testing.T) {
+	defer func(loaded chan struct{}, bans *banManager) {
+		bansLoaded, banMan = loaded, bans
+	}(bansLoaded, banMan)
+	bansLoaded, banMan = make(chan struct{}), nil
+
+	if err := Ban("10.0.0.1", time.Hour); err != errNotStarted {
+		t.Errorf("ban got %v, want %v", err, errNotStarted)
+	}
+	if err := Unban("10.0.0.1"); err != errNotStarted {
+		t.Errorf("unban got %v, want %v", err, errNotStarted)
+	}
+
+	path := filepath.Join(t.TempDir(), "banlist.json")
+	banMan = newBanManager(path)
+	close(bansLoaded)
+	if err := Ban("10.0.0.0/8", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !banMan.isBanned(net.ParseIP("10.1.2.3")) || banMan.isBanned(net.ParseIP("11.0.0.1")) {
+		t.Error("10.0.0.0/8 isn't what's banned")
+	}
+	// the ban is on disk
+	loaded := newBanManager(path)
+	if err := loaded.load(); err != nil || !loaded.isBanned(net.ParseIP("10.1.2.3")) {
+		t.Errorf("ban wasn't saved: %v", err)
+	}
+	if err := Unban("10.0.0.1"); err == nil {
+		t.Error("unbanned an address inside a banned subnet")
+	}
+	if err := Unban("10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	if banMan.isBanned(net.ParseIP("10.1.2.3")) {
+		t.Error("still banned after unban")
+	}
+	if err := Ban("not an address", time.Hour); err == nil {
+		t.Error("banned garbage")
+	}
+}
@@ -0,0 +1,179 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/singurty/goldchain/blockchain"
+)
+
+const (
+	// how far past the last connected block we download
+	blockDownloadWindow      = 1024
+	maxBlocksInFlightPerPeer = 16
+	// how long a peer gets to deliver a block we asked for
+	blockStallTimeout = time.Minute
+	// peers that time out this many times are disconnected
+	maxBlockStalls = 3
+	// how often requests are checked and handed out
+	blockDownloadTick = time.Second
+)
+
+// blockRequest is a block asked for and who from
+type blockRequest struct {
+	peer      *Peer
+	requested time.Time
+}
+
+// blockReceived tells the scheduler peer sent the block hash
+type blockReceived struct {
+	peer *Peer
+	hash [32]byte
+}
+
+// blockDownloader hands the blocks missing after the header sync out to
+// peers so each is asked for by one peer at a time. Requests that time out
+// go to another peer and peers that keep timing out are dropped. All of
+// its state is owned by its one goroutine.
+type blockDownloader struct {
+	received chan blockReceived
+	inFlight map[[32]byte]*blockRequest
+	// how many of inFlight each peer has
+	peerInFlight map[*Peer]int
+	stalls       map[*Peer]int
+	// the peer that last timed out on a block, so it goes elsewhere
+	timedOut map[[32]byte]*Peer
+}
+
+var blockDownload = &blockDownloader{
+	received:     make(chan blockReceived, maxPeers),
+	inFlight:     make(map[[32]byte]*blockRequest),
+	peerInFlight: make(map[*Peer]int),
+	stalls:       make(map[*Peer]int),
+	timedOut:     make(map[[32]byte]*Peer),
+}
+
+func (d *blockDownloader) run() {
+	ticker := time.NewTicker(blockDownloadTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case r := <-d.received:
+			d.blockReceived(r.peer, r.hash)
+		case <-ticker.C:
+			d.checkRequests()
+			d.schedule()
+		}
+	}
+}
+
+func (d *blockDownloader) blockReceived(peer *Peer, hash [32]byte) {
+	req, ok := d.inFlight[hash]
+	if !ok {
+		return
+	}
+	d.finish(hash, req)
+	delete(d.timedOut, hash)
+	// a peer that delivers is forgiven earlier stalls
+	if req.peer == peer {
+		d.stalls[peer] = 0
+	}
+}
+
+func (d *blockDownloader) finish(hash [32]byte, req *blockRequest) {
+	delete(d.inFlight, hash)
+	d.peerInFlight[req.peer]--
+	if d.peerInFlight[req.peer] <= 0 {
+		delete(d.peerInFlight, req.peer)
+	}
+}
+
+// checkRequests frees the blocks of peers that are gone or too slow so
+// schedule can ask someone else. A peer stalls once per check however many
+// of its blocks timed out.
+func (d *blockDownloader) checkRequests() {
+	stalled := make(map[*Peer]bool)
+	for hash, req := range d.inFlight {
+		if !req.peer.Alive() {
+			d.finish(hash, req)
+			continue
+		}
+		if time.Since(req.requested) < blockStallTimeout {
+			continue
+		}
+		d.finish(hash, req)
+		d.timedOut[hash] = req.peer
+		stalled[req.peer] = true
+	}
+	for peer := range d.stalls {
+		if !peer.Alive() {
+			delete(d.stalls, peer)
+		}
+	}
+	for peer := range stalled {
+		d.stalls[peer]++
+		fmt.Printf("peer %v stalled on a block download\n", peer.Conn.RemoteAddr())
+		if d.stalls[peer] >= maxBlockStalls {
+			fmt.Printf("disconnecting peer %v after stalling %v times\n", peer.Conn.RemoteAddr(), d.stalls[peer])
+			delete(d.stalls, peer)
+			peer.disconnect()
+		}
+	}
+}
+
+// schedule asks for the missing blocks in the window that nobody has been
+// asked for, spreading them over the peers with the fewest in flight.
+func (d *blockDownloader) schedule() {
+	requests := make(map[*Peer][][32]byte)
+	for _, block := range blockchain.MissingBlocks(blockDownloadWindow) {
+		if _, ok := d.inFlight[block.Hash]; ok {
+			continue
+		}
+		peer := d.pickPeer(block)
+		if peer == nil {
+			continue
+		}
+		d.inFlight[block.Hash] = &blockRequest{peer: peer, requested: time.Now()}
+		d.peerInFlight[peer]++
+		requests[peer] = append(requests[peer], block.Hash)
+	}
+	for peer, hashes := range requests {
+		err := peer.GetBlocks(hashes)
+		if err != nil {
+			fmt.Println("failed to request blocks", err)
+			peer.disconnect()
+		}
+	}
+}
+
+// pickPeer is the least busy segwit peer that should have block, the one that
+// stalls the least and then answers pings fastest among equals, nil if they
+// are all full. The peer that last timed out on it is only asked if nobody
+// else can be.
+func (d *blockDownloader) pickPeer(block *blockchain.Block) *Peer {
+	var best, fallback *Peer
+	for _, peer := range Peers.All() {
+		if !peer.Alive() || !peer.witness() || d.peerInFlight[peer] >= maxBlocksInFlightPerPeer || peer.BestHeight() < block.Height {
+			continue
+		}
+		if peer == d.timedOut[block.Hash] {
+			fallback = peer
+			continue
+		}
+		if best == nil || d.peerInFlight[peer] < d.peerInFlight[best] {
+			best = peer
+			continue
+		}
+		if d.peerInFlight[peer] > d.peerInFlight[best] {
+			continue
+		}
+		if d.stalls[peer] < d.stalls[best] ||
+			(d.stalls[peer] == d.stalls[best] && peer.latency() < best.latency()) {
+			best = peer
+		}
+	}
+	if best == nil {
+		return fallback
+	}
+	return best
+}
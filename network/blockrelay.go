@@ -0,0 +1,33 @@
+package network
+
+import (
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/wire"
+)
+
+// announceBlock tells peers about a new tip, with its header to those that
+// sent sendheaders and an inv to the rest. Blocks connected while we are
+// still catching up to the best header aren't news to anyone.
+func announceBlock(block *blockchain.Block) {
+	tip := blockchain.LastBlock()
+	if tip == nil || block.Hash != tip.Hash {
+		return
+	}
+	header := &block.ToWire().Header
+	for _, peer := range Peers.All() {
+		if !peer.Alive() || peer.BestHeight() >= block.Height {
+			continue
+		}
+		peer.mu.Lock()
+		sendHeaders := peer.sendHeaders
+		peer.mu.Unlock()
+		var msg wire.Message = &wire.InvMsg{InvList: []*wire.InvVect{{Type: wire.InvTypeBlock, Hash: block.Hash}}}
+		if sendHeaders {
+			msg = &wire.HeadersMsg{Headers: []*wire.BlockHeader{header}}
+		}
+		err := peer.sendMessage(msg)
+		if err != nil {
+			peer.disconnect()
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+const (
+	// outbound peers we relay everything with
+	maxOutboundFullRelay = 8
+	// outbound peers we only exchange blocks with, they leak nothing about
+	// our addresses or transactions so are harder to map out
+	maxOutboundBlockRelay = 2
+	// how often a short lived connection checks that an address is up
+	feelerInterval = 2 * time.Minute
+	// how often empty slots are filled
+	connectInterval = 500 * time.Millisecond
+	dialTimeout     = 10 * time.Second
+	// failed dials wait this long, doubling each time up to maxRetryBackoff
+	retryBackoff    = 5 * time.Second
+	maxRetryBackoff = 10 * time.Minute
+	// how many addresses are drawn from the book looking for a usable one
+	maxAddrTries = 100
+)
+
+type connType int
+
+const (
+	connFullRelay connType = iota
+	connBlockRelay
+	// closed as soon as the handshake is done
+	connFeeler
+	// asked for with -addnode, kept up whatever the slots say
+	connManual
+)
+
+// outbound is a connection we made or are making, peer is nil while
+// dialing
+type outbound struct {
+	addr *wire.NetAddr
+	typ  connType
+	peer *Peer
+}
+
+// connManager keeps the outbound slots filled. Addresses come from the
+// address book, at most one per network group so a single operator can't
+// own all our connections, and ones that fail are retried with backoff.
+// It also keeps count of the peers that connected to us.
+type connManager struct {
+	mu       sync.Mutex
+	conns    map[string]*outbound
+	failures map[string]int
+	retryAt  map[string]time.Time
+	manual   []*wire.NetAddr
+	// peers that connected to us
+	inbound map[*Peer]bool
+	// when the next feeler goes out
+	nextFeeler time.Time
+}
+
+var connMgr = &connManager{
+	conns:    make(map[string]*outbound),
+	failures: make(map[string]int),
+	retryAt:  make(map[string]time.Time),
+	inbound:  make(map[*Peer]bool),
+}
+
+func (c *connManager) run() {
+	c.nextFeeler = time.Now().Add(feelerInterval)
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.fillSlots()
+	}
+}
+
+// addManual keeps a connection to addr up for as long as we run
+func (c *connManager) addManual(addr *wire.NetAddr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.manual = append(c.manual, addr)
+}
+
+// fillSlots dials whatever is missing, manual peers first, then full
+// relay and block relay only slots, then a feeler when one is due.
+func (c *connManager) fillSlots() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for _, addr := range c.manual {
+		key := addrKey(addr.Address, addr.Port)
+		if _, ok := c.conns[key]; !ok && !c.retryAt[key].After(now) {
+			c.dial(addr, connManual)
+		}
+	}
+	counts := make(map[connType]int)
+	for _, o := range c.conns {
+		counts[o.typ]++
+	}
+	switch {
+	case counts[connFullRelay] < maxOutboundFullRelay:
+		if addr := c.pickAddress(false); addr != nil {
+			c.dial(addr, connFullRelay)
+		}
+	case counts[connBlockRelay] < maxOutboundBlockRelay:
+		if addr := c.pickAddress(false); addr != nil {
+			c.dial(addr, connBlockRelay)
+		}
+	case now.After(c.nextFeeler) && counts[connFeeler] == 0:
+		c.nextFeeler = now.Add(feelerInterval)
+		// feelers test addresses we have never connected to
+		if addr := c.pickAddress(true); addr != nil {
+			c.dial(addr, connFeeler)
+		}
+	}
+}
+
+// pickAddress draws from the address book until it finds one we aren't
+// connected to, aren't waiting to retry, haven't banned and whose network
+// group none of our automatic connections are in.
+func (c *connManager) pickAddress(newOnly bool) *wire.NetAddr {
+	groups := make(map[string]bool)
+	for _, o := range c.conns {
+		if o.typ != connManual {
+			groups[groupKey(o.addr.Address)] = true
+		}
+	}
+	now := time.Now()
+	for i := 0; i < maxAddrTries; i++ {
+		addr := addrMan.Select(newOnly)
+		if addr == nil {
+			return nil
+		}
+		key := addrKey(addr.Address, addr.Port)
+		if _, ok := c.conns[key]; ok || c.retryAt[key].After(now) || groups[groupKey(addr.Address)] || banMan.isBanned(addr.Address) {
+			continue
+		}
+		return addr
+	}
+	return nil
+}
+
+// dial takes the slot for addr and connects in the background, c.mu has
+// to be held
+func (c *connManager) dial(addr *wire.NetAddr, typ connType) {
+	key := addrKey(addr.Address, addr.Port)
+	o := &outbound{addr: addr, typ: typ}
+	c.conns[key] = o
+	go func() {
+		addrMan.Attempt(addr)
+		conn, err := net.DialTimeout("tcp", key, dialTimeout)
+		if err != nil {
+			c.mu.Lock()
+			delete(c.conns, key)
+			c.backoff(key)
+			c.mu.Unlock()
+			return
+		}
+		peer := &Peer{Conn: conn, addr: addr, connType: typ, connected: time.Now()}
+		c.mu.Lock()
+		o.peer = peer
+		c.mu.Unlock()
+		peer.Start()
+	}()
+}
+
+// backoff doubles how long key waits before the next dial, c.mu has to be
+// held
+func (c *connManager) backoff(key string) {
+	wait := retryBackoff << uint(c.failures[key])
+	if wait > maxRetryBackoff || wait <= 0 {
+		wait = maxRetryBackoff
+	} else {
+		c.failures[key]++
+	}
+	c.retryAt[key] = time.Now().Add(wait)
+}
+
+// handshakeDone is called by a peer's listener once verack is in
+func (c *connManager) handshakeDone(p *Peer) {
+	c.mu.Lock()
+	if p.addr != nil {
+		key := addrKey(p.addr.Address, p.addr.Port)
+		delete(c.failures, key)
+		delete(c.retryAt, key)
+	}
+	c.mu.Unlock()
+	if p.addr != nil {
+		addrMan.Good(p.addr)
+	}
+	if p.connType == connFeeler {
+		// the address works, that is all a feeler wanted to know
+		p.disconnect()
+		return
+	}
+	fmt.Printf("connected to %v\n", p.Conn.RemoteAddr())
+	Peers.add(p)
+}
+
+// peerClosed is called by a peer's handler when it stops
+func (c *connManager) peerClosed(p *Peer) {
+	wasAlive := Peers.remove(p)
+	c.mu.Lock()
+	delete(c.inbound, p)
+	if p.addr != nil {
+		key := addrKey(p.addr.Address, p.addr.Port)
+		if o, ok := c.conns[key]; ok && o.peer == p {
+			delete(c.conns, key)
+		}
+		if (!wasAlive && p.connType != connFeeler) || p.connType == connManual {
+			c.backoff(key)
+		}
+	}
+	c.mu.Unlock()
+	if wasAlive {
+		fmt.Printf("disconnected from %v\n", p.Conn.RemoteAddr())
+	}
+}
+
+func (t connType) String() string {
+	switch t {
+	case connFullRelay:
+		return "full-relay"
+	case connBlockRelay:
+		return "block-relay-only"
+	case connFeeler:
+		return "feeler"
+	case connManual:
+		return "manual"
+	}
+	return "unknown"
+}
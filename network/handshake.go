@@ -0,0 +1,219 @@
+package network
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/mempool"
+	"github.com/singurty/goldchain/wire"
+)
+
+const (
+	// UserAgent is what we call ourselves in version messages, BIP14
+	UserAgent = "/goldchain:0.1.0/"
+	// peers older than this don't know headers first sync
+	MinProtocolVersion = 70002
+	// versions that introduced the messages we negotiate
+	sendHeadersVersion = 70012
+	feeFilterVersion   = 70013
+	wtxidRelayVersion  = 70016
+	// how long a peer gets to finish version and verack
+	handshakeTimeout = time.Minute
+	// what we offer: full blocks with witnesses
+	localServices = wire.SFNodeNetwork | wire.SFNodeWitness
+)
+
+type handshakeState int
+
+const (
+	// nothing from the peer yet, we may or may not have sent our version
+	awaitingVersion handshakeState = iota
+	// both versions are out, waiting on the peer's verack
+	awaitingVerack
+	established
+)
+
+// the nonces in version messages we sent that haven't been answered, a
+// version carrying one of them means we connected to ourselves
+var localNonces = struct {
+	sync.Mutex
+	m map[uint64]bool
+}{m: make(map[uint64]bool)}
+
+func randomNonce() (uint64, error) {
+	var b [8]byte
+	_, err := rand.Read(b[:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b[:]), nil
+}
+
+func (p *Peer) sendVersion() error {
+	nonce, err := randomNonce()
+	if err != nil {
+		return err
+	}
+	localNonces.Lock()
+	localNonces.m[nonce] = true
+	localNonces.Unlock()
+	p.localNonce = nonce
+	msg := &wire.VersionMsg{
+		Version:      int32(ProtocolVersion),
+		Services:     localServices,
+		Timestamp:    time.Now().Unix(),
+		Nonce:        nonce,
+		User_agent:   UserAgent,
+		Start_height: int32(blockchain.BestHeight()),
+		// block relay only peers shouldn't send us transactions
+		Relay: p.connType != connBlockRelay,
+	}
+	if tcpAddr, ok := p.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		msg.Addr_recv = wire.NetAddr{Address: tcpAddr.IP, Port: uint16(tcpAddr.Port)}
+	}
+	return p.sendMessage(msg)
+}
+
+// forgetNonce drops our version nonce once the handshake is over either way
+func (p *Peer) forgetNonce() {
+	localNonces.Lock()
+	delete(localNonces.m, p.localNonce)
+	localNonces.Unlock()
+}
+
+// handshakeMessage takes the messages that arrive before the handshake is
+// done. A peer has to send version first and then verack, with only the
+// feature negotiation messages in between, anything else is ignored.
+func (p *Peer) handshakeMessage(msg wire.Message) {
+	switch p.handshake {
+	case awaitingVersion:
+		version, ok := msg.(*wire.VersionMsg)
+		if !ok {
+			p.misbehaving(1, "message before version")
+			return
+		}
+		err := p.handleVersion(version)
+		if err != nil {
+			fmt.Printf("dropping peer %v: %v\n", p.Conn.RemoteAddr(), err)
+			p.forgetNonce()
+			p.disconnect()
+			return
+		}
+		p.handshake = awaitingVerack
+	case awaitingVerack:
+		switch msg.(type) {
+		case *wire.VerackMsg:
+			p.handshake = established
+			p.forgetNonce()
+			p.Conn.SetReadDeadline(time.Time{})
+			p.finishHandshake()
+		case *wire.WtxidRelayMsg:
+			if p.version >= wtxidRelayVersion {
+				p.wtxidRelay = true
+			}
+		case *wire.SendAddrV2Msg:
+			p.addrV2 = true
+		case *wire.VersionMsg:
+			p.misbehaving(1, "duplicate version message")
+		}
+	}
+}
+
+// handleVersion checks the peer's version, answers it if the peer
+// connected to us and sends what we negotiate before our verack.
+func (p *Peer) handleVersion(msg *wire.VersionMsg) error {
+	localNonces.Lock()
+	self := localNonces.m[msg.Nonce]
+	localNonces.Unlock()
+	if self {
+		return fmt.Errorf("connected to ourselves")
+	}
+	if msg.Version < MinProtocolVersion {
+		return fmt.Errorf("protocol version %v is older than %v", msg.Version, MinProtocolVersion)
+	}
+	// we sync from outbound peers so they need to serve witness blocks
+	if !p.inbound && p.connType != connFeeler {
+		if msg.Services&wire.SFNodeWitness == 0 || msg.Services&(wire.SFNodeNetwork|wire.SFNodeNetworkLimited) == 0 {
+			return fmt.Errorf("missing services, it offers %#x", msg.Services)
+		}
+	}
+	p.version = msg.Version
+	p.services = msg.Services
+	p.user_agent = msg.User_agent
+	p.start_height = msg.Start_height
+	p.raiseBestHeight(int(msg.Start_height))
+	p.relay = msg.Relay
+	if p.inbound {
+		err := p.sendVersion()
+		if err != nil {
+			return err
+		}
+	}
+	if p.version >= wtxidRelayVersion {
+		err := p.sendMessage(&wire.WtxidRelayMsg{})
+		if err != nil {
+			return err
+		}
+	}
+	err := p.sendMessage(&wire.SendAddrV2Msg{})
+	if err != nil {
+		return err
+	}
+	return p.sendVerack()
+}
+
+// finishHandshake sends the preferences that only count once verack is
+// in and hands the peer to the connection manager
+func (p *Peer) finishHandshake() {
+	if p.version >= sendHeadersVersion {
+		err := p.sendMessage(&wire.SendHeadersMsg{})
+		if err != nil {
+			p.disconnect()
+			return
+		}
+	}
+	if p.version >= feeFilterVersion && p.connType != connBlockRelay {
+		// we don't want to hear about what the pool wouldn't take
+		err := p.sendMessage(&wire.FeeFilterMsg{MinFee: mempool.MinFee()})
+		if err != nil {
+			p.disconnect()
+			return
+		}
+	}
+	connMgr.handshakeDone(p)
+	// measure latency right away rather than at the first tick
+	if p.Alive() {
+		err := p.sendPing()
+		if err != nil {
+			p.disconnect()
+		}
+	}
+}
+
+// handleFeature takes the negotiation messages that arrive after the
+// handshake, the ones that belong before verack break the protocol there
+func (p *Peer) handleFeature(msg wire.Message) {
+	switch msg := msg.(type) {
+	case *wire.VersionMsg:
+		p.misbehaving(1, "duplicate version message")
+	case *wire.VerackMsg:
+		p.misbehaving(1, "duplicate verack message")
+	case *wire.WtxidRelayMsg, *wire.SendAddrV2Msg:
+		fmt.Printf("dropping peer %v: %v after verack\n", p.Conn.RemoteAddr(), msg.Command())
+		p.disconnect()
+	case *wire.SendHeadersMsg:
+		p.mu.Lock()
+		p.sendHeaders = true
+		p.mu.Unlock()
+	case *wire.FeeFilterMsg:
+		if msg.MinFee >= 0 && msg.MinFee <= blockchain.MaxMoney {
+			atomic.StoreInt64(&p.feeFilter, msg.MinFee)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package network
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// hashLRU remembers up to limit hashes along with when they were added,
+// forgetting the oldest to make room. Callers hold the lock.
+type hashLRU struct {
+	sync.Mutex
+	limit int
+	items map[[32]byte]*list.Element
+	// newest at the front
+	order *list.List
+}
+
+type lruEntry struct {
+	hash  [32]byte
+	added time.Time
+}
+
+func newHashLRU(limit int) *hashLRU {
+	return &hashLRU{limit: limit, items: make(map[[32]byte]*list.Element), order: list.New()}
+}
+
+// add records hash as added now
+func (c *hashLRU) add(hash [32]byte) {
+	if e, ok := c.items[hash]; ok {
+		e.Value.(*lruEntry).added = time.Now()
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[hash] = c.order.PushFront(&lruEntry{hash: hash, added: time.Now()})
+	for c.order.Len() > c.limit {
+		c.remove(c.order.Back().Value.(*lruEntry).hash)
+	}
+}
+
+// added is when hash was added, false if it isn't there
+func (c *hashLRU) added(hash [32]byte) (time.Time, bool) {
+	e, ok := c.items[hash]
+	if !ok {
+		return time.Time{}, false
+	}
+	return e.Value.(*lruEntry).added, true
+}
+
+func (c *hashLRU) remove(hash [32]byte) {
+	if e, ok := c.items[hash]; ok {
+		c.order.Remove(e)
+		delete(c.items, hash)
+	}
+}
+
+// removeOlder forgets the hashes added more than age ago
+func (c *hashLRU) removeOlder(age time.Duration) {
+	for e := c.order.Back(); e != nil && time.Since(e.Value.(*lruEntry).added) > age; e = c.order.Back() {
+		c.remove(e.Value.(*lruEntry).hash)
+	}
+}
+
+func (c *hashLRU) clear() {
+	c.items = make(map[[32]byte]*list.Element)
+	c.order.Init()
+}
@@ -0,0 +1,211 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/wire"
+)
+
+const (
+	// how long the sync peer gets to answer a getheaders before we try
+	// another one
+	headerSyncTimeout = 2 * time.Minute
+	// how often the sync peer is checked on
+	headerSyncTick = 5 * time.Second
+	// headers that don't connect to anything we know we answer with a
+	// getheaders this many times before ignoring the peer's announcements
+	maxUnconnectingHeaders = 10
+)
+
+// headersMsg is a headers message and the peer it came from
+type headersMsg struct {
+	peer *Peer
+	msg  *wire.HeadersMsg
+}
+
+// headerSyncManager downloads the header chain before any blocks. One sync
+// peer is walked through the chain 2000 headers at a time while headers
+// other peers send are still accepted, and a sync peer that stops
+// answering is swapped for another. Every headers message goes through
+// its one goroutine.
+type headerSyncManager struct {
+	msgs     chan headersMsg
+	syncPeer *Peer
+	// when syncPeer was last asked for headers
+	requested time.Time
+	// peers that stalled or have nothing more for us, so aren't picked
+	// again until they announce a block we didn't have
+	done map[*Peer]bool
+	// peers that went away
+	closed chan *Peer
+}
+
+var headerSync = &headerSyncManager{
+	msgs:   make(chan headersMsg, maxPeers),
+	done:   make(map[*Peer]bool),
+	closed: make(chan *Peer, maxPeers),
+}
+
+func (m *headerSyncManager) run() {
+	ticker := time.NewTicker(headerSyncTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case hm := <-m.msgs:
+			m.handleHeaders(hm.peer, hm.msg)
+		case peer := <-m.closed:
+			delete(m.done, peer)
+			if peer == m.syncPeer {
+				m.syncPeer = nil
+			}
+		case <-ticker.C:
+			m.checkSyncPeer()
+		}
+	}
+}
+
+// checkSyncPeer drops a sync peer that is gone or stalled and picks a new
+// one if some peer claims more blocks than we have headers for, the
+// fastest of them if we have measured any.
+func (m *headerSyncManager) checkSyncPeer() {
+	if m.syncPeer != nil {
+		if !m.syncPeer.Alive() {
+			m.syncPeer = nil
+		} else if time.Since(m.requested) > headerSyncTimeout {
+			fmt.Printf("header sync peer %v stalled, switching\n", m.syncPeer.Conn.RemoteAddr())
+			m.done[m.syncPeer] = true
+			m.syncPeer = nil
+		}
+	}
+	if m.syncPeer != nil {
+		return
+	}
+	height := bestHeaderHeight()
+	var best *Peer
+	for _, peer := range Peers.All() {
+		if !peer.Alive() || m.done[peer] || peer.BestHeight() <= height {
+			continue
+		}
+		if best == nil || peer.latency() < best.latency() ||
+			(peer.latency() == best.latency() && peer.BestHeight() > best.BestHeight()) {
+			best = peer
+		}
+	}
+	if best == nil {
+		return
+	}
+	fmt.Printf("syncing headers from %v, which has %v blocks\n", best.Conn.RemoteAddr(), best.BestHeight())
+	m.syncPeer = best
+	m.requestHeaders(best, [32]byte{})
+}
+
+// requestHeaders asks peer for the headers after from, or after our tip if
+// from is zero
+func (m *headerSyncManager) requestHeaders(peer *Peer, from [32]byte) {
+	if peer == m.syncPeer {
+		m.requested = time.Now()
+	}
+	peer.sendGetHeaders(blockchain.BlockLocator(from), [32]byte{})
+}
+
+func (m *headerSyncManager) handleHeaders(peer *Peer, msg *wire.HeadersMsg) {
+	if len(msg.Headers) == 0 {
+		if peer == m.syncPeer {
+			m.finishSync(peer)
+		}
+		return
+	}
+	for i := 1; i < len(msg.Headers); i++ {
+		if msg.Headers[i].PrevBlock != msg.Headers[i-1].BlockHash() {
+			peer.misbehaving(20, "non-continuous headers sequence")
+			return
+		}
+	}
+	if !blockchain.HaveBlock(msg.Headers[0].PrevBlock) {
+		// the peer is on a branch we haven't seen or announced a block
+		// past a gap, either way our locator shows it where to start
+		peer.unconnectingHeaders++
+		if peer.unconnectingHeaders <= maxUnconnectingHeaders {
+			m.requestHeaders(peer, [32]byte{})
+		} else if peer.unconnectingHeaders == maxUnconnectingHeaders+1 {
+			peer.misbehaving(20, "too many unconnecting headers")
+		}
+		return
+	}
+	peer.unconnectingHeaders = 0
+	// a peer that has something new is worth syncing from again
+	if !blockchain.HaveBlock(msg.Headers[len(msg.Headers)-1].BlockHash()) {
+		delete(m.done, peer)
+	}
+	for _, header := range msg.Headers {
+		err := blockchain.NewBlock(blockchain.BlockFromHeader(header))
+		var ruleErr blockchain.RuleError
+		if errors.As(err, &ruleErr) && ruleErr.Hash != header.BlockHash() {
+			// the header was added but a block we already had failed to
+			// connect on the way to it
+			fmt.Println("block failed to connect:", err)
+			continue
+		}
+		if err != nil {
+			// the rest build on this one
+			fmt.Println("rejected header:", err)
+			if errors.As(err, &ruleErr) {
+				peer.misbehaving(100, "invalid header")
+			}
+			if peer == m.syncPeer {
+				m.done[peer] = true
+				m.syncPeer = nil
+			}
+			return
+		}
+	}
+	if height, ok := blockchain.BlockHeight(msg.Headers[len(msg.Headers)-1].BlockHash()); ok {
+		peer.raiseBestHeight(height)
+	}
+	reportHeaderProgress()
+	if len(msg.Headers) == wire.MaxBlockHeadersPerMsg {
+		// a full batch means the peer has more, whether it is the sync
+		// peer or one on another branch
+		m.requestHeaders(peer, msg.Headers[len(msg.Headers)-1].BlockHash())
+		return
+	}
+	if peer == m.syncPeer {
+		m.finishSync(peer)
+	}
+}
+
+func (m *headerSyncManager) finishSync(peer *Peer) {
+	fmt.Printf("synced headers with %v at height %v\n", peer.Conn.RemoteAddr(), bestHeaderHeight())
+	m.done[peer] = true
+	m.syncPeer = nil
+}
+
+func bestHeaderHeight() int {
+	tip := blockchain.LastBlock()
+	if tip == nil {
+		return 0
+	}
+	return tip.Height
+}
+
+// reportHeaderProgress prints our header height and how far along that is,
+// guessing the current height from how long ago the tip was mined
+func reportHeaderProgress() {
+	tip := blockchain.LastBlock()
+	if tip == nil {
+		return
+	}
+	behind := time.Since(time.Unix(int64(tip.Time), 0)) / params.TargetTimePerBlock
+	if behind < 0 {
+		behind = 0
+	}
+	estimate := float64(tip.Height) + float64(behind)
+	percent := 100.0
+	if estimate > 0 {
+		percent = 100 * float64(tip.Height) / estimate
+	}
+	fmt.Printf("synced headers to height %v (about %.2f%%)\n", tip.Height, percent)
+}
@@ -0,0 +1,133 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// peers that connected to us, whatever is left over once the outbound
+// slots are taken
+var maxInbound = maxPeers - maxOutboundFullRelay - maxOutboundBlockRelay
+
+const (
+	// inbound peers that relayed us a block most recently are kept
+	evictProtectBlocks = 4
+	// as are the ones with the lowest latency
+	evictProtectPing = 8
+	// and so are the ones connected the longest
+	evictProtectUptime = 8
+)
+
+// listen accepts connections on bind, which is a host, host:port or empty
+// for every interface, the port defaults to the chain's
+func listen(bind string) error {
+	if _, _, err := net.SplitHostPort(bind); err != nil {
+		bind = net.JoinHostPort(bind, strconv.Itoa(params.DefaultPort))
+	}
+	ln, err := net.Listen("tcp", bind)
+	if err != nil {
+		return err
+	}
+	fmt.Println("listening on", ln.Addr())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				fmt.Println("stopped listening:", err)
+				return
+			}
+			connMgr.accept(conn)
+		}
+	}()
+	return nil
+}
+
+// accept takes an inbound connection, making room by evicting a peer when
+// the inbound slots are full. The connection is dropped if no peer can go.
+func (c *connManager) accept(conn net.Conn) {
+	if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && banMan.isBanned(tcpAddr.IP) {
+		conn.Close()
+		return
+	}
+	c.mu.Lock()
+	if len(c.inbound) >= maxInbound {
+		victim := c.evictionCandidate()
+		if victim == nil {
+			c.mu.Unlock()
+			conn.Close()
+			return
+		}
+		fmt.Printf("evicting inbound peer %v for %v\n", victim.Conn.RemoteAddr(), conn.RemoteAddr())
+		// the newcomer takes its slot right away, the victim can't be
+		// picked again while it shuts down and peerClosed finds it gone
+		delete(c.inbound, victim)
+		victim.disconnect()
+	}
+	peer := &Peer{Conn: conn, inbound: true, connected: time.Now()}
+	c.inbound[peer] = true
+	c.mu.Unlock()
+	peer.Start()
+}
+
+// evictionCandidate picks the inbound peer we lose the least by dropping,
+// c.mu has to be held. Peers that recently sent blocks, answer pings
+// fastest or have been around the longest are kept since they are hard for
+// an attacker to fake, and of the rest the newest from the network group
+// with the most connections goes. It is nil when every peer is protected.
+func (c *connManager) evictionCandidate() *Peer {
+	var candidates []*Peer
+	for peer := range c.inbound {
+		candidates = append(candidates, peer)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastBlockTime().After(candidates[j].lastBlockTime())
+	})
+	candidates = dropProtected(candidates, evictProtectBlocks, func(p *Peer) bool { return !p.lastBlockTime().IsZero() })
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].latency() < candidates[j].latency()
+	})
+	candidates = dropProtected(candidates, evictProtectPing, func(p *Peer) bool { return p.latency() != unmeasuredLatency })
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].connected.Before(candidates[j].connected)
+	})
+	candidates = dropProtected(candidates, evictProtectUptime, func(p *Peer) bool { return true })
+	if len(candidates) == 0 {
+		return nil
+	}
+	groups := make(map[string][]*Peer)
+	var biggest string
+	for _, peer := range candidates {
+		group := groupKey(remoteIP(peer))
+		groups[group] = append(groups[group], peer)
+		if len(groups[group]) > len(groups[biggest]) {
+			biggest = group
+		}
+	}
+	var newest *Peer
+	for _, peer := range groups[biggest] {
+		if newest == nil || peer.connected.After(newest.connected) {
+			newest = peer
+		}
+	}
+	return newest
+}
+
+// dropProtected removes up to n peers from the front of sorted that
+// deserve protecting
+func dropProtected(sorted []*Peer, n int, deserves func(*Peer) bool) []*Peer {
+	i := 0
+	for i < n && i < len(sorted) && deserves(sorted[i]) {
+		i++
+	}
+	return sorted[i:]
+}
+
+func remoteIP(p *Peer) net.IP {
+	if tcpAddr, ok := p.Conn.RemoteAddr().(*net.TCPAddr); ok {
+		return tcpAddr.IP
+	}
+	return nil
+}
@@ -1,7 +1,6 @@
 package network
 
 import (
-	"bytes"
 	"fmt"
 	"net"
 	"strconv"
@@ -9,50 +8,69 @@ import (
 
 	"github.com/miekg/dns"
 	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/chainparams"
+	"github.com/singurty/goldchain/wire"
 )
 
+var params *chainparams.Params
+
 //dns seeds to bootstrap
 var seeds []string
-// computers that bitcoin
-var Nodes []*Node
-// peers that we connected to
-var Peers []*Peer
-
-var ProtocolVersion = 70013
 
-type Node struct {
-	Address net.IP
-	Port int
-	Status int	// node status codes:
-				// 0 - not contacted yet
-				// 1 - connected, bash a Peer instance
-				// 2 - connection was attempted
-	Peer *Peer
-}
+var ProtocolVersion = 70016
 
 var maxPeers = 50
 
-var headers = make(chan string)
-
-func Start() {
-	seeds = []string{"seed.bitcoin.sipa.be", "dnsseed.bluematt.me", "dnsseed.bitcoin.dashjr.org", "seed.bitcoinstats.com", "seed.bitcoin.jonasschnelli.ch", "seed.btc.petertodd.org", "seed.bitcoin.sprovoost.nl", "dnsseed.emzy.de", "seed.bitcoin.wiz.biz"}
-	getNodes()
-	for {
-		if len(Peers) >= maxPeers {
-			break
-		}
-		for _, node := range Nodes {
-			if node.Status == 0 {
-				node.Status = 2
-				go node.connect()
-			}
+// Start connects to the network, addNodes are host:port pairs to contact on
+// top of the ones in the address book and bind is where we accept
+// connections, a host or host:port on the chain's port by default.
+func Start(chainParams *chainparams.Params, addNodes []string, bind string) {
+	params = chainParams
+	seeds = params.DNSSeeds
+	addrMan = newAddrManager(blockchain.DataDir() + "peers.json")
+	err := addrMan.load()
+	if err != nil {
+		fmt.Println(err)
+	}
+	go addrMan.saveLoop()
+	banMan = newBanManager(blockchain.DataDir() + "banlist.json")
+	err = banMan.load()
+	if err != nil {
+		fmt.Println(err)
+	}
+	close(bansLoaded)
+	for _, address := range addNodes {
+		err := AddNode(address)
+		if err != nil {
+			fmt.Println(err)
 		}
 	}
-	go fillBlockchain()
-	go populateHeaders()
+	// the address book is enough once we have been online before
+	if addrMan.Size() == 0 {
+		getNodes()
+	}
+	blockchain.OnBlockConnected(txRelayBlockConnected)
+	blockchain.OnBlockConnected(announceBlock)
+	Peers.OnDisconnect(func(p *Peer) { headerSync.closed <- p })
+	go headerSync.run()
+	go blockDownload.run()
+	go connMgr.run()
+	err = listen(bind)
+	if err != nil {
+		fmt.Println("can't accept connections:", err)
+	}
+}
+
+// Stop writes out the address book
+func Stop() error {
+	if addrMan == nil {
+		return nil
+	}
+	return addrMan.save()
 }
 
 func getNodes() {
+	now := uint32(time.Now().Unix())
 	for _, seed := range seeds {
 		m := new(dns.Msg)
 		m.SetQuestion(dns.Fqdn(seed), dns.TypeA)
@@ -64,100 +82,37 @@ func getNodes() {
 			fmt.Println(err)
 			continue
 		}
+		addrs := make([]*wire.NetAddr, 0, len(in.Answer))
 		for _, ans := range in.Answer {
 			if t, ok := ans.(*dns.A); ok {
-				if !doesExist(t.A) {
-					node := &Node{Address: t.A, Port: 8333}
-					Nodes = append(Nodes, node)
-				}
+				addrs = append(addrs, &wire.NetAddr{Timestamp: now, Address: t.A, Port: uint16(params.DefaultPort)})
 			}
 		}
+		addrMan.Add(addrs, nil)
 	}
 }
 
-func doesExist(address net.IP) bool {
-	for _, node := range Nodes {
-		if node.Address.String() == address.String() {
-			return true
-		}
-	}
-	return false
-}
-
-func NewNode(address []byte, port int) {
-	// might be non-existent
-	if port == 0 {
-		return
-	}
-	// check for duplicates
-	for _, node := range Nodes {
-		if bytes.Equal(node.Address.To16(), address) {
-			return
-		}
-	}
-	Nodes = append(Nodes, &Node{Address: address, Port: port})
-}
-
-func fillBlockchain() {
-	for _, peer := range Peers {
-		for {
-			peer.SendGetHeaders(blockchain.LastBlock.Hash, [32]byte{})
-			select {
-			case msg := <-headers:
-				switch msg {
-				case "finished":
-					continue
-				case "best":
-					goto next
-				}
-			case <-time.After(15 * time.Second):
-				// not actually best but works
-				goto next
-			}
-		}
-next:
+// AddNode adds a host:port to the address book and keeps a connection to
+// it, the port defaults to the chain's
+func AddNode(address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+		portStr = strconv.Itoa(params.DefaultPort)
 	}
-	fmt.Println("fully synced headers chain")
-}
-
-func populateHeaders() {
-	for {
-		// no unpopulated headers
-		if blockchain.FirstHeader == nil {
-			continue
-		}
-		blocks := make([][32]byte, 0)
-		blocks = append(blocks, blockchain.FirstHeader.Hash)
-		blocksAfter, err := blockchain.GetNBlockHashesAfter(blocks[0], 15)
-		if err != nil {
-			fmt.Println("failed to fetch headers", err)
-			continue
-		}
-		blocks = append(blocks, blocksAfter...)
-		for _, peer := range Peers {
-			err = peer.GetBlocks(blocks)
-			if err != nil {
-				fmt.Println("failed to request blocks", err)
-				continue
-			}
-			blocksAfter, err = blockchain.GetNBlockHashesAfter(blocks[len(blocks)-1], 16)
-			if err != nil {
-				fmt.Println("failed to fetch headers", err)
-				break
-			}
-			blocks = make([][32]byte, 0)
-			blocks = append(blocks, blocksAfter...)
-		}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return err
 	}
-}
-
-func (n *Node) connect() {
-	conn, err := net.Dial("tcp", n.Address.String() + ":" + strconv.Itoa(n.Port))
+	ips, err := net.LookupIP(host)
 	if err != nil {
-		return
+		return err
+	}
+	now := uint32(time.Now().Unix())
+	for _, ip := range ips {
+		addr := &wire.NetAddr{Timestamp: now, Address: ip, Port: uint16(port)}
+		addrMan.Add([]*wire.NetAddr{addr}, nil)
+		connMgr.addManual(addr)
 	}
-	peer := &Peer{Conn: conn}
-	n.Peer = peer
-	n.Status = 1
-	peer.Start()
+	return nil
 }
@@ -2,400 +2,432 @@ package network
 
 import (
 	"bufio"
-	"bytes"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/binary"
+	"errors"
 	"fmt"
-	"math"
-	"math/big"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/mempool"
 	"github.com/singurty/goldchain/wire"
 //	"github.com/davecgh/go-spew/spew"
 )
 
+// most blocks a getblocks is answered with, same as bitcoind
+const maxGetBlocksInv = 500
+
+// how long a peer gets to take a message off us. Writes happen on
+// goroutines shared by every peer, so one that stops reading is dropped
+// instead of holding them up.
+var writeTimeout = time.Minute
+
 type Peer struct {
-	Alive bool
 	Conn net.Conn
+	addr *wire.NetAddr // the address we dialed
+	connType connType
+	inbound bool // they connected to us
+	connected time.Time
+	mu sync.Mutex // guards alive, bestHeight, lastBlock, ping, invQueue and sendHeaders
+	alive bool // the handshake is done and the connection is up
+	lastBlock time.Time // when the peer last sent a block
+	ping pingStats
+	invQueue []*mempool.TxDesc // transactions to announce at the next trickle
+	sentAddrs bool // we answer one getaddr per connection
+	misbehavior int32 // banned at banThreshold
 	version int32
 	services uint64
 	user_agent string
 	start_height int32
 	relay bool
-	nonce uint64 // for ping pong
+	handshake handshakeState // only touched by the listener
+	localNonce uint64 // the nonce in the version we sent
+	wtxidRelay bool // announce transactions by wtxid
+	addrV2 bool // the peer wants addrv2
+	sendHeaders bool // the peer wants blocks announced with headers
+	feeFilter int64 // satoshis per kilobyte, set atomically
+	unconnectingHeaders int // headers messages in a row we couldn't connect
+	bestHeight int // highest block we know the peer has
 	hc chan string // to signal handler
 }
 
 func (p *Peer) Start()  {
-	go p.handler()
-	err := p.sendVersion()
-	if err != nil {
-		fmt.Print(err)
-		p.hc <- "closed"
+	p.hc = make(chan string, 1)
+	// inbound peers speak first
+	if !p.inbound {
+		err := p.sendVersion()
+		if err != nil {
+			fmt.Println(err)
+			p.forgetNonce()
+			p.Conn.Close()
+			connMgr.peerClosed(p)
+			return
+		}
 	}
+	go p.handler()
 }
 
 func (p *Peer) handler() {
-	p.hc = make(chan string, 1)
-	listen := make(chan string, 5)
-	go p.listener(listen)
+	go p.listener()
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	trickle := time.NewTimer(p.invDelay())
+	defer trickle.Stop()
 	for {
 		select {
-		case command := <-listen:
-			switch command {
-			case "version":
-				// perhaps alive
-				p.Alive = true
-				Peers = append(Peers, p)
-				p.sendVerack()
-			case "ping":
-				p.sendPong()
-			}
 		case handle := <-p.hc:
 			switch handle {
 			// connection closed
 			case "closed":
+				p.Conn.Close()
+				connMgr.peerClosed(p)
 				return
 			}
-		case <-time.After(10 * time.Minute):
-			p.sendPing()
-			select {
-			case command := <-listen:
-				switch command {
-				case "pong":
-					break
-				default:
-					listen <- command
-				}
-			case <-time.After(10 * time.Minute):
-				p.Alive = false
+		case <-ticker.C:
+			if p.Alive() {
+				p.checkPing()
+			}
+		case <-trickle.C:
+			if p.Alive() {
+				p.flushInv()
 			}
+			trickle.Reset(p.invDelay())
 		}
 	}
 }
 
-func (p *Peer) listener(c chan string) {
-	buf := make([]byte, 65536)
+func (p *Peer) listener() {
 	bufReader := bufio.NewReader(p.Conn)
+	// cleared once the handshake is done
+	p.Conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
 	for {
-		n, err := bufReader.Read(buf)
+		header, payload, err := wire.ReadMessage(bufReader, params.Net)
 		if err != nil {
-			p.hc <- "closed"
+			// a bad checksum still consumed the whole message
+			if errors.Is(err, wire.ErrInvalidChecksum) {
+				fmt.Println(err)
+				continue
+			}
+			if p.handshake != established {
+				fmt.Printf("handshake with %v failed: %v\n", p.Conn.RemoteAddr(), err)
+				p.forgetNonce()
+			}
+			p.disconnect()
 			return
 		}
-		// should be atleast the size of the bitcoin message structure
-		if n < 24 {
+		msg, err := wire.DecodeMessage(header, payload)
+		if err != nil {
+			if !errors.Is(err, wire.ErrUnknownCommand) {
+				p.misbehaving(100, err.Error())
+			}
 			continue
 		}
-		// check if this is a bitcoin message
-		magic := binary.LittleEndian.Uint32(buf[:4])
-		if !(magic == 0xD9B4BEF9 || magic == 0xDAB5BFFA || magic == 0x0709110B || magic == 0x40CF030A || magic == 0xFEB4BEF9) {
+		if p.handshake != established {
+			p.handshakeMessage(msg)
 			continue
 		}
-		command := string(bytes.TrimRight(buf[4:16], "\x00"))
-		length := int(binary.LittleEndian.Uint32(buf[16:20]))
-		payload := make([]byte, length)
-		checksum := make([]byte, 4)
-		copy(checksum, buf[20:24])
-		if length > 0 {
-			if length + 24 > n {
-				copy(payload, buf[24:n])
-				payloadBufIndex := n - 24
-				toRead := length - (n - 24)
-				for {
-					if toRead == 0 {
-						break
-					}
-					n, err := bufReader.Read(payload[payloadBufIndex:])
-					if err != nil {
-						p.hc <- "closed"
-						return
-					}
-					payloadBufIndex += n
-					toRead -= n
-				}
-			} else {
-				copy(payload, buf[24:24+length])
-			}
-			singleHash := sha256.Sum256(payload)
-			doubleHash := sha256.Sum256(singleHash[:])
-			if !bytes.Equal(checksum, doubleHash[:4]){
-				continue
-			}
-		}
-		switch command {
-		case "version":
-			c <- "version"
-			err := p.parseVersion(payload)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-		case "addr":
-			err := p.parseAddr(payload)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-		case "ping":
-			p.nonce = binary.LittleEndian.Uint64(payload[:8])
-			c <- "ping"
-		case "pong":
-			nonce := binary.LittleEndian.Uint64(payload[:8])
-			if p.nonce == nonce {
-				c <- "pong"
-			}
-		case "headers":
-			err := p.parseHeaders(payload)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
-		case "block":
-			fmt.Printf("checksum: %x\n", checksum)
-			err := p.parseBlock(payload)
-			if err != nil {
-				fmt.Println(err)
-				continue
-			}
+		switch msg := msg.(type) {
+		case *wire.VersionMsg, *wire.VerackMsg, *wire.WtxidRelayMsg, *wire.SendAddrV2Msg, *wire.SendHeadersMsg, *wire.FeeFilterMsg:
+			p.handleFeature(msg)
+		case *wire.AddrMsg:
+			p.handleAddr(msg.AddrList)
+		case *wire.AddrV2Msg:
+			p.handleAddr(msg.AddrList)
+		case *wire.PingMsg:
+			p.sendPong(msg.Nonce)
+		case *wire.PongMsg:
+			p.handlePong(msg)
+		case *wire.HeadersMsg:
+			p.handleHeaders(msg)
+		case *wire.BlockMsg:
+			p.handleBlock(msg)
+		case *wire.InvMsg:
+			p.handleInv(msg)
+		case *wire.TxMsg:
+			p.handleTx(msg)
+		case *wire.GetHeadersMsg:
+			p.handleGetHeaders(msg)
+		case *wire.GetBlocksMsg:
+			p.handleGetBlocks(msg)
+		case *wire.GetDataMsg:
+			p.handleGetData(msg)
+		case *wire.GetAddrMsg:
+			p.handleGetAddr()
 		}
 	}
 }
 
-func (p *Peer) parseVersion(payload []byte) error {
-	p.version = int32(binary.LittleEndian.Uint32(payload[:4]))
-	p.services = binary.LittleEndian.Uint64(payload[4:12])
-	user_agent, size, err := wire.ReadVarStr(payload[80:])
+func (p *Peer) handleHeaders(msg *wire.HeadersMsg) {
+	headerSync.msgs <- headersMsg{peer: p, msg: msg}
+}
+
+func (p *Peer) handleBlock(msg *wire.BlockMsg) {
+	block := blockchain.BlockFromWire(msg)
+	fmt.Printf("got block %x with %v transactions\n", msg.Header.BlockHash(), len(block.Transactions))
+	// so it isn't announced back to the peer once connected
+	if height, ok := blockchain.BlockHeight(msg.Header.BlockHash()); ok {
+		p.raiseBestHeight(height)
+	}
+	err := blockchain.NewBlock(block)
+	if err == nil {
+		p.mu.Lock()
+		p.lastBlock = time.Now()
+		p.mu.Unlock()
+	}
+	blockDownload.received <- blockReceived{peer: p, hash: block.Hash}
 	if err != nil {
-		return err
-	}
-	p.user_agent = user_agent
-	p.start_height = int32(binary.LittleEndian.Uint32(payload[80+size:84+size]))
-	// there might be a relay field
-	if uint(len(payload)) > 84 + uint(size) {
-		if payload[84+size] == 0x01 {
-			p.relay = true
-		} else {
-			p.relay = false
+		fmt.Println("rejected block:", err)
+		// connecting it may have failed on an earlier block someone else
+		// sent, only the one this peer delivered is its fault
+		var ruleErr blockchain.RuleError
+		if errors.As(err, &ruleErr) && ruleErr.Hash == block.Hash {
+			p.sendReject(wire.CmdBlock, ruleErr.RejectCode(), ruleErr.Description, block.Hash)
+			p.misbehaving(100, "invalid block")
 		}
 	}
-	return nil
 }
 
-func (p *Peer) parseHeaders(payload []byte) error {
-	count, size, err := wire.ReadVarInt(payload)
-	if err != nil {
-		return err
-	}
-	if count == 0 {
-		headers <- "best"
-		return nil
-	}
-	for i := 0; i < count; i++ {
-		version := int(binary.LittleEndian.Uint32(payload[size:size + 4]))
-		prevBlock := payload[size + 4:size + 36]
-		merkleRoot := payload[size + 36:size + 68]
-		timestamp := int(binary.LittleEndian.Uint32(payload[size + 68:size + 72]))
-		bits := int(binary.LittleEndian.Uint32(payload[size + 72:size + 76]))
-		nonce := int(binary.LittleEndian.Uint32(payload[size + 76:size + 80]))
-		block := &blockchain.Block{
-			Version: version,
-			Time: timestamp,
-			Bits: bits,
-			Nonce: nonce,
-		}
-		copy(block.PrevHash[:], prevBlock)
-		copy(block.MerkleRoot[:], merkleRoot)
-		blockchain.NewBlock(block)
-		size += 81
+func (p *Peer) handleAddr(addrs []*wire.NetAddr) {
+	// block relay only peers aren't asked for addresses and don't get to
+	// fill our address book
+	if p.connType == connBlockRelay {
+		return
 	}
-	headers <- "finished"
-	return nil
+	addrMan.Add(addrs, remoteIP(p))
 }
 
-func (p *Peer) parseBlock(payload []byte) error {
-	fmt.Println("parsing block")
-	var prevHash [32]byte
-	copy(prevHash[:], payload[4:36])
-	block, err := blockchain.GetBlockAfter(prevHash)
+func (p *Peer) handleGetHeaders(msg *wire.GetHeadersMsg) {
+	blocks := blockchain.LocateBlocks(msg.BlockLocatorHashes, msg.HashStop, wire.MaxBlockHeadersPerMsg)
+	headers := make([]*wire.BlockHeader, 0, len(blocks))
+	for _, block := range blocks {
+		headers = append(headers, &block.ToWire().Header)
+	}
+	err := p.sendMessage(&wire.HeadersMsg{Headers: headers})
 	if err != nil {
-		return err
-	}
-	fmt.Printf("populating block %x\n", block.Hash)
-	fmt.Printf("merkleRoot: %x\n", block.MerkleRoot)
-	// only care about transactions
-	block.Transactions = make([]*blockchain.Transaction, 0)
-	// parse transactions
-	count, size, err := wire.ReadVarInt(payload[80:])
+		p.disconnect()
+	}
+}
+
+func (p *Peer) handleGetBlocks(msg *wire.GetBlocksMsg) {
+	blocks := blockchain.LocateBlocks(msg.BlockLocatorHashes, msg.HashStop, maxGetBlocksInv)
+	if len(blocks) == 0 {
+		return
+	}
+	invList := make([]*wire.InvVect, 0, len(blocks))
+	for _, block := range blocks {
+		invList = append(invList, &wire.InvVect{Type: wire.InvTypeBlock, Hash: block.Hash})
+	}
+	err := p.sendMessage(&wire.InvMsg{InvList: invList})
 	if err != nil {
-		return err
-	}
-	fmt.Printf("got %v transactions\n", count)
-	payload = payload[80+size:]
-	for i := 0; i < count; i++ {
-		transaction := &blockchain.Transaction{}
-		transaction.Version = int(binary.LittleEndian.Uint32(payload[:4]))
-		payload = payload[4:]
-		if bytes.Equal(payload[:2], []byte{0x00, 0x01}) {
-			transaction.Flag = [2]uint8{0x00, 0x01}
-			payload = payload[2:]
-		}
-		// txIn
-		count2, size, err := wire.ReadVarInt(payload)
-		if err != nil {
-			return err
-		}
-		fmt.Printf("got %v inputs\n", count2)
-		payload = payload[size:]
-		for j := 0; j < count2; j++ {
-			txIn := &blockchain.TxIn{}
-			prevTxHash := payload[:32]
-			copy(txIn.PrevTxHash[:], prevTxHash)
-			txIn.PrevTxIndex = int(binary.LittleEndian.Uint32(payload[32:36]))
-			txScriptLen, size, err := wire.ReadVarInt(payload[36:])
-			if err != nil {
-				return err
+		p.disconnect()
+	}
+}
+
+// handleGetData sends the blocks and pool transactions asked for and a
+// notfound listing the rest
+func (p *Peer) handleGetData(msg *wire.GetDataMsg) {
+	notFound := make([]*wire.InvVect, 0)
+	for _, inv := range msg.InvList {
+		if inv.Type == wire.InvTypeTx || inv.Type == wire.InvTypeWitnessTx || inv.Type == wire.InvTypeWTx {
+			if !p.sendTx(inv) {
+				notFound = append(notFound, inv)
 			}
-			payload = payload[36+size:]
-			txIn.Script = payload[:txScriptLen]
-			sequence := payload[txScriptLen:txScriptLen+4]
-			copy(txIn.Sequence[:], sequence)
-			payload = payload[txScriptLen+4:]
-			transaction.Inputs = append(transaction.Inputs, txIn)
+			continue
+		}
+		if inv.Type != wire.InvTypeBlock && inv.Type != wire.InvTypeWitnessBlock {
+			notFound = append(notFound, inv)
+			continue
 		}
-		// txOut
-		count2, size, err = wire.ReadVarInt(payload)
+		block, err := blockchain.FetchBlock(inv.Hash)
 		if err != nil {
-			return err
+			notFound = append(notFound, inv)
+			continue
 		}
-		fmt.Printf("got %v outputs\n", count2)
-		payload = payload[size:]
-		for k := 0; k < count2; k++ {
-			txOut := &blockchain.TxOut{}
-			txOut.Value = int(binary.LittleEndian.Uint64(payload[:8]))
-			txOutScriptLen, size, err := wire.ReadVarInt(payload[8:])
-			if err != nil {
-				return err
+		blockMsg := block.ToWire()
+		if inv.Type != wire.InvTypeWitnessBlock {
+			// peers that don't know segwit get the stripped block
+			for _, tx := range blockMsg.Transactions {
+				for _, in := range tx.TxIn {
+					in.Witness = nil
+				}
 			}
-			txOut.Script = payload[8+size:8+size+txOutScriptLen]
-			payload = payload[8+size+txOutScriptLen:]
-			transaction.Outputs = append(transaction.Outputs, txOut)
 		}
-		// segregated witness
-		count2, size, err = wire.ReadVarInt(payload)
+		err = p.sendMessage(blockMsg)
 		if err != nil {
-			return err
-		}
-		fmt.Printf("got %v witnesses\n", count2)
-		if count2 > 0 {
-			payload = payload[size:]
-		}
-		for l := 0; l < count2; l++ {
-			componentLen, size, err  := wire.ReadVarInt(payload)
-			if err != nil {
-				return err
-			}
-			payload = payload[size:]
-			component := payload[:componentLen]
-			transaction.Witnesses = append(transaction.Witnesses, component)
-			payload = payload[componentLen:]
+			p.disconnect()
+			return
 		}
-		transaction.LockTime = int(binary.LittleEndian.Uint32(payload[:4]))
-		payload = payload[4:]
-		block.Transactions = append(block.Transactions, transaction)
 	}
-	fmt.Println("adding block to db")
-	blockchain.NewBlock(block)
-	return nil
+	if len(notFound) == 0 {
+		return
+	}
+	err := p.sendMessage(&wire.NotFoundMsg{InvList: notFound})
+	if err != nil {
+		p.disconnect()
+	}
 }
 
-func (p *Peer) parseAddr(payload []byte) error {
-	count, size, err := wire.ReadVarInt(payload)
+// handleGetAddr answers once per connection and only to inbound peers, so
+// whoever we connect out to can't learn what is in our address book
+func (p *Peer) handleGetAddr() {
+	if !p.inbound || p.sentAddrs {
+		return
+	}
+	p.sentAddrs = true
+	addrs := addrMan.Sample()
+	var msg wire.Message = &wire.AddrMsg{AddrList: addrs}
+	if p.addrV2 {
+		msg = &wire.AddrV2Msg{AddrList: addrs}
+	}
+	err := p.sendMessage(msg)
 	if err != nil {
-		return err
+		p.disconnect()
 	}
-	for i := 0; i < count; i++{
-		offset := size + (i * 30)
-		if offset + 30 >= len(payload) {
-			break
-		}
-		address := payload[offset + 12 : offset + 28]
-		port := int(binary.BigEndian.Uint16(payload[offset + 28 : offset + 30]))
-		Nodes = append(Nodes, &Node{Address: address, Port: port})
+}
+
+// Alive tells whether the peer finished its handshake and is still
+// connected
+func (p *Peer) Alive() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.alive
+}
+
+func (p *Peer) setAlive(alive bool) {
+	p.mu.Lock()
+	p.alive = alive
+	p.mu.Unlock()
+}
+
+// BestHeight is the highest block we know the peer has
+func (p *Peer) BestHeight() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.bestHeight
+}
+
+// raiseBestHeight records that the peer has a block at height
+func (p *Peer) raiseBestHeight(height int) {
+	p.mu.Lock()
+	if height > p.bestHeight {
+		p.bestHeight = height
 	}
-	return nil
+	p.mu.Unlock()
 }
 
-func (p *Peer) sendVersion() error {
-	nonceBig, err := rand.Int(rand.Reader, big.NewInt(int64(math.Pow(2, 62))))
-	if err != nil {
-		return err
-	}
-	nonce := nonceBig.Uint64()
-	msg := wire.VersionMsg{
-		Version:    int32(ProtocolVersion),
-		Services:   0x00,
-		Timestamp:  time.Now().Unix(),
-		Addr_recv:  wire.NetAddr{Services: 0x00, Address: net.ParseIP("::ffff:127.0.0.1"), Port: 0},
-		Addr_from:  wire.NetAddr{Services: 0x00, Address: net.ParseIP("::ffff:127.0.0.1"), Port: 0},
-		Nonce:      nonce,
-		User_agent: byte(0x00),
-		Relay: false,
-	}
-	err = msg.Write(p.Conn)
-	if err != nil {
-		return err
+func (p *Peer) lastBlockTime() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lastBlock
+}
+
+func (p *Peer) stats() PeerStats {
+	typ := p.connType.String()
+	if p.inbound {
+		typ = "inbound"
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var avgPing time.Duration
+	if p.ping.count > 0 {
+		avgPing = p.ping.total / time.Duration(p.ping.count)
+	}
+	return PeerStats{
+		Addr:        p.Conn.RemoteAddr().String(),
+		Inbound:     p.inbound,
+		ConnType:    typ,
+		Version:     p.version,
+		UserAgent:   p.user_agent,
+		Services:    p.services,
+		StartHeight: p.start_height,
+		BestHeight:  p.bestHeight,
+		Connected:   p.connected,
+		LastBlock:   p.lastBlock,
+		Misbehavior: atomic.LoadInt32(&p.misbehavior),
+		PingTime:    p.ping.last,
+		MinPing:     p.ping.min,
+		AvgPing:     avgPing,
+	}
+}
+
+// disconnect tells the handler to stop without blocking callers outside the
+// peer's goroutines if it already has
+func (p *Peer) disconnect() {
+	select {
+	case p.hc <- "closed":
+	default:
+	}
+}
+
+func (p *Peer) sendMessage(msg wire.Message) error {
+	p.Conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	err := wire.WriteMessage(p.Conn, msg, params.Net)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		fmt.Printf("peer %v stopped reading, disconnecting\n", p.Conn.RemoteAddr())
+		// closing fails any other write stuck on the connection right away
+		p.Conn.Close()
+		p.disconnect()
 	}
-	return nil
+	return err
 }
 
 func (p *Peer) sendVerack() error {
-	return wire.WriteVerackMsg(p.Conn)
+	return p.sendMessage(&wire.VerackMsg{})
 }
 
-func (p *Peer) sendPing() error {
-	nonceBig, err := rand.Int(rand.Reader, big.NewInt(2^64))
+func (p *Peer) sendPong(nonce uint64) {
+	err := p.sendMessage(&wire.PongMsg{Nonce: nonce})
 	if err != nil {
-		return err
+		p.disconnect()
 	}
-	p.nonce = nonceBig.Uint64()
-	return wire.WritePing(p.Conn, p.nonce)
 }
 
-func (p *Peer) sendPong() {
-	err := wire.WritePong(p.Conn, p.nonce)
+func (p *Peer) sendReject(cmd string, code uint8, reason string, hash [32]byte) {
+	if len(reason) > wire.MaxRejectReasonLen {
+		reason = reason[:wire.MaxRejectReasonLen]
+	}
+	err := p.sendMessage(&wire.RejectMsg{Cmd: cmd, Code: code, Reason: reason, Hash: hash})
 	if err != nil {
-		p.hc <- "closed"
+		p.disconnect()
 	}
 }
 
 func (p *Peer) sendGetAddr() {
-	err := wire.WriteGetaddr(p.Conn)
+	err := p.sendMessage(&wire.GetAddrMsg{})
 	if err != nil {
-		p.hc <- "closed"
+		p.disconnect()
 	}
 }
 
-func (p *Peer) SendGetHeaders(start [32]byte, end [32]byte) {
-	err := wire.WriteGetHeaders(p.Conn, ProtocolVersion, start, end)
+func (p *Peer) sendGetHeaders(locator [][32]byte, stop [32]byte) {
+	msg := &wire.GetHeadersMsg{
+		ProtocolVersion: int32(ProtocolVersion),
+		BlockLocatorHashes: locator,
+		HashStop: stop,
+	}
+	err := p.sendMessage(msg)
 	if err != nil {
-		p.hc <- "closed"
+		p.disconnect()
 	}
 }
 
+// witness tells whether the peer serves blocks with their witnesses
+func (p *Peer) witness() bool {
+	return p.services&wire.SFNodeWitness != 0
+}
+
+// GetBlocks asks for blocks with their witnesses when the peer has them,
+// without them the witness commitment can't be checked
 func (p *Peer) GetBlocks(blocks [][32]byte) error {
-	var inventoryBuffer bytes.Buffer
-	binary.Write(&inventoryBuffer, binary.LittleEndian, uint32(2))
-	for _, block := range blocks {
-		inventoryBuffer.Write(block[:])
+	typ := wire.InvTypeBlock
+	if p.witness() {
+		typ = wire.InvTypeWitnessBlock
 	}
-	inventory := make([]byte, inventoryBuffer.Len())
-	_, err := inventoryBuffer.Read(inventory)
-	if err != nil {
-		return err
+	msg := &wire.GetDataMsg{}
+	for _, block := range blocks {
+		msg.InvList = append(msg.InvList, &wire.InvVect{Type: typ, Hash: block})
 	}
-	return wire.WriteGetData(p.Conn, inventory)
+	return p.sendMessage(msg)
 }
@@ -0,0 +1,42 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// a peer that never reads has to give up the goroutine writing to it and
+// get disconnected
+func TestWriteTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { writeTimeout = timeout }(writeTimeout)
+	writeTimeout = 50 * time.Millisecond
+
+	conn, other := net.Pipe()
+	defer other.Close()
+	p := &Peer{Conn: conn, hc: make(chan string, 1)}
+
+	start := time.Now()
+	if err := p.GetBlocks([][32]byte{{1}}); err == nil {
+		t.Fatal("write to a peer that doesn't read succeeded")
+	}
+	if waited := time.Since(start); waited > 10*writeTimeout {
+		t.Errorf("write took %v with a %v timeout", waited, writeTimeout)
+	}
+	select {
+	case handle := <-p.hc:
+		if handle != "closed" {
+			t.Errorf("handler got %q, want closed", handle)
+		}
+	default:
+		t.Error("peer wasn't disconnected")
+	}
+	// the connection is closed so later writes don't wait out the timeout
+	start = time.Now()
+	if err := p.GetBlocks([][32]byte{{2}}); err == nil {
+		t.Error("write after the timeout succeeded")
+	}
+	if waited := time.Since(start); waited >= writeTimeout {
+		t.Errorf("second write waited %v", waited)
+	}
+}
@@ -0,0 +1,116 @@
+package network
+
+import (
+	"sync"
+	"time"
+)
+
+// PeerManager owns the set of peers that finished their handshake. The
+// set is only touched behind its lock and handed out as copies so callers
+// can range over it while peers come and go.
+type PeerManager struct {
+	mu    sync.RWMutex
+	peers []*Peer
+
+	onConnect    []func(*Peer)
+	onDisconnect []func(*Peer)
+}
+
+// Peers are the peers we are talking to
+var Peers = &PeerManager{}
+
+// PeerStats is a snapshot of what we know about a peer
+type PeerStats struct {
+	Addr        string
+	Inbound     bool
+	ConnType    string
+	Version     int32
+	UserAgent   string
+	Services    uint64
+	StartHeight int32
+	BestHeight  int
+	Connected   time.Time
+	LastBlock   time.Time
+	Misbehavior int32
+	// round trip times, zero until the first pong
+	PingTime time.Duration
+	MinPing  time.Duration
+	AvgPing  time.Duration
+}
+
+// OnConnect registers f to be called with every peer that finishes its
+// handshake
+func (m *PeerManager) OnConnect(f func(*Peer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onConnect = append(m.onConnect, f)
+}
+
+// OnDisconnect registers f to be called with every peer that goes away
+// after finishing its handshake
+func (m *PeerManager) OnDisconnect(f func(*Peer)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onDisconnect = append(m.onDisconnect, f)
+}
+
+// All returns the peers at the time of the call
+func (m *PeerManager) All() []*Peer {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	peers := make([]*Peer, len(m.peers))
+	copy(peers, m.peers)
+	return peers
+}
+
+// Count is how many peers we have
+func (m *PeerManager) Count() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.peers)
+}
+
+// Stats describes every peer
+func (m *PeerManager) Stats() []PeerStats {
+	peers := m.All()
+	stats := make([]PeerStats, 0, len(peers))
+	for _, p := range peers {
+		stats = append(stats, p.stats())
+	}
+	return stats
+}
+
+// add marks p alive and tells the callbacks about it
+func (m *PeerManager) add(p *Peer) {
+	p.setAlive(true)
+	m.mu.Lock()
+	m.peers = append(m.peers, p)
+	callbacks := m.onConnect
+	m.mu.Unlock()
+	for _, f := range callbacks {
+		f(p)
+	}
+}
+
+// remove takes p out of the set, telling the callbacks if it was in it
+func (m *PeerManager) remove(p *Peer) bool {
+	p.setAlive(false)
+	m.mu.Lock()
+	found := false
+	for i, peer := range m.peers {
+		if peer == p {
+			m.peers = append(m.peers[:i], m.peers[i+1:]...)
+			found = true
+			break
+		}
+	}
+	callbacks := m.onDisconnect
+	m.mu.Unlock()
+	if !found {
+		return false
+	}
+	for _, f := range callbacks {
+		f(p)
+	}
+	return true
+}
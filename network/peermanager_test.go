@@ -0,0 +1,453 @@
+package network
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/chainparams"
+	"github.com/singurty/goldchain/script"
+	"github.com/singurty/goldchain/wire"
+)
+
+// TestMain gives the tests a regtest chain in a throwaway home. The chain
+// keeps its state in globals so it is started once however many times the
+// tests run.
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "goldchain")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	params = &chainparams.RegTestParams
+	blockchain.Start(params)
+	code := m.Run()
+	blockchain.Stop()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// mineBlock builds the next regtest block on top of tip, paying its subsidy
+// to OP_TRUE
+func mineBlock(tip *blockchain.Block, height int) *blockchain.Block {
+	coinbase := &blockchain.Transaction{
+		Version: 1,
+		Inputs: []*blockchain.TxIn{{
+			PrevTxIndex: 0xffffffff,
+			// BIP34 height with padding, coinbase scripts need two bytes
+			Script:   append(script.NumberScript(int64(height)), 0x00, 0x00),
+			Sequence: [4]byte{0xff, 0xff, 0xff, 0xff},
+		}},
+		Outputs: []*blockchain.TxOut{{Value: int(blockchain.CalcBlockSubsidy(height)), Script: []byte{script.OP_TRUE}}},
+	}
+	block := &blockchain.Block{
+		Version:      4,
+		PrevHash:     tip.Hash,
+		MerkleRoot:   coinbase.TxID(),
+		Time:         tip.Time + 1,
+		Bits:         int(chainparams.RegTestParams.PowLimitBits),
+		Transactions: []*blockchain.Transaction{coinbase},
+	}
+	// below 0x7f in the top byte is always under the regtest limit
+	for {
+		block.Hash = block.GetHash()
+		if block.Hash[31] < 0x7f {
+			return block
+		}
+		block.Nonce++
+	}
+}
+
+// TestPeersRace has peers come and go while others read the peer set and
+// the chain grows under them. It only means something under go test -race.
+func TestPeersRace(t *testing.T) {
+	const blocks = 20
+	const peers = 8
+	first := blockchain.BestHeight() + 1
+	top := first + blocks - 1
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	// the miner
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(done)
+		for height := first; height <= top; height++ {
+			block := mineBlock(blockchain.LastBlock(), height)
+			if err := blockchain.NewBlock(block); err != nil {
+				t.Errorf("block %v: %v", height, err)
+				return
+			}
+		}
+	}()
+
+	// peers connecting, catching up with us and going away
+	for i := 0; i < peers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				conn, other := net.Pipe()
+				p := &Peer{Conn: conn, connected: time.Now(), services: wire.SFNodeWitness}
+				Peers.add(p)
+				p.raiseBestHeight(blockchain.BestHeight())
+				if !Peers.remove(p) {
+					t.Error("peer was not in the set")
+				}
+				conn.Close()
+				other.Close()
+			}
+		}()
+	}
+
+	// everything that reads the peers and the chain from other goroutines
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, p := range Peers.All() {
+				p.BestHeight()
+			}
+			Peers.Stats()
+			Peers.Count()
+			last := blockchain.LastBlock()
+			if !blockchain.HaveBlock(last.Hash) {
+				t.Errorf("tip %x is not in the index", last.Hash)
+			}
+			if _, ok := blockchain.BlockHeight(last.Hash); !ok {
+				t.Errorf("no height for tip %x", last.Hash)
+			}
+			locator := blockchain.BlockLocator(last.Hash)
+			if len(locator) == 0 || locator[0] != last.Hash {
+				t.Errorf("locator does not start at %x", last.Hash)
+			}
+			if blockchain.BestHeight() > top {
+				t.Errorf("best height %v is past what was mined", blockchain.BestHeight())
+			}
+		}
+	}()
+
+	wg.Wait()
+	if n := Peers.Count(); n != 0 {
+		t.Errorf("%v peers left after all of them went away", n)
+	}
+	if height := blockchain.BestHeight(); height != top {
+		t.Errorf("best height %v, want %v", height, top)
+	}
+}
+
+// testNode is the far end of a pipe to one of our peers, speaking the
+// protocol by hand. Everything the peer sends is read straight away since
+// writes to a pipe wait for the reader.
+type testNode struct {
+	t    *testing.T
+	conn net.Conn
+	msgs chan wire.Message
+}
+
+func newTestNode(t *testing.T, conn net.Conn) *testNode {
+	n := &testNode{t: t, conn: conn, msgs: make(chan wire.Message, 100)}
+	go func() {
+		defer close(n.msgs)
+		r := bufio.NewReader(conn)
+		for {
+			header, payload, err := wire.ReadMessage(r, params.Net)
+			if err != nil {
+				return
+			}
+			msg, err := wire.DecodeMessage(header, payload)
+			if err != nil {
+				t.Errorf("peer sent %v: %v", header.Command, err)
+				return
+			}
+			n.msgs <- msg
+		}
+	}()
+	return n
+}
+
+func (n *testNode) send(msg wire.Message) {
+	if err := wire.WriteMessage(n.conn, msg, params.Net); err != nil {
+		n.t.Errorf("sending %v: %v", msg.Command(), err)
+	}
+}
+
+// expect is the next message from the peer, which has to be a cmd
+func (n *testNode) expect(cmd string) wire.Message {
+	select {
+	case msg, ok := <-n.msgs:
+		if !ok {
+			n.t.Errorf("connection closed waiting for %v", cmd)
+			return nil
+		}
+		if msg.Command() != cmd {
+			n.t.Errorf("got %v, want %v", msg.Command(), cmd)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		n.t.Errorf("no %v from the peer", cmd)
+		return nil
+	}
+}
+
+// closed waits for the peer to hang up
+func (n *testNode) closed() {
+	for {
+		select {
+		case msg, ok := <-n.msgs:
+			if !ok {
+				return
+			}
+			n.t.Errorf("got %v, want the connection closed", msg.Command())
+		case <-time.After(5 * time.Second):
+			n.t.Error("peer didn't hang up")
+			return
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Errorf("timed out waiting for %v", what)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func testVersion(nonce uint64, version int32) *wire.VersionMsg {
+	return &wire.VersionMsg{
+		Version:      version,
+		Services:     localServices,
+		Timestamp:    time.Now().Unix(),
+		Nonce:        nonce,
+		User_agent:   "/test:0.1/",
+		Start_height: 7,
+		Relay:        true,
+	}
+}
+
+func inPeers(p *Peer) bool {
+	for _, peer := range Peers.All() {
+		if peer == p {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptTestPeer hands conn to the connection manager like the listener
+// does and returns the peer it made
+func acceptTestPeer(conn net.Conn) *Peer {
+	connMgr.accept(conn)
+	connMgr.mu.Lock()
+	defer connMgr.mu.Unlock()
+	for p := range connMgr.inbound {
+		if p.Conn == conn {
+			return p
+		}
+	}
+	return nil
+}
+
+// dialTestPeer starts an outbound peer on conn as if connMgr had dialed
+// addr
+func dialTestPeer(conn net.Conn, addr *wire.NetAddr) *Peer {
+	p := &Peer{Conn: conn, addr: addr, connType: connFullRelay, connected: time.Now()}
+	connMgr.mu.Lock()
+	connMgr.conns[addrKey(addr.Address, addr.Port)] = &outbound{addr: addr, typ: connFullRelay, peer: p}
+	connMgr.mu.Unlock()
+	p.Start()
+	return p
+}
+
+// runTestPeer takes a peer through the handshake and a few messages, then
+// hangs up on it
+func runTestPeer(t *testing.T, inbound bool, i int) {
+	conn, other := net.Pipe()
+	node := newTestNode(t, other)
+	defer other.Close()
+	addr := &wire.NetAddr{Timestamp: uint32(time.Now().Unix()), Services: localServices, Address: net.IPv4(1, 2, 3, byte(i)), Port: 8333}
+	key := addrKey(addr.Address, addr.Port)
+	var p *Peer
+	if inbound {
+		p = acceptTestPeer(conn)
+		if p == nil {
+			t.Error("inbound peer wasn't accepted")
+			return
+		}
+		node.send(testVersion(uint64(1000+i), int32(ProtocolVersion)))
+		node.expect("version")
+	} else {
+		addrMan.Add([]*wire.NetAddr{addr}, nil)
+		// the pipe waits for our reader, which is running already
+		p = dialTestPeer(conn, addr)
+		node.expect("version")
+		node.send(testVersion(uint64(1000+i), int32(ProtocolVersion)))
+	}
+	node.expect("wtxidrelay")
+	node.expect("sendaddrv2")
+	node.expect("verack")
+	node.send(&wire.WtxidRelayMsg{})
+	node.send(&wire.SendAddrV2Msg{})
+	node.send(&wire.VerackMsg{})
+	node.expect("sendheaders")
+	node.expect("feefilter")
+	ping, ok := node.expect("ping").(*wire.PingMsg)
+	if !ok {
+		return
+	}
+	// the peer went in the set before it pinged
+	if !inPeers(p) {
+		t.Error("peer isn't in the set after the handshake")
+	}
+	if !p.Alive() || p.BestHeight() != 7 {
+		t.Errorf("alive %v, best height %v, want 7", p.Alive(), p.BestHeight())
+	}
+	node.send(&wire.PongMsg{Nonce: ping.Nonce})
+	waitFor(t, "the ping time", func() bool {
+		for _, stats := range Peers.Stats() {
+			if stats.Addr == conn.RemoteAddr().String() && stats.PingTime > 0 {
+				return true
+			}
+		}
+		return false
+	})
+
+	node.send(&wire.SendHeadersMsg{})
+	node.send(&wire.FeeFilterMsg{MinFee: 5000})
+	node.send(&wire.GetHeadersMsg{
+		ProtocolVersion:    int32(ProtocolVersion),
+		BlockLocatorHashes: [][32]byte{chainparams.RegTestParams.GenesisHash},
+	})
+	if headers, ok := node.expect("headers").(*wire.HeadersMsg); ok {
+		if len(headers.Headers) != blockchain.BestHeight() {
+			t.Errorf("%v headers, want %v", len(headers.Headers), blockchain.BestHeight())
+		} else if hash := headers.Headers[len(headers.Headers)-1].BlockHash(); hash != blockchain.LastBlock().Hash {
+			t.Errorf("last header %x, want the tip", hash)
+		}
+	}
+	// only inbound peers get our addresses, and only once
+	node.send(&wire.GetAddrMsg{})
+	if inbound {
+		node.expect("addrv2")
+		node.send(&wire.GetAddrMsg{})
+	}
+	node.send(&wire.PingMsg{Nonce: uint64(i)})
+	if pong, ok := node.expect("pong").(*wire.PongMsg); ok && pong.Nonce != uint64(i) {
+		t.Errorf("pong %v, want %v", pong.Nonce, i)
+	}
+	p.mu.Lock()
+	sendHeaders := p.sendHeaders
+	p.mu.Unlock()
+	if !sendHeaders || atomic.LoadInt64(&p.feeFilter) != 5000 {
+		t.Errorf("sendheaders %v, fee filter %v", sendHeaders, atomic.LoadInt64(&p.feeFilter))
+	}
+	if !inbound {
+		addrMan.mu.Lock()
+		tried := addrMan.index[key] != nil && addrMan.index[key].Tried
+		addrMan.mu.Unlock()
+		if !tried {
+			t.Error("address isn't tried after the handshake")
+		}
+	}
+
+	other.Close()
+	node.closed()
+	waitFor(t, "the peer to go", func() bool {
+		connMgr.mu.Lock()
+		defer connMgr.mu.Unlock()
+		_, dialed := connMgr.conns[key]
+		return !inPeers(p) && !connMgr.inbound[p] && !dialed
+	})
+	connMgr.mu.Lock()
+	_, backoff := connMgr.retryAt[key]
+	connMgr.mu.Unlock()
+	if backoff {
+		t.Error("a peer that got through the handshake is waiting to be retried")
+	}
+}
+
+// TestPeerConnection runs inbound and outbound peers over pipes at the same
+// time, through the handshake, some requests and the hang up, so the
+// handler, listener and connection manager all run together under -race.
+func TestPeerConnection(t *testing.T) {
+	addrMan = newAddrManager("")
+	tip := blockchain.LastBlock()
+	for height := blockchain.BestHeight() + 1; height <= 3; height++ {
+		block := mineBlock(tip, height)
+		if err := blockchain.NewBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		tip = block
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runTestPeer(t, i%2 == 0, i)
+		}(i)
+	}
+	wg.Wait()
+	if n := Peers.Count(); n != 0 {
+		t.Errorf("%v peers left", n)
+	}
+}
+
+// a peer too old for us is dropped during the handshake and, being
+// outbound, retried later
+func TestHandshakeFailure(t *testing.T) {
+	addrMan = newAddrManager("")
+	conn, other := net.Pipe()
+	node := newTestNode(t, other)
+	defer other.Close()
+	addr := &wire.NetAddr{Address: net.IPv4(1, 2, 4, 1), Port: 8333}
+	key := addrKey(addr.Address, addr.Port)
+	p := dialTestPeer(conn, addr)
+	node.expect("version")
+	node.send(testVersion(1, MinProtocolVersion-1))
+	node.closed()
+	waitFor(t, "the connection manager to let go", func() bool {
+		connMgr.mu.Lock()
+		defer connMgr.mu.Unlock()
+		_, dialed := connMgr.conns[key]
+		return !dialed
+	})
+	connMgr.mu.Lock()
+	retry := connMgr.retryAt[key]
+	delete(connMgr.retryAt, key)
+	delete(connMgr.failures, key)
+	connMgr.mu.Unlock()
+	if !retry.After(time.Now()) {
+		t.Error("failed handshake isn't backed off")
+	}
+	if inPeers(p) || p.Alive() {
+		t.Error("peer counts as connected")
+	}
+	localNonces.Lock()
+	left := localNonces.m[p.localNonce]
+	localNonces.Unlock()
+	if left {
+		t.Error("our version nonce wasn't forgotten")
+	}
+}
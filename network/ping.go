@@ -0,0 +1,99 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/singurty/goldchain/wire"
+)
+
+const (
+	// how often peers are pinged to measure latency and check they are up
+	pingInterval = 2 * time.Minute
+	// peers that don't answer a ping for this long are dropped
+	pingTimeout = 20 * time.Minute
+	// the latency of peers that haven't answered a ping yet
+	unmeasuredLatency = time.Duration(1<<63 - 1)
+)
+
+// pingStats is the round trip times measured with one peer, guarded by the
+// peer's mu
+type pingStats struct {
+	// the ping waiting for a pong, nonce is zero when there is none
+	nonce uint64
+	sent  time.Time
+	last  time.Duration
+	min   time.Duration
+	total time.Duration
+	count int
+}
+
+// checkPing drops the peer if its last ping went unanswered for too long
+// and sends a new one when none is outstanding. It runs on the handler's
+// ticker, the ping is written from its own goroutine so a peer that stops
+// reading can't keep the handler from seeing the timeout.
+func (p *Peer) checkPing() {
+	p.mu.Lock()
+	outstanding := p.ping.nonce != 0
+	waited := time.Since(p.ping.sent)
+	p.mu.Unlock()
+	if outstanding {
+		if waited > pingTimeout {
+			fmt.Printf("peer %v didn't answer a ping for %v, disconnecting\n", p.Conn.RemoteAddr(), waited.Round(time.Second))
+			p.disconnect()
+		}
+		return
+	}
+	go func() {
+		err := p.sendPing()
+		if err != nil {
+			p.disconnect()
+		}
+	}()
+}
+
+func (p *Peer) sendPing() error {
+	nonce, err := randomNonce()
+	if err != nil {
+		return err
+	}
+	// zero means no ping is outstanding
+	if nonce == 0 {
+		nonce = 1
+	}
+	p.mu.Lock()
+	p.ping.nonce = nonce
+	p.ping.sent = time.Now()
+	p.mu.Unlock()
+	return p.sendMessage(&wire.PingMsg{Nonce: nonce})
+}
+
+// handlePong records the round trip time of the ping msg answers, pongs
+// for pings we didn't send are ignored
+func (p *Peer) handlePong(msg *wire.PongMsg) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ping.nonce == 0 || msg.Nonce != p.ping.nonce {
+		return
+	}
+	rtt := now.Sub(p.ping.sent)
+	p.ping.nonce = 0
+	p.ping.last = rtt
+	if p.ping.count == 0 || rtt < p.ping.min {
+		p.ping.min = rtt
+	}
+	p.ping.total += rtt
+	p.ping.count++
+}
+
+// latency is the fastest round trip we measured, unmeasuredLatency until
+// the first pong so those peers sort last
+func (p *Peer) latency() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ping.count == 0 {
+		return unmeasuredLatency
+	}
+	return p.ping.min
+}
@@ -0,0 +1,58 @@
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// a peer that stops reading can't keep checkPing from returning, so the
+// handler still gets to drop it once the ping times out
+func TestPingTimeout(t *testing.T) {
+	conn, other := net.Pipe()
+	defer other.Close()
+	p := &Peer{Conn: conn, hc: make(chan string, 1)}
+
+	done := make(chan struct{})
+	go func() {
+		p.checkPing()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("checkPing blocked on a peer that doesn't read")
+	}
+
+	// the ping is sent from another goroutine
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.mu.Lock()
+		if p.ping.nonce != 0 {
+			break
+		}
+		p.mu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("no ping outstanding")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.ping.sent = time.Now().Add(-pingTimeout - time.Second)
+	p.mu.Unlock()
+	p.checkPing()
+	select {
+	case handle := <-p.hc:
+		if handle != "closed" {
+			t.Errorf("handler got %q, want closed", handle)
+		}
+	default:
+		t.Error("peer wasn't disconnected after the ping timed out")
+	}
+	// once the connection goes the ping write fails and disconnects too
+	conn.Close()
+	select {
+	case <-p.hc:
+	case <-time.After(time.Second):
+		t.Error("ping write didn't fail on a closed connection")
+	}
+}
@@ -0,0 +1,279 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	mathrand "math/rand"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/mempool"
+	"github.com/singurty/goldchain/wire"
+)
+
+const (
+	// the average time between announcing transactions to a peer. Inbound
+	// peers wait longer so a spy connected to everyone can't tell who
+	// heard of a transaction first.
+	inboundInvInterval  = 5 * time.Second
+	outboundInvInterval = 2 * time.Second
+	// most transactions announced to a peer at once, the rest wait
+	maxInvBroadcast = 1000
+	// how long a peer gets to send a transaction we asked for before
+	// another peer announcing it is asked
+	txRequestTimeout = time.Minute
+	// how many requests and rejects are remembered, peers can announce
+	// as many hashes as they like
+	maxTxRequests    = 50000
+	maxRecentRejects = 120000
+)
+
+// txRequests is when we last asked a peer for each transaction so one
+// announced by many peers is only downloaded once
+var txRequests = newHashLRU(maxTxRequests)
+
+// recentRejects are transactions the pool turned away, they aren't
+// downloaded again until the next block may have changed the answer
+var recentRejects = newHashLRU(maxRecentRejects)
+
+// wantTx tells whether the transaction hash should be asked for, marking it
+// requested if so
+func wantTx(hash [32]byte) bool {
+	if mempool.Have(hash) {
+		return false
+	}
+	recentRejects.Lock()
+	_, rejected := recentRejects.added(hash)
+	recentRejects.Unlock()
+	if rejected {
+		return false
+	}
+	txRequests.Lock()
+	defer txRequests.Unlock()
+	if requested, ok := txRequests.added(hash); ok && time.Since(requested) < txRequestTimeout {
+		return false
+	}
+	txRequests.add(hash)
+	return true
+}
+
+// txRelayBlockConnected forgets rejects and requests that timed out once
+// the chain moves
+func txRelayBlockConnected(*blockchain.Block) {
+	recentRejects.Lock()
+	recentRejects.clear()
+	recentRejects.Unlock()
+	txRequests.Lock()
+	txRequests.removeOlder(txRequestTimeout)
+	txRequests.Unlock()
+}
+
+// handleInv asks for the transactions we don't have and the headers of
+// blocks we don't know
+func (p *Peer) handleInv(msg *wire.InvMsg) {
+	getData := make([]*wire.InvVect, 0)
+	var unknownBlock *[32]byte
+	for _, inv := range msg.InvList {
+		switch inv.Type {
+		case wire.InvTypeBlock, wire.InvTypeWitnessBlock:
+			if !blockchain.HaveBlock(inv.Hash) {
+				unknownBlock = &inv.Hash
+			}
+		case wire.InvTypeTx, wire.InvTypeWTx:
+			// block relay only peers were told not to send transactions
+			// and the rest have to announce by the hash we agreed on
+			if p.connType == connBlockRelay || (inv.Type == wire.InvTypeWTx) != p.wtxidRelay {
+				continue
+			}
+			if !wantTx(inv.Hash) {
+				continue
+			}
+			typ := wire.InvTypeWitnessTx
+			if p.wtxidRelay {
+				typ = wire.InvTypeWTx
+			}
+			getData = append(getData, &wire.InvVect{Type: typ, Hash: inv.Hash})
+		}
+	}
+	// the headers bring the blocks in through the normal download, the
+	// last one covers everything announced before it
+	if unknownBlock != nil {
+		p.sendGetHeaders(blockchain.BlockLocator([32]byte{}), *unknownBlock)
+	}
+	if len(getData) == 0 {
+		return
+	}
+	err := p.sendMessage(&wire.GetDataMsg{InvList: getData})
+	if err != nil {
+		p.disconnect()
+	}
+}
+
+// handleTx offers a transaction to the pool and relays it if it gets in
+func (p *Peer) handleTx(msg *wire.TxMsg) {
+	if p.connType == connBlockRelay {
+		return
+	}
+	tx := blockchain.TransactionFromWire(msg)
+	txid := tx.TxID()
+	wtxid := tx.WTxID()
+	txRequests.Lock()
+	txRequests.remove(txid)
+	txRequests.remove(wtxid)
+	txRequests.Unlock()
+	desc, err := mempool.ProcessTransaction(tx)
+	if err != nil {
+		p.rejectTx(tx, txid, wtxid, err)
+		return
+	}
+	relayTransaction(desc, p)
+}
+
+// rejectTx tells the peer why its transaction didn't get in and remembers
+// not to download it again. Peers are only punished for breaking
+// consensus in ways that don't depend on what our chain looks like.
+func (p *Peer) rejectTx(tx *blockchain.Transaction, txid, wtxid [32]byte, err error) {
+	var poolErr mempool.RuleError
+	var ruleErr blockchain.RuleError
+	var code uint8
+	var reason string
+	consensus := false
+	switch {
+	case errors.As(err, &poolErr):
+		// the parents of a transaction with missing inputs may still come
+		if poolErr.ErrorCode == mempool.ErrAlreadyHave || poolErr.ErrorCode == mempool.ErrMissingInputs {
+			return
+		}
+		code, reason = poolErr.RejectCode(), poolErr.Description
+	case errors.As(err, &ruleErr):
+		code, reason = ruleErr.RejectCode(), ruleErr.Description
+		consensus = true
+	default:
+		fmt.Printf("failed to check transaction %x: %v\n", txid, err)
+		return
+	}
+	recentRejects.Lock()
+	recentRejects.add(wtxid)
+	// another witness could still make a segwit transaction valid
+	if !tx.HasWitness() {
+		recentRejects.add(txid)
+	}
+	recentRejects.Unlock()
+	p.sendReject(wire.CmdTx, code, reason, txid)
+	if consensus && punishable(ruleErr.ErrorCode) {
+		p.misbehaving(100, "invalid transaction")
+	}
+}
+
+// punishable tells whether a transaction failing with code is invalid for
+// everyone, rather than only at the tip of our chain or under our policy
+func punishable(code blockchain.ErrorCode) bool {
+	switch code {
+	case blockchain.ErrMissingTxOut, blockchain.ErrOverwriteTx, blockchain.ErrUnfinalizedTx,
+		blockchain.ErrSequenceLock, blockchain.ErrImmatureSpend, blockchain.ErrScriptPolicy:
+		return false
+	}
+	return true
+}
+
+// relayTransaction queues desc to be announced to every peer that wants
+// transactions except the one it came from
+func relayTransaction(desc *mempool.TxDesc, from *Peer) {
+	for _, peer := range Peers.All() {
+		if peer == from || !peer.relay || peer.connType == connBlockRelay {
+			continue
+		}
+		peer.mu.Lock()
+		peer.invQueue = append(peer.invQueue, desc)
+		peer.mu.Unlock()
+	}
+}
+
+// invDelay is how long until the next trickle of announcements. The gaps
+// are random so announcements can't be timed across peers.
+func (p *Peer) invDelay() time.Duration {
+	mean := outboundInvInterval
+	if p.inbound {
+		mean = inboundInvInterval
+	}
+	return time.Duration(mathrand.ExpFloat64() * float64(mean))
+}
+
+// flushInv announces the queued transactions that are still in the pool
+// and pay at least the peer's fee filter, parents first and then the best
+// paying. Past maxInvBroadcast they wait for the next trickle.
+func (p *Peer) flushInv() {
+	p.mu.Lock()
+	queue := p.invQueue
+	p.invQueue = nil
+	p.mu.Unlock()
+	feeFilter := atomic.LoadInt64(&p.feeFilter)
+	pending := make([]*mempool.TxDesc, 0, len(queue))
+	ancestors := make(map[*mempool.TxDesc]int, len(queue))
+	for _, desc := range queue {
+		// it may have been mined or evicted while it waited
+		if mempool.Fetch(desc.TxID) != desc || desc.FeeRate() < feeFilter {
+			continue
+		}
+		if _, ok := ancestors[desc]; ok {
+			continue
+		}
+		ancestors[desc] = mempool.Ancestors(desc)
+		pending = append(pending, desc)
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		if ancestors[pending[i]] != ancestors[pending[j]] {
+			return ancestors[pending[i]] < ancestors[pending[j]]
+		}
+		return pending[i].FeeRate() > pending[j].FeeRate()
+	})
+	if len(pending) > maxInvBroadcast {
+		p.mu.Lock()
+		p.invQueue = append(pending[maxInvBroadcast:], p.invQueue...)
+		p.mu.Unlock()
+		pending = pending[:maxInvBroadcast]
+	}
+	if len(pending) == 0 {
+		return
+	}
+	invList := make([]*wire.InvVect, 0, len(pending))
+	for _, desc := range pending {
+		if p.wtxidRelay {
+			invList = append(invList, &wire.InvVect{Type: wire.InvTypeWTx, Hash: desc.WTxID})
+		} else {
+			invList = append(invList, &wire.InvVect{Type: wire.InvTypeTx, Hash: desc.TxID})
+		}
+	}
+	err := p.sendMessage(&wire.InvMsg{InvList: invList})
+	if err != nil {
+		p.disconnect()
+	}
+}
+
+// sendTx sends the transaction inv asks for out of the pool, it is false
+// when we don't have it
+func (p *Peer) sendTx(inv *wire.InvVect) bool {
+	var desc *mempool.TxDesc
+	if inv.Type == wire.InvTypeWTx {
+		desc = mempool.FetchByWTxID(inv.Hash)
+	} else {
+		desc = mempool.Fetch(inv.Hash)
+	}
+	if desc == nil {
+		return false
+	}
+	msg := desc.Tx.ToWire()
+	if inv.Type == wire.InvTypeTx {
+		// peers that don't know segwit get the stripped transaction
+		for _, in := range msg.TxIn {
+			in.Witness = nil
+		}
+	}
+	err := p.sendMessage(msg)
+	if err != nil {
+		p.disconnect()
+	}
+	return true
+}
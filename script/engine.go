@@ -0,0 +1,647 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+)
+
+// every signature checked in tapscript uses up this much of the budget
+// the witness size pays for
+const validationWeightPerSigOp = 50
+
+// turns CHECKSEQUENCEVERIFY into a NOP (BIP112)
+const sequenceLockTimeDisableFlag = 1 << 31
+
+// engine runs one script at a time with the context of the input it is
+// spending.
+type engine struct {
+	flags      Flags
+	checker    SignatureChecker
+	sigVersion SigVersion
+	// tapscript only
+	taproot          *TaprootData
+	validationWeight int64
+}
+
+func (e *engine) isTapscript() bool {
+	return e.sigVersion == SigVersionTapscript
+}
+
+// execute runs script on stk
+func (e *engine) execute(script []byte, stk *stack) error {
+	if !e.isTapscript() && len(script) > MaxScriptSize {
+		return scriptError(ErrScriptSize, fmt.Sprintf("script size %d is larger than max allowed size %d", len(script), MaxScriptSize))
+	}
+	ops, err := parse(script)
+	if err != nil {
+		return err
+	}
+	altStack := make(stack, 0)
+	// whether each nested IF branch we are in is taken
+	condStack := make([]bool, 0)
+	opCount := 0
+	// the scriptCode signatures commit to starts after the last executed
+	// OP_CODESEPARATOR
+	codeSepOffset := 0
+	if e.isTapscript() {
+		e.taproot.CodeSepPos = 0xffffffff
+	}
+	for pos, op := range ops {
+		executing := true
+		for _, taken := range condStack {
+			if !taken {
+				executing = false
+				break
+			}
+		}
+		if len(op.data) > MaxScriptElementSize {
+			return scriptError(ErrPushSize, fmt.Sprintf("element size %d exceeds max allowed size %d", len(op.data), MaxScriptElementSize))
+		}
+		if !e.isTapscript() && op.value > OP_16 {
+			opCount++
+			if opCount > MaxOpsPerScript {
+				return scriptError(ErrOpCount, fmt.Sprintf("exceeded max operation limit of %d", MaxOpsPerScript))
+			}
+		}
+		if isDisabled(op.value) {
+			return scriptError(ErrDisabledOpcode, fmt.Sprintf("attempt to execute disabled opcode 0x%02x", op.value))
+		}
+		if executing && op.value <= OP_PUSHDATA4 {
+			stk.push(op.data)
+		} else if executing || (op.value >= OP_IF && op.value <= OP_ENDIF) {
+			switch op.value {
+			case OP_1NEGATE, OP_1, OP_2, OP_3, OP_4, OP_5, OP_6, OP_7, OP_8,
+				OP_9, OP_10, OP_11, OP_12, OP_13, OP_14, OP_15, OP_16:
+				stk.push(numBytes(int64(op.value) - (OP_1 - 1)))
+
+			case OP_NOP, OP_NOP1, OP_NOP4, OP_NOP5, OP_NOP6, OP_NOP7, OP_NOP8, OP_NOP9, OP_NOP10:
+
+			case OP_CHECKLOCKTIMEVERIFY:
+				if e.flags&VerifyCheckLockTimeVerify == 0 {
+					break
+				}
+				top, err := stk.peek(0)
+				if err != nil {
+					return err
+				}
+				lockTime, err := makeNum(top, lockTimeNumSize)
+				if err != nil {
+					return err
+				}
+				if lockTime < 0 {
+					return scriptError(ErrNegativeLockTime, fmt.Sprintf("negative lock time %d", lockTime))
+				}
+				if !e.checker.CheckLockTime(lockTime) {
+					return scriptError(ErrUnsatisfiedLockTime, fmt.Sprintf("lock time %d is not satisfied", lockTime))
+				}
+
+			case OP_CHECKSEQUENCEVERIFY:
+				if e.flags&VerifyCheckSequenceVerify == 0 {
+					break
+				}
+				top, err := stk.peek(0)
+				if err != nil {
+					return err
+				}
+				sequence, err := makeNum(top, lockTimeNumSize)
+				if err != nil {
+					return err
+				}
+				if sequence < 0 {
+					return scriptError(ErrNegativeLockTime, fmt.Sprintf("negative sequence %d", sequence))
+				}
+				if sequence&sequenceLockTimeDisableFlag != 0 {
+					break
+				}
+				if !e.checker.CheckSequence(sequence) {
+					return scriptError(ErrUnsatisfiedLockTime, fmt.Sprintf("sequence %d is not satisfied", sequence))
+				}
+
+			case OP_IF, OP_NOTIF:
+				taken := false
+				if executing {
+					top, err := stk.pop()
+					if err != nil {
+						return scriptError(ErrUnbalancedConditional, "OP_IF with an empty stack")
+					}
+					// tapscript makes MINIMALIF consensus (BIP342)
+					if e.isTapscript() && (len(top) > 1 || (len(top) == 1 && top[0] != 1)) {
+						return scriptError(ErrMinimalIf, "tapscript OP_IF argument must be empty or 1")
+					}
+					taken = castToBool(top)
+					if op.value == OP_NOTIF {
+						taken = !taken
+					}
+				}
+				condStack = append(condStack, taken)
+
+			case OP_ELSE:
+				if len(condStack) == 0 {
+					return scriptError(ErrUnbalancedConditional, "OP_ELSE without OP_IF")
+				}
+				condStack[len(condStack)-1] = !condStack[len(condStack)-1]
+
+			case OP_ENDIF:
+				if len(condStack) == 0 {
+					return scriptError(ErrUnbalancedConditional, "OP_ENDIF without OP_IF")
+				}
+				condStack = condStack[:len(condStack)-1]
+
+			case OP_VERIFY:
+				ok, err := stk.popBool()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return scriptError(ErrVerify, "OP_VERIFY failed")
+				}
+
+			case OP_RETURN:
+				return scriptError(ErrOpReturn, "script returned early")
+
+			case OP_TOALTSTACK:
+				v, err := stk.pop()
+				if err != nil {
+					return err
+				}
+				altStack.push(v)
+
+			case OP_FROMALTSTACK:
+				v, err := altStack.pop()
+				if err != nil {
+					return scriptError(ErrInvalidAltStackOperation, "attempt to pop from an empty alt stack")
+				}
+				stk.push(v)
+
+			case OP_2DROP:
+				if err := stk.need(2); err != nil {
+					return err
+				}
+				*stk = (*stk)[:len(*stk)-2]
+
+			case OP_2DUP, OP_3DUP:
+				n := 2
+				if op.value == OP_3DUP {
+					n = 3
+				}
+				if err := stk.need(n); err != nil {
+					return err
+				}
+				for i := 0; i < n; i++ {
+					v, _ := stk.peek(n - 1)
+					stk.push(v)
+				}
+
+			case OP_2OVER:
+				if err := stk.need(4); err != nil {
+					return err
+				}
+				for i := 0; i < 2; i++ {
+					v, _ := stk.peek(3)
+					stk.push(v)
+				}
+
+			case OP_2ROT:
+				if err := stk.need(6); err != nil {
+					return err
+				}
+				for i := 0; i < 2; i++ {
+					v, _ := stk.remove(5)
+					stk.push(v)
+				}
+
+			case OP_2SWAP:
+				if err := stk.need(4); err != nil {
+					return err
+				}
+				for i := 0; i < 2; i++ {
+					v, _ := stk.remove(3)
+					stk.push(v)
+				}
+
+			case OP_IFDUP:
+				v, err := stk.peek(0)
+				if err != nil {
+					return err
+				}
+				if castToBool(v) {
+					stk.push(v)
+				}
+
+			case OP_DEPTH:
+				stk.push(numBytes(int64(len(*stk))))
+
+			case OP_DROP:
+				if _, err := stk.pop(); err != nil {
+					return err
+				}
+
+			case OP_DUP:
+				v, err := stk.peek(0)
+				if err != nil {
+					return err
+				}
+				stk.push(v)
+
+			case OP_NIP:
+				if _, err := stk.remove(1); err != nil {
+					return err
+				}
+
+			case OP_OVER:
+				v, err := stk.peek(1)
+				if err != nil {
+					return err
+				}
+				stk.push(v)
+
+			case OP_PICK, OP_ROLL:
+				n, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				if n < 0 || n >= int64(len(*stk)) {
+					return scriptError(ErrInvalidStackOperation, fmt.Sprintf("index %d is invalid for stack size %d", n, len(*stk)))
+				}
+				var v []byte
+				if op.value == OP_PICK {
+					v, _ = stk.peek(int(n))
+				} else {
+					v, _ = stk.remove(int(n))
+				}
+				stk.push(v)
+
+			case OP_ROT:
+				if err := stk.need(3); err != nil {
+					return err
+				}
+				v, _ := stk.remove(2)
+				stk.push(v)
+
+			case OP_SWAP:
+				if err := stk.need(2); err != nil {
+					return err
+				}
+				v, _ := stk.remove(1)
+				stk.push(v)
+
+			case OP_TUCK:
+				if err := stk.need(2); err != nil {
+					return err
+				}
+				top, _ := stk.pop()
+				second, _ := stk.pop()
+				stk.push(top)
+				stk.push(second)
+				stk.push(top)
+
+			case OP_SIZE:
+				v, err := stk.peek(0)
+				if err != nil {
+					return err
+				}
+				stk.push(numBytes(int64(len(v))))
+
+			case OP_EQUAL, OP_EQUALVERIFY:
+				if err := stk.need(2); err != nil {
+					return err
+				}
+				a, _ := stk.pop()
+				b, _ := stk.pop()
+				equal := bytes.Equal(a, b)
+				if op.value == OP_EQUALVERIFY {
+					if !equal {
+						return scriptError(ErrEqualVerify, "OP_EQUALVERIFY failed")
+					}
+					break
+				}
+				stk.push(boolBytes(equal))
+
+			case OP_1ADD, OP_1SUB, OP_NEGATE, OP_ABS, OP_NOT, OP_0NOTEQUAL:
+				n, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				switch op.value {
+				case OP_1ADD:
+					n++
+				case OP_1SUB:
+					n--
+				case OP_NEGATE:
+					n = -n
+				case OP_ABS:
+					if n < 0 {
+						n = -n
+					}
+				case OP_NOT:
+					n = boolNum(n == 0)
+				case OP_0NOTEQUAL:
+					n = boolNum(n != 0)
+				}
+				stk.push(numBytes(n))
+
+			case OP_ADD, OP_SUB, OP_BOOLAND, OP_BOOLOR, OP_NUMEQUAL, OP_NUMEQUALVERIFY,
+				OP_NUMNOTEQUAL, OP_LESSTHAN, OP_GREATERTHAN, OP_LESSTHANOREQUAL,
+				OP_GREATERTHANOREQUAL, OP_MIN, OP_MAX:
+				if err := stk.need(2); err != nil {
+					return err
+				}
+				b, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				a, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				var n int64
+				switch op.value {
+				case OP_ADD:
+					n = a + b
+				case OP_SUB:
+					n = a - b
+				case OP_BOOLAND:
+					n = boolNum(a != 0 && b != 0)
+				case OP_BOOLOR:
+					n = boolNum(a != 0 || b != 0)
+				case OP_NUMEQUAL, OP_NUMEQUALVERIFY:
+					n = boolNum(a == b)
+				case OP_NUMNOTEQUAL:
+					n = boolNum(a != b)
+				case OP_LESSTHAN:
+					n = boolNum(a < b)
+				case OP_GREATERTHAN:
+					n = boolNum(a > b)
+				case OP_LESSTHANOREQUAL:
+					n = boolNum(a <= b)
+				case OP_GREATERTHANOREQUAL:
+					n = boolNum(a >= b)
+				case OP_MIN:
+					n = a
+					if b < a {
+						n = b
+					}
+				case OP_MAX:
+					n = a
+					if b > a {
+						n = b
+					}
+				}
+				if op.value == OP_NUMEQUALVERIFY {
+					if n == 0 {
+						return scriptError(ErrNumEqualVerify, "OP_NUMEQUALVERIFY failed")
+					}
+					break
+				}
+				stk.push(numBytes(n))
+
+			case OP_WITHIN:
+				if err := stk.need(3); err != nil {
+					return err
+				}
+				max, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				min, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				x, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				stk.push(boolBytes(min <= x && x < max))
+
+			case OP_RIPEMD160, OP_SHA1, OP_SHA256, OP_HASH160, OP_HASH256:
+				v, err := stk.pop()
+				if err != nil {
+					return err
+				}
+				stk.push(hashOp(op.value, v))
+
+			case OP_CODESEPARATOR:
+				codeSepOffset = len(script)
+				if pos+1 < len(ops) {
+					codeSepOffset = ops[pos+1].offset
+				}
+				if e.isTapscript() {
+					e.taproot.CodeSepPos = uint32(pos)
+				}
+
+			case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+				if err := stk.need(2); err != nil {
+					return err
+				}
+				pubKey, _ := stk.pop()
+				sig, _ := stk.pop()
+				ok, err := e.checkSig(sig, pubKey, script[codeSepOffset:])
+				if err != nil {
+					return err
+				}
+				if op.value == OP_CHECKSIGVERIFY {
+					if !ok {
+						return scriptError(ErrCheckSigVerify, "OP_CHECKSIGVERIFY failed")
+					}
+					break
+				}
+				stk.push(boolBytes(ok))
+
+			case OP_CHECKSIGADD:
+				if !e.isTapscript() {
+					return scriptError(ErrBadOpcode, "OP_CHECKSIGADD is only available in tapscript")
+				}
+				if err := stk.need(3); err != nil {
+					return err
+				}
+				pubKey, _ := stk.pop()
+				n, err := stk.popNum(maxNumSize)
+				if err != nil {
+					return err
+				}
+				sig, _ := stk.pop()
+				ok, err := e.checkSig(sig, pubKey, nil)
+				if err != nil {
+					return err
+				}
+				if ok {
+					n++
+				}
+				stk.push(numBytes(n))
+
+			case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+				if e.isTapscript() {
+					return scriptError(ErrTapscriptCheckMultiSig, "OP_CHECKMULTISIG is not available in tapscript")
+				}
+				ok, err := e.checkMultiSig(stk, script[codeSepOffset:], &opCount)
+				if err != nil {
+					return err
+				}
+				if op.value == OP_CHECKMULTISIGVERIFY {
+					if !ok {
+						return scriptError(ErrCheckMultiSigVerify, "OP_CHECKMULTISIGVERIFY failed")
+					}
+					break
+				}
+				stk.push(boolBytes(ok))
+
+			default:
+				return scriptError(ErrBadOpcode, fmt.Sprintf("attempt to execute invalid opcode 0x%02x", op.value))
+			}
+		}
+		if len(*stk)+len(altStack) > MaxStackSize {
+			return scriptError(ErrStackSize, fmt.Sprintf("combined stack size %d exceeds max allowed %d", len(*stk)+len(altStack), MaxStackSize))
+		}
+	}
+	if len(condStack) != 0 {
+		return scriptError(ErrUnbalancedConditional, "end of script reached in conditional execution")
+	}
+	return nil
+}
+
+func boolNum(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func hashOp(op byte, v []byte) []byte {
+	switch op {
+	case OP_RIPEMD160:
+		h := ripemd160(v)
+		return h[:]
+	case OP_SHA1:
+		h := sha1.Sum(v)
+		return h[:]
+	case OP_SHA256:
+		h := sha256.Sum256(v)
+		return h[:]
+	case OP_HASH160:
+		single := sha256.Sum256(v)
+		h := ripemd160(single[:])
+		return h[:]
+	default:
+		single := sha256.Sum256(v)
+		h := sha256.Sum256(single[:])
+		return h[:]
+	}
+}
+
+// checkSig verifies one signature, failing the script only for encoding
+// errors. scriptCode is ignored in tapscript.
+func (e *engine) checkSig(sig, pubKey, scriptCode []byte) (bool, error) {
+	if e.isTapscript() {
+		return e.checkSchnorrSig(sig, pubKey)
+	}
+	if e.sigVersion == SigVersionBase {
+		// a signature can't sign itself
+		scriptCode = findAndDelete(scriptCode, sig)
+	}
+	err := e.checkSignatureEncoding(sig)
+	if err != nil {
+		return false, err
+	}
+	err = e.checkPubKeyEncoding(pubKey)
+	if err != nil {
+		return false, err
+	}
+	return e.checker.CheckECDSASignature(sig, pubKey, scriptCode, e.sigVersion), nil
+}
+
+// checkSchnorrSig follows BIP342, an empty signature is just false but one
+// that doesn't verify fails the script
+func (e *engine) checkSchnorrSig(sig, pubKey []byte) (bool, error) {
+	if len(sig) > 0 {
+		e.validationWeight -= validationWeightPerSigOp
+		if e.validationWeight < 0 {
+			return false, scriptError(ErrTapscriptValidationWeight, "too many signature checks for the witness size")
+		}
+	}
+	if len(pubKey) == 0 {
+		return false, scriptError(ErrPubKeyType, "empty public key in tapscript")
+	}
+	if len(pubKey) == 32 {
+		if len(sig) > 0 && !e.checker.CheckSchnorrSignature(sig, pubKey, e.sigVersion, e.taproot) {
+			return false, scriptError(ErrSchnorrSig, "invalid schnorr signature")
+		}
+	}
+	// other key sizes are left for soft forks and always succeed
+	return len(sig) > 0, nil
+}
+
+func (e *engine) checkMultiSig(stk *stack, scriptCode []byte, opCount *int) (bool, error) {
+	numKeys, err := stk.popNum(maxNumSize)
+	if err != nil {
+		return false, err
+	}
+	if numKeys < 0 || numKeys > MaxPubKeysPerMultiSig {
+		return false, scriptError(ErrPubKeyCount, fmt.Sprintf("number of pubkeys %d is out of range", numKeys))
+	}
+	*opCount += int(numKeys)
+	if *opCount > MaxOpsPerScript {
+		return false, scriptError(ErrOpCount, fmt.Sprintf("exceeded max operation limit of %d", MaxOpsPerScript))
+	}
+	if err := stk.need(int(numKeys)); err != nil {
+		return false, err
+	}
+	pubKeys := make([][]byte, numKeys)
+	for i := range pubKeys {
+		pubKeys[i], _ = stk.pop()
+	}
+	numSigs, err := stk.popNum(maxNumSize)
+	if err != nil {
+		return false, err
+	}
+	if numSigs < 0 || numSigs > numKeys {
+		return false, scriptError(ErrSigCount, fmt.Sprintf("number of signatures %d is out of range", numSigs))
+	}
+	if err := stk.need(int(numSigs)); err != nil {
+		return false, err
+	}
+	sigs := make([][]byte, numSigs)
+	for i := range sigs {
+		sigs[i], _ = stk.pop()
+	}
+	// a bug in the original client pops one extra item
+	dummy, err := stk.pop()
+	if err != nil {
+		return false, err
+	}
+	if e.sigVersion == SigVersionBase {
+		for _, sig := range sigs {
+			scriptCode = findAndDelete(scriptCode, sig)
+		}
+	}
+	// signatures have to be in the same order as their keys. Both lists
+	// were popped so they start with the last pushed, which is where the
+	// original client starts matching too.
+	sigIdx, keyIdx := 0, 0
+	ok := true
+	for ok && sigIdx < len(sigs) {
+		sig := sigs[sigIdx]
+		pubKey := pubKeys[keyIdx]
+		err = e.checkSignatureEncoding(sig)
+		if err != nil {
+			return false, err
+		}
+		err = e.checkPubKeyEncoding(pubKey)
+		if err != nil {
+			return false, err
+		}
+		if e.checker.CheckECDSASignature(sig, pubKey, scriptCode, e.sigVersion) {
+			sigIdx++
+		}
+		keyIdx++
+		// more signatures left than keys to match them
+		if len(sigs)-sigIdx > len(pubKeys)-keyIdx {
+			ok = false
+		}
+	}
+	if e.flags&VerifyNullDummy != 0 && len(dummy) != 0 {
+		return false, scriptError(ErrSigNullDummy, "CHECKMULTISIG dummy argument is not empty")
+	}
+	return ok, nil
+}
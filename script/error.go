@@ -0,0 +1,143 @@
+package script
+
+import "fmt"
+
+// ErrorCode says why a script failed.
+type ErrorCode int
+
+const (
+	// the script finished with an empty or false stack
+	ErrEvalFalse ErrorCode = iota
+	// OP_RETURN was executed
+	ErrOpReturn
+	// the script is longer than MaxScriptSize
+	ErrScriptSize
+	// a push is longer than MaxScriptElementSize
+	ErrPushSize
+	// more than MaxOpsPerScript non-push opcodes
+	ErrOpCount
+	// the stack and alt stack hold more than MaxStackSize items
+	ErrStackSize
+	// more than MaxPubKeysPerMultiSig keys or a negative count
+	ErrPubKeyCount
+	// a negative or too large signature count
+	ErrSigCount
+	// a push runs past the end of the script
+	ErrMalformedPush
+	// OP_VERIFY or one of the *VERIFY opcodes failed
+	ErrVerify
+	ErrEqualVerify
+	ErrCheckMultiSigVerify
+	ErrCheckSigVerify
+	ErrNumEqualVerify
+	// an opcode that isn't defined
+	ErrBadOpcode
+	// one of the disabled opcodes
+	ErrDisabledOpcode
+	// an opcode needed more items than the stack has
+	ErrInvalidStackOperation
+	ErrInvalidAltStackOperation
+	// IF without ENDIF or the other way around
+	ErrUnbalancedConditional
+	// a number operand longer than allowed
+	ErrNumberTooBig
+	// CHECKLOCKTIMEVERIFY or CHECKSEQUENCEVERIFY with a negative value
+	ErrNegativeLockTime
+	// the transaction doesn't meet the lock time or sequence asked for
+	ErrUnsatisfiedLockTime
+	// a hash type STRICTENC doesn't know
+	ErrSigHashType
+	// a signature that isn't strict DER (BIP66)
+	ErrSigDER
+	// S above half the order with VerifyLowS
+	ErrSigHighS
+	// a public key that is neither compressed nor uncompressed
+	ErrPubKeyType
+	// the CHECKMULTISIG dummy isn't empty (BIP147)
+	ErrSigNullDummy
+	// P2SH spends need a push only scriptSig
+	ErrSigPushOnly
+	// the witness program is empty or has the wrong witness
+	ErrWitnessProgramWrongLength
+	ErrWitnessProgramWitnessEmpty
+	ErrWitnessProgramMismatch
+	// native witness spends need an empty scriptSig, P2SH ones just the redeem script
+	ErrWitnessMalleated
+	ErrWitnessMalleatedP2SH
+	// witness data for an input that isn't a witness spend
+	ErrWitnessUnexpected
+	// the argument of IF/NOTIF in tapscript isn't empty or 1
+	ErrMinimalIf
+	// a bad or failing BIP340 signature
+	ErrSchnorrSig
+	// a taproot control block of the wrong size
+	ErrTaprootWrongControlSize
+	// more signatures than the witness size pays for
+	ErrTapscriptValidationWeight
+	// CHECKMULTISIG isn't available in tapscript
+	ErrTapscriptCheckMultiSig
+)
+
+var errorCodeStrings = map[ErrorCode]string{
+	ErrEvalFalse:                  "ErrEvalFalse",
+	ErrOpReturn:                   "ErrOpReturn",
+	ErrScriptSize:                 "ErrScriptSize",
+	ErrPushSize:                   "ErrPushSize",
+	ErrOpCount:                    "ErrOpCount",
+	ErrStackSize:                  "ErrStackSize",
+	ErrPubKeyCount:                "ErrPubKeyCount",
+	ErrSigCount:                   "ErrSigCount",
+	ErrMalformedPush:              "ErrMalformedPush",
+	ErrVerify:                     "ErrVerify",
+	ErrEqualVerify:                "ErrEqualVerify",
+	ErrCheckMultiSigVerify:        "ErrCheckMultiSigVerify",
+	ErrCheckSigVerify:             "ErrCheckSigVerify",
+	ErrNumEqualVerify:             "ErrNumEqualVerify",
+	ErrBadOpcode:                  "ErrBadOpcode",
+	ErrDisabledOpcode:             "ErrDisabledOpcode",
+	ErrInvalidStackOperation:      "ErrInvalidStackOperation",
+	ErrInvalidAltStackOperation:   "ErrInvalidAltStackOperation",
+	ErrUnbalancedConditional:      "ErrUnbalancedConditional",
+	ErrNumberTooBig:               "ErrNumberTooBig",
+	ErrNegativeLockTime:           "ErrNegativeLockTime",
+	ErrUnsatisfiedLockTime:        "ErrUnsatisfiedLockTime",
+	ErrSigHashType:                "ErrSigHashType",
+	ErrSigDER:                     "ErrSigDER",
+	ErrSigHighS:                   "ErrSigHighS",
+	ErrPubKeyType:                 "ErrPubKeyType",
+	ErrSigNullDummy:               "ErrSigNullDummy",
+	ErrSigPushOnly:                "ErrSigPushOnly",
+	ErrWitnessProgramWrongLength:  "ErrWitnessProgramWrongLength",
+	ErrWitnessProgramWitnessEmpty: "ErrWitnessProgramWitnessEmpty",
+	ErrWitnessProgramMismatch:     "ErrWitnessProgramMismatch",
+	ErrWitnessMalleated:           "ErrWitnessMalleated",
+	ErrWitnessMalleatedP2SH:       "ErrWitnessMalleatedP2SH",
+	ErrWitnessUnexpected:          "ErrWitnessUnexpected",
+	ErrMinimalIf:                  "ErrMinimalIf",
+	ErrSchnorrSig:                 "ErrSchnorrSig",
+	ErrTaprootWrongControlSize:    "ErrTaprootWrongControlSize",
+	ErrTapscriptValidationWeight:  "ErrTapscriptValidationWeight",
+	ErrTapscriptCheckMultiSig:     "ErrTapscriptCheckMultiSig",
+}
+
+func (e ErrorCode) String() string {
+	if s, ok := errorCodeStrings[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown ErrorCode (%d)", int(e))
+}
+
+// Error is returned for scripts that fail, as opposed to callers passing
+// something that can't be checked at all.
+type Error struct {
+	ErrorCode   ErrorCode
+	Description string
+}
+
+func (e Error) Error() string {
+	return e.Description
+}
+
+func scriptError(c ErrorCode, desc string) Error {
+	return Error{ErrorCode: c, Description: desc}
+}
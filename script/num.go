@@ -0,0 +1,77 @@
+package script
+
+import "fmt"
+
+// numbers are at most 4 bytes going into arithmetic, results may overflow
+// that and only fail once they are used as an operand again
+const maxNumSize = 4
+
+// lock times get 5 bytes so they can go past 2^31
+const lockTimeNumSize = 5
+
+// makeNum decodes a little endian sign-magnitude number from the stack
+func makeNum(v []byte, maxSize int) (int64, error) {
+	if len(v) > maxSize {
+		return 0, scriptError(ErrNumberTooBig, fmt.Sprintf("numeric value encoded as %x is %d bytes which exceeds the max allowed of %d", v, len(v), maxSize))
+	}
+	if len(v) == 0 {
+		return 0, nil
+	}
+	var result int64
+	for i, b := range v {
+		result |= int64(b) << uint(8*i)
+	}
+	// the top bit of the last byte is the sign
+	if v[len(v)-1]&0x80 != 0 {
+		result &= ^(int64(0x80) << uint(8*(len(v)-1)))
+		return -result, nil
+	}
+	return result, nil
+}
+
+// numBytes is the minimal encoding of n
+func numBytes(n int64) []byte {
+	if n == 0 {
+		return nil
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	result := make([]byte, 0, 9)
+	for n > 0 {
+		result = append(result, byte(n&0xff))
+		n >>= 8
+	}
+	// the sign needs its own byte if the top bit is taken
+	if result[len(result)-1]&0x80 != 0 {
+		extra := byte(0x00)
+		if negative {
+			extra = 0x80
+		}
+		result = append(result, extra)
+	} else if negative {
+		result[len(result)-1] |= 0x80
+	}
+	return result
+}
+
+// castToBool is false for any encoding of zero, negative zero included
+func castToBool(v []byte) bool {
+	for i, b := range v {
+		if b != 0 {
+			if i == len(v)-1 && b == 0x80 {
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+func boolBytes(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+// Package script parses and executes Bitcoin Script, covering legacy,
+// P2SH, segwit v0 and taproot spends. Signatures are checked through a
+// SignatureChecker since hashing them needs the spending transaction.
+package script
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	MaxScriptSize         = 10000
+	MaxScriptElementSize  = 520
+	MaxOpsPerScript       = 201
+	MaxStackSize          = 1000
+	MaxPubKeysPerMultiSig = 20
+)
+
+// Flags picks which soft forks a script is checked under
+type Flags uint32
+
+const (
+	// BIP16 pay to script hash
+	VerifyP2SH Flags = 1 << iota
+	// signatures and public keys must be well formed, with known hash types
+	VerifyStrictEnc
+	// signatures must be strict DER (BIP66)
+	VerifyDERSig
+	// OP_CHECKLOCKTIMEVERIFY (BIP65)
+	VerifyCheckLockTimeVerify
+	// OP_CHECKSEQUENCEVERIFY (BIP112)
+	VerifyCheckSequenceVerify
+	// the CHECKMULTISIG dummy must be empty (BIP147)
+	VerifyNullDummy
+	// segwit v0 (BIP141, BIP143)
+	VerifyWitness
+	// taproot and tapscript (BIP341, BIP342)
+	VerifyTaproot
+	// ECDSA signatures must have S in the lower half of the order, policy
+	// only since consensus accepts either
+	VerifyLowS
+)
+
+// SigVersion is the kind of script a signature is checked in, which picks
+// the signature hash algorithm.
+type SigVersion int
+
+const (
+	SigVersionBase SigVersion = iota
+	SigVersionWitnessV0
+	// taproot key path spends
+	SigVersionTaproot
+	// taproot script path spends
+	SigVersionTapscript
+)
+
+// TaprootData is what BIP341 signature hashes need beyond the transaction
+type TaprootData struct {
+	// the annex, nil if the witness has none
+	Annex []byte
+	// hash of the leaf being spent, script path only
+	TapLeafHash [32]byte
+	// opcode position of the last executed OP_CODESEPARATOR
+	CodeSepPos uint32
+}
+
+// SignatureChecker checks the parts of a script that depend on the
+// spending transaction.
+type SignatureChecker interface {
+	// CheckECDSASignature verifies sig, hash type byte included, against
+	// pubKey for the signature hash of scriptCode
+	CheckECDSASignature(sig, pubKey, scriptCode []byte, sigVersion SigVersion) bool
+	// CheckSchnorrSignature verifies a 64 or 65 byte BIP340 signature
+	CheckSchnorrSignature(sig, pubKey []byte, sigVersion SigVersion, data *TaprootData) bool
+	CheckLockTime(lockTime int64) bool
+	CheckSequence(sequence int64) bool
+	// CheckTapTweak tells whether outputKey is internalKey tweaked by
+	// merkleRoot and has the given parity
+	CheckTapTweak(outputKey, internalKey []byte, merkleRoot [32]byte, parity bool) bool
+}
+
+// opcode is one parsed instruction, data is set for pushes
+type opcode struct {
+	value byte
+	data  []byte
+	// where the instruction starts in the script
+	offset int
+}
+
+// parse splits a script into its instructions, on error it also returns
+// the ones before the malformed push
+func parse(script []byte) ([]opcode, error) {
+	ops := make([]opcode, 0, len(script))
+	for i := 0; i < len(script); {
+		op := opcode{value: script[i], offset: i}
+		i++
+		var size int
+		switch {
+		case op.value < OP_PUSHDATA1:
+			size = int(op.value)
+		case op.value == OP_PUSHDATA1:
+			if len(script)-i < 1 {
+				return ops, scriptError(ErrMalformedPush, "OP_PUSHDATA1 has no length")
+			}
+			size = int(script[i])
+			i++
+		case op.value == OP_PUSHDATA2:
+			if len(script)-i < 2 {
+				return ops, scriptError(ErrMalformedPush, "OP_PUSHDATA2 has no length")
+			}
+			size = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		case op.value == OP_PUSHDATA4:
+			if len(script)-i < 4 {
+				return ops, scriptError(ErrMalformedPush, "OP_PUSHDATA4 has no length")
+			}
+			size = int(binary.LittleEndian.Uint32(script[i:]))
+			i += 4
+		}
+		if op.value <= OP_PUSHDATA4 {
+			if size < 0 || len(script)-i < size {
+				return ops, scriptError(ErrMalformedPush, fmt.Sprintf("push of %d bytes at offset %d runs past the end of the script", size, op.offset))
+			}
+			op.data = script[i : i+size]
+			i += size
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// IsPushOnly tells whether script only pushes data, OP_1NEGATE to OP_16
+// included
+func IsPushOnly(script []byte) bool {
+	ops, err := parse(script)
+	if err != nil {
+		return false
+	}
+	for _, op := range ops {
+		if op.value > OP_16 {
+			return false
+		}
+	}
+	return true
+}
+
+// IsPayToScriptHash matches OP_HASH160 <20 bytes> OP_EQUAL exactly
+func IsPayToScriptHash(script []byte) bool {
+	return len(script) == 23 && script[0] == OP_HASH160 && script[1] == 0x14 && script[22] == OP_EQUAL
+}
+
+// IsWitnessProgram matches a version opcode followed by one push of 2 to
+// 40 bytes
+func IsWitnessProgram(script []byte) bool {
+	_, _, ok := ExtractWitnessProgram(script)
+	return ok
+}
+
+func ExtractWitnessProgram(script []byte) (version int, program []byte, ok bool) {
+	if len(script) < 4 || len(script) > 42 {
+		return 0, nil, false
+	}
+	if script[0] != OP_0 && (script[0] < OP_1 || script[0] > OP_16) {
+		return 0, nil, false
+	}
+	if int(script[1])+2 != len(script) {
+		return 0, nil, false
+	}
+	return decodeSmallInt(script[0]), script[2:], true
+}
+
+func decodeSmallInt(op byte) int {
+	if op == OP_0 {
+		return 0
+	}
+	return int(op) - (OP_1 - 1)
+}
+
+// pushData is the shortest push of data
+func pushData(data []byte) []byte {
+	var buf bytes.Buffer
+	switch {
+	case len(data) < OP_PUSHDATA1:
+		buf.WriteByte(byte(len(data)))
+	case len(data) <= 0xff:
+		buf.WriteByte(OP_PUSHDATA1)
+		buf.WriteByte(byte(len(data)))
+	case len(data) <= 0xffff:
+		buf.WriteByte(OP_PUSHDATA2)
+		binary.Write(&buf, binary.LittleEndian, uint16(len(data)))
+	default:
+		buf.WriteByte(OP_PUSHDATA4)
+		binary.Write(&buf, binary.LittleEndian, uint32(len(data)))
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// findAndDelete removes every push of sig from script, matching only on
+// instruction boundaries like Bitcoin Core does for legacy scriptCodes.
+func findAndDelete(script, sig []byte) []byte {
+	pattern := pushData(sig)
+	ops, err := parse(script)
+	if err != nil {
+		// can't happen for a script that got this far
+		return script
+	}
+	result := make([]byte, 0, len(script))
+	for i, op := range ops {
+		end := len(script)
+		if i+1 < len(ops) {
+			end = ops[i+1].offset
+		}
+		if bytes.Equal(script[op.offset:end], pattern) {
+			continue
+		}
+		result = append(result, script[op.offset:end]...)
+	}
+	return result
+}
+
+// RemoveCodeSeparators is scriptCode as legacy signature hashes see it
+func RemoveCodeSeparators(script []byte) []byte {
+	ops, err := parse(script)
+	if err != nil {
+		return script
+	}
+	result := make([]byte, 0, len(script))
+	for i, op := range ops {
+		end := len(script)
+		if i+1 < len(ops) {
+			end = ops[i+1].offset
+		}
+		if op.value != OP_CODESEPARATOR {
+			result = append(result, script[op.offset:end]...)
+		}
+	}
+	return result
+}
@@ -0,0 +1,370 @@
+package script_test
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/singurty/goldchain/blockchain"
+	"github.com/singurty/goldchain/script"
+)
+
+var opcodeNames = map[string]byte{
+	"OP_0":                   script.OP_0,
+	"OP_FALSE":               script.OP_FALSE,
+	"OP_PUSHDATA1":           script.OP_PUSHDATA1,
+	"OP_PUSHDATA2":           script.OP_PUSHDATA2,
+	"OP_PUSHDATA4":           script.OP_PUSHDATA4,
+	"OP_1NEGATE":             script.OP_1NEGATE,
+	"OP_RESERVED":            script.OP_RESERVED,
+	"OP_1":                   script.OP_1,
+	"OP_TRUE":                script.OP_TRUE,
+	"OP_2":                   script.OP_2,
+	"OP_3":                   script.OP_3,
+	"OP_4":                   script.OP_4,
+	"OP_5":                   script.OP_5,
+	"OP_6":                   script.OP_6,
+	"OP_7":                   script.OP_7,
+	"OP_8":                   script.OP_8,
+	"OP_9":                   script.OP_9,
+	"OP_10":                  script.OP_10,
+	"OP_11":                  script.OP_11,
+	"OP_12":                  script.OP_12,
+	"OP_13":                  script.OP_13,
+	"OP_14":                  script.OP_14,
+	"OP_15":                  script.OP_15,
+	"OP_16":                  script.OP_16,
+	"OP_NOP":                 script.OP_NOP,
+	"OP_VER":                 script.OP_VER,
+	"OP_IF":                  script.OP_IF,
+	"OP_NOTIF":               script.OP_NOTIF,
+	"OP_VERIF":               script.OP_VERIF,
+	"OP_VERNOTIF":            script.OP_VERNOTIF,
+	"OP_ELSE":                script.OP_ELSE,
+	"OP_ENDIF":               script.OP_ENDIF,
+	"OP_VERIFY":              script.OP_VERIFY,
+	"OP_RETURN":              script.OP_RETURN,
+	"OP_TOALTSTACK":          script.OP_TOALTSTACK,
+	"OP_FROMALTSTACK":        script.OP_FROMALTSTACK,
+	"OP_2DROP":               script.OP_2DROP,
+	"OP_2DUP":                script.OP_2DUP,
+	"OP_3DUP":                script.OP_3DUP,
+	"OP_2OVER":               script.OP_2OVER,
+	"OP_2ROT":                script.OP_2ROT,
+	"OP_2SWAP":               script.OP_2SWAP,
+	"OP_IFDUP":               script.OP_IFDUP,
+	"OP_DEPTH":               script.OP_DEPTH,
+	"OP_DROP":                script.OP_DROP,
+	"OP_DUP":                 script.OP_DUP,
+	"OP_NIP":                 script.OP_NIP,
+	"OP_OVER":                script.OP_OVER,
+	"OP_PICK":                script.OP_PICK,
+	"OP_ROLL":                script.OP_ROLL,
+	"OP_ROT":                 script.OP_ROT,
+	"OP_SWAP":                script.OP_SWAP,
+	"OP_TUCK":                script.OP_TUCK,
+	"OP_CAT":                 script.OP_CAT,
+	"OP_SUBSTR":              script.OP_SUBSTR,
+	"OP_LEFT":                script.OP_LEFT,
+	"OP_RIGHT":               script.OP_RIGHT,
+	"OP_SIZE":                script.OP_SIZE,
+	"OP_INVERT":              script.OP_INVERT,
+	"OP_AND":                 script.OP_AND,
+	"OP_OR":                  script.OP_OR,
+	"OP_XOR":                 script.OP_XOR,
+	"OP_EQUAL":               script.OP_EQUAL,
+	"OP_EQUALVERIFY":         script.OP_EQUALVERIFY,
+	"OP_RESERVED1":           script.OP_RESERVED1,
+	"OP_RESERVED2":           script.OP_RESERVED2,
+	"OP_1ADD":                script.OP_1ADD,
+	"OP_1SUB":                script.OP_1SUB,
+	"OP_2MUL":                script.OP_2MUL,
+	"OP_2DIV":                script.OP_2DIV,
+	"OP_NEGATE":              script.OP_NEGATE,
+	"OP_ABS":                 script.OP_ABS,
+	"OP_NOT":                 script.OP_NOT,
+	"OP_0NOTEQUAL":           script.OP_0NOTEQUAL,
+	"OP_ADD":                 script.OP_ADD,
+	"OP_SUB":                 script.OP_SUB,
+	"OP_MUL":                 script.OP_MUL,
+	"OP_DIV":                 script.OP_DIV,
+	"OP_MOD":                 script.OP_MOD,
+	"OP_LSHIFT":              script.OP_LSHIFT,
+	"OP_RSHIFT":              script.OP_RSHIFT,
+	"OP_BOOLAND":             script.OP_BOOLAND,
+	"OP_BOOLOR":              script.OP_BOOLOR,
+	"OP_NUMEQUAL":            script.OP_NUMEQUAL,
+	"OP_NUMEQUALVERIFY":      script.OP_NUMEQUALVERIFY,
+	"OP_NUMNOTEQUAL":         script.OP_NUMNOTEQUAL,
+	"OP_LESSTHAN":            script.OP_LESSTHAN,
+	"OP_GREATERTHAN":         script.OP_GREATERTHAN,
+	"OP_LESSTHANOREQUAL":     script.OP_LESSTHANOREQUAL,
+	"OP_GREATERTHANOREQUAL":  script.OP_GREATERTHANOREQUAL,
+	"OP_MIN":                 script.OP_MIN,
+	"OP_MAX":                 script.OP_MAX,
+	"OP_WITHIN":              script.OP_WITHIN,
+	"OP_RIPEMD160":           script.OP_RIPEMD160,
+	"OP_SHA1":                script.OP_SHA1,
+	"OP_SHA256":              script.OP_SHA256,
+	"OP_HASH160":             script.OP_HASH160,
+	"OP_HASH256":             script.OP_HASH256,
+	"OP_CODESEPARATOR":       script.OP_CODESEPARATOR,
+	"OP_CHECKSIG":            script.OP_CHECKSIG,
+	"OP_CHECKSIGVERIFY":      script.OP_CHECKSIGVERIFY,
+	"OP_CHECKMULTISIG":       script.OP_CHECKMULTISIG,
+	"OP_CHECKMULTISIGVERIFY": script.OP_CHECKMULTISIGVERIFY,
+	"OP_NOP1":                script.OP_NOP1,
+	"OP_CHECKLOCKTIMEVERIFY": script.OP_CHECKLOCKTIMEVERIFY,
+	"OP_NOP2":                script.OP_NOP2,
+	"OP_CHECKSEQUENCEVERIFY": script.OP_CHECKSEQUENCEVERIFY,
+	"OP_NOP3":                script.OP_NOP3,
+	"OP_NOP4":                script.OP_NOP4,
+	"OP_NOP5":                script.OP_NOP5,
+	"OP_NOP6":                script.OP_NOP6,
+	"OP_NOP7":                script.OP_NOP7,
+	"OP_NOP8":                script.OP_NOP8,
+	"OP_NOP9":                script.OP_NOP9,
+	"OP_NOP10":               script.OP_NOP10,
+	"OP_CHECKSIGADD":         script.OP_CHECKSIGADD,
+	"OP_INVALIDOPCODE":       script.OP_INVALIDOPCODE,
+}
+
+// coreErrors are the names script_tests.json gives our error codes
+var coreErrors = map[script.ErrorCode]string{
+	script.ErrEvalFalse:                  "EVAL_FALSE",
+	script.ErrOpReturn:                   "OP_RETURN",
+	script.ErrScriptSize:                 "SCRIPT_SIZE",
+	script.ErrPushSize:                   "PUSH_SIZE",
+	script.ErrOpCount:                    "OP_COUNT",
+	script.ErrStackSize:                  "STACK_SIZE",
+	script.ErrPubKeyCount:                "PUBKEY_COUNT",
+	script.ErrSigCount:                   "SIG_COUNT",
+	script.ErrMalformedPush:              "BAD_OPCODE",
+	script.ErrVerify:                     "VERIFY",
+	script.ErrEqualVerify:                "EQUALVERIFY",
+	script.ErrCheckMultiSigVerify:        "CHECKMULTISIGVERIFY",
+	script.ErrCheckSigVerify:             "CHECKSIGVERIFY",
+	script.ErrNumEqualVerify:             "NUMEQUALVERIFY",
+	script.ErrBadOpcode:                  "BAD_OPCODE",
+	script.ErrDisabledOpcode:             "DISABLED_OPCODE",
+	script.ErrInvalidStackOperation:      "INVALID_STACK_OPERATION",
+	script.ErrInvalidAltStackOperation:   "INVALID_ALTSTACK_OPERATION",
+	script.ErrUnbalancedConditional:      "UNBALANCED_CONDITIONAL",
+	script.ErrNumberTooBig:               "UNKNOWN_ERROR",
+	script.ErrNegativeLockTime:           "NEGATIVE_LOCKTIME",
+	script.ErrUnsatisfiedLockTime:        "UNSATISFIED_LOCKTIME",
+	script.ErrSigHashType:                "SIG_HASHTYPE",
+	script.ErrSigDER:                     "SIG_DER",
+	script.ErrSigHighS:                   "SIG_HIGH_S",
+	script.ErrPubKeyType:                 "PUBKEYTYPE",
+	script.ErrSigNullDummy:               "SIG_NULLDUMMY",
+	script.ErrSigPushOnly:                "SIG_PUSHONLY",
+	script.ErrWitnessProgramWrongLength:  "WITNESS_PROGRAM_WRONG_LENGTH",
+	script.ErrWitnessProgramWitnessEmpty: "WITNESS_PROGRAM_WITNESS_EMPTY",
+	script.ErrWitnessProgramMismatch:     "WITNESS_PROGRAM_MISMATCH",
+	script.ErrWitnessMalleated:           "WITNESS_MALLEATED",
+	script.ErrWitnessMalleatedP2SH:       "WITNESS_MALLEATED_P2SH",
+	script.ErrWitnessUnexpected:          "WITNESS_UNEXPECTED",
+	script.ErrMinimalIf:                  "TAPSCRIPT_MINIMALIF",
+	script.ErrSchnorrSig:                 "SCHNORR_SIG",
+	script.ErrTaprootWrongControlSize:    "TAPROOT_WRONG_CONTROL_SIZE",
+	script.ErrTapscriptValidationWeight:  "TAPSCRIPT_VALIDATION_WEIGHT",
+	script.ErrTapscriptCheckMultiSig:     "TAPSCRIPT_CHECKMULTISIG",
+}
+
+var coreFlags = map[string]script.Flags{
+	"P2SH":                script.VerifyP2SH,
+	"STRICTENC":           script.VerifyStrictEnc,
+	"DERSIG":              script.VerifyDERSig,
+	"CHECKLOCKTIMEVERIFY": script.VerifyCheckLockTimeVerify,
+	"CHECKSEQUENCEVERIFY": script.VerifyCheckSequenceVerify,
+	"NULLDUMMY":           script.VerifyNullDummy,
+	"WITNESS":             script.VerifyWitness,
+	"TAPROOT":             script.VerifyTaproot,
+	"LOW_S":               script.VerifyLowS,
+}
+
+// policyFlags are Core's flags for standardness rules we don't enforce,
+// tests that use them are skipped
+var policyFlags = map[string]bool{
+	"SIGPUSHONLY":                           true,
+	"MINIMALDATA":                           true,
+	"DISCOURAGE_UPGRADABLE_NOPS":            true,
+	"CLEANSTACK":                            true,
+	"MINIMALIF":                             true,
+	"NULLFAIL":                              true,
+	"DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM": true,
+	"WITNESS_PUBKEYTYPE":                    true,
+	"CONST_SCRIPTCODE":                      true,
+	"DISCOURAGE_UPGRADABLE_PUBKEYTYPE":      true,
+	"DISCOURAGE_OP_SUCCESS":                 true,
+	"DISCOURAGE_UPGRADABLE_TAPROOT_VERSION": true,
+}
+
+// parseFlags reads a comma separated flag list, policy is set when it has
+// a policy flag we don't implement
+func parseFlags(s string) (flags script.Flags, policy bool, err error) {
+	if s == "" || s == "NONE" {
+		return 0, false, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		if policyFlags[name] {
+			policy = true
+			continue
+		}
+		flag, ok := coreFlags[name]
+		if !ok {
+			return 0, false, fmt.Errorf("unknown flag %q", name)
+		}
+		flags |= flag
+	}
+	return flags, policy, nil
+}
+
+// parseShortForm turns Core's test notation into a script. Numbers are
+// pushed the way Core pushes them, 0x tokens are copied in raw, quoted
+// strings are pushed and the rest are opcode names with or without OP_.
+func parseShortForm(s string) ([]byte, error) {
+	var out []byte
+	for _, token := range strings.Fields(s) {
+		if n, err := strconv.ParseInt(token, 10, 64); err == nil {
+			out = append(out, script.NumberScript(n)...)
+			continue
+		}
+		if strings.HasPrefix(token, "0x") {
+			b, err := hex.DecodeString(token[2:])
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, b...)
+			continue
+		}
+		if len(token) >= 2 && token[0] == '\'' && token[len(token)-1] == '\'' {
+			out = append(out, pushData([]byte(token[1:len(token)-1]))...)
+			continue
+		}
+		op, ok := opcodeNames[token]
+		if !ok {
+			op, ok = opcodeNames["OP_"+token]
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown token %q", token)
+		}
+		out = append(out, op)
+	}
+	return out, nil
+}
+
+func pushData(data []byte) []byte {
+	switch {
+	case len(data) < script.OP_PUSHDATA1:
+		return append([]byte{byte(len(data))}, data...)
+	case len(data) <= 0xff:
+		return append([]byte{script.OP_PUSHDATA1, byte(len(data))}, data...)
+	default:
+		return append([]byte{script.OP_PUSHDATA2, byte(len(data)), byte(len(data) >> 8)}, data...)
+	}
+}
+
+// spendingTx is the transaction Core checks every test against, it spends
+// the only output of a coinbase paying amount to scriptPubKey
+func spendingTx(scriptSig, scriptPubKey []byte, witness [][]byte, amount int) *blockchain.Transaction {
+	credit := &blockchain.Transaction{
+		Version: 1,
+		Inputs: []*blockchain.TxIn{{
+			PrevTxIndex: math.MaxUint32,
+			Script:      []byte{script.OP_0, script.OP_0},
+			Sequence:    [4]byte{0xff, 0xff, 0xff, 0xff},
+		}},
+		Outputs: []*blockchain.TxOut{{Value: amount, Script: scriptPubKey}},
+	}
+	return &blockchain.Transaction{
+		Version: 1,
+		Inputs: []*blockchain.TxIn{{
+			PrevTxHash: credit.TxID(),
+			Script:     scriptSig,
+			Sequence:   [4]byte{0xff, 0xff, 0xff, 0xff},
+			Witness:    witness,
+		}},
+		Outputs: []*blockchain.TxOut{{Value: amount}},
+	}
+}
+
+func TestScripts(t *testing.T) {
+	file, err := os.ReadFile("testdata/script_tests.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests [][]interface{}
+	err = json.Unmarshal(file, &tests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run, skipped := 0, 0
+	for i, test := range tests {
+		// single strings are comments
+		if len(test) == 1 {
+			continue
+		}
+		var witness [][]byte
+		amount := 0
+		if items, ok := test[0].([]interface{}); ok {
+			for _, item := range items[:len(items)-1] {
+				b, err := hex.DecodeString(item.(string))
+				if err != nil {
+					t.Fatalf("test %d: bad witness: %v", i, err)
+				}
+				witness = append(witness, b)
+			}
+			amount = int(math.Round(items[len(items)-1].(float64) * 1e8))
+			test = test[1:]
+		}
+		if len(test) < 4 {
+			t.Fatalf("test %d is malformed: %v", i, test)
+		}
+		name := fmt.Sprintf("test %d %q %q %q", i, test[0], test[1], test[2])
+		scriptSig, err := parseShortForm(test[0].(string))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		scriptPubKey, err := parseShortForm(test[1].(string))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		flags, policy, err := parseFlags(test[2].(string))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if policy {
+			skipped++
+			continue
+		}
+		run++
+		expected := test[3].(string)
+		tx := spendingTx(scriptSig, scriptPubKey, witness, amount)
+		prevOuts := []*blockchain.UtxoEntry{{Value: int64(amount), Script: scriptPubKey}}
+		checker := blockchain.NewTxSigChecker(tx, 0, prevOuts, blockchain.NewTxSigHashes(tx, prevOuts))
+		err = script.VerifyScript(scriptSig, scriptPubKey, witness, flags, checker)
+		got := "OK"
+		if err != nil {
+			var scriptErr script.Error
+			if !errors.As(err, &scriptErr) {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			got = coreErrors[scriptErr.ErrorCode]
+		}
+		if got != expected {
+			t.Errorf("%s: got %v, want %v (%v)", name, got, expected, err)
+		}
+	}
+	if skipped > 0 {
+		t.Logf("ran %d tests, skipped %d that need policy flags we don't implement", run, skipped)
+	}
+}
@@ -0,0 +1,113 @@
+package script
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	SigHashAll          = 0x01
+	SigHashNone         = 0x02
+	SigHashSingle       = 0x03
+	SigHashAnyOneCanPay = 0x80
+	// taproot only, means SigHashAll without committing to a hash type byte
+	SigHashDefault = 0x00
+)
+
+// checkSignatureEncoding applies the rules the flags ask for to an ECDSA
+// signature with its hash type byte. Empty signatures are left for the
+// checker to fail.
+func (e *engine) checkSignatureEncoding(sig []byte) error {
+	if len(sig) == 0 {
+		return nil
+	}
+	if e.flags&(VerifyDERSig|VerifyLowS|VerifyStrictEnc) != 0 && !isValidSignatureEncoding(sig) {
+		return scriptError(ErrSigDER, fmt.Sprintf("signature %x is not strict DER", sig))
+	}
+	if e.flags&VerifyLowS != 0 && !isLowS(sig) {
+		return scriptError(ErrSigHighS, fmt.Sprintf("signature %x has a high S value", sig))
+	}
+	if e.flags&VerifyStrictEnc != 0 {
+		hashType := sig[len(sig)-1] &^ SigHashAnyOneCanPay
+		if hashType < SigHashAll || hashType > SigHashSingle {
+			return scriptError(ErrSigHashType, fmt.Sprintf("signature hash type 0x%02x is not defined", sig[len(sig)-1]))
+		}
+	}
+	return nil
+}
+
+func (e *engine) checkPubKeyEncoding(pubKey []byte) error {
+	if e.flags&VerifyStrictEnc == 0 {
+		return nil
+	}
+	if len(pubKey) == 33 && (pubKey[0] == 0x02 || pubKey[0] == 0x03) {
+		return nil
+	}
+	if len(pubKey) == 65 && pubKey[0] == 0x04 {
+		return nil
+	}
+	return scriptError(ErrPubKeyType, fmt.Sprintf("public key %x is neither compressed nor uncompressed", pubKey))
+}
+
+// isValidSignatureEncoding is the BIP66 strict DER check, sig includes the
+// hash type byte.
+//
+//	0x30 [total-length] 0x02 [R-length] [R] 0x02 [S-length] [S] [sighash]
+func isValidSignatureEncoding(sig []byte) bool {
+	if len(sig) < 9 || len(sig) > 73 {
+		return false
+	}
+	if sig[0] != 0x30 {
+		return false
+	}
+	// the length covers everything but the hash type
+	if int(sig[1]) != len(sig)-3 {
+		return false
+	}
+	lenR := int(sig[3])
+	if 5+lenR >= len(sig) {
+		return false
+	}
+	lenS := int(sig[5+lenR])
+	if lenR+lenS+7 != len(sig) {
+		return false
+	}
+	if sig[2] != 0x02 {
+		return false
+	}
+	if lenR == 0 {
+		return false
+	}
+	// negative
+	if sig[4]&0x80 != 0 {
+		return false
+	}
+	// padded with a zero it doesn't need
+	if lenR > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0 {
+		return false
+	}
+	if sig[lenR+4] != 0x02 {
+		return false
+	}
+	if lenS == 0 {
+		return false
+	}
+	if sig[lenR+6]&0x80 != 0 {
+		return false
+	}
+	if lenS > 1 && sig[lenR+6] == 0x00 && sig[lenR+7]&0x80 == 0 {
+		return false
+	}
+	return true
+}
+
+// half the secp256k1 group order
+var halfOrder, _ = new(big.Int).SetString("7fffffffffffffffffffffffffffffff5d576e7357a4501ddfe92f46681b20a0", 16)
+
+// isLowS expects a signature that passed isValidSignatureEncoding
+func isLowS(sig []byte) bool {
+	lenR := int(sig[3])
+	lenS := int(sig[5+lenR])
+	s := new(big.Int).SetBytes(sig[6+lenR : 6+lenR+lenS])
+	return s.Cmp(halfOrder) <= 0
+}
@@ -0,0 +1,92 @@
+package script
+
+// SigOpCount counts the signature operations in script. Legacy counting
+// charges every CHECKMULTISIG the maximum of 20 keys, accurate counting
+// uses the key count pushed right before it. Counting stops at the first
+// malformed push.
+func SigOpCount(script []byte, accurate bool) int {
+	// whatever parsed before an error still counts
+	ops, _ := parse(script)
+	count := 0
+	var lastOp byte = OP_INVALIDOPCODE
+	for _, op := range ops {
+		switch op.value {
+		case OP_CHECKSIG, OP_CHECKSIGVERIFY:
+			count++
+		case OP_CHECKMULTISIG, OP_CHECKMULTISIGVERIFY:
+			if accurate && lastOp >= OP_1 && lastOp <= OP_16 {
+				count += decodeSmallInt(lastOp)
+			} else {
+				count += MaxPubKeysPerMultiSig
+			}
+		}
+		lastOp = op.value
+	}
+	return count
+}
+
+// P2SHSigOpCount counts the signature operations of the redeem script a
+// P2SH scriptSig pushes last. Scripts that aren't P2SH are counted
+// accurately as they are.
+func P2SHSigOpCount(scriptSig, scriptPubKey []byte) int {
+	if !IsPayToScriptHash(scriptPubKey) {
+		return SigOpCount(scriptPubKey, true)
+	}
+	ops, err := parse(scriptSig)
+	if err != nil || len(ops) == 0 {
+		return 0
+	}
+	for _, op := range ops {
+		if op.value > OP_16 {
+			return 0
+		}
+	}
+	return SigOpCount(ops[len(ops)-1].data, true)
+}
+
+// WitnessSigOpCount counts the signature operations of a segwit v0 spend,
+// native or nested in P2SH. Taproot spends are limited by their
+// validation weight instead and count nothing.
+func WitnessSigOpCount(scriptSig, scriptPubKey []byte, witness [][]byte, flags Flags) int {
+	if flags&VerifyWitness == 0 {
+		return 0
+	}
+	if version, program, ok := ExtractWitnessProgram(scriptPubKey); ok {
+		return witnessSigOps(version, program, witness)
+	}
+	if IsPayToScriptHash(scriptPubKey) && IsPushOnly(scriptSig) {
+		ops, _ := parse(scriptSig)
+		if len(ops) == 0 {
+			return 0
+		}
+		if version, program, ok := ExtractWitnessProgram(ops[len(ops)-1].data); ok {
+			return witnessSigOps(version, program, witness)
+		}
+	}
+	return 0
+}
+
+func witnessSigOps(version int, program []byte, witness [][]byte) int {
+	if version != 0 {
+		return 0
+	}
+	if len(program) == 20 {
+		return 1
+	}
+	if len(program) == 32 && len(witness) > 0 {
+		return SigOpCount(witness[len(witness)-1], true)
+	}
+	return 0
+}
+
+// NumberScript is the script that pushes n the way Bitcoin Core builds it,
+// which is what BIP34 coinbases have to start with.
+func NumberScript(n int64) []byte {
+	if n == 0 {
+		return []byte{OP_0}
+	}
+	if n == -1 || (n >= 1 && n <= 16) {
+		return []byte{byte(n + (OP_1 - 1))}
+	}
+	return pushData(numBytes(n))
+}
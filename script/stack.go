@@ -0,0 +1,67 @@
+package script
+
+import "fmt"
+
+type stack [][]byte
+
+func (s *stack) push(v []byte) {
+	*s = append(*s, v)
+}
+
+func (s *stack) pop() ([]byte, error) {
+	if len(*s) == 0 {
+		return nil, scriptError(ErrInvalidStackOperation, "attempt to pop from an empty stack")
+	}
+	v := (*s)[len(*s)-1]
+	*s = (*s)[:len(*s)-1]
+	return v, nil
+}
+
+// peek returns the item depth places below the top
+func (s stack) peek(depth int) ([]byte, error) {
+	if depth < 0 || depth >= len(s) {
+		return nil, scriptError(ErrInvalidStackOperation, fmt.Sprintf("index %d is invalid for stack size %d", depth, len(s)))
+	}
+	return s[len(s)-1-depth], nil
+}
+
+func (s *stack) popNum(maxSize int) (int64, error) {
+	v, err := s.pop()
+	if err != nil {
+		return 0, err
+	}
+	return makeNum(v, maxSize)
+}
+
+func (s *stack) popBool() (bool, error) {
+	v, err := s.pop()
+	if err != nil {
+		return false, err
+	}
+	return castToBool(v), nil
+}
+
+// need fails unless the stack holds at least n items
+func (s stack) need(n int) error {
+	if len(s) < n {
+		return scriptError(ErrInvalidStackOperation, fmt.Sprintf("operation needs %d stack items but the stack has %d", n, len(s)))
+	}
+	return nil
+}
+
+// remove takes out the item depth places below the top
+func (s *stack) remove(depth int) ([]byte, error) {
+	v, err := s.peek(depth)
+	if err != nil {
+		return nil, err
+	}
+	i := len(*s) - 1 - depth
+	*s = append((*s)[:i], (*s)[i+1:]...)
+	return v, nil
+}
+
+func (s stack) copy() stack {
+	c := make(stack, len(s))
+	copy(c, s)
+	return c
+}
@@ -0,0 +1,252 @@
+package script
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	// leaf version of tapscript (BIP342)
+	tapLeafVersion  = 0xc0
+	tapLeafMask     = 0xfe
+	controlBaseSize = 33
+	controlNodeSize = 32
+	controlMaxNodes = 128
+	annexTag        = 0x50
+	// added to the witness size to get the tapscript signature budget
+	validationWeightOffset = 50
+)
+
+// VerifyScript checks that scriptSig and witness satisfy scriptPubKey
+// under flags. Failing scripts return an Error.
+func VerifyScript(scriptSig, scriptPubKey []byte, witness [][]byte, flags Flags, checker SignatureChecker) error {
+	e := &engine{flags: flags, checker: checker, sigVersion: SigVersionBase}
+	stk := make(stack, 0)
+	err := e.execute(scriptSig, &stk)
+	if err != nil {
+		return err
+	}
+	var p2shStack stack
+	if flags&VerifyP2SH != 0 {
+		p2shStack = stk.copy()
+	}
+	err = e.execute(scriptPubKey, &stk)
+	if err != nil {
+		return err
+	}
+	if len(stk) == 0 || !castToBool(stk[len(stk)-1]) {
+		return scriptError(ErrEvalFalse, "script finished with a false stack")
+	}
+	hadWitness := false
+	if flags&VerifyWitness != 0 {
+		if version, program, ok := ExtractWitnessProgram(scriptPubKey); ok {
+			hadWitness = true
+			if len(scriptSig) != 0 {
+				return scriptError(ErrWitnessMalleated, "native witness program spent with a non-empty scriptSig")
+			}
+			err = verifyWitnessProgram(witness, version, program, flags, checker, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if flags&VerifyP2SH != 0 && IsPayToScriptHash(scriptPubKey) {
+		if !IsPushOnly(scriptSig) {
+			return scriptError(ErrSigPushOnly, "pay to script hash spent with a scriptSig that isn't push only")
+		}
+		stk = p2shStack
+		// the redeem script can't be missing since the hash check passed
+		redeemScript, _ := stk.pop()
+		err = e.execute(redeemScript, &stk)
+		if err != nil {
+			return err
+		}
+		if len(stk) == 0 || !castToBool(stk[len(stk)-1]) {
+			return scriptError(ErrEvalFalse, "redeem script finished with a false stack")
+		}
+		if flags&VerifyWitness != 0 {
+			if version, program, ok := ExtractWitnessProgram(redeemScript); ok {
+				hadWitness = true
+				if !bytes.Equal(scriptSig, pushData(redeemScript)) {
+					return scriptError(ErrWitnessMalleatedP2SH, "P2SH witness program spent with more than the redeem script")
+				}
+				err = verifyWitnessProgram(witness, version, program, flags, checker, true)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if flags&VerifyWitness != 0 && !hadWitness && len(witness) > 0 {
+		return scriptError(ErrWitnessUnexpected, "witness data for an input that isn't a witness spend")
+	}
+	return nil
+}
+
+func verifyWitnessProgram(witness [][]byte, version int, program []byte, flags Flags, checker SignatureChecker, isP2SH bool) error {
+	stk := make(stack, len(witness))
+	copy(stk, witness)
+	switch {
+	case version == 0 && len(program) == 32:
+		// P2WSH, the last item is the script
+		if len(stk) == 0 {
+			return scriptError(ErrWitnessProgramWitnessEmpty, "P2WSH spent with an empty witness")
+		}
+		witnessScript, _ := stk.pop()
+		hash := sha256.Sum256(witnessScript)
+		if !bytes.Equal(hash[:], program) {
+			return scriptError(ErrWitnessProgramMismatch, "witness script does not match the P2WSH program")
+		}
+		return executeWitnessScript(stk, witnessScript, SigVersionWitnessV0, flags, checker, nil, 0)
+	case version == 0 && len(program) == 20:
+		// P2WPKH runs as the usual pay to pubkey hash script
+		if len(stk) != 2 {
+			return scriptError(ErrWitnessProgramMismatch, fmt.Sprintf("P2WPKH spent with %d witness items instead of 2", len(stk)))
+		}
+		script := make([]byte, 0, 25)
+		script = append(script, OP_DUP, OP_HASH160, 0x14)
+		script = append(script, program...)
+		script = append(script, OP_EQUALVERIFY, OP_CHECKSIG)
+		return executeWitnessScript(stk, script, SigVersionWitnessV0, flags, checker, nil, 0)
+	case version == 0:
+		return scriptError(ErrWitnessProgramWrongLength, fmt.Sprintf("v0 witness program of %d bytes", len(program)))
+	case version == 1 && len(program) == 32 && !isP2SH && flags&VerifyTaproot != 0:
+		return verifyTaproot(witness, stk, program, flags, checker)
+	}
+	// other versions are left for soft forks and anyone can spend them
+	return nil
+}
+
+func verifyTaproot(witness [][]byte, stk stack, program []byte, flags Flags, checker SignatureChecker) error {
+	if len(stk) == 0 {
+		return scriptError(ErrWitnessProgramWitnessEmpty, "taproot output spent with an empty witness")
+	}
+	data := &TaprootData{}
+	if len(stk) >= 2 && len(stk[len(stk)-1]) > 0 && stk[len(stk)-1][0] == annexTag {
+		data.Annex, _ = stk.pop()
+	}
+	if len(stk) == 1 {
+		// key path
+		if !checker.CheckSchnorrSignature(stk[0], program, SigVersionTaproot, data) {
+			return scriptError(ErrSchnorrSig, "invalid taproot key path signature")
+		}
+		return nil
+	}
+	// script path
+	control, _ := stk.pop()
+	script, _ := stk.pop()
+	if len(control) < controlBaseSize || len(control) > controlBaseSize+controlNodeSize*controlMaxNodes || (len(control)-controlBaseSize)%controlNodeSize != 0 {
+		return scriptError(ErrTaprootWrongControlSize, fmt.Sprintf("taproot control block of %d bytes", len(control)))
+	}
+	leafVersion := control[0] & tapLeafMask
+	data.TapLeafHash = tapLeafHash(leafVersion, script)
+	root := data.TapLeafHash
+	for i := controlBaseSize; i < len(control); i += controlNodeSize {
+		var node [32]byte
+		copy(node[:], control[i:i+controlNodeSize])
+		root = tapBranchHash(root, node)
+	}
+	if !checker.CheckTapTweak(program, control[1:controlBaseSize], root, control[0]&1 == 1) {
+		return scriptError(ErrWitnessProgramMismatch, "taproot script path commitment does not match the output key")
+	}
+	if leafVersion != tapLeafVersion {
+		// unknown leaf versions are left for soft forks
+		return nil
+	}
+	// an OP_SUCCESS anywhere makes the spend valid, unless the script
+	// can't be parsed up to it
+	ops, err := parse(script)
+	for _, op := range ops {
+		if isOpSuccess(op.value) {
+			return nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	weight := int64(serializedWitnessSize(witness)) + validationWeightOffset
+	return executeWitnessScript(stk, script, SigVersionTapscript, flags, checker, data, weight)
+}
+
+// executeWitnessScript runs a segwit script, which unlike legacy ones has to
+// leave exactly one true item behind
+func executeWitnessScript(stk stack, script []byte, sigVersion SigVersion, flags Flags, checker SignatureChecker, data *TaprootData, weight int64) error {
+	if sigVersion == SigVersionTapscript && len(stk) > MaxStackSize {
+		return scriptError(ErrStackSize, fmt.Sprintf("witness stack of %d items exceeds max allowed %d", len(stk), MaxStackSize))
+	}
+	for _, item := range stk {
+		if len(item) > MaxScriptElementSize {
+			return scriptError(ErrPushSize, fmt.Sprintf("witness item of %d bytes exceeds max allowed size %d", len(item), MaxScriptElementSize))
+		}
+	}
+	e := &engine{flags: flags, checker: checker, sigVersion: sigVersion, taproot: data, validationWeight: weight}
+	err := e.execute(script, &stk)
+	if err != nil {
+		return err
+	}
+	if len(stk) != 1 {
+		return scriptError(ErrEvalFalse, fmt.Sprintf("witness script left %d items on the stack instead of 1", len(stk)))
+	}
+	if !castToBool(stk[0]) {
+		return scriptError(ErrEvalFalse, "witness script finished with a false stack")
+	}
+	return nil
+}
+
+// serializedWitnessSize is the size of the witness as it is on the wire
+func serializedWitnessSize(witness [][]byte) int {
+	size := compactSizeLen(len(witness))
+	for _, item := range witness {
+		size += compactSizeLen(len(item)) + len(item)
+	}
+	return size
+}
+
+func compactSizeLen(n int) int {
+	switch size := uint64(n); {
+	case size < 0xfd:
+		return 1
+	case size <= 0xffff:
+		return 3
+	case size <= 0xffffffff:
+		return 5
+	default:
+		return 9
+	}
+}
+
+// TaggedHash is the BIP340 hash sha256(sha256(tag) || sha256(tag) || data)
+func TaggedHash(tag string, data ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	var result [32]byte
+	copy(result[:], h.Sum(nil))
+	return result
+}
+
+func tapLeafHash(leafVersion byte, script []byte) [32]byte {
+	var size bytes.Buffer
+	switch {
+	case len(script) < 0xfd:
+		size.WriteByte(byte(len(script)))
+	case len(script) <= 0xffff:
+		size.Write([]byte{0xfd, byte(len(script)), byte(len(script) >> 8)})
+	default:
+		size.Write([]byte{0xfe, byte(len(script)), byte(len(script) >> 8), byte(len(script) >> 16), byte(len(script) >> 24)})
+	}
+	return TaggedHash("TapLeaf", []byte{leafVersion}, size.Bytes(), script)
+}
+
+// tapBranchHash hashes the two children in lexicographic order
+func tapBranchHash(a, b [32]byte) [32]byte {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return TaggedHash("TapBranch", a[:], b[:])
+}
@@ -0,0 +1,177 @@
+// Package secp256k1 verifies the ECDSA and BIP340 Schnorr signatures
+// Bitcoin uses. It only ever sees public data so nothing here tries to be
+// constant time.
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"math/big"
+)
+
+var (
+	// field size
+	P, _ = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	// group order
+	N, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	gx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	gy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+	// HalfN is the highest S a low-S signature can have
+	HalfN = new(big.Int).Rsh(N, 1)
+)
+
+var curveB = big.NewInt(7)
+
+// (p+1)/4, p is 3 mod 4 so this gives square roots
+var sqrtExp = new(big.Int).Rsh(new(big.Int).Add(P, big.NewInt(1)), 2)
+
+// point is in jacobian coordinates, x/z^2 and y/z^3, z = 0 is infinity
+type point struct {
+	x, y, z *big.Int
+}
+
+var generator = &point{gx, gy, big.NewInt(1)}
+
+func infinity() *point {
+	return &point{new(big.Int), new(big.Int), new(big.Int)}
+}
+
+func (p *point) isInfinity() bool {
+	return p.z.Sign() == 0
+}
+
+func mod(x *big.Int) *big.Int {
+	return x.Mod(x, P)
+}
+
+func mul(a, b *big.Int) *big.Int {
+	return mod(new(big.Int).Mul(a, b))
+}
+
+func sub(a, b *big.Int) *big.Int {
+	return mod(new(big.Int).Sub(a, b))
+}
+
+func add(a, b *big.Int) *big.Int {
+	return mod(new(big.Int).Add(a, b))
+}
+
+func (p *point) double() *point {
+	if p.isInfinity() || p.y.Sign() == 0 {
+		return infinity()
+	}
+	a := mul(p.x, p.x)
+	b := mul(p.y, p.y)
+	c := mul(b, b)
+	d := sub(mul(add(p.x, b), add(p.x, b)), add(a, c))
+	d = add(d, d)
+	e := add(add(a, a), a)
+	f := mul(e, e)
+	x := sub(f, add(d, d))
+	eightC := new(big.Int).Lsh(c, 3)
+	y := sub(mul(e, sub(d, x)), mod(eightC))
+	z := mul(add(p.y, p.y), p.z)
+	return &point{x, y, z}
+}
+
+func (p *point) add(q *point) *point {
+	if p.isInfinity() {
+		return q
+	}
+	if q.isInfinity() {
+		return p
+	}
+	z1z1 := mul(p.z, p.z)
+	z2z2 := mul(q.z, q.z)
+	u1 := mul(p.x, z2z2)
+	u2 := mul(q.x, z1z1)
+	s1 := mul(mul(p.y, q.z), z2z2)
+	s2 := mul(mul(q.y, p.z), z1z1)
+	if u1.Cmp(u2) == 0 {
+		if s1.Cmp(s2) == 0 {
+			return p.double()
+		}
+		return infinity()
+	}
+	h := sub(u2, u1)
+	i := mul(add(h, h), add(h, h))
+	j := mul(h, i)
+	r := sub(s2, s1)
+	r = add(r, r)
+	v := mul(u1, i)
+	x := sub(sub(mul(r, r), j), add(v, v))
+	s1j := mul(s1, j)
+	y := sub(mul(r, sub(v, x)), add(s1j, s1j))
+	z := mul(sub(mul(add(p.z, q.z), add(p.z, q.z)), add(z1z1, z2z2)), h)
+	return &point{x, y, z}
+}
+
+func (p *point) affine() (x, y *big.Int) {
+	zInv := new(big.Int).ModInverse(p.z, P)
+	zInv2 := mul(zInv, zInv)
+	return mul(p.x, zInv2), mul(p.y, mul(zInv2, zInv))
+}
+
+// mulAdd returns a*G + b*q, doubling once for both scalars
+func mulAdd(a *big.Int, b *big.Int, q *point) *point {
+	sum := generator.add(q)
+	result := infinity()
+	bits := a.BitLen()
+	if b.BitLen() > bits {
+		bits = b.BitLen()
+	}
+	for i := bits - 1; i >= 0; i-- {
+		result = result.double()
+		switch {
+		case a.Bit(i) == 1 && b.Bit(i) == 1:
+			result = result.add(sum)
+		case a.Bit(i) == 1:
+			result = result.add(generator)
+		case b.Bit(i) == 1:
+			result = result.add(q)
+		}
+	}
+	return result
+}
+
+// liftX returns the point with the given x and an even y
+func liftX(x *big.Int) (*point, bool) {
+	if x.Cmp(P) >= 0 {
+		return nil, false
+	}
+	c := add(mul(mul(x, x), x), curveB)
+	y := new(big.Int).Exp(c, sqrtExp, P)
+	if mul(y, y).Cmp(c) != 0 {
+		return nil, false
+	}
+	if y.Bit(0) == 1 {
+		y.Sub(P, y)
+	}
+	return &point{new(big.Int).Set(x), y, big.NewInt(1)}, true
+}
+
+func isOnCurve(x, y *big.Int) bool {
+	if x.Cmp(P) >= 0 || y.Cmp(P) >= 0 {
+		return false
+	}
+	return mul(y, y).Cmp(add(mul(mul(x, x), x), curveB)) == 0
+}
+
+func taggedHash(tag string, data ...[]byte) [32]byte {
+	tagHash := sha256.Sum256([]byte(tag))
+	h := sha256.New()
+	h.Write(tagHash[:])
+	h.Write(tagHash[:])
+	for _, d := range data {
+		h.Write(d)
+	}
+	var result [32]byte
+	copy(result[:], h.Sum(nil))
+	return result
+}
+
+// bytes32 is x as 32 big endian bytes
+func bytes32(x *big.Int) []byte {
+	var b [32]byte
+	x.FillBytes(b[:])
+	return b[:]
+}
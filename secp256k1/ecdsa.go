@@ -0,0 +1,156 @@
+package secp256k1
+
+import (
+	"errors"
+	"math/big"
+)
+
+// PublicKey is a point on the curve
+type PublicKey struct {
+	X, Y *big.Int
+}
+
+var ErrInvalidPubKey = errors.New("invalid public key")
+
+// ParsePubKey accepts compressed, uncompressed and hybrid keys, the same
+// ones consensus does.
+func ParsePubKey(b []byte) (*PublicKey, error) {
+	switch {
+	case len(b) == 33 && (b[0] == 0x02 || b[0] == 0x03):
+		p, ok := liftX(new(big.Int).SetBytes(b[1:]))
+		if !ok {
+			return nil, ErrInvalidPubKey
+		}
+		if b[0] == 0x03 {
+			p.y.Sub(P, p.y)
+		}
+		return &PublicKey{X: p.x, Y: p.y}, nil
+	case len(b) == 65 && (b[0] == 0x04 || b[0] == 0x06 || b[0] == 0x07):
+		x := new(big.Int).SetBytes(b[1:33])
+		y := new(big.Int).SetBytes(b[33:])
+		if !isOnCurve(x, y) {
+			return nil, ErrInvalidPubKey
+		}
+		// hybrid keys also give the parity of y in the prefix
+		if b[0] != 0x04 && uint(y.Bit(0)) != uint(b[0]&1) {
+			return nil, ErrInvalidPubKey
+		}
+		return &PublicKey{X: x, Y: y}, nil
+	}
+	return nil, ErrInvalidPubKey
+}
+
+// Signature is an ECDSA signature, r or s zero when the encoding
+// overflowed, which then never verifies.
+type Signature struct {
+	R, S *big.Int
+}
+
+// ParseDERSignature is as lenient as OpenSSL was before BIP66, which still
+// matters for old blocks. It fails only where OpenSSL would have.
+func ParseDERSignature(sig []byte) (*Signature, bool) {
+	pos := 0
+	// sequence tag and length, the length is skipped over
+	if pos == len(sig) || sig[pos] != 0x30 {
+		return nil, false
+	}
+	pos++
+	if pos == len(sig) {
+		return nil, false
+	}
+	lenByte := int(sig[pos])
+	pos++
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(sig)-pos {
+			return nil, false
+		}
+		pos += lenByte
+	}
+	r, pos, ok := parseDERInteger(sig, pos)
+	if !ok {
+		return nil, false
+	}
+	s, _, ok := parseDERInteger(sig, pos)
+	if !ok {
+		return nil, false
+	}
+	result := &Signature{R: new(big.Int), S: new(big.Int)}
+	r = trimZeros(r)
+	s = trimZeros(s)
+	if len(r) > 32 || len(s) > 32 {
+		return result, true
+	}
+	result.R.SetBytes(r)
+	result.S.SetBytes(s)
+	if result.R.Cmp(N) >= 0 || result.S.Cmp(N) >= 0 {
+		return &Signature{R: new(big.Int), S: new(big.Int)}, true
+	}
+	return result, true
+}
+
+func parseDERInteger(sig []byte, pos int) ([]byte, int, bool) {
+	if pos == len(sig) || sig[pos] != 0x02 {
+		return nil, 0, false
+	}
+	pos++
+	if pos == len(sig) {
+		return nil, 0, false
+	}
+	lenByte := int(sig[pos])
+	pos++
+	length := lenByte
+	if lenByte&0x80 != 0 {
+		lenByte -= 0x80
+		if lenByte > len(sig)-pos {
+			return nil, 0, false
+		}
+		for lenByte > 0 && sig[pos] == 0 {
+			pos++
+			lenByte--
+		}
+		if lenByte >= 8 {
+			return nil, 0, false
+		}
+		length = 0
+		for lenByte > 0 {
+			length = length<<8 + int(sig[pos])
+			pos++
+			lenByte--
+		}
+	}
+	if length > len(sig)-pos {
+		return nil, 0, false
+	}
+	return sig[pos : pos+length], pos + length, true
+}
+
+func trimZeros(b []byte) []byte {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+// VerifyECDSA checks sig over the 32 byte hash. High S values are
+// accepted like consensus does, the low-S rule is policy.
+func VerifyECDSA(pub *PublicKey, hash []byte, sig *Signature) bool {
+	if sig.R.Sign() <= 0 || sig.S.Sign() <= 0 || sig.R.Cmp(N) >= 0 || sig.S.Cmp(N) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(hash)
+	e.Mod(e, N)
+	w := new(big.Int).ModInverse(sig.S, N)
+	u1 := e.Mul(e, w)
+	u1.Mod(u1, N)
+	u2 := new(big.Int).Mul(sig.R, w)
+	u2.Mod(u2, N)
+	q := &point{pub.X, pub.Y, big.NewInt(1)}
+	result := mulAdd(u1, u2, q)
+	if result.isInfinity() {
+		return false
+	}
+	x, _ := result.affine()
+	x.Mod(x, N)
+	return x.Cmp(sig.R) == 0
+}
@@ -0,0 +1,180 @@
+package secp256k1
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// a signature of testHash by the key at testX, testY. R has its top bit
+// set so DER needs a zero byte in front of it.
+const (
+	testX    = "8bf461599e2d858b36f2b6c13a8e1cfc787cf2dbd0525a4c22fccae24084beb7"
+	testY    = "2b4f515c7a2077b521ee3186939c12925328834df1a0a659b34d11d4bb3c653f"
+	testHash = "bb6408c59dc591439f462000266e82646e65500fab2acf207386387492d566d2"
+	testR    = "d12a5c290d9ca59d2bdebb519fbe8c7444f1df3c4a4c2d06cf36d08a552d3ced"
+	testS    = "2077d4dbd9efb3c7d3b362e509181107027ed89c7265327cbd4bb5ad92699009"
+	// n - testS
+	testHighS = "df882b2426104c382c4c9d1af6e7eef7b830044a3ce36dbf0286a8df3dccb138"
+)
+
+// derInt is an integer with a short length
+func derInt(value string) string {
+	return "02" + hex.EncodeToString([]byte{byte(len(value) / 2)}) + value
+}
+
+// derSeq is a sequence of the integers with a short length
+func derSeq(ints ...string) string {
+	body := ""
+	for _, i := range ints {
+		body += i
+	}
+	return "30" + hex.EncodeToString([]byte{byte(len(body) / 2)}) + body
+}
+
+func TestParseDERSignature(t *testing.T) {
+	pub, err := ParsePubKey(mustHex(t, "03"+testX))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := mustHex(t, testHash)
+	r := derInt("00" + testR)
+	s := derInt(testS)
+	valid := derSeq(r, s)
+
+	tests := []struct {
+		name     string
+		sig      string
+		parses   bool
+		verifies bool
+	}{
+		{"strict DER", valid, true, true},
+		{"high S, consensus doesn't care", derSeq(r, derInt("00"+testHighS)), true, true},
+		{"negative R is read as unsigned", derSeq(derInt(testR), s), true, true},
+		{"R padded with extra zeros", derSeq(derInt("000000"+testR), s), true, true},
+		{"S padded past 33 bytes", derSeq(r, derInt("0000000000"+testS)), true, true},
+		{"long form sequence length", "3081" + valid[2:], true, true},
+		{"sequence length is ignored", "3000" + valid[4:], true, true},
+		{"long form R length", derSeq("028121"+r[4:], s), true, true},
+		{"long form R length with zero padding", derSeq("02830000"+"21"+r[4:], s), true, true},
+		{"garbage at the end", valid + "0102", true, true},
+		{"other S", derSeq(r, derInt("2077d4dbd9efb3c7d3b362e509181107027ed89c7265327cbd4bb5ad9269900a")), true, false},
+		{"R of zero", derSeq(derInt("00"), s), true, false},
+		{"empty R", derSeq("0200", s), true, false},
+		{"R of n overflows to zero", derSeq(derInt("00fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141"), s), true, false},
+		{"R over 32 bytes", derSeq(derInt("01"+testR), s), true, false},
+		{"S of n overflows to zero", derSeq(r, derInt("00fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")), true, false},
+		{"empty", "", false, false},
+		{"no sequence tag", "31" + valid[2:], false, false},
+		{"only the sequence tag", "30", false, false},
+		{"sequence length bytes past the end", "3085", false, false},
+		{"R tag wrong", derSeq("03"+r[2:], s), false, false},
+		{"R past the end", derSeq("0250" + r[4:]), false, false},
+		{"R length taking 8 bytes", derSeq("0288"+"0100000000000021"+r[4:], s), false, false},
+		{"no S", derSeq(r), false, false},
+		{"S past the end", derSeq(r, "0221"+s[4:]), false, false},
+	}
+	for _, test := range tests {
+		sig, ok := ParseDERSignature(mustHex(t, test.sig))
+		if ok != test.parses {
+			t.Errorf("%v: parsed %v, want %v", test.name, ok, test.parses)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if verifies := VerifyECDSA(pub, hash, sig); verifies != test.verifies {
+			t.Errorf("%v: verified %v, want %v", test.name, verifies, test.verifies)
+		}
+	}
+}
+
+func TestVerifyECDSA(t *testing.T) {
+	pub, err := ParsePubKey(mustHex(t, "04"+testX+testY))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := mustHex(t, testHash)
+	r, _ := new(big.Int).SetString(testR, 16)
+	s, _ := new(big.Int).SetString(testS, 16)
+	if !VerifyECDSA(pub, hash, &Signature{R: r, S: s}) {
+		t.Fatal("signature doesn't verify")
+	}
+	highS := new(big.Int).Sub(N, s)
+	if !VerifyECDSA(pub, hash, &Signature{R: r, S: highS}) {
+		t.Error("high S signature doesn't verify")
+	}
+	if highS.Cmp(HalfN) <= 0 {
+		t.Error("n - s isn't above HalfN")
+	}
+
+	otherHash := append([]byte{}, hash...)
+	otherHash[0] ^= 1
+	if VerifyECDSA(pub, otherHash, &Signature{R: r, S: s}) {
+		t.Error("signature verified for another hash")
+	}
+	negated := &PublicKey{X: pub.X, Y: new(big.Int).Sub(P, pub.Y)}
+	if VerifyECDSA(negated, hash, &Signature{R: r, S: s}) {
+		t.Error("signature verified for the negated key")
+	}
+	// r and s are only meaningful mod n
+	rPlusN := new(big.Int).Add(r, N)
+	if VerifyECDSA(pub, hash, &Signature{R: rPlusN, S: s}) {
+		t.Error("r + n verified")
+	}
+	if VerifyECDSA(pub, hash, &Signature{R: r, S: new(big.Int).Neg(s)}) {
+		t.Error("negative s verified")
+	}
+}
+
+func TestParsePubKey(t *testing.T) {
+	x := mustHex(t, testX)
+	y := mustHex(t, testY)
+	// y is odd
+	evenY := bytes32(new(big.Int).Sub(P, new(big.Int).SetBytes(y)))
+	offCurveY := bytes32(new(big.Int).Add(new(big.Int).SetBytes(y), big.NewInt(1)))
+	p := bytes32(P)
+	concat := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name string
+		key  []byte
+		y    []byte
+	}{
+		{"compressed odd", concat([]byte{0x03}, x), y},
+		{"compressed even", concat([]byte{0x02}, x), evenY},
+		{"uncompressed", concat([]byte{0x04}, x, y), y},
+		{"hybrid odd", concat([]byte{0x07}, x, y), y},
+		{"hybrid even", concat([]byte{0x06}, x, evenY), evenY},
+		{"hybrid with the wrong parity", concat([]byte{0x06}, x, y), nil},
+		{"uncompressed off the curve", concat([]byte{0x04}, x, offCurveY), nil},
+		{"uncompressed y of p", concat([]byte{0x04}, x, p), nil},
+		// there's no y with y^2 = 5^3 + 7
+		{"compressed x not on the curve", concat([]byte{0x02}, bytes32(big.NewInt(5))), nil},
+		{"compressed x of p", concat([]byte{0x02}, p), nil},
+		{"compressed with the uncompressed prefix", concat([]byte{0x04}, x), nil},
+		{"uncompressed with the compressed prefix", concat([]byte{0x02}, x, y), nil},
+		{"unknown prefix", concat([]byte{0x05}, x), nil},
+		{"too short", concat([]byte{0x02}, x[1:]), nil},
+		{"empty", nil, nil},
+	}
+	for _, test := range tests {
+		pub, err := ParsePubKey(test.key)
+		if test.y == nil {
+			if err == nil {
+				t.Errorf("%v: parsed", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(bytes32(pub.X), x) || !bytes.Equal(bytes32(pub.Y), test.y) {
+			t.Errorf("%v: got %x %x", test.name, bytes32(pub.X), bytes32(pub.Y))
+		}
+	}
+}
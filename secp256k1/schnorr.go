@@ -0,0 +1,59 @@
+package secp256k1
+
+import (
+	"bytes"
+	"math/big"
+)
+
+// VerifySchnorr checks a 64 byte BIP340 signature of msg by the x-only
+// public key pubKey.
+func VerifySchnorr(pubKey, msg, sig []byte) bool {
+	if len(pubKey) != 32 || len(sig) != 64 {
+		return false
+	}
+	p, ok := liftX(new(big.Int).SetBytes(pubKey))
+	if !ok {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	if r.Cmp(P) >= 0 {
+		return false
+	}
+	s := new(big.Int).SetBytes(sig[32:])
+	if s.Cmp(N) >= 0 {
+		return false
+	}
+	challenge := taggedHash("BIP0340/challenge", sig[:32], pubKey, msg)
+	e := new(big.Int).SetBytes(challenge[:])
+	e.Mod(e, N)
+	// R = s*G - e*P
+	e.Sub(N, e)
+	result := mulAdd(s, e, p)
+	if result.isInfinity() {
+		return false
+	}
+	x, y := result.affine()
+	return y.Bit(0) == 0 && x.Cmp(r) == 0
+}
+
+// CheckTweakAdd tells whether outputKey is the x-only internalKey plus
+// tweak*G, with y parity odd if parity is set.
+func CheckTweakAdd(outputKey, internalKey []byte, tweak [32]byte, parity bool) bool {
+	if len(outputKey) != 32 || len(internalKey) != 32 {
+		return false
+	}
+	p, ok := liftX(new(big.Int).SetBytes(internalKey))
+	if !ok {
+		return false
+	}
+	t := new(big.Int).SetBytes(tweak[:])
+	if t.Cmp(N) >= 0 {
+		return false
+	}
+	result := mulAdd(t, big.NewInt(1), p)
+	if result.isInfinity() {
+		return false
+	}
+	x, y := result.affine()
+	return bytes.Equal(bytes32(x), outputKey) && (y.Bit(0) == 1) == parity
+}
@@ -0,0 +1,103 @@
+package secp256k1
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// testdata/bip340-vectors.csv is test-vectors.csv from BIP340
+func TestBIP340Vectors(t *testing.T) {
+	file, err := os.Open("testdata/bip340-vectors.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows[1:] {
+		index, secKey, comment := row[0], row[1], row[7]
+		pubKey := mustHex(t, row[2])
+		msg := mustHex(t, row[4])
+		sig := mustHex(t, row[5])
+		want := row[6] == "TRUE"
+		if got := VerifySchnorr(pubKey, msg, sig); got != want {
+			t.Errorf("vector %v: got %v, want %v %v", index, got, want, comment)
+		}
+		// signing vectors also give the secret key, which has to lead to
+		// the same public key
+		if secKey != "" {
+			d := new(big.Int).SetBytes(mustHex(t, secKey))
+			x, _ := mulAdd(d, new(big.Int), generator).affine()
+			if !bytes.Equal(bytes32(x), pubKey) {
+				t.Errorf("vector %v: public key %x, want %x", index, bytes32(x), pubKey)
+			}
+		}
+	}
+}
+
+func TestVerifySchnorrSizes(t *testing.T) {
+	// vector 1
+	pubKey := mustHex(t, "dff1d77f2a671c5f36183726db2341be58feae1da2deced843240f7b502ba659")
+	msg := mustHex(t, "243f6a8885a308d313198a2e03707344a4093822299f31d0082efa98ec4e6c89")
+	sig := mustHex(t, "6896bd60eeae296db48a229ff71dfe071bde413e6d43f917dc8dcf8c78de33418906d11ac976abccb20b091292bff4ea897efcb639ea871cfa95f6de339e4b0a")
+	if !VerifySchnorr(pubKey, msg, sig) {
+		t.Fatal("vector 1 doesn't verify")
+	}
+	if VerifySchnorr(pubKey, msg, append(sig, 0x01)) {
+		t.Error("65 byte signature verified, the hash type is for the caller to strip")
+	}
+	if VerifySchnorr(pubKey, msg, sig[:63]) {
+		t.Error("63 byte signature verified")
+	}
+	if VerifySchnorr(append([]byte{0x02}, pubKey...), msg, sig) {
+		t.Error("33 byte public key verified")
+	}
+}
+
+func TestCheckTweakAdd(t *testing.T) {
+	internal := mustHex(t, "6d2a214e498535282b11a86d721a3f03cd6d7d6210232e3273458f53d34a22ca")
+	tests := []struct {
+		tweak, output string
+		parity        bool
+	}{
+		{"8da746f0bec35136938c0781dcc8897d40e4c585dd5bd82c6c98809a215bec1e", "7287def20dec88e3e80c012970d93ada40ef363965f37a5a04047005ce72a170", true},
+		{"ea59e8ccc1a054b6f0fc997f94cc2ffcfd2d86b1f0ea6a550b0e955ebcaba6e0", "57f6e758f793ff21918ac928b67653f1da91f820cb05e7263ace0efb3e8ad408", false},
+	}
+	for _, test := range tests {
+		var tweak [32]byte
+		copy(tweak[:], mustHex(t, test.tweak))
+		output := mustHex(t, test.output)
+		if !CheckTweakAdd(output, internal, tweak, test.parity) {
+			t.Errorf("tweak %v: doesn't give %v", test.tweak, test.output)
+		}
+		if CheckTweakAdd(output, internal, tweak, !test.parity) {
+			t.Errorf("tweak %v: passed with the wrong parity", test.tweak)
+		}
+		tweak[31] ^= 1
+		if CheckTweakAdd(output, internal, tweak, test.parity) {
+			t.Errorf("tweak %v: passed with a different tweak", test.tweak)
+		}
+	}
+
+	// tweaks have to be below the group order
+	var tweak [32]byte
+	N.FillBytes(tweak[:])
+	if CheckTweakAdd(internal, internal, tweak, false) {
+		t.Error("a tweak of n passed")
+	}
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,178 @@
+package wire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+)
+
+// MaxAddrPerMsg is the most addresses a single addr message may carry.
+const MaxAddrPerMsg = 1000
+
+type NetAddr struct {
+	Timestamp uint32 // not sent inside version messages
+	Services  uint64
+	Address   net.IP
+	Port      uint16
+}
+
+type AddrMsg struct {
+	AddrList []*NetAddr
+}
+
+func (a *AddrMsg) Command() string {
+	return CmdAddr
+}
+
+func (a *AddrMsg) Encode(w io.Writer) error {
+	err := writeVarInt(w, len(a.AddrList))
+	if err != nil {
+		return err
+	}
+	for _, addr := range a.AddrList {
+		err = writeNetaddress(w, addr, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AddrMsg) Decode(r io.Reader) error {
+	count, err := readCount(r, MaxAddrPerMsg, "addresses")
+	if err != nil {
+		return err
+	}
+	a.AddrList = make([]*NetAddr, 0, count)
+	for i := 0; i < count; i++ {
+		addr := &NetAddr{}
+		err = readNetAddress(r, addr, true)
+		if err != nil {
+			return err
+		}
+		a.AddrList = append(a.AddrList, addr)
+	}
+	return nil
+}
+
+// BIP155 network ids, the only ones we can reach
+const (
+	addrV2IPv4 = 1
+	addrV2IPv6 = 2
+	// longer than any network's addresses
+	maxAddrV2Size = 512
+)
+
+// AddrV2Msg is addr for peers that sent sendaddrv2, BIP155. Addresses on
+// networks other than IPv4 and IPv6 are dropped while decoding since we
+// couldn't connect to them anyway.
+type AddrV2Msg struct {
+	AddrList []*NetAddr
+}
+
+func (a *AddrV2Msg) Command() string {
+	return CmdAddrV2
+}
+
+func (a *AddrV2Msg) Encode(w io.Writer) error {
+	err := writeVarInt(w, len(a.AddrList))
+	if err != nil {
+		return err
+	}
+	for _, addr := range a.AddrList {
+		network, ip := uint8(addrV2IPv6), []byte(addr.Address.To16())
+		if ip4 := addr.Address.To4(); ip4 != nil {
+			network, ip = addrV2IPv4, ip4
+		}
+		err = writeElement(w, addr.Timestamp)
+		if err != nil {
+			return err
+		}
+		err = writeVarInt(w, int(addr.Services))
+		if err != nil {
+			return err
+		}
+		err = writeElement(w, network)
+		if err != nil {
+			return err
+		}
+		err = writeVarBytes(w, ip)
+		if err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.BigEndian, addr.Port)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *AddrV2Msg) Decode(r io.Reader) error {
+	count, err := readCount(r, MaxAddrPerMsg, "addresses")
+	if err != nil {
+		return err
+	}
+	a.AddrList = make([]*NetAddr, 0, count)
+	for i := 0; i < count; i++ {
+		addr := &NetAddr{}
+		err = readElement(r, &addr.Timestamp)
+		if err != nil {
+			return err
+		}
+		addr.Services, err = readVarInt(r)
+		if err != nil {
+			return err
+		}
+		var network uint8
+		err = readElement(r, &network)
+		if err != nil {
+			return err
+		}
+		ip, err := readVarBytes(r, maxAddrV2Size, "address bytes")
+		if err != nil {
+			return err
+		}
+		var port [2]byte
+		_, err = io.ReadFull(r, port[:])
+		if err != nil {
+			return err
+		}
+		addr.Port = binary.BigEndian.Uint16(port[:])
+		switch network {
+		case addrV2IPv4:
+			if len(ip) != net.IPv4len {
+				return fmt.Errorf("wire: %v byte IPv4 address", len(ip))
+			}
+		case addrV2IPv6:
+			if len(ip) != net.IPv6len {
+				return fmt.Errorf("wire: %v byte IPv6 address", len(ip))
+			}
+			// IPv4 has its own id, BIP155 says to ignore it embedded
+			if net.IP(ip).To4() != nil {
+				continue
+			}
+		default:
+			continue
+		}
+		// the same form addr messages use
+		addr.Address = net.IP(ip).To16()
+		a.AddrList = append(a.AddrList, addr)
+	}
+	return nil
+}
+
+type GetAddrMsg struct{}
+
+func (g *GetAddrMsg) Command() string {
+	return CmdGetAddr
+}
+
+func (g *GetAddrMsg) Encode(w io.Writer) error {
+	return nil
+}
+
+func (g *GetAddrMsg) Decode(r io.Reader) error {
+	return nil
+}
@@ -0,0 +1,142 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+)
+
+// MaxBlockPayload is the largest serialized block allowed by the weight
+// limit, so nothing inside a block can be bigger than this.
+const MaxBlockPayload = 4000000
+
+// MaxBlockHeadersPerMsg is the most headers a single headers message may carry.
+const MaxBlockHeadersPerMsg = 2000
+
+// BlockHeaderSize is the size of a serialized header without the tx count.
+const BlockHeaderSize = 80
+
+type BlockHeader struct {
+	Version    int32
+	PrevBlock  [32]byte
+	MerkleRoot [32]byte
+	Timestamp  uint32
+	Bits       uint32
+	Nonce      uint32
+}
+
+func (h *BlockHeader) Encode(w io.Writer) error {
+	return writeElements(w, h.Version, h.PrevBlock, h.MerkleRoot, h.Timestamp, h.Bits, h.Nonce)
+}
+
+func (h *BlockHeader) Decode(r io.Reader) error {
+	return readElements(r, &h.Version, &h.PrevBlock, &h.MerkleRoot, &h.Timestamp, &h.Bits, &h.Nonce)
+}
+
+func (h *BlockHeader) BlockHash() [32]byte {
+	var headerBuff bytes.Buffer
+	h.Encode(&headerBuff)
+	single := sha256.Sum256(headerBuff.Bytes())
+	return sha256.Sum256(single[:])
+}
+
+type HeadersMsg struct {
+	Headers []*BlockHeader
+}
+
+func (h *HeadersMsg) Command() string {
+	return CmdHeaders
+}
+
+func (h *HeadersMsg) Encode(w io.Writer) error {
+	err := writeVarInt(w, len(h.Headers))
+	if err != nil {
+		return err
+	}
+	for _, header := range h.Headers {
+		err = header.Encode(w)
+		if err != nil {
+			return err
+		}
+		// headers always claim zero transactions
+		err = writeVarInt(w, 0)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *HeadersMsg) Decode(r io.Reader) error {
+	count, err := readCount(r, MaxBlockHeadersPerMsg, "headers")
+	if err != nil {
+		return err
+	}
+	h.Headers = make([]*BlockHeader, 0, count)
+	for i := 0; i < count; i++ {
+		header := &BlockHeader{}
+		err = header.Decode(r)
+		if err != nil {
+			return err
+		}
+		txCount, err := readVarInt(r)
+		if err != nil {
+			return err
+		}
+		if txCount != 0 {
+			return errors.New("wire: header with transactions")
+		}
+		h.Headers = append(h.Headers, header)
+	}
+	return nil
+}
+
+type BlockMsg struct {
+	Header       BlockHeader
+	Transactions []*TxMsg
+}
+
+func (b *BlockMsg) Command() string {
+	return CmdBlock
+}
+
+func (b *BlockMsg) Encode(w io.Writer) error {
+	err := b.Header.Encode(w)
+	if err != nil {
+		return err
+	}
+	err = writeVarInt(w, len(b.Transactions))
+	if err != nil {
+		return err
+	}
+	for _, tx := range b.Transactions {
+		err = tx.Encode(w)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *BlockMsg) Decode(r io.Reader) error {
+	err := b.Header.Decode(r)
+	if err != nil {
+		return err
+	}
+	// the smallest possible transaction is 10 bytes
+	count, err := readCount(r, MaxBlockPayload/10, "transactions")
+	if err != nil {
+		return err
+	}
+	b.Transactions = make([]*TxMsg, 0, count)
+	for i := 0; i < count; i++ {
+		tx := &TxMsg{}
+		err = tx.Decode(r)
+		if err != nil {
+			return err
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+	return nil
+}
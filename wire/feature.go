@@ -0,0 +1,78 @@
+package wire
+
+import "io"
+
+// service bits a node sets in its version message
+const (
+	SFNodeNetwork        uint64 = 1 << 0
+	SFNodeBloom          uint64 = 1 << 2
+	SFNodeWitness        uint64 = 1 << 3
+	SFNodeCompactFilters uint64 = 1 << 6
+	SFNodeNetworkLimited uint64 = 1 << 10
+)
+
+// SendHeadersMsg asks for new blocks to be announced with headers instead
+// of inv, BIP130.
+type SendHeadersMsg struct{}
+
+func (s *SendHeadersMsg) Command() string {
+	return CmdSendHeaders
+}
+
+func (s *SendHeadersMsg) Encode(w io.Writer) error {
+	return nil
+}
+
+func (s *SendHeadersMsg) Decode(r io.Reader) error {
+	return nil
+}
+
+// WtxidRelayMsg announces transactions by wtxid, BIP339. It has to come
+// between version and verack.
+type WtxidRelayMsg struct{}
+
+func (m *WtxidRelayMsg) Command() string {
+	return CmdWtxidRelay
+}
+
+func (m *WtxidRelayMsg) Encode(w io.Writer) error {
+	return nil
+}
+
+func (m *WtxidRelayMsg) Decode(r io.Reader) error {
+	return nil
+}
+
+// SendAddrV2Msg asks for addresses in addrv2 messages, BIP155. It has to
+// come between version and verack.
+type SendAddrV2Msg struct{}
+
+func (m *SendAddrV2Msg) Command() string {
+	return CmdSendAddrV2
+}
+
+func (m *SendAddrV2Msg) Encode(w io.Writer) error {
+	return nil
+}
+
+func (m *SendAddrV2Msg) Decode(r io.Reader) error {
+	return nil
+}
+
+// FeeFilterMsg asks not to be sent transactions paying less than MinFee
+// satoshis per kilobyte, BIP133.
+type FeeFilterMsg struct {
+	MinFee int64
+}
+
+func (f *FeeFilterMsg) Command() string {
+	return CmdFeeFilter
+}
+
+func (f *FeeFilterMsg) Encode(w io.Writer) error {
+	return writeElement(w, f.MinFee)
+}
+
+func (f *FeeFilterMsg) Decode(r io.Reader) error {
+	return readElement(r, &f.MinFee)
+}
@@ -0,0 +1,108 @@
+package wire
+
+import "io"
+
+// MaxInvPerMsg is the most inventory vectors a single message may carry.
+const MaxInvPerMsg = 50000
+
+const (
+	InvTypeError         uint32 = 0
+	InvTypeTx            uint32 = 1
+	InvTypeBlock         uint32 = 2
+	InvTypeFilteredBlock uint32 = 3
+	InvTypeCmpctBlock    uint32 = 4
+	InvTypeWTx           uint32 = 5
+	InvWitnessFlag       uint32 = 1 << 30
+	InvTypeWitnessTx            = InvTypeTx | InvWitnessFlag
+	InvTypeWitnessBlock         = InvTypeBlock | InvWitnessFlag
+)
+
+type InvVect struct {
+	Type uint32
+	Hash [32]byte
+}
+
+func writeInvList(w io.Writer, invList []*InvVect) error {
+	err := writeVarInt(w, len(invList))
+	if err != nil {
+		return err
+	}
+	for _, inv := range invList {
+		err = writeElements(w, inv.Type, inv.Hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readInvList(r io.Reader) ([]*InvVect, error) {
+	count, err := readCount(r, MaxInvPerMsg, "inventory vectors")
+	if err != nil {
+		return nil, err
+	}
+	invList := make([]*InvVect, 0, count)
+	for i := 0; i < count; i++ {
+		inv := &InvVect{}
+		err = readElements(r, &inv.Type, &inv.Hash)
+		if err != nil {
+			return nil, err
+		}
+		invList = append(invList, inv)
+	}
+	return invList, nil
+}
+
+type InvMsg struct {
+	InvList []*InvVect
+}
+
+func (i *InvMsg) Command() string {
+	return CmdInv
+}
+
+func (i *InvMsg) Encode(w io.Writer) error {
+	return writeInvList(w, i.InvList)
+}
+
+func (i *InvMsg) Decode(r io.Reader) error {
+	var err error
+	i.InvList, err = readInvList(r)
+	return err
+}
+
+type GetDataMsg struct {
+	InvList []*InvVect
+}
+
+func (g *GetDataMsg) Command() string {
+	return CmdGetData
+}
+
+func (g *GetDataMsg) Encode(w io.Writer) error {
+	return writeInvList(w, g.InvList)
+}
+
+func (g *GetDataMsg) Decode(r io.Reader) error {
+	var err error
+	g.InvList, err = readInvList(r)
+	return err
+}
+
+type NotFoundMsg struct {
+	InvList []*InvVect
+}
+
+func (n *NotFoundMsg) Command() string {
+	return CmdNotFound
+}
+
+func (n *NotFoundMsg) Encode(w io.Writer) error {
+	return writeInvList(w, n.InvList)
+}
+
+func (n *NotFoundMsg) Decode(r io.Reader) error {
+	var err error
+	n.InvList, err = readInvList(r)
+	return err
+}
@@ -0,0 +1,83 @@
+package wire
+
+import "io"
+
+// MaxBlockLocatorsPerMsg is the most locator hashes bitcoind will accept.
+const MaxBlockLocatorsPerMsg = 101
+
+func writeLocator(w io.Writer, version int32, locator [][32]byte, hashStop [32]byte) error {
+	err := writeElement(w, version)
+	if err != nil {
+		return err
+	}
+	err = writeVarInt(w, len(locator))
+	if err != nil {
+		return err
+	}
+	for _, hash := range locator {
+		err = writeElement(w, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return writeElement(w, hashStop)
+}
+
+func readLocator(r io.Reader, version *int32, hashStop *[32]byte) ([][32]byte, error) {
+	err := readElement(r, version)
+	if err != nil {
+		return nil, err
+	}
+	count, err := readCount(r, MaxBlockLocatorsPerMsg, "locator hashes")
+	if err != nil {
+		return nil, err
+	}
+	locator := make([][32]byte, count)
+	for i := range locator {
+		err = readElement(r, &locator[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return locator, readElement(r, hashStop)
+}
+
+type GetHeadersMsg struct {
+	ProtocolVersion    int32
+	BlockLocatorHashes [][32]byte
+	HashStop           [32]byte
+}
+
+func (g *GetHeadersMsg) Command() string {
+	return CmdGetHeaders
+}
+
+func (g *GetHeadersMsg) Encode(w io.Writer) error {
+	return writeLocator(w, g.ProtocolVersion, g.BlockLocatorHashes, g.HashStop)
+}
+
+func (g *GetHeadersMsg) Decode(r io.Reader) error {
+	var err error
+	g.BlockLocatorHashes, err = readLocator(r, &g.ProtocolVersion, &g.HashStop)
+	return err
+}
+
+type GetBlocksMsg struct {
+	ProtocolVersion    int32
+	BlockLocatorHashes [][32]byte
+	HashStop           [32]byte
+}
+
+func (g *GetBlocksMsg) Command() string {
+	return CmdGetBlocks
+}
+
+func (g *GetBlocksMsg) Encode(w io.Writer) error {
+	return writeLocator(w, g.ProtocolVersion, g.BlockLocatorHashes, g.HashStop)
+}
+
+func (g *GetBlocksMsg) Decode(r io.Reader) error {
+	var err error
+	g.BlockLocatorHashes, err = readLocator(r, &g.ProtocolVersion, &g.HashStop)
+	return err
+}
@@ -0,0 +1,219 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// magic values that start every message, one per network
+const (
+	MainNet  uint32 = 0xD9B4BEF9
+	TestNet3 uint32 = 0x0709110B
+	TestNet4 uint32 = 0x283F161C
+	SigNet   uint32 = 0x40CF030A
+	RegTest  uint32 = 0xDAB5BFFA
+)
+
+// MessageHeaderSize is the size of magic, command, length and checksum.
+const MessageHeaderSize = 24
+
+// CommandSize is the fixed, null padded length of a command.
+const CommandSize = 12
+
+// MaxPayloadLength is the largest payload we are willing to read, same as
+// MAX_SIZE in bitcoind.
+const MaxPayloadLength = 32 * 1024 * 1024
+
+var (
+	ErrInvalidMagic    = errors.New("wire: invalid network magic")
+	ErrInvalidCommand  = errors.New("wire: malformed command")
+	ErrPayloadTooLarge = errors.New("wire: payload exceeds maximum length")
+	ErrInvalidChecksum = errors.New("wire: payload checksum mismatch")
+	ErrUnknownCommand  = errors.New("wire: unknown command")
+)
+
+const (
+	CmdVersion     = "version"
+	CmdVerack      = "verack"
+	CmdPing        = "ping"
+	CmdPong        = "pong"
+	CmdAddr        = "addr"
+	CmdAddrV2      = "addrv2"
+	CmdGetAddr     = "getaddr"
+	CmdInv         = "inv"
+	CmdGetData     = "getdata"
+	CmdNotFound    = "notfound"
+	CmdGetHeaders  = "getheaders"
+	CmdGetBlocks   = "getblocks"
+	CmdHeaders     = "headers"
+	CmdBlock       = "block"
+	CmdTx          = "tx"
+	CmdMempool     = "mempool"
+	CmdReject      = "reject"
+	CmdSendHeaders = "sendheaders"
+	CmdWtxidRelay  = "wtxidrelay"
+	CmdSendAddrV2  = "sendaddrv2"
+	CmdFeeFilter   = "feefilter"
+)
+
+// Message is a bitcoin p2p message payload that knows its own command.
+type Message interface {
+	Command() string
+	Encode(w io.Writer) error
+	Decode(r io.Reader) error
+}
+
+type MessageHeader struct {
+	Magic    uint32
+	Command  string
+	Length   uint32
+	Checksum [4]byte
+}
+
+// ReadMessage reads exactly one message from r. Only ErrInvalidChecksum
+// leaves the stream at a message boundary, every other error means the
+// caller has lost framing and should drop the connection.
+func ReadMessage(r io.Reader, magic uint32) (*MessageHeader, []byte, error) {
+	var headerBytes [MessageHeaderSize]byte
+	_, err := io.ReadFull(r, headerBytes[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	header := &MessageHeader{}
+	header.Magic = binary.LittleEndian.Uint32(headerBytes[:4])
+	if header.Magic != magic {
+		return nil, nil, ErrInvalidMagic
+	}
+	command, err := parseCommand(headerBytes[4 : 4+CommandSize])
+	if err != nil {
+		return nil, nil, err
+	}
+	header.Command = command
+	header.Length = binary.LittleEndian.Uint32(headerBytes[16:20])
+	if header.Length > MaxPayloadLength {
+		return nil, nil, ErrPayloadTooLarge
+	}
+	copy(header.Checksum[:], headerBytes[20:24])
+	// the buffer grows as the payload arrives rather than trusting the
+	// length a peer claims up front
+	var buf bytes.Buffer
+	_, err = io.CopyN(&buf, r, int64(header.Length))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	payload := buf.Bytes()
+	if checksum(payload) != header.Checksum {
+		return header, nil, ErrInvalidChecksum
+	}
+	return header, payload, nil
+}
+
+// WriteMessage encodes msg and writes it to w with a full message header.
+func WriteMessage(w io.Writer, msg Message, magic uint32) error {
+	var payloadBuffer bytes.Buffer
+	err := msg.Encode(&payloadBuffer)
+	if err != nil {
+		return err
+	}
+	return writeMsg(w, magic, msg.Command(), payloadBuffer.Bytes())
+}
+
+// DecodeMessage turns a payload returned by ReadMessage into its message
+// struct. Commands we don't know about return ErrUnknownCommand.
+func DecodeMessage(header *MessageHeader, payload []byte) (Message, error) {
+	msg, err := makeEmptyMessage(header.Command)
+	if err != nil {
+		return nil, err
+	}
+	err = msg.Decode(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("wire: failed to decode %v: %w", header.Command, err)
+	}
+	return msg, nil
+}
+
+func makeEmptyMessage(command string) (Message, error) {
+	switch command {
+	case CmdVersion:
+		return &VersionMsg{}, nil
+	case CmdVerack:
+		return &VerackMsg{}, nil
+	case CmdPing:
+		return &PingMsg{}, nil
+	case CmdPong:
+		return &PongMsg{}, nil
+	case CmdAddr:
+		return &AddrMsg{}, nil
+	case CmdAddrV2:
+		return &AddrV2Msg{}, nil
+	case CmdGetAddr:
+		return &GetAddrMsg{}, nil
+	case CmdInv:
+		return &InvMsg{}, nil
+	case CmdGetData:
+		return &GetDataMsg{}, nil
+	case CmdNotFound:
+		return &NotFoundMsg{}, nil
+	case CmdGetHeaders:
+		return &GetHeadersMsg{}, nil
+	case CmdGetBlocks:
+		return &GetBlocksMsg{}, nil
+	case CmdHeaders:
+		return &HeadersMsg{}, nil
+	case CmdBlock:
+		return &BlockMsg{}, nil
+	case CmdTx:
+		return &TxMsg{}, nil
+	case CmdMempool:
+		return &MempoolMsg{}, nil
+	case CmdReject:
+		return &RejectMsg{}, nil
+	case CmdSendHeaders:
+		return &SendHeadersMsg{}, nil
+	case CmdWtxidRelay:
+		return &WtxidRelayMsg{}, nil
+	case CmdSendAddrV2:
+		return &SendAddrV2Msg{}, nil
+	case CmdFeeFilter:
+		return &FeeFilterMsg{}, nil
+	}
+	return nil, ErrUnknownCommand
+}
+
+// a command is printable ascii followed only by null padding, it can't be
+// all padding
+func parseCommand(raw []byte) (string, error) {
+	end := bytes.IndexByte(raw, 0x00)
+	if end == -1 {
+		end = len(raw)
+	}
+	if end == 0 {
+		return "", ErrInvalidCommand
+	}
+	for _, b := range raw[end:] {
+		if b != 0x00 {
+			return "", ErrInvalidCommand
+		}
+	}
+	for _, b := range raw[:end] {
+		if b < 0x20 || b > 0x7e {
+			return "", ErrInvalidCommand
+		}
+	}
+	return string(raw[:end]), nil
+}
+
+func checksum(payload []byte) [4]byte {
+	var sum [4]byte
+	singleHash := sha256.Sum256(payload)
+	doubleHash := sha256.Sum256(singleHash[:])
+	copy(sum[:], doubleHash[:4])
+	return sum
+}
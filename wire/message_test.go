@@ -0,0 +1,170 @@
+package wire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// frame is a raw message with whatever header fields we like
+func frame(magic uint32, command []byte, length uint32, sum [4]byte, payload []byte) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, magic)
+	var cmd [CommandSize]byte
+	copy(cmd[:], command)
+	buf.Write(cmd[:])
+	binary.Write(&buf, binary.LittleEndian, length)
+	buf.Write(sum[:])
+	buf.Write(payload)
+	return buf.Bytes()
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	payload := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	good := frame(RegTest, []byte(CmdPing), 8, checksum(payload), payload)
+	badSum := checksum(payload)
+	badSum[0] ^= 1
+
+	tests := []struct {
+		name string
+		raw  []byte
+		err  error
+	}{
+		{"nothing", nil, io.EOF},
+		{"short header", good[:MessageHeaderSize-1], io.ErrUnexpectedEOF},
+		{"short payload", good[:len(good)-1], io.ErrUnexpectedEOF},
+		{"no payload", good[:MessageHeaderSize], io.ErrUnexpectedEOF},
+		{"other network", frame(MainNet, []byte(CmdPing), 8, checksum(payload), payload), ErrInvalidMagic},
+		{"empty command", frame(RegTest, nil, 8, checksum(payload), payload), ErrInvalidCommand},
+		{"unprintable command", frame(RegTest, []byte("pi\x01g"), 8, checksum(payload), payload), ErrInvalidCommand},
+		{"junk after padding", frame(RegTest, []byte("ping\x00x"), 8, checksum(payload), payload), ErrInvalidCommand},
+		{"padding first", frame(RegTest, []byte("\x00ping"), 8, checksum(payload), payload), ErrInvalidCommand},
+		{"too long", frame(RegTest, []byte(CmdBlock), MaxPayloadLength+1, [4]byte{}, nil), ErrPayloadTooLarge},
+		{"bad checksum", frame(RegTest, []byte(CmdPing), 8, badSum, payload), ErrInvalidChecksum},
+	}
+	for _, test := range tests {
+		_, _, err := ReadMessage(bytes.NewReader(test.raw), RegTest)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: got %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	// a full command without padding is fine
+	header, _, err := ReadMessage(bytes.NewReader(frame(RegTest, []byte("abcdefghijkl"), 0, checksum(nil), nil)), RegTest)
+	if err != nil || header.Command != "abcdefghijkl" {
+		t.Errorf("12 byte command: %v", err)
+	}
+	if _, err := DecodeMessage(header, nil); !errors.Is(err, ErrUnknownCommand) {
+		t.Errorf("unknown command decoded: %v", err)
+	}
+
+	// the checksum is only known to be wrong once the payload is read, so
+	// the next message can still be read
+	r := bytes.NewReader(append(frame(RegTest, []byte(CmdPing), 8, badSum, payload), good...))
+	if _, _, err := ReadMessage(r, RegTest); !errors.Is(err, ErrInvalidChecksum) {
+		t.Fatalf("got %v, want %v", err, ErrInvalidChecksum)
+	}
+	header, got, err := ReadMessage(r, RegTest)
+	if err != nil || header.Command != CmdPing || !bytes.Equal(got, payload) {
+		t.Errorf("message after a bad checksum: %v", err)
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	addr := &NetAddr{Timestamp: 1700000000, Services: 1033, Address: net.ParseIP("203.0.113.7"), Port: 8333}
+	addr6 := &NetAddr{Timestamp: 1700000001, Services: 9, Address: net.ParseIP("2001:db8::1"), Port: 18444}
+	header := BlockHeader{Version: 0x20000000, PrevBlock: [32]byte{1}, MerkleRoot: [32]byte{2}, Timestamp: 1700000000, Bits: 0x207fffff, Nonce: 42}
+	legacyTx := &TxMsg{
+		Version:  1,
+		TxIn:     []*TxIn{{PreviousOutPoint: OutPoint{Hash: [32]byte{3}, Index: 1}, SignatureScript: []byte{0x51}, Sequence: 0xfffffffe}},
+		TxOut:    []*TxOut{{Value: 5000000000, PkScript: []byte{0x76, 0xa9}}, {Value: 1, PkScript: []byte{0x6a}}},
+		LockTime: 100,
+	}
+	witnessTx := &TxMsg{
+		Version: 2,
+		TxIn: []*TxIn{
+			{PreviousOutPoint: OutPoint{Hash: [32]byte{4}}, SignatureScript: []byte{}, Witness: [][]byte{{0x30, 0x44}, {0x02}}, Sequence: 0xffffffff},
+			{PreviousOutPoint: OutPoint{Hash: [32]byte{5}, Index: 7}, SignatureScript: []byte{0x00}, Witness: [][]byte{{}}, Sequence: 0},
+		},
+		TxOut: []*TxOut{{Value: 1000, PkScript: []byte{0x00, 0x14}}},
+	}
+	inv := []*InvVect{{Type: InvTypeBlock, Hash: [32]byte{6}}, {Type: InvTypeWitnessBlock, Hash: [32]byte{7}}}
+	locator := [][32]byte{{8}, {9}}
+
+	msgs := []Message{
+		&VersionMsg{
+			Version:      70016,
+			Services:     1033,
+			Timestamp:    1700000000,
+			Addr_recv:    NetAddr{Services: 1, Address: net.ParseIP("198.51.100.1"), Port: 8333},
+			Addr_from:    NetAddr{Address: net.ParseIP("::"), Port: 0},
+			Nonce:        0x0123456789abcdef,
+			User_agent:   "/goldchain:0.1.0/",
+			Start_height: 800000,
+			Relay:        false,
+		},
+		&VerackMsg{},
+		&PingMsg{Nonce: 77},
+		&PongMsg{Nonce: 78},
+		&AddrMsg{AddrList: []*NetAddr{addr, addr6}},
+		&AddrV2Msg{AddrList: []*NetAddr{addr, addr6}},
+		&GetAddrMsg{},
+		&InvMsg{InvList: inv},
+		&GetDataMsg{InvList: inv},
+		&NotFoundMsg{InvList: inv},
+		&GetHeadersMsg{ProtocolVersion: 70016, BlockLocatorHashes: locator, HashStop: [32]byte{10}},
+		&GetBlocksMsg{ProtocolVersion: 70016, BlockLocatorHashes: locator},
+		&HeadersMsg{Headers: []*BlockHeader{&header, {Version: 1}}},
+		&BlockMsg{Header: header, Transactions: []*TxMsg{legacyTx, witnessTx}},
+		legacyTx,
+		witnessTx,
+		&MempoolMsg{},
+		&RejectMsg{Cmd: CmdTx, Code: RejectInsufficientFee, Reason: "min relay fee not met", Hash: [32]byte{11}},
+		&RejectMsg{Cmd: CmdVersion, Code: RejectObsolete, Reason: "too old"},
+		&SendHeadersMsg{},
+		&WtxidRelayMsg{},
+		&SendAddrV2Msg{},
+		&FeeFilterMsg{MinFee: 1000},
+	}
+	seen := make(map[string]bool)
+	for _, msg := range msgs {
+		seen[msg.Command()] = true
+		var buf bytes.Buffer
+		if err := WriteMessage(&buf, msg, RegTest); err != nil {
+			t.Errorf("%v: %v", msg.Command(), err)
+			continue
+		}
+		header, payload, err := ReadMessage(&buf, RegTest)
+		if err != nil {
+			t.Errorf("%v: %v", msg.Command(), err)
+			continue
+		}
+		if header.Command != msg.Command() {
+			t.Errorf("%v came back as %v", msg.Command(), header.Command)
+		}
+		decoded, err := DecodeMessage(header, payload)
+		if err != nil {
+			t.Errorf("%v: %v", msg.Command(), err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, msg) {
+			t.Errorf("%v came back as %+v, sent %+v", msg.Command(), decoded, msg)
+		}
+		// and the same bytes again
+		var again bytes.Buffer
+		if err := decoded.Encode(&again); err != nil || !bytes.Equal(again.Bytes(), payload) {
+			t.Errorf("%v encodes differently after decoding: %v", msg.Command(), err)
+		}
+	}
+	for _, cmd := range []string{CmdVersion, CmdVerack, CmdPing, CmdPong, CmdAddr, CmdAddrV2, CmdGetAddr,
+		CmdInv, CmdGetData, CmdNotFound, CmdGetHeaders, CmdGetBlocks, CmdHeaders, CmdBlock, CmdTx,
+		CmdMempool, CmdReject, CmdSendHeaders, CmdWtxidRelay, CmdSendAddrV2, CmdFeeFilter} {
+		if !seen[cmd] {
+			t.Errorf("no %v message tested", cmd)
+		}
+	}
+}
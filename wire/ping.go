@@ -0,0 +1,35 @@
+package wire
+
+import "io"
+
+type PingMsg struct {
+	Nonce uint64
+}
+
+func (p *PingMsg) Command() string {
+	return CmdPing
+}
+
+func (p *PingMsg) Encode(w io.Writer) error {
+	return writeElement(w, p.Nonce)
+}
+
+func (p *PingMsg) Decode(r io.Reader) error {
+	return readElement(r, &p.Nonce)
+}
+
+type PongMsg struct {
+	Nonce uint64
+}
+
+func (p *PongMsg) Command() string {
+	return CmdPong
+}
+
+func (p *PongMsg) Encode(w io.Writer) error {
+	return writeElement(w, p.Nonce)
+}
+
+func (p *PongMsg) Decode(r io.Reader) error {
+	return readElement(r, &p.Nonce)
+}
@@ -0,0 +1,75 @@
+package wire
+
+import (
+	"errors"
+	"io"
+)
+
+// MaxRejectReasonLen is how much of a reject reason we bother reading.
+const MaxRejectReasonLen = 111
+
+const (
+	RejectMalformed       uint8 = 0x01
+	RejectInvalid         uint8 = 0x10
+	RejectObsolete        uint8 = 0x11
+	RejectDuplicate       uint8 = 0x12
+	RejectNonstandard     uint8 = 0x40
+	RejectDust            uint8 = 0x41
+	RejectInsufficientFee uint8 = 0x42
+	RejectCheckpoint      uint8 = 0x43
+)
+
+type RejectMsg struct {
+	Cmd    string
+	Code   uint8
+	Reason string
+	Hash   [32]byte // only for rejected blocks and transactions
+}
+
+func (rej *RejectMsg) Command() string {
+	return CmdReject
+}
+
+func (rej *RejectMsg) Encode(w io.Writer) error {
+	err := writeVarStr(w, rej.Cmd)
+	if err != nil {
+		return err
+	}
+	err = writeElement(w, rej.Code)
+	if err != nil {
+		return err
+	}
+	err = writeVarStr(w, rej.Reason)
+	if err != nil {
+		return err
+	}
+	if rej.Cmd == CmdBlock || rej.Cmd == CmdTx {
+		return writeElement(w, rej.Hash)
+	}
+	return nil
+}
+
+func (rej *RejectMsg) Decode(r io.Reader) error {
+	var err error
+	rej.Cmd, err = readVarStr(r, CommandSize)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &rej.Code)
+	if err != nil {
+		return err
+	}
+	rej.Reason, err = readVarStr(r, MaxRejectReasonLen)
+	if err != nil {
+		return err
+	}
+	if rej.Cmd == CmdBlock || rej.Cmd == CmdTx {
+		err = readElement(r, &rej.Hash)
+		// some implementations leave the hash out
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package wire
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	// outpoint, empty script and sequence
+	minTxInSize = 41
+	// value and empty script
+	minTxOutSize = 9
+
+	maxWitnessItemsPerInput = 500000
+	// the witness item count comes from the peer, past this the slice
+	// grows as items actually arrive
+	maxWitnessItemsPrealloc = 16
+)
+
+// segwit transactions replace the input count with these two bytes
+const (
+	witnessMarker = 0x00
+	witnessFlag   = 0x01
+)
+
+type OutPoint struct {
+	Hash  [32]byte
+	Index uint32
+}
+
+type TxIn struct {
+	PreviousOutPoint OutPoint
+	SignatureScript  []byte
+	Witness          [][]byte
+	Sequence         uint32
+}
+
+type TxOut struct {
+	Value    int64
+	PkScript []byte
+}
+
+type TxMsg struct {
+	Version  int32
+	TxIn     []*TxIn
+	TxOut    []*TxOut
+	LockTime uint32
+}
+
+func (tx *TxMsg) Command() string {
+	return CmdTx
+}
+
+func (tx *TxMsg) HasWitness() bool {
+	for _, in := range tx.TxIn {
+		if len(in.Witness) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode writes the transaction in the BIP144 format when it has witness
+// data and the legacy format otherwise.
+func (tx *TxMsg) Encode(w io.Writer) error {
+	return tx.encode(w, tx.HasWitness())
+}
+
+// EncodeNoWitness always writes the legacy format, which is what the txid
+// commits to.
+func (tx *TxMsg) EncodeNoWitness(w io.Writer) error {
+	return tx.encode(w, false)
+}
+
+func (tx *TxMsg) encode(w io.Writer, witness bool) error {
+	err := writeElement(w, tx.Version)
+	if err != nil {
+		return err
+	}
+	if witness {
+		err = writeElements(w, uint8(witnessMarker), uint8(witnessFlag))
+		if err != nil {
+			return err
+		}
+	}
+	err = writeVarInt(w, len(tx.TxIn))
+	if err != nil {
+		return err
+	}
+	for _, in := range tx.TxIn {
+		err = writeElements(w, in.PreviousOutPoint.Hash, in.PreviousOutPoint.Index)
+		if err != nil {
+			return err
+		}
+		err = writeVarBytes(w, in.SignatureScript)
+		if err != nil {
+			return err
+		}
+		err = writeElement(w, in.Sequence)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeVarInt(w, len(tx.TxOut))
+	if err != nil {
+		return err
+	}
+	for _, out := range tx.TxOut {
+		err = writeElement(w, out.Value)
+		if err != nil {
+			return err
+		}
+		err = writeVarBytes(w, out.PkScript)
+		if err != nil {
+			return err
+		}
+	}
+	if witness {
+		for _, in := range tx.TxIn {
+			err = writeVarInt(w, len(in.Witness))
+			if err != nil {
+				return err
+			}
+			for _, item := range in.Witness {
+				err = writeVarBytes(w, item)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return writeElement(w, tx.LockTime)
+}
+
+func (tx *TxMsg) Decode(r io.Reader) error {
+	err := readElement(r, &tx.Version)
+	if err != nil {
+		return err
+	}
+	count, err := readCount(r, MaxBlockPayload/minTxInSize, "inputs")
+	if err != nil {
+		return err
+	}
+	// an empty input list is really the segwit marker
+	witness := false
+	if count == witnessMarker {
+		var flag uint8
+		err = readElement(r, &flag)
+		if err != nil {
+			return err
+		}
+		if flag != witnessFlag {
+			return errors.New("wire: invalid witness flag")
+		}
+		witness = true
+		count, err = readCount(r, MaxBlockPayload/minTxInSize, "inputs")
+		if err != nil {
+			return err
+		}
+	}
+	tx.TxIn = make([]*TxIn, 0, count)
+	for i := 0; i < count; i++ {
+		in := &TxIn{}
+		err = readElements(r, &in.PreviousOutPoint.Hash, &in.PreviousOutPoint.Index)
+		if err != nil {
+			return err
+		}
+		in.SignatureScript, err = readVarBytes(r, MaxBlockPayload, "signature script bytes")
+		if err != nil {
+			return err
+		}
+		err = readElement(r, &in.Sequence)
+		if err != nil {
+			return err
+		}
+		tx.TxIn = append(tx.TxIn, in)
+	}
+	count, err = readCount(r, MaxBlockPayload/minTxOutSize, "outputs")
+	if err != nil {
+		return err
+	}
+	tx.TxOut = make([]*TxOut, 0, count)
+	for i := 0; i < count; i++ {
+		out := &TxOut{}
+		err = readElement(r, &out.Value)
+		if err != nil {
+			return err
+		}
+		out.PkScript, err = readVarBytes(r, MaxBlockPayload, "public key script bytes")
+		if err != nil {
+			return err
+		}
+		tx.TxOut = append(tx.TxOut, out)
+	}
+	if witness {
+		for _, in := range tx.TxIn {
+			items, err := readCount(r, maxWitnessItemsPerInput, "witness items")
+			if err != nil {
+				return err
+			}
+			prealloc := items
+			if prealloc > maxWitnessItemsPrealloc {
+				prealloc = maxWitnessItemsPrealloc
+			}
+			in.Witness = make([][]byte, 0, prealloc)
+			for j := 0; j < items; j++ {
+				item, err := readVarBytes(r, MaxBlockPayload, "witness item bytes")
+				if err != nil {
+					return err
+				}
+				in.Witness = append(in.Witness, item)
+			}
+		}
+		// a marker without any actual witness data is not allowed
+		if !tx.HasWitness() {
+			return errors.New("wire: superfluous witness record")
+		}
+	}
+	return readElement(r, &tx.LockTime)
+}
+
+type MempoolMsg struct{}
+
+func (m *MempoolMsg) Command() string {
+	return CmdMempool
+}
+
+func (m *MempoolMsg) Encode(w io.Writer) error {
+	return nil
+}
+
+func (m *MempoolMsg) Decode(r io.Reader) error {
+	return nil
+}
@@ -0,0 +1,37 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+)
+
+// a witness item count is just a number the peer sends, it mustn't make us
+// allocate for items that never arrive
+func TestWitnessCountPrealloc(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write([]byte{1, 0, 0, 0})
+	buf.Write([]byte{witnessMarker, witnessFlag})
+	// one input with an empty script, no outputs
+	buf.WriteByte(1)
+	buf.Write(make([]byte, 36))
+	buf.WriteByte(0)
+	buf.Write([]byte{0xff, 0xff, 0xff, 0xff})
+	buf.WriteByte(0)
+	// the most witness items allowed, none of which follow
+	buf.Write([]byte{0xfe, 0x20, 0xa1, 0x07, 0x00})
+	raw := buf.Bytes()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	err := (&TxMsg{}).Decode(bytes.NewReader(raw))
+	runtime.ReadMemStats(&after)
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v, want EOF", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+		t.Errorf("decoding %v bytes allocated %v", len(raw), allocated)
+	}
+}
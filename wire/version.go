@@ -0,0 +1,97 @@
+package wire
+
+import (
+	"errors"
+	"io"
+)
+
+// MaxUserAgentLen is the longest user agent we accept, same as bitcoind.
+const MaxUserAgentLen = 256
+
+type VersionMsg struct {
+	Version      int32
+	Services     uint64
+	Timestamp    int64
+	Addr_recv    NetAddr
+	Addr_from    NetAddr
+	Nonce        uint64
+	User_agent   string
+	Start_height int32
+	Relay        bool
+}
+
+func (ver *VersionMsg) Command() string {
+	return CmdVersion
+}
+
+func (ver *VersionMsg) Encode(w io.Writer) error {
+	err := writeElements(w, ver.Version, ver.Services, ver.Timestamp)
+	if err != nil {
+		return err
+	}
+	err = writeNetaddress(w, &ver.Addr_recv, false)
+	if err != nil {
+		return err
+	}
+	err = writeNetaddress(w, &ver.Addr_from, false)
+	if err != nil {
+		return err
+	}
+	err = writeElement(w, ver.Nonce)
+	if err != nil {
+		return err
+	}
+	err = writeVarStr(w, ver.User_agent)
+	if err != nil {
+		return err
+	}
+	return writeElements(w, ver.Start_height, ver.Relay)
+}
+
+func (ver *VersionMsg) Decode(r io.Reader) error {
+	err := readElements(r, &ver.Version, &ver.Services, &ver.Timestamp)
+	if err != nil {
+		return err
+	}
+	err = readNetAddress(r, &ver.Addr_recv, false)
+	if err != nil {
+		return err
+	}
+	err = readNetAddress(r, &ver.Addr_from, false)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &ver.Nonce)
+	if err != nil {
+		return err
+	}
+	ver.User_agent, err = readVarStr(r, MaxUserAgentLen)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &ver.Start_height)
+	if err != nil {
+		return err
+	}
+	// relay was added by BIP37, peers that don't send it want everything
+	err = readElement(r, &ver.Relay)
+	if errors.Is(err, io.EOF) {
+		ver.Relay = true
+		return nil
+	}
+	return err
+}
+
+type VerackMsg struct{}
+
+func (v *VerackMsg) Command() string {
+	return CmdVerack
+}
+
+func (v *VerackMsg) Encode(w io.Writer) error {
+	return nil
+}
+
+func (v *VerackMsg) Decode(r io.Reader) error {
+	return nil
+}
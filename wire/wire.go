@@ -2,38 +2,13 @@ package wire
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"net"
 )
 
-type VersionMsg struct {
-	Version int32
-	Services uint64
-	Timestamp int64
-	Addr_recv NetAddr
-	Addr_from NetAddr
-	Nonce uint64
-	User_agent byte
-	Start_height int32
-	Relay bool
-}
-
-type NetAddr struct {
-	Services uint64
-	Address net.IP
-	Port uint16
-}
-
-func (v *VersionMsg) Serialize (w io.Writer) error {
-	var scratch [8]byte
-	binary.LittleEndian.PutUint32(scratch[0:4], uint32(v.Version))
-	w.Write(scratch[0:4])
-	return nil
-}
-
 func writeElement(w io.Writer, element interface{}) error {
 	var scratch [8]byte
 	switch e := element.(type) {
@@ -76,11 +51,94 @@ func writeElement(w io.Writer, element interface{}) error {
 		if err != nil {
 			return err
 		}
+	case uint8:
+		_, err := w.Write([]byte{e})
+		if err != nil {
+			return err
+		}
 	case [16]byte:
 		err := binary.Write(w, binary.BigEndian, e[:])
 		if err != nil {
 			return err
 		}
+	case [32]byte:
+		_, err := w.Write(e[:])
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("wire: can't write element of type %T", element)
+	}
+	return nil
+}
+
+func readElement(r io.Reader, element interface{}) error {
+	var scratch [8]byte
+	switch e := element.(type) {
+	case *int32:
+		b := scratch[0:4]
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		*e = int32(binary.LittleEndian.Uint32(b))
+	case *int64:
+		b := scratch[0:8]
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		*e = int64(binary.LittleEndian.Uint64(b))
+	case *uint32:
+		b := scratch[0:4]
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		*e = binary.LittleEndian.Uint32(b)
+	case *uint64:
+		b := scratch[0:8]
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		*e = binary.LittleEndian.Uint64(b)
+	case *bool:
+		b := scratch[0:1]
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		*e = b[0] != 0x00
+	case *uint8:
+		b := scratch[0:1]
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return err
+		}
+		*e = b[0]
+	case *[16]byte:
+		_, err := io.ReadFull(r, e[:])
+		if err != nil {
+			return err
+		}
+	case *[32]byte:
+		_, err := io.ReadFull(r, e[:])
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("wire: can't read element of type %T", element)
+	}
+	return nil
+}
+
+func readElements(r io.Reader, elements ...interface{}) error {
+	for _, element := range elements {
+		err := readElement(r, element)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -94,7 +152,14 @@ func writeElements(w io.Writer, elements ...interface{}) error {
 	}
 	return nil
 }
-func writeNetaddress(w io.Writer, addr NetAddr) error {
+
+func writeNetaddress(w io.Writer, addr *NetAddr, withTimestamp bool) error {
+	if withTimestamp {
+		err := writeElement(w, addr.Timestamp)
+		if err != nil {
+			return err
+		}
+	}
 	err := writeElement(w, addr.Services)
 	if err != nil {
 		return err
@@ -112,18 +177,40 @@ func writeNetaddress(w io.Writer, addr NetAddr) error {
 	return nil
 }
 
+func readNetAddress(r io.Reader, addr *NetAddr, withTimestamp bool) error {
+	if withTimestamp {
+		err := readElement(r, &addr.Timestamp)
+		if err != nil {
+			return err
+		}
+	}
+	var ip [16]byte
+	err := readElements(r, &addr.Services, &ip)
+	if err != nil {
+		return err
+	}
+	addr.Address = net.IP(ip[:])
+	var port [2]byte
+	_, err = io.ReadFull(r, port[:])
+	if err != nil {
+		return err
+	}
+	addr.Port = binary.BigEndian.Uint16(port[:])
+	return nil
+}
+
 func writeVarInt(w io.Writer, integer int) error {
 	if integer < 0xfd {
 		return binary.Write(w, binary.LittleEndian, uint8(integer))
 	}
-	if integer < 0xffff {
+	if integer <= 0xffff {
 		_, err := w.Write([]byte{0xfd})
 		if err != nil {
 			return err
 		}
 		return binary.Write(w, binary.LittleEndian, uint16(integer))
 	}
-	if integer < 0xffffffff {
+	if integer <= 0xffffffff {
 		_, err := w.Write([]byte{0xfe})
 		if err != nil {
 			return err
@@ -154,6 +241,41 @@ func ReadVarInt(integer []byte) (int, int, error) {
 	return 0, 0, errors.New("invalid var int")
 }
 
+func readVarInt(r io.Reader) (uint64, error) {
+	var prefix uint8
+	err := readElement(r, &prefix)
+	if err != nil {
+		return 0, err
+	}
+	switch prefix {
+	case 0xff:
+		var integer uint64
+		err = readElement(r, &integer)
+		return integer, err
+	case 0xfe:
+		var integer uint32
+		err = readElement(r, &integer)
+		return uint64(integer), err
+	case 0xfd:
+		var b [2]byte
+		_, err = io.ReadFull(r, b[:])
+		return uint64(binary.LittleEndian.Uint16(b[:])), err
+	}
+	return uint64(prefix), nil
+}
+
+// readCount reads a var int that is going to be used to size an allocation
+func readCount(r io.Reader, max int, what string) (int, error) {
+	count, err := readVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if count > uint64(max) {
+		return 0, fmt.Errorf("wire: too many %v: %v > %v", what, count, max)
+	}
+	return int(count), nil
+}
+
 func writeVarStr(w io.Writer, element string) error {
 	err := writeVarInt(w, len(element))
 	if err != nil {
@@ -163,6 +285,11 @@ func writeVarStr(w io.Writer, element string) error {
 	return err
 }
 
+func readVarStr(r io.Reader, max int) (string, error) {
+	str, err := readVarBytes(r, max, "string bytes")
+	return string(str), err
+}
+
 func ReadVarStr(str []byte) (string, int, error) {
 	length, size, err := ReadVarInt(str)
 	if err != nil {
@@ -171,145 +298,73 @@ func ReadVarStr(str []byte) (string, int, error) {
 	return string(str[size:size+length]), size + length, nil
 }
 
-func writeMsg(w io.Writer, command string, payload []byte) error {
-	var msgBuffer bytes.Buffer
-	err := binary.Write(&msgBuffer, binary.LittleEndian, uint32(0xD9B4BEF9))
-	if err != nil {
-		return err
-	}
-	_, err = msgBuffer.Write([]byte(command))
-	if err != nil {
-		return err
-	}
-	_, err = msgBuffer.Write(make([]byte, 12 - len(command)))
-	if err != nil {
-		return err
-	}
-	err = writeElement(&msgBuffer, uint32(len(payload)))
-	if err != nil {
-		return err
-	}
-	singleHash := sha256.Sum256(payload)
-	doubleHash := sha256.Sum256(singleHash[:])
-	_, err = msgBuffer.Write(doubleHash[:4])
-	if err != nil {
-		return err
-	}
-	_, err = msgBuffer.Write(payload)
-	if err != nil {
-		return err
-	}
-	msg := make([]byte, msgBuffer.Len())
-	_, err = msgBuffer.Read(msg)
-	if err != nil {
-		return err
-	}
-	_, err = w.Write(msg)
-	return nil
-}
-
-func (ver *VersionMsg) Write(w io.Writer) error {
-	var payloadBuffer bytes.Buffer
-	err := writeElements(&payloadBuffer, ver.Version, ver .Services, ver.Timestamp)
-	if err != nil {
-		return err
-	}
-	err = writeNetaddress(&payloadBuffer, ver.Addr_recv)
-	if err != nil {
-		return err
-	}
-	err = writeNetaddress(&payloadBuffer, ver.Addr_from)
-	if err != nil {
-		return err
-	}
-	err = writeElements(&payloadBuffer, ver.Nonce, ver.User_agent)
-	if err != nil {
-		return err
-	}
-	_, err = payloadBuffer.Write([]byte{ver.User_agent})
-	if err != nil {
-		return err
-	}
-	err = writeElement(&payloadBuffer, int32(ver.Start_height))
-	if err != nil {
-		return err
-	}
-	payload := make([]byte, payloadBuffer.Len())
-	_, err = payloadBuffer.Read(payload)
+func writeVarBytes(w io.Writer, element []byte) error {
+	err := writeVarInt(w, len(element))
 	if err != nil {
 		return err
 	}
-	return writeMsg(w, "version", payload)
+	_, err = w.Write(element)
+	return err
 }
 
-func WriteVerackMsg(w io.Writer) error {
-	return writeMsg(w, "verack", []byte{})
+// WriteVarBytes writes element prefixed with its length, the way scripts
+// are serialized
+func WriteVarBytes(w io.Writer, element []byte) error {
+	return writeVarBytes(w, element)
 }
 
-func writePingPong(w io.Writer, nonce uint64, command string) error {
-	var payloadBuffer bytes.Buffer
-	err := writeElement(&payloadBuffer, nonce)
+func readVarBytes(r io.Reader, max int, what string) ([]byte, error) {
+	length, err := readCount(r, max, what)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	payload := make([]byte, payloadBuffer.Len())
-	_, err = payloadBuffer.Read(payload)
+	element := make([]byte, length)
+	_, err = io.ReadFull(r, element)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return writeMsg(w, command, payload)
+	return element, nil
 }
 
-func WritePing(w io.Writer, nonce uint64) error {
-	return writePingPong(w, nonce, "ping")
-}
-
-func WritePong(w io.Writer, nonce uint64) error {
-	return writePingPong(w, nonce, "pong")
-}
-
-func WriteGetaddr(w io.Writer) error {
-	return writeMsg(w, "getaddr", []byte{})
-}
-
-func WriteGetHeaders(w io.Writer, protocolVersion int, start [32]byte, end [32]byte) error {
-	var payloadBuffer bytes.Buffer
-	err := writeElement(&payloadBuffer, int32(protocolVersion))
+func writeMsg(w io.Writer, magic uint32, command string, payload []byte) error {
+	if len(command) > CommandSize {
+		return ErrInvalidCommand
+	}
+	if len(payload) > MaxPayloadLength {
+		return ErrPayloadTooLarge
+	}
+	var msgBuffer bytes.Buffer
+	err := binary.Write(&msgBuffer, binary.LittleEndian, magic)
 	if err != nil {
 		return err
 	}
-	err = writeVarInt(&payloadBuffer, 1)
+	_, err = msgBuffer.Write([]byte(command))
 	if err != nil {
 		return err
 	}
-	_, err = payloadBuffer.Write(start[:])
+	_, err = msgBuffer.Write(make([]byte, 12 - len(command)))
 	if err != nil {
 		return err
 	}
-	_, err = payloadBuffer.Write(end[:])
+	err = writeElement(&msgBuffer, uint32(len(payload)))
 	if err != nil {
 		return err
 	}
-	payload := make([]byte, payloadBuffer.Len())
-	_, err = payloadBuffer.Read(payload)
+	sum := checksum(payload)
+	_, err = msgBuffer.Write(sum[:])
 	if err != nil {
 		return err
 	}
-	return writeMsg(w, "getheaders", payload)
-}
-
-func WriteGetData(w io.Writer, inventory []byte) error {
-	var payloadBuffer bytes.Buffer
-	count := len(inventory)/36
-	err := writeVarInt(&payloadBuffer, count)
+	_, err = msgBuffer.Write(payload)
 	if err != nil {
 		return err
 	}
-	_, err = payloadBuffer.Write(inventory)
+	msg := make([]byte, msgBuffer.Len())
+	_, err = msgBuffer.Read(msg)
 	if err != nil {
 		return err
 	}
-	payload := make([]byte, payloadBuffer.Len())
-	payloadBuffer.Read(payload)
-	return writeMsg(w, "getdata", payload)
+	_, err = w.Write(msg)
+	return err
 }
+